@@ -0,0 +1,67 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "os/exec"
+    "path"
+    "path/filepath"
+    "strings"
+)
+
+// Default place on the device where the SimpleX Android app saves database exports
+const defaultAndroidExportDir = "/sdcard/Download"
+
+// Run adb (the device is picked by adb itself, e.g. through ANDROID_SERIAL)
+func runAdb(args ...string) ([]byte, error) {
+    cmd := exec.Command("adb", args...)
+    var stderr bytes.Buffer
+    cmd.Stderr = &stderr
+    output, err := cmd.Output()
+    if err != nil {
+        return nil, fmt.Errorf("adb %s failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+    }
+    return output, nil
+}
+
+// Find the newest SimpleX database export on the device. The app's own data directory isn't readable
+// over adb on unrooted devices, so this relies on Settings > Database > Export database having saved one
+func findAndroidExport(deviceDir string) (string, error) {
+    output, err := runAdb("shell", fmt.Sprintf("ls -t %s/simplex-chat*.zip", strings.TrimSuffix(deviceDir, "/")))
+    if err != nil {
+        return "", fmt.Errorf("no SimpleX export found in %s on the device (export the database in the app first): %w", deviceDir, err)
+    }
+
+    for _, line := range strings.Split(string(output), "\n") {
+        line = strings.TrimSpace(line)
+        // Skip archives this tool pushed earlier
+        if line != "" && !strings.Contains(filepath.Base(line), "_updated") {
+            return line, nil
+        }
+    }
+    return "", fmt.Errorf("no SimpleX export found in %s on the device (export the database in the app first)", deviceDir)
+}
+
+// Pull the newest export from the device into localDir
+func pullAndroidExport(deviceDir, localDir string) (string, error) {
+    remotePath, err := findAndroidExport(deviceDir)
+    if err != nil {
+        return "", err
+    }
+
+    fmt.Printf("Pulling %s from the device...\n", remotePath)
+    localPath := filepath.Join(localDir, path.Base(remotePath))
+    if _, err := runAdb("pull", remotePath, localPath); err != nil {
+        return "", err
+    }
+    return localPath, nil
+}
+
+// Push the updated archive back next to the original export, returning its path on the device
+func pushAndroidExport(localPath, deviceDir string) (string, error) {
+    remotePath := path.Join(deviceDir, filepath.Base(localPath))
+    if _, err := runAdb("push", localPath, remotePath); err != nil {
+        return "", err
+    }
+    return remotePath, nil
+}
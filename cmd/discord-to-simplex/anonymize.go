@@ -0,0 +1,131 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+
+    "github.com/ritiek/discord-to-simplex/pkg/archive"
+    "github.com/ritiek/discord-to-simplex/pkg/discord"
+    "github.com/ritiek/discord-to-simplex/pkg/progress"
+    "github.com/ritiek/discord-to-simplex/pkg/simplexdb"
+    "github.com/ritiek/discord-to-simplex/pkg/tempfiles"
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// anonymize: write a scrubbed copy of a Discord export (-json) or a SimpleX archive (-zip) or
+// database (-db) to share when reporting a bug. The input is left as it is
+func runAnonymize(args []string) {
+    fs := flag.NewFlagSet("anonymize", flag.ExitOnError)
+    jsonFilePath := fs.String("json", "", "DiscordChatExporter JSON export to scrub")
+    zipPath := fs.String("zip", "", "SimpleX export ZIP file to scrub")
+    dbPath := fs.String("db", "", "SimpleX database file to scrub")
+    outputPath := fs.String("output", "", "Where to write the scrubbed copy (required)")
+    var target simplexTarget
+    target.registerAccess(fs)
+    fs.Parse(args)
+
+    inputs := 0
+    for _, input := range []string{*jsonFilePath, *zipPath, *dbPath} {
+        if input != "" {
+            inputs++
+        }
+    }
+    if inputs != 1 {
+        log.Fatal("Give exactly one of -json, -zip and -db to anonymize.")
+    }
+    if *outputPath == "" {
+        log.Fatal("Output path is required. Use -output flag.")
+    }
+    if outputInfo, err := os.Stat(*outputPath); err == nil {
+        for _, input := range []string{*jsonFilePath, *zipPath, *dbPath} {
+            if inputInfo, err := os.Stat(input); err == nil && os.SameFile(inputInfo, outputInfo) {
+                log.Fatal("-output must not be the input, anonymize writes a copy.")
+            }
+        }
+    }
+    target.checkAccess()
+
+    if *jsonFilePath != "" {
+        data, err := os.ReadFile(*jsonFilePath)
+        if err != nil {
+            log.Fatalf("Failed to read export: %v", err)
+        }
+        scrubbed, err := discord.Anonymize(data)
+        if err != nil {
+            log.Fatalf("Failed to anonymize export: %v", err)
+        }
+        if err := os.WriteFile(*outputPath, scrubbed, 0644); err != nil {
+            log.Fatalf("Failed to write %s: %v", *outputPath, err)
+        }
+        fmt.Printf("Wrote anonymized export: %s\n", *outputPath)
+        fmt.Println("Attachment files aren't copied; put ones the bug needs in an attachments folder next to it, under their new names.")
+        return
+    }
+
+    cleanUpOnInterrupt()
+    defer tempfiles.Cleanup()
+    ctx := context.Background()
+
+    chatDBPath, filesDir, extractedDir := *outputPath, "", ""
+    if *zipPath != "" {
+        maxArchiveBytes, _ := universal.ParseByteSize(target.maxArchiveSize)
+        var err error
+        extractedDir, err = archive.Extract(ctx, *zipPath, tempfiles.Root, false, maxArchiveBytes)
+        if err != nil {
+            fatalf("Failed to extract SimpleX ZIP: %v", err)
+        }
+        tempfiles.Register(extractedDir)
+        chatDBPath, filesDir, err = archive.Locate(extractedDir)
+        if err != nil {
+            fatalf("Failed to find SimpleX database: %v", err)
+        }
+    } else {
+        if err := archive.CopyFile(*dbPath, *outputPath); err != nil {
+            fatalf("Failed to copy database: %v", err)
+        }
+        // Don't leave a copy that isn't scrubbed yet
+        tempfiles.Register(*outputPath)
+    }
+
+    password, err := resolvePassword(target.keyFile, target.keyCmd, target.keyringService)
+    if err != nil {
+        fatalf("Failed to get database password: %v", err)
+    }
+    if password == "" {
+        fatalf("Database password is required.")
+    }
+    db, err := openDatabase(chatDBPath, password, false)
+    if err != nil {
+        fatalf("%v", err)
+    }
+    stats, err := simplexdb.Anonymize(db, filesDir)
+    db.Close()
+    if err != nil {
+        fatalf("Failed to anonymize database: %v", err)
+    }
+    fmt.Printf("Anonymized %d chat items, %d messages, %d files, %d profiles and %d display names\n",
+        stats.ChatItems, stats.Messages, stats.Files, stats.Profiles, stats.Names)
+
+    if extractedDir == "" {
+        tempfiles.Keep(*outputPath)
+        fmt.Printf("Wrote anonymized database: %s\n", *outputPath)
+        return
+    }
+
+    // The agent database holds the keys of every connection, which must not be shared
+    layout, dir, err := archive.DetectLayout(extractedDir)
+    if err == nil {
+        os.Remove(filepath.Join(dir, layout.AgentDB))
+    }
+    bar := progress.NewBytes("Writing ZIP", 0)
+    err = archive.Create(ctx, extractedDir, *outputPath, "", bar)
+    bar.Finish()
+    if err != nil {
+        fatalf("Failed to create output ZIP: %v", err)
+    }
+    fmt.Printf("Wrote anonymized archive without its agent database: %s\n", *outputPath)
+}
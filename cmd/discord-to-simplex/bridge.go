@@ -0,0 +1,160 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/url"
+    "os"
+    "path/filepath"
+    "sort"
+    "time"
+
+    "github.com/ritiek/discord-to-simplex/pkg/discord"
+    "github.com/ritiek/discord-to-simplex/pkg/media"
+    "github.com/ritiek/discord-to-simplex/pkg/simplexchat"
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// What the bridge has mirrored so far, so restarts pick up where it stopped
+type BridgeState struct {
+    LastMessageID string                     `json:"lastMessageId"`
+    ItemIDs       map[string]int             `json:"itemIds"`
+    Reacted       map[string]map[string]bool `json:"reacted"`
+}
+
+func loadBridgeState(path string) (*BridgeState, error) {
+    state := &BridgeState{ItemIDs: make(map[string]int), Reacted: make(map[string]map[string]bool)}
+    data, err := os.ReadFile(path)
+    if os.IsNotExist(err) {
+        return state, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    if err := json.Unmarshal(data, state); err != nil {
+        return nil, fmt.Errorf("failed to parse bridge state %s: %w", path, err)
+    }
+    if state.ItemIDs == nil {
+        state.ItemIDs = make(map[string]int)
+    }
+    if state.Reacted == nil {
+        state.Reacted = make(map[string]map[string]bool)
+    }
+    return state, nil
+}
+
+func saveBridgeState(path string, state *BridgeState) error {
+    data, err := json.MarshalIndent(state, "", "  ")
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return err
+    }
+    tempPath := path + ".tmp"
+    if err := os.WriteFile(tempPath, data, 0600); err != nil {
+        return err
+    }
+    return os.Rename(tempPath, path)
+}
+
+// Settings for -bridge
+type BridgeConfig struct {
+    ChannelID    string
+    StatePath    string
+    MyUsername   string
+    CacheDir     string
+    PollInterval time.Duration
+    Convert      discord.ConvertOptions
+}
+
+// Mirror new messages of a Discord channel into SimpleX until interrupted. New messages are
+// polled with after=<last mirrored ID>; the latest page is re-read too so reactions added
+// after a message was mirrored are carried over. Edits and deletions are not mirrored
+func runDiscordBridge(ctx context.Context, api *discord.APIClient, sender *simplexchat.LiveSender, cfg BridgeConfig) error {
+    state, err := loadBridgeState(cfg.StatePath)
+    if err != nil {
+        return err
+    }
+    sender.ItemIDs = state.ItemIDs
+    sender.Reacted = state.Reacted
+
+    // Without saved state, start from the newest message instead of replaying the whole channel
+    if state.LastMessageID == "" {
+        latest, err := api.ChannelMessages(ctx, cfg.ChannelID, url.Values{"limit": {"1"}})
+        if err != nil {
+            return err
+        }
+        state.LastMessageID = "0"
+        if len(latest) > 0 {
+            state.LastMessageID = latest[0].ID
+        }
+        if err := saveBridgeState(cfg.StatePath, state); err != nil {
+            return err
+        }
+    }
+    fmt.Printf("Bridging channel %s (state in %s), polling every %s...\n", cfg.ChannelID, cfg.StatePath, cfg.PollInterval)
+
+    for {
+        newMessages, err := api.ChannelMessages(ctx, cfg.ChannelID, url.Values{"after": {state.LastMessageID}, "limit": {"100"}})
+        if err != nil {
+            log.Printf("Warning: failed to fetch new messages: %v", err)
+        }
+        sort.Slice(newMessages, func(i, j int) bool { return discord.SnowflakeLess(newMessages[i].ID, newMessages[j].ID) })
+
+        // Replies may quote messages from before the bridge started, the API includes those
+        discordMessages := make(map[string]*discord.Message)
+        for _, apiMsg := range newMessages {
+            exportMsg := apiMsg.ToExportMessage()
+            discordMessages[apiMsg.ID] = &exportMsg
+            if apiMsg.ReferencedMessage != nil {
+                referencedMsg := apiMsg.ReferencedMessage.ToExportMessage()
+                discordMessages[apiMsg.ReferencedMessage.ID] = &referencedMsg
+            }
+        }
+
+        for _, apiMsg := range newMessages {
+            converted := discord.ConvertMessage(*discordMessages[apiMsg.ID], cfg.MyUsername, discordMessages, ".", cfg.Convert)
+            parts := universal.SplitMultiAttachmentMessages([]universal.Message{converted})
+            media.CacheRemoteAttachments(ctx, parts, cfg.CacheDir, nil)
+
+            for _, part := range parts {
+                if err := sender.Send(part); err != nil {
+                    log.Printf("Warning: failed to mirror message %s: %v", part.ID, err)
+                }
+            }
+            fmt.Printf("Mirrored message %s from %s\n", apiMsg.ID, apiMsg.Author.Username)
+
+            state.LastMessageID = apiMsg.ID
+            if err := saveBridgeState(cfg.StatePath, state); err != nil {
+                log.Printf("Warning: failed to save bridge state: %v", err)
+            }
+        }
+
+        // Carry over reactions added to recently mirrored messages
+        recent, err := api.ChannelMessages(ctx, cfg.ChannelID, url.Values{"limit": {"50"}})
+        if err != nil {
+            log.Printf("Warning: failed to fetch recent messages: %v", err)
+        }
+        for _, apiMsg := range recent {
+            if _, mirrored := sender.ItemIDs[apiMsg.ID]; !mirrored || len(apiMsg.Reactions) == 0 {
+                continue
+            }
+            converted := discord.ConvertMessage(apiMsg.ToExportMessage(), cfg.MyUsername, nil, ".", cfg.Convert)
+            sender.SyncReactions(converted)
+        }
+        if len(recent) > 0 {
+            if err := saveBridgeState(cfg.StatePath, state); err != nil {
+                log.Printf("Warning: failed to save bridge state: %v", err)
+            }
+        }
+
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-time.After(cfg.PollInterval):
+        }
+    }
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+
+    "github.com/ritiek/discord-to-simplex/pkg/simplexdb"
+    "github.com/ritiek/discord-to-simplex/pkg/tempfiles"
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// How far an import got, written after every committed batch so -resume can continue it
+type importCheckpoint struct {
+    JSON          string `json:"json"`
+    Contact       string `json:"contact"`
+    Messages      int    `json:"messages"`      // Messages to import after conversion
+    Done          int    `json:"done"`          // Messages in committed batches
+    LastMessageID string `json:"lastMessageId"` // Source ID of the last committed message
+    FirstMessageID  int  `json:"firstMessageId,omitempty"`
+    FirstChatItemID int  `json:"firstChatItemId,omitempty"`
+    Recorded        bool `json:"recorded,omitempty"` // Already in the import_runs ledger
+    InMemory      bool   `json:"inMemory,omitempty"`
+    WorkDir       string `json:"workDir,omitempty"` // Extracted archive the batches went into, kept until the import is done
+    Indexes       []simplexdb.Index `json:"indexes,omitempty"` // Dropped for the bulk load, built again at the end
+}
+
+// Sidecar next to what's being imported into; for -dir it's outside the directory so it doesn't end up in the ZIP
+func checkpointPath(zipPath, dbPath, dirPath string) string {
+    switch {
+    case zipPath != "":
+        return zipPath + ".checkpoint.json"
+    case dirPath != "":
+        return filepath.Clean(dirPath) + ".checkpoint.json"
+    }
+    return dbPath + ".checkpoint.json"
+}
+
+func loadCheckpoint(path string) (*importCheckpoint, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    checkpoint := &importCheckpoint{}
+    if err := json.Unmarshal(data, checkpoint); err != nil {
+        return nil, fmt.Errorf("failed to parse checkpoint %s: %w", path, err)
+    }
+    return checkpoint, nil
+}
+
+// Check that a checkpoint was made by the same import of the same converted messages. Which of
+// them to still import is left to simplexdb.SkipImported. One saved before any batch was
+// committed (for the indexes it dropped) matches whatever the messages are now
+func (c *importCheckpoint) matches(jsonPath, contact string, inMemory bool, messages *universal.MessageQueue) error {
+    if c.JSON != jsonPath || c.Contact != contact {
+        return fmt.Errorf("it is for importing %s into the chat with %s", c.JSON, c.Contact)
+    }
+    if c.InMemory != inMemory {
+        return fmt.Errorf("-in-memory must be given the same way as before")
+    }
+    if c.Done == 0 && c.LastMessageID == "" {
+        return nil
+    }
+    if c.Messages == messages.Len() && c.Done <= messages.Len() {
+        found := false
+        err := messages.Each(func(chunk []universal.Message) error {
+            for _, msg := range chunk {
+                found = found || msg.ID == c.LastMessageID
+            }
+            return nil
+        })
+        if err != nil {
+            return err
+        }
+        if found {
+            return nil
+        }
+    }
+    return fmt.Errorf("the export or the options converting it changed since")
+}
+
+// Checkpoint an import goes on with, from the one at path: with resume the earlier import's,
+// otherwise fresh (nil for an import that keeps none). A checkpoint left before any batch was
+// committed holds nothing to resume and is started over, while one with committed batches must be
+// resumed or removed by hand. Also returns the indexes the earlier import left dropped, for this
+// one to build again
+func openCheckpoint(path string, resume bool, fresh *importCheckpoint, jsonPath, contact string, inMemory bool, messages *universal.MessageQueue) (*importCheckpoint, []simplexdb.Index, error) {
+    checkpoint, err := loadCheckpoint(path)
+    switch {
+    case err != nil && !os.IsNotExist(err):
+        return nil, nil, err
+    case resume && checkpoint == nil:
+        return nil, nil, fmt.Errorf("no checkpoint to resume from at %s", path)
+    case resume:
+        if err := checkpoint.matches(jsonPath, contact, inMemory, messages); err != nil {
+            return nil, nil, fmt.Errorf("can't resume from %s: %w", path, err)
+        }
+        fmt.Printf("Resuming after %d of %d messages\n", checkpoint.Done, checkpoint.Messages)
+        return checkpoint, checkpoint.Indexes, nil
+    case checkpoint == nil:
+        return fresh, nil, nil
+    case checkpoint.Done > 0:
+        return nil, nil, fmt.Errorf("an earlier import stopped part-way and left %s; continue it with -resume, or delete it (and its workDir) to start over", path)
+    }
+
+    fmt.Printf("Starting over the import that left %s before committing anything\n", path)
+    if fresh != nil {
+        // Until the fresh checkpoint replaces it the file keeps the indexes, so they're never lost
+        fresh.Indexes = checkpoint.Indexes
+    }
+    return fresh, checkpoint.Indexes, nil
+}
+
+func saveCheckpoint(path string, checkpoint *importCheckpoint) error {
+    data, err := json.MarshalIndent(checkpoint, "", "  ")
+    if err != nil {
+        return err
+    }
+    tempPath := path + ".tmp"
+    if err := os.WriteFile(tempPath, data, 0600); err != nil {
+        return err
+    }
+    if err := os.Rename(tempPath, path); err != nil {
+        return err
+    }
+    return nil
+}
+
+// Remove the checkpoint of a finished import, along with the work directory it kept (an -atomic
+// import has none)
+func clearCheckpoint(path string, checkpoint *importCheckpoint) {
+    if checkpoint != nil && checkpoint.WorkDir != "" {
+        tempfiles.Register(checkpoint.WorkDir)
+    }
+    if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+        log.Printf("Warning: failed to remove checkpoint %s: %v", path, err)
+    }
+}
+
+// Tell how to continue after a failure, when the import left the checkpoint at path
+func printResumeHint(path string) {
+    if _, err := os.Stat(path); err == nil {
+        fmt.Printf("Committed batches are recorded in %s, run the same command with -resume to continue\n", path)
+    }
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "github.com/ritiek/discord-to-simplex/pkg/simplexdb"
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+func checkpointMessages(t *testing.T, ids ...string) *universal.MessageQueue {
+    t.Helper()
+    messages := universal.NewMessageQueue(0, "")
+    for _, id := range ids {
+        if err := messages.Append(universal.Message{ID: id, Content: "message " + id, Timestamp: time.Now()}); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return messages
+}
+
+func indexCount(t *testing.T, db *sql.DB) int {
+    t.Helper()
+    var count int
+    if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name LIKE 'idx_%'").Scan(&count); err != nil {
+        t.Fatal(err)
+    }
+    return count
+}
+
+// An import whose first batch fails after the indexes were dropped leaves a checkpoint with
+// nothing committed; the next run goes on from it with -resume and starts over without, and
+// either way gets the indexes to build again
+func TestCheckpointOfFailedFirstBatch(t *testing.T) {
+    dir := t.TempDir()
+    db, err := sql.Open("sqlite3", filepath.Join(dir, "chat.db"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer db.Close()
+    schema := []string{
+        "CREATE TABLE chat_items (chat_item_id INTEGER PRIMARY KEY, contact_id INTEGER, item_ts TEXT)",
+        "CREATE INDEX idx_chat_items_ts ON chat_items (contact_id, item_ts)",
+        "CREATE TABLE messages (message_id INTEGER PRIMARY KEY, shared_msg_id BLOB)",
+        "CREATE INDEX idx_messages_shared_msg_id ON messages (shared_msg_id)",
+    }
+    for _, statement := range schema {
+        if _, err := db.Exec(statement); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    path := checkpointPath("", filepath.Join(dir, "chat.db"), "")
+    messages := checkpointMessages(t, "1", "2", "3")
+    checkpoint := &importCheckpoint{JSON: "export.json", Contact: "alice", Messages: messages.Len()}
+    dropped, err := simplexdb.DropIndexes(db)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(dropped) != 2 {
+        t.Fatalf("dropped %d indexes, want 2", len(dropped))
+    }
+    checkpoint.Indexes = dropped
+    if err := saveCheckpoint(path, checkpoint); err != nil {
+        t.Fatal(err)
+    }
+    all, err := messages.All()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := simplexdb.InsertMessages(context.Background(), db, all, 1, simplexdb.InsertOptions{ContactID: 1}); err == nil {
+        t.Fatal("the first batch went into a database without SimpleX's tables")
+    }
+
+    // The export changed since: nothing was committed, so it doesn't matter
+    changed := checkpointMessages(t, "1", "2", "3", "4")
+    resumed, leftover, err := openCheckpoint(path, true, nil, "export.json", "alice", false, changed)
+    if err != nil {
+        t.Fatalf("can't resume: %v", err)
+    }
+    if resumed == nil || len(leftover) != 2 {
+        t.Fatalf("resumed %+v with %d indexes to build, want the checkpoint with 2", resumed, len(leftover))
+    }
+
+    fresh := &importCheckpoint{JSON: "export.json", Contact: "alice", Messages: changed.Len()}
+    started, leftover, err := openCheckpoint(path, false, fresh, "export.json", "alice", false, changed)
+    if err != nil {
+        t.Fatalf("can't start over: %v", err)
+    }
+    if started != fresh || len(leftover) != 2 || len(started.Indexes) != 2 {
+        t.Fatalf("started over with %+v and %d indexes to build, want the fresh checkpoint with 2", started, len(leftover))
+    }
+    if err := simplexdb.RestoreIndexes(db, leftover); err != nil {
+        t.Fatal(err)
+    }
+    if count := indexCount(t, db); count != 2 {
+        t.Fatalf("%d indexes after rebuilding, want 2", count)
+    }
+
+    // An -atomic import keeps no checkpoint of its own but still builds the indexes
+    started, leftover, err = openCheckpoint(path, false, nil, "export.json", "alice", false, changed)
+    if err != nil || started != nil || len(leftover) != 2 {
+        t.Fatalf("atomic import got %+v and %d indexes (%v), want none and 2", started, len(leftover), err)
+    }
+}
+
+func TestOpenCheckpoint(t *testing.T) {
+    committed := importCheckpoint{JSON: "export.json", Contact: "alice", Messages: 3, Done: 2, LastMessageID: "2"}
+    tests := []struct {
+        name       string
+        checkpoint *importCheckpoint // Left by an earlier run, nil for none
+        resume     bool
+        messages   []string
+        wantErr    bool
+        wantFresh  bool
+    }{
+        {"no checkpoint", nil, false, []string{"1", "2", "3"}, false, true},
+        {"no checkpoint to resume", nil, true, []string{"1", "2", "3"}, true, false},
+        {"resume", &committed, true, []string{"1", "2", "3"}, false, false},
+        {"committed batches without -resume", &committed, false, []string{"1", "2", "3"}, true, false},
+        {"resume a changed export", &committed, true, []string{"1", "3", "4"}, true, false},
+        {"resume into another chat", &importCheckpoint{JSON: "export.json", Contact: "bob"}, true, []string{"1"}, true, false},
+        {"nothing committed", &importCheckpoint{JSON: "export.json", Contact: "alice", Messages: 5}, false, []string{"1", "2", "3"}, false, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            path := filepath.Join(t.TempDir(), "simplex.zip.checkpoint.json")
+            if tt.checkpoint != nil {
+                if err := saveCheckpoint(path, tt.checkpoint); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            messages := checkpointMessages(t, tt.messages...)
+            fresh := &importCheckpoint{JSON: "export.json", Contact: "alice", Messages: messages.Len()}
+            got, _, err := openCheckpoint(path, tt.resume, fresh, "export.json", "alice", false, messages)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("error %v, want one: %v", err, tt.wantErr)
+            }
+            if err == nil && (got == fresh) != tt.wantFresh {
+                t.Fatalf("got %+v, want the fresh checkpoint: %v", got, tt.wantFresh)
+            }
+        })
+    }
+}
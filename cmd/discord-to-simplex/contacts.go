@@ -0,0 +1,137 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "flag"
+    "fmt"
+    "os"
+    "text/tabwriter"
+
+    "github.com/ritiek/discord-to-simplex/pkg/tempfiles"
+)
+
+// Look up the -contact name, or with -contact-id the name of that contact, which is stored in
+// *name. Returns the contact ID
+func findContact(byName func(string) (int, error), byID func(int) (string, error), name *string, contactID int) (int, error) {
+    if contactID == 0 {
+        return byName(*name)
+    }
+    found, err := byID(contactID)
+    if err != nil {
+        return 0, err
+    }
+    *name = found
+    return contactID, nil
+}
+
+// list-contacts: show the contacts (and groups) of a SimpleX database with how many messages
+// each chat has, to find the name to pass to -contact or the ID for -contact-id. The database is
+// opened read-only
+func runListContacts(args []string) {
+    fs := flag.NewFlagSet("list-contacts", flag.ExitOnError)
+    var target simplexTarget
+    target.register(fs)
+    fs.Parse(args)
+    target.check()
+
+    cleanUpOnInterrupt()
+    defer tempfiles.Cleanup()
+
+    db, _, err := target.open(context.Background(), false)
+    if err != nil {
+        fatalf("%v", err)
+    }
+    defer db.Close()
+
+    contacts, err := listContacts(db)
+    if err != nil {
+        fatalf("Failed to list contacts: %v", err)
+    }
+
+    groups, err := listGroups(db)
+    if err != nil {
+        fatalf("Failed to list groups: %v", err)
+    }
+
+    out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(out, "ID\tNAME\tDISPLAY NAME\tFULL NAME\tPROFILE\tMESSAGES")
+    for _, contact := range contacts {
+        fmt.Fprintf(out, "%d\t%s\t%s\t%s\t%d\t%d\n", contact.ID, contact.LocalName, contact.DisplayName, contact.FullName, contact.UserID, contact.Messages)
+    }
+    out.Flush()
+
+    // Imports go into direct chats only, groups are listed to tell them apart
+    if len(groups) > 0 {
+        fmt.Println("\nGroups (not an import target):")
+        out = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+        fmt.Fprintln(out, "ID\tNAME\tMESSAGES")
+        for _, group := range groups {
+            fmt.Fprintf(out, "%d\t%s\t%d\n", group.ID, group.Name, group.Messages)
+        }
+        out.Flush()
+    }
+}
+
+// Contact as listed by list-contacts
+type contactRow struct {
+    ID          int
+    LocalName   string
+    DisplayName string
+    FullName    string
+    UserID      int // User profile the contact belongs to, for -profile
+    Messages    int // Chat items in the direct chat
+}
+
+// Group as listed by list-contacts
+type groupRow struct {
+    ID       int
+    Name     string
+    Messages int
+}
+
+// Contacts of a SimpleX database by name, leaving out deleted ones and the user's own
+func listContacts(db *sql.DB) ([]contactRow, error) {
+    rows, err := db.Query(`SELECT c.contact_id, c.local_display_name, COALESCE(cp.display_name, ''), COALESCE(cp.full_name, ''), c.user_id,
+                                  (SELECT COUNT(*) FROM chat_items ci WHERE ci.contact_id = c.contact_id)
+                           FROM contacts c
+                           LEFT JOIN contact_profiles cp ON c.contact_profile_id = cp.contact_profile_id
+                           WHERE c.deleted = 0 AND c.is_user = 0
+                           ORDER BY c.local_display_name`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var contacts []contactRow
+    for rows.Next() {
+        var contact contactRow
+        if err := rows.Scan(&contact.ID, &contact.LocalName, &contact.DisplayName, &contact.FullName, &contact.UserID, &contact.Messages); err != nil {
+            return nil, err
+        }
+        contacts = append(contacts, contact)
+    }
+    return contacts, rows.Err()
+}
+
+// Groups of a SimpleX database by name
+func listGroups(db *sql.DB) ([]groupRow, error) {
+    rows, err := db.Query(`SELECT g.group_id, g.local_display_name,
+                                  (SELECT COUNT(*) FROM chat_items ci WHERE ci.group_id = g.group_id)
+                           FROM groups g
+                           ORDER BY g.local_display_name`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var groups []groupRow
+    for rows.Next() {
+        var group groupRow
+        if err := rows.Scan(&group.ID, &group.Name, &group.Messages); err != nil {
+            return nil, err
+        }
+        groups = append(groups, group)
+    }
+    return groups, rows.Err()
+}
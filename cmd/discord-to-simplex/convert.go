@@ -0,0 +1,115 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/ritiek/discord-to-simplex/pkg/discord"
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// convert: turn an export into universal messages and write them out as JSON, for checking a
+// conversion or feeding other tools. Nothing is downloaded and no SimpleX database is needed
+func runConvert(args []string) {
+    fs := flag.NewFlagSet("convert", flag.ExitOnError)
+    var transforms, redact, dropMatching stringList
+    jsonFilePath := fs.String("json", "", "Path to the export file (required)")
+    sourcePlatform := fs.String("source", "discord", "Platform the -json export comes from: "+strings.Join(universal.SourcePlatforms(), ", "))
+    myUsername := fs.String("me", "", "Your username on the platform, to mark your messages as sent")
+    outputPath := fs.String("output", "", "Write the JSON to this file (defaults to standard output)")
+    timezone := fs.String("timezone", "Local", "IANA time zone used to render Discord timestamp markup and for export times without a UTC offset")
+    importDeleted := fs.Bool("import-deleted", false, "Keep messages marked deleted in the export")
+    customEmojiMode := fs.String("custom-emoji", discord.CustomEmojiText, "How to convert reactions with Discord custom emoji: text, unicode or skip")
+    pinnedMode := fs.String("pinned", discord.PinnedNone, "How to mark pinned Discord messages: marker or none")
+    missingReplies := fs.String("missing-replies", discord.MissingRepliesPlaceholder, "What replies to messages outside the export quote: placeholder or drop")
+    after := fs.String("after", "", "Only convert messages from this time (RFC3339) or date (YYYY-MM-DD) on")
+    before := fs.String("before", "", "Only convert messages before this time (RFC3339) or date (YYYY-MM-DD)")
+    skipBots := fs.Bool("skip-bots", false, "Leave out messages of bots, webhooks included")
+    skipWebhooks := fs.Bool("skip-webhooks", false, "Leave out messages posted through webhooks")
+    strict := fs.Bool("strict", false, "Stop when a message of the export can't be read in full instead of leaving it out")
+    parseErrorsPath := fs.String("parse-errors", "", "Write the messages that can't be read in full, with the reasons, to this file (defaults to the -json file with .errors.jsonl appended)")
+    fs.Var(&redact, "redact", "Replace matches of a regular expression in message text, given as pattern=replacement, may be given more than once")
+    fs.Var(&dropMatching, "drop-matching", "Leave out messages whose text matches this regular expression, may be given more than once")
+    fs.Var(&transforms, "transform", "Run every message through this program (or .wasm module), may be given more than once")
+    fs.Parse(args)
+
+    if *jsonFilePath == "" {
+        log.Fatal("JSON file path is required. Use -json flag.")
+    }
+    checkConvertModes(*customEmojiMode, *pinnedMode, *missingReplies)
+    location, err := time.LoadLocation(*timezone)
+    if err != nil {
+        log.Fatalf("Invalid time zone '%s': %v", *timezone, err)
+    }
+    filter := messageFilter{
+        ImportDeleted: *importDeleted,
+        Transforms:    transforms,
+        After:         parseTimeFlag("after", *after, location),
+        Before:        parseTimeFlag("before", *before, location),
+        SkipBots:      *skipBots,
+        SkipWebhooks:  *skipWebhooks,
+        Strict:        *strict,
+        ParseErrors:   parseErrorsFile(*parseErrorsPath, *jsonFilePath),
+    }
+    filter.Redactions, filter.DropMatching = parseContentRules(redact, dropMatching)
+
+    // Progress goes to stderr so standard output only has the JSON
+    out := os.Stdout
+    os.Stdout = os.Stderr
+    if *outputPath != "" {
+        out, err = os.Create(*outputPath)
+        if err != nil {
+            log.Fatalf("Failed to create output file: %v", err)
+        }
+    }
+
+    loaded, err := loadMessages(context.Background(), *sourcePlatform, universal.SourceConfig{
+        Path:       *jsonFilePath,
+        MyUsername: *myUsername,
+        Location:   location,
+        Options: map[string]string{
+            "custom-emoji":    *customEmojiMode,
+            "pinned":          *pinnedMode,
+            "missing-replies": *missingReplies,
+        },
+    }, filter)
+    if err != nil {
+        log.Fatalf("%v", err)
+    }
+    if loaded.SkippedDeleted > 0 {
+        fmt.Printf("Skipped %d deleted messages (use -import-deleted to keep them)\n", loaded.SkippedDeleted)
+    }
+    if loaded.OutOfRange > 0 {
+        fmt.Printf("Skipped %d messages outside -after/-before\n", loaded.OutOfRange)
+    }
+    if loaded.SkippedBots > 0 {
+        fmt.Printf("Skipped %d bot messages\n", loaded.SkippedBots)
+    }
+    if loaded.Matching > 0 {
+        fmt.Printf("Skipped %d messages matching -drop-matching\n", loaded.Matching)
+    }
+    if loaded.Redacted > 0 {
+        fmt.Printf("Redacted %d messages\n", loaded.Redacted)
+    }
+    printSanitized(loaded.Sanitized)
+
+    messages, err := loaded.Messages.All()
+    if err != nil {
+        log.Fatalf("%v", err)
+    }
+    encoder := json.NewEncoder(out)
+    encoder.SetIndent("", "  ")
+    if err := encoder.Encode(messages); err != nil {
+        log.Fatalf("Failed to write messages: %v", err)
+    }
+    if err := out.Close(); err != nil {
+        log.Fatalf("Failed to write messages: %v", err)
+    }
+    fmt.Printf("Converted %d messages\n", len(messages))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "runtime"
+    "strconv"
+    "strings"
+)
+
+// SimpleX Desktop data directories for the current OS, most common first
+func desktopDataDirCandidates() []string {
+    home, _ := os.UserHomeDir()
+    switch runtime.GOOS {
+    case "darwin":
+        return []string{filepath.Join(home, "Library", "Application Support", "SimpleX")}
+    case "windows":
+        return []string{filepath.Join(os.Getenv("APPDATA"), "SimpleX")}
+    default:
+        dataHome := os.Getenv("XDG_DATA_HOME")
+        if dataHome == "" {
+            dataHome = filepath.Join(home, ".local", "share")
+        }
+        return []string{
+            filepath.Join(dataHome, "simplex"),
+            // Flatpak keeps app data in its own sandbox
+            filepath.Join(home, ".var", "app", "chat.simplex.simplex", "data", "simplex"),
+        }
+    }
+}
+
+// Find the SimpleX Desktop data directory holding the chat database
+func findDesktopDataDir() (string, error) {
+    candidates := desktopDataDirCandidates()
+    for _, dir := range candidates {
+        if _, err := os.Stat(filepath.Join(dir, "simplex_v1_chat.db")); err == nil {
+            return dir, nil
+        }
+    }
+    return "", fmt.Errorf("no SimpleX Desktop database found in %s", strings.Join(candidates, ", "))
+}
+
+// Whether SimpleX Desktop seems to be running; writing to its database while it's open
+// would race with the app and get overwritten or corrupt it
+func simplexDesktopRunning() (bool, error) {
+    var names []string
+    switch runtime.GOOS {
+    case "linux":
+        entries, err := os.ReadDir("/proc")
+        if err != nil {
+            return false, err
+        }
+        for _, entry := range entries {
+            if _, err := strconv.Atoi(entry.Name()); err != nil {
+                continue
+            }
+            if cmdline, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "cmdline")); err == nil {
+                names = append(names, strings.SplitN(string(cmdline), "\x00", 2)[0])
+            }
+        }
+    case "windows":
+        output, err := exec.Command("tasklist", "/FO", "CSV", "/NH").Output()
+        if err != nil {
+            return false, err
+        }
+        for _, line := range strings.Split(string(output), "\n") {
+            names = append(names, strings.SplitN(strings.Trim(line, "\""), "\",", 2)[0])
+        }
+    default:
+        output, err := exec.Command("ps", "-axo", "comm").Output()
+        if err != nil {
+            return false, err
+        }
+        names = strings.Split(string(output), "\n")
+    }
+
+    for _, name := range names {
+        base := strings.ToLower(filepath.Base(strings.TrimSpace(name)))
+        if strings.HasPrefix(base, "simplex") && !strings.Contains(base, "discord-to-simplex") {
+            return true, nil
+        }
+    }
+    return false, nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "sort"
+    "text/tabwriter"
+
+    "github.com/ritiek/discord-to-simplex/pkg/simplexdb"
+    "github.com/ritiek/discord-to-simplex/pkg/tempfiles"
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// diff: compare two SimpleX databases, e.g. the archive before an import and the one it wrote:
+// schema version, rows per table, item counts per chat and the files directory. Both are only read
+func runDiff(args []string) {
+    fs := flag.NewFlagSet("diff", flag.ExitOnError)
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "Usage: discord-to-simplex diff [flags] <before> <after>")
+        fmt.Fprintln(fs.Output(), "\nEach of before and after is a SimpleX export ZIP, an extracted export directory or a database file.")
+        fs.PrintDefaults()
+    }
+    var before simplexTarget
+    before.registerAccess(fs)
+    fs.Parse(args)
+    if fs.NArg() != 2 {
+        fs.Usage()
+        os.Exit(2)
+    }
+    before.checkAccess()
+
+    cleanUpOnInterrupt()
+    defer tempfiles.Cleanup()
+
+    after := before
+    before.setPath(fs.Arg(0))
+    after.setPath(fs.Arg(1))
+
+    beforeDB, beforeFiles, err := before.open(context.Background(), true)
+    if err != nil {
+        fatalf("Failed to open %s: %v", fs.Arg(0), err)
+    }
+    defer beforeDB.Close()
+
+    // Both usually share the password, so only ask again if it doesn't open the second one
+    after.password = before.password
+    afterDB, afterFiles, err := after.open(context.Background(), true)
+    if err != nil {
+        after.password = ""
+        afterDB, afterFiles, err = after.open(context.Background(), true)
+    }
+    if err != nil {
+        fatalf("Failed to open %s: %v", fs.Arg(1), err)
+    }
+    defer afterDB.Close()
+
+    fmt.Printf("Before: %s\nAfter:  %s\n", fs.Arg(0), fs.Arg(1))
+    diffSchema(beforeDB, afterDB)
+    diffTables(beforeDB, afterDB)
+    diffChats(beforeDB, afterDB)
+    diffFiles(beforeFiles, afterFiles)
+}
+
+func diffSchema(beforeDB, afterDB *sql.DB) {
+    version := func(db *sql.DB) string {
+        schema, err := simplexdb.ReadSchemaVersion(db)
+        if err != nil {
+            fatalf("%v", err)
+        }
+        return schema.String()
+    }
+    beforeVersion, afterVersion := version(beforeDB), version(afterDB)
+    if beforeVersion == afterVersion {
+        fmt.Printf("\nSchema version: %s, unchanged\n", beforeVersion)
+    } else {
+        fmt.Printf("\nSchema version: %s -> %s\n", beforeVersion, afterVersion)
+    }
+}
+
+// Row counts of the tables that differ. NEW ROWS counts rows past the highest rowid of before,
+// which shows inserts even when as many rows were deleted
+func diffTables(beforeDB, afterDB *sql.DB) {
+    beforeTables, afterTables := tableNames(beforeDB), tableNames(afterDB)
+    names := make(map[string]bool)
+    for name := range beforeTables {
+        names[name] = true
+    }
+    for name := range afterTables {
+        names[name] = true
+    }
+    sorted := make([]string, 0, len(names))
+    for name := range names {
+        sorted = append(sorted, name)
+    }
+    sort.Strings(sorted)
+
+    fmt.Println("\nTables:")
+    out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(out, "TABLE\tBEFORE\tAFTER\tCHANGE\tNEW ROWS")
+    unchanged := 0
+    for _, name := range sorted {
+        switch {
+        case !afterTables[name]:
+            fmt.Fprintf(out, "%s\t%d\t-\tremoved\t-\n", name, countRows(beforeDB, name))
+        case !beforeTables[name]:
+            rows := countRows(afterDB, name)
+            fmt.Fprintf(out, "%s\t-\t%d\tadded\t%d\n", name, rows, rows)
+        default:
+            beforeRows, afterRows := countRows(beforeDB, name), countRows(afterDB, name)
+            newRows := "-"
+            var maxRowID sql.NullInt64
+            if err := beforeDB.QueryRow(fmt.Sprintf(`SELECT MAX(rowid) FROM "%s"`, name)).Scan(&maxRowID); err == nil {
+                var n int
+                if err := afterDB.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM "%s" WHERE rowid > ?`, name), maxRowID.Int64).Scan(&n); err == nil {
+                    newRows = fmt.Sprint(n)
+                }
+            }
+            if beforeRows == afterRows && (newRows == "-" || newRows == "0") {
+                unchanged++
+                continue
+            }
+            fmt.Fprintf(out, "%s\t%d\t%d\t%+d\t%s\n", name, beforeRows, afterRows, afterRows-beforeRows, newRows)
+        }
+    }
+    out.Flush()
+    fmt.Printf("%d tables unchanged\n", unchanged)
+}
+
+// Names of the tables of a database, SQLite's own left out
+func tableNames(db *sql.DB) map[string]bool {
+    rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'")
+    if err != nil {
+        fatalf("Failed to list tables: %v", err)
+    }
+    defer rows.Close()
+
+    names := make(map[string]bool)
+    for rows.Next() {
+        var name string
+        if err := rows.Scan(&name); err != nil {
+            fatalf("Failed to list tables: %v", err)
+        }
+        names[name] = true
+    }
+    return names
+}
+
+func countRows(db *sql.DB, table string) int {
+    var n int
+    if err := db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM "%s"`, table)).Scan(&n); err != nil {
+        fatalf("Failed to count rows of %s: %v", table, err)
+    }
+    return n
+}
+
+// Chats that were added, removed or whose number of items changed
+func diffChats(beforeDB, afterDB *sql.DB) {
+    beforeChats, err := listChats(beforeDB)
+    if err != nil {
+        fatalf("Failed to read chats: %v", err)
+    }
+    afterChats, err := listChats(afterDB)
+    if err != nil {
+        fatalf("Failed to read chats: %v", err)
+    }
+
+    key := func(chat chatSummary) string {
+        return fmt.Sprintf("%s\x00%d\x00%s", chat.Kind, chat.UserID, chat.Name)
+    }
+    beforeByKey := make(map[string]chatSummary)
+    for _, chat := range beforeChats {
+        beforeByKey[key(chat)] = chat
+    }
+
+    fmt.Println("\nChats:")
+    out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(out, "TYPE\tNAME\tUSER\tBEFORE\tAFTER\tCHANGE\tLAST ITEM")
+    unchanged := 0
+    for _, chat := range afterChats {
+        old, found := beforeByKey[key(chat)]
+        delete(beforeByKey, key(chat))
+        switch {
+        case !found:
+            fmt.Fprintf(out, "%s\t%s\t%d\t-\t%d\tadded\t%s\n", chat.Kind, chat.Name, chat.UserID, chat.Items, chat.LastItem)
+        case old.Items != chat.Items || old.LastItem != chat.LastItem:
+            fmt.Fprintf(out, "%s\t%s\t%d\t%d\t%d\t%+d\t%s\n", chat.Kind, chat.Name, chat.UserID, old.Items, chat.Items, chat.Items-old.Items, chat.LastItem)
+        default:
+            unchanged++
+        }
+    }
+    for _, chat := range beforeChats {
+        if _, removed := beforeByKey[key(chat)]; removed {
+            fmt.Fprintf(out, "%s\t%s\t%d\t%d\t-\tremoved\t%s\n", chat.Kind, chat.Name, chat.UserID, chat.Items, chat.LastItem)
+        }
+    }
+    out.Flush()
+    fmt.Printf("%d chats unchanged\n", unchanged)
+}
+
+func diffFiles(beforeDir, afterDir string) {
+    usage := func(dir string) string {
+        files, size, err := dirUsage(dir)
+        switch {
+        case os.IsNotExist(err):
+            return "none"
+        case err != nil:
+            log.Printf("Warning: failed to read files directory %s: %v", dir, err)
+            return "unreadable"
+        }
+        return fmt.Sprintf("%d files, %s", files, universal.FormatByteSize(size))
+    }
+    fmt.Printf("\nFiles directory: %s -> %s\n", usage(beforeDir), usage(afterDir))
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "sort"
+
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// Print what -dry-run found: the items per type, the attachment files that would be copied
+// and the ones that can't be found
+func printDryRunReport(messages *universal.MessageQueue, jsonDir string) {
+    types := make(map[string]int)
+    sent, deleted, quotes, forwarded, reactions := 0, 0, 0, 0, 0
+    eachMessages(messages, func(chunk []universal.Message) {
+        for _, msg := range chunk {
+            types[msg.MessageType]++
+            if msg.IsSent {
+                sent++
+            }
+            if msg.IsDeleted {
+                deleted++
+            }
+            if msg.QuotedMessage != nil {
+                quotes++
+            }
+            if msg.ForwardedFrom != nil {
+                forwarded++
+            }
+            for _, reaction := range msg.Reactions {
+                reactions += reaction.Count
+            }
+        }
+    })
+
+    fmt.Println()
+    fmt.Println("Dry run report")
+    fmt.Printf("Items: %d (%d sent, %d received)\n", messages.Len(), sent, messages.Len()-sent)
+    typeNames := make([]string, 0, len(types))
+    for messageType := range types {
+        typeNames = append(typeNames, messageType)
+    }
+    sort.Strings(typeNames)
+    for _, messageType := range typeNames {
+        fmt.Printf("  %s: %d\n", messageType, types[messageType])
+    }
+    fmt.Printf("Quotes: %d\n", quotes)
+    fmt.Printf("Reactions: %d\n", reactions)
+    if forwarded > 0 {
+        fmt.Printf("Forwarded: %d\n", forwarded)
+    }
+    if deleted > 0 {
+        fmt.Printf("Marked deleted: %d\n", deleted)
+    }
+
+    var totalSize int64
+    var files, missing []string
+    eachMessages(messages, func(chunk []universal.Message) {
+        for _, msg := range chunk {
+            for _, attachment := range msg.Attachments {
+                path := universal.ResolveExportPath(jsonDir, attachment.URL)
+                info, err := os.Stat(path)
+                if err != nil {
+                    missing = append(missing, path)
+                    continue
+                }
+                totalSize += info.Size()
+                files = append(files, fmt.Sprintf("%s (%s)", attachment.Filename, universal.FormatByteSize(info.Size())))
+            }
+        }
+    })
+
+    fmt.Printf("Files to copy: %d, %s\n", len(files), universal.FormatByteSize(totalSize))
+    for _, file := range files {
+        fmt.Printf("  %s\n", file)
+    }
+    if len(missing) > 0 {
+        fmt.Printf("Warning: %d attachments can't be found and would be imported without their file:\n", len(missing))
+        for _, path := range missing {
+            fmt.Printf("  %s\n", path)
+        }
+    }
+}
@@ -0,0 +1,1214 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "database/sql"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/ritiek/discord-to-simplex/pkg/archive"
+    "github.com/ritiek/discord-to-simplex/pkg/discord"
+    "github.com/ritiek/discord-to-simplex/pkg/media"
+    "github.com/ritiek/discord-to-simplex/pkg/progress"
+    "github.com/ritiek/discord-to-simplex/pkg/simplexchat"
+    "github.com/ritiek/discord-to-simplex/pkg/simplexdb"
+    "github.com/ritiek/discord-to-simplex/pkg/tempfiles"
+    "github.com/ritiek/discord-to-simplex/pkg/timing"
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// An import as it goes through its phases, each picking up what the ones before left here
+type importer struct {
+    importOptions
+    ctx    context.Context
+    report *importReport
+
+    // The export, converted
+    info           universal.SourceInfo
+    messages       *universal.MessageQueue
+    skippedDeleted int
+    jsonDir        string                   // Directory of the export, for relative paths
+    contactProfile simplexdb.ContactProfile // What of the contact's profile comes from the export
+
+    // The database and the chat in it
+    password        string
+    checkpoint      *importCheckpoint // nil for imports that keep none
+    resumePath      string
+    leftoverIndexes []simplexdb.Index // Dropped by an earlier run that stopped, built again by this one
+    dbPath          string
+    filesDir        string
+    extractedDir    string // Archive extracted for the import, "" when there's none
+    db              *sql.DB
+    userID          int
+    contactID       int
+    startMessageID  int
+    nextMessageID   int
+    reactedImported []universal.Message // Left out as imported before, with reactions to add to them
+    script          *sqlScript
+}
+
+// Run the phases one after another. Whatever goes wrong, interrupts included, returns from
+// here, and each phase undoes what it set up in defers on the way out
+func (im *importer) run() (err error) {
+    if err := im.startReport(); err != nil {
+        return err
+    }
+    if im.target.bridgeMode {
+        return im.runBridge()
+    }
+    if err := im.runPhases(im.loadSource, im.prepareMedia); err != nil {
+        return err
+    }
+    if im.target.liveURL != "" {
+        return im.sendLive()
+    }
+
+    defer func() {
+        if im.db != nil {
+            im.db.Close()
+        }
+        if err != nil && im.checkpoint != nil {
+            printResumeHint(im.resumePath)
+        }
+    }()
+    return im.runPhases(im.locateDatabase, im.openChat, im.skipImported, im.writeChat, im.writeOutput)
+}
+
+// Run phases until one fails, stopping in between when interrupted
+func (im *importer) runPhases(phases ...func() error) error {
+    for _, phase := range phases {
+        if err := im.ctx.Err(); err != nil {
+            return err
+        }
+        if err := phase(); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Start timing the import and its report, with what goes where
+func (im *importer) startReport() error {
+    activeTimings = timing.New()
+    im.report = startReport(im.output.reportPath)
+    if im.output.pprofTarget != "" {
+        if err := startProfiling(im.output.pprofTarget); err != nil {
+            return fmt.Errorf("-pprof: %w", err)
+        }
+    }
+    report, target := im.report, &im.target
+    report.Source.Platform = im.source.platform
+    report.Source.Path = im.source.jsonPath
+    report.Target.Contact = target.contactName
+    switch {
+    case im.output.sqlPath != "":
+        report.Target.Kind, report.Target.Output = "sql", im.output.sqlPath
+    case target.liveURL != "":
+        report.Target.Kind, report.Target.Path = "live", target.liveURL
+    case target.androidMode:
+        report.Target.Kind, report.Target.Path = "android", target.androidDir
+    case target.desktopMode:
+        report.Target.Kind, report.Target.Path = "desktop", target.directDBPath
+    case target.directDBPath != "":
+        report.Target.Kind, report.Target.Path = "db", target.directDBPath
+    case target.exportDirPath != "":
+        report.Target.Kind, report.Target.Path = "dir", target.exportDirPath
+    default:
+        report.Target.Kind, report.Target.Path = "zip", target.zipPath
+    }
+    return nil
+}
+
+// -bridge: mirror new messages of the Discord channel into the running simplex-chat until stopped
+func (im *importer) runBridge() error {
+    source, target := &im.source, &im.target
+    client, err := simplexchat.Dial(im.ctx, target.liveURL)
+    if err != nil {
+        return err
+    }
+    defer client.Close()
+
+    contactID, err := findContact(client.ContactID, client.ContactName, &target.contactName, target.contactID)
+    if err != nil {
+        return fmt.Errorf("failed to find contact: %w", err)
+    }
+    fmt.Printf("Contact: %s (ID: %d)\n", target.contactName, contactID)
+    im.report.Target.Contact = target.contactName
+
+    if target.bridgeStatePath == "" {
+        target.bridgeStatePath = filepath.Join(im.media.cacheDir, "bridge-"+source.discordChannelID+".json")
+    }
+    sender := simplexchat.NewLiveSender(client, contactID, ".", source.location)
+    sender.ReactionEmoji = source.reactionEmoji
+    err = runDiscordBridge(im.ctx, discord.NewAPIClient(source.discordToken, source.discordUserToken), sender, BridgeConfig{
+        ChannelID:    source.discordChannelID,
+        StatePath:    target.bridgeStatePath,
+        MyUsername:   source.myUsername,
+        CacheDir:     im.media.cacheDir,
+        PollInterval: target.pollInterval,
+        Convert: discord.ConvertOptions{
+            Location:        source.location,
+            ChannelNames:    map[string]string{},
+            RoleNames:       map[string]string{},
+            CustomEmojiMode: source.customEmojiMode,
+            PinnedMode:      source.pinnedMode,
+            MissingReplies:  source.missingReplies,
+        },
+    })
+    if err != nil {
+        return fmt.Errorf("bridge stopped: %w", err)
+    }
+    return nil
+}
+
+// Load the export (or, for Discord, fetch the same from the API) and convert it
+func (im *importer) loadSource() error {
+    source, report := &im.source, im.report
+    sourceOptions := map[string]string{
+        "custom-emoji":    source.customEmojiMode,
+        "pinned":          source.pinnedMode,
+        "missing-replies": source.missingReplies,
+    }
+    if source.discordChannelID != "" {
+        sourceOptions["channel"] = source.discordChannelID
+        sourceOptions["token"] = source.discordToken
+        sourceOptions["user-token"] = strconv.FormatBool(source.discordUserToken)
+    }
+    loaded, err := loadMessages(im.ctx, source.platform, universal.SourceConfig{
+        Path:       source.jsonPath,
+        MyUsername: source.myUsername,
+        Location:   source.location,
+        Options:    sourceOptions,
+    }, source.filter)
+    if err != nil {
+        return err
+    }
+    im.info, im.messages, im.skippedDeleted = loaded.Info, loaded.Messages, loaded.SkippedDeleted
+    report.Source.Chat = im.info.ChatName
+    report.Source.Messages = im.info.MessageCount
+    report.Skipped.Deleted = im.skippedDeleted
+    report.Skipped.OutOfRange = loaded.OutOfRange
+    if loaded.OutOfRange > 0 {
+        fmt.Printf("Skipped %d messages outside -after/-before\n", loaded.OutOfRange)
+    }
+    report.Skipped.Unreadable = countSkippedParseErrors(loaded.ParseErrors)
+    report.Skipped.Bots = loaded.SkippedBots
+    if loaded.SkippedBots > 0 {
+        fmt.Printf("Skipped %d bot messages\n", loaded.SkippedBots)
+    }
+    report.Skipped.Matching = loaded.Matching
+    if loaded.Matching > 0 {
+        fmt.Printf("Skipped %d messages matching -drop-matching\n", loaded.Matching)
+    }
+    report.Imported.Redacted = loaded.Redacted
+    if loaded.Redacted > 0 {
+        fmt.Printf("Redacted %d messages\n", loaded.Redacted)
+    }
+    report.Imported.Sanitized = loaded.Sanitized.Messages
+    report.Imported.SanitizedFiles = loaded.Sanitized.Files
+    printSanitized(loaded.Sanitized)
+    report.Skipped.DroppedByTransforms = loaded.Dropped
+    fmt.Printf("Your username: %s\n", source.myUsername)
+    fmt.Printf("Batch size: %d\n\n", im.safety.batchSize)
+
+    // Directory containing the export, for relative path resolution
+    im.jsonDir = im.info.BaseDir
+    fmt.Printf("JSON directory: %s\n", im.jsonDir)
+    return nil
+}
+
+// Make the attachments ready to import: one message each, within -max-attachment-size,
+// downloaded, converted and stripped as asked
+func (im *importer) prepareMedia() error {
+    opts, messages, report := &im.media, im.messages, im.report
+
+    // Text-only imports drop attachments before splitting, so every Discord message stays one item
+    // and none of the media steps below have anything to do. When the messages are spilled to
+    // disk, each step goes through them a chunk at a time
+    if opts.noAttachments {
+        dropped := 0
+        updateMessages(messages, func(chunk []universal.Message) []universal.Message {
+            dropped += universal.DropAttachments(chunk)
+            return chunk
+        })
+        report.Skipped.Attachments = dropped
+        fmt.Printf("Text-only import: skipped %d attachments\n", dropped)
+    }
+
+    // Its author is picked before attachments become messages of their own, which would count too
+    im.fillContactProfile()
+
+    updateMessages(messages, universal.SplitMultiAttachmentMessages)
+
+    // Oversized attachments are dropped before anything gets downloaded
+    if opts.maxAttachmentSize != "" {
+        skipped := 0
+        updateMessages(messages, func(chunk []universal.Message) []universal.Message {
+            skipped += universal.ApplyAttachmentSizeLimit(chunk, opts.maxAttachmentBytes)
+            return chunk
+        })
+        report.Skipped.OversizedAttachments = skipped
+        if skipped > 0 {
+            fmt.Printf("Skipped %d attachments larger than %s\n", skipped, universal.FormatByteSize(opts.maxAttachmentBytes))
+        }
+    }
+
+    // Exports made without --media reference attachments on the Discord CDN
+    var cacheBar *progress.Bar
+    remote := false
+    eachMessages(messages, func(chunk []universal.Message) {
+        remote = remote || hasRemoteAttachments(chunk)
+    })
+    downloaded, cached := 0, 0
+    if remote {
+        cacheBar = progress.New("Downloading attachments", messages.Len())
+        timed := activeTimings.Start("media: downloading attachments")
+        updateMessages(messages, func(chunk []universal.Message) []universal.Message {
+            chunkDownloaded, chunkCached := media.CacheRemoteAttachments(im.ctx, chunk, opts.cacheDir, cacheBar)
+            downloaded, cached = downloaded+chunkDownloaded, cached+chunkCached
+            return chunk
+        })
+        timed()
+    }
+    cacheBar.Finish()
+    if downloaded > 0 || cached > 0 {
+        fmt.Printf("Remote attachments: %d downloaded, %d already cached in %s\n", downloaded, cached, opts.cacheDir)
+    }
+
+    if im.skippedDeleted > 0 {
+        fmt.Printf("Skipped %d deleted messages (use -import-deleted to import them as tombstones)\n", im.skippedDeleted)
+    }
+
+    // Converted images and transcoded audio are written to a temporary media directory
+    mediaDir, err := os.MkdirTemp(tempfiles.Root, "simplex_media_")
+    if err != nil {
+        return fmt.Errorf("failed to create temp directory for converted media: %w", err)
+    }
+    tempfiles.Register(mediaDir)
+
+    if opts.convertImages != media.ImageConvertNone {
+        bar := progress.New("Converting images", messages.Len())
+        timed := activeTimings.Start("media: converting images")
+        converted := 0
+        updateMessages(messages, func(chunk []universal.Message) []universal.Message {
+            converted += media.ConvertImageAttachments(chunk, im.jsonDir, mediaDir, opts.convertImages, bar)
+            return chunk
+        })
+        timed()
+        bar.Finish()
+        if converted > 0 {
+            fmt.Printf("Converted %d webp/heic images to %s\n", converted, opts.convertImages)
+        }
+    }
+
+    if opts.stripMetadata && !opts.noAttachments {
+        bar := progress.New("Stripping metadata", messages.Len())
+        timed := activeTimings.Start("media: stripping metadata")
+        stripped := 0
+        updateMessages(messages, func(chunk []universal.Message) []universal.Message {
+            stripped += media.StripImageMetadata(chunk, im.jsonDir, mediaDir, bar)
+            return chunk
+        })
+        timed()
+        bar.Finish()
+        fmt.Printf("Stripped metadata from %d images\n", stripped)
+    }
+
+    if opts.transcodeAudio && !opts.noAttachments {
+        bar := progress.New("Transcoding voice messages", messages.Len())
+        timed := activeTimings.Start("media: transcoding voice messages")
+        transcoded := 0
+        err := messages.Update(func(chunk []universal.Message) ([]universal.Message, error) {
+            chunkTranscoded, err := media.TranscodeVoiceAttachments(im.ctx, chunk, im.jsonDir, mediaDir, bar)
+            transcoded += chunkTranscoded
+            return chunk, err
+        })
+        timed()
+        bar.Finish()
+        if err != nil {
+            return fmt.Errorf("failed to transcode voice messages: %w", err)
+        }
+        fmt.Printf("Transcoded %d voice messages to m4a\n", transcoded)
+    }
+    return nil
+}
+
+// Fill in the contact's profile from the other side of the chat, for the import to set once the
+// contact is found in the database
+func (im *importer) fillContactProfile() {
+    target := &im.target
+    importAvatar := !im.media.noAvatar && !im.media.noAttachments
+    if !(importAvatar || target.updateContactName != "" || target.contactIdentity) || im.safety.dryRun || target.liveURL != "" {
+        return
+    }
+    author, ok := contactAuthor(im.messages)
+    if !ok {
+        return
+    }
+    name := profileDisplayName(author.DisplayName)
+    if name == "" {
+        name = profileDisplayName(author.Username)
+    }
+    if target.updateContactName == "display" || target.updateContactName == "both" {
+        im.contactProfile.DisplayName = name
+    }
+    if target.updateContactName == "full" || target.updateContactName == "both" {
+        im.contactProfile.FullName = name
+    }
+    if target.contactIdentity {
+        identity := platformIdentity(im.source.platform, author)
+        if im.contactProfile.FullName != "" {
+            identity = im.contactProfile.FullName + ", " + identity
+        }
+        im.contactProfile.FullName = identity
+    }
+
+    if avatarURL := author.AvatarURL; importAvatar && avatarURL != nil && *avatarURL != "" {
+        timed := activeTimings.Start("media: contact avatar")
+        var err error
+        im.contactProfile.Image, err = media.ContactAvatar(im.ctx, *avatarURL, im.jsonDir, im.media.cacheDir)
+        timed()
+        if err != nil {
+            log.Printf("Warning: failed to import the contact's avatar: %v", err)
+        }
+    }
+}
+
+// -live: send the messages through the running simplex-chat instead of writing a database
+func (im *importer) sendLive() error {
+    target, messages, report := &im.target, im.messages, im.report
+    client, err := simplexchat.Dial(im.ctx, target.liveURL)
+    if err != nil {
+        return err
+    }
+    defer client.Close()
+
+    contactID, err := findContact(client.ContactID, client.ContactName, &target.contactName, target.contactID)
+    if err != nil {
+        return fmt.Errorf("failed to find contact: %w", err)
+    }
+    fmt.Printf("Contact: %s (ID: %d)\n", target.contactName, contactID)
+    report.Target.Contact = target.contactName
+    report.Target.ContactID = contactID
+    report.setMessages(messages)
+    report.Insert = nil
+
+    if im.safety.dryRun {
+        printDryRunReport(messages, im.jsonDir)
+        fmt.Printf("Dry run: would send %d messages to %s through %s\n", messages.Len(), target.contactName, target.liveURL)
+        report.finish("dry-run")
+        return nil
+    }
+
+    fmt.Printf("Sending %d messages through %s...\n", messages.Len(), target.liveURL)
+    timed := activeTimings.Start("sending")
+    sent, err := simplexchat.ImportMessages(im.ctx, client, messages, contactID, im.jsonDir, im.source.location, im.source.reactionEmoji)
+    timed()
+    if err != nil {
+        return fmt.Errorf("live import stopped after %d messages: %w", sent, err)
+    }
+    fmt.Printf("Import complete! Sent %d messages.\n", sent)
+    report.finish("complete")
+    return nil
+}
+
+// Get the database ready to open: pulled from the device, extracted from the archive or found
+// where it is, and backed up; with the checkpoint of the import and its password
+func (im *importer) locateDatabase() error {
+    target, safety, output := &im.target, &im.safety, &im.output
+    if target.androidMode {
+        androidTempDir, err := os.MkdirTemp(tempfiles.Root, "simplex_android_")
+        if err != nil {
+            return fmt.Errorf("failed to create temp directory: %w", err)
+        }
+        tempfiles.Register(androidTempDir)
+
+        target.zipPath, err = pullAndroidExport(target.androidDir, androidTempDir)
+        if err != nil {
+            return fmt.Errorf("failed to pull SimpleX export from the device: %w", err)
+        }
+    }
+
+    // Set default output path if not provided
+    if output.zipPath == "" && target.zipPath != "" && !output.inPlace && output.dirPath == "" {
+        dir := filepath.Dir(target.zipPath)
+        base := filepath.Base(target.zipPath)
+        ext := filepath.Ext(base)
+        name := base[:len(base)-len(ext)]
+        output.zipPath = filepath.Join(dir, name+"_updated"+ext)
+    }
+
+    // Get database password from the given source, the environment or a prompt
+    var err error
+    im.password, err = resolvePassword(target.keyFile, target.keyCmd, target.keyringService)
+    if err != nil {
+        return fmt.Errorf("failed to get database password: %w", err)
+    }
+    if im.password == "" {
+        return fmt.Errorf("database password is required")
+    }
+
+    if err := im.loadCheckpoint(); err != nil {
+        return err
+    }
+
+    if target.exportDirPath != "" {
+        // Already extracted: update the directory itself, zipping it afterwards only if -output is set
+        im.dbPath, im.filesDir, err = archive.Locate(target.exportDirPath)
+        if err != nil {
+            return fmt.Errorf("failed to find SimpleX database: %w", err)
+        }
+    } else if target.directDBPath != "" {
+        // Work on the database directly, there is nothing to extract or zip up again
+        if _, err := os.Stat(target.directDBPath); err != nil {
+            return fmt.Errorf("failed to find SimpleX database: %w", err)
+        }
+        im.dbPath = target.directDBPath
+
+        im.filesDir = target.filesDirPath
+        if im.filesDir == "" {
+            im.filesDir = defaultFilesDir(im.dbPath)
+        }
+        if !safety.dryRun && output.sqlPath == "" {
+            if err := os.MkdirAll(im.filesDir, 0755); err != nil {
+                return fmt.Errorf("failed to find or create SimpleX files directory: %w", err)
+            }
+        }
+    } else if safety.resume && im.checkpoint.WorkDir != "" {
+        // The batches so far went into the archive extracted by the run that stopped
+        im.extractedDir = im.checkpoint.WorkDir
+        fmt.Printf("Continuing in the SimpleX export extracted to: %s\n", im.extractedDir)
+        im.dbPath, im.filesDir, err = archive.Locate(im.extractedDir)
+        if err != nil {
+            return fmt.Errorf("failed to find SimpleX database: %w", err)
+        }
+    } else {
+        // Extract SimpleX ZIP export
+        fmt.Printf("Extracting SimpleX ZIP export from: %s\n", target.zipPath)
+        if target.inMemory && tempfiles.Root == "" {
+            tempfiles.Root, err = tempfiles.MemoryRoot()
+            if err != nil {
+                return fmt.Errorf("failed to set up in-memory extraction: %w", err)
+            }
+        }
+        if target.inMemory {
+            fmt.Printf("Extracting only the databases to %s\n", tempfiles.Root)
+        }
+        // Dry runs and scripts copy no attachments into it, so the existing ones can stay in the archive
+        timed := activeTimings.Start("unzip")
+        im.extractedDir, err = archive.Extract(im.ctx, target.zipPath, tempfiles.Root, target.inMemory || safety.dryRun || output.sqlPath != "", target.maxArchiveBytes)
+        timed()
+        if err != nil {
+            return fmt.Errorf("failed to extract SimpleX ZIP: %w", err)
+        }
+        tempfiles.Register(im.extractedDir)
+
+        // Find database and files directory in extracted content
+        im.dbPath, im.filesDir, err = archive.Locate(im.extractedDir)
+        if err != nil {
+            return fmt.Errorf("failed to find SimpleX database: %w", err)
+        }
+    }
+
+    fmt.Printf("Found database at: %s\n", im.dbPath)
+
+    if err := im.backUpInput(); err != nil {
+        return err
+    }
+
+    // The statements for a script run against a scratch copy of the database, which keeps the IDs
+    // consistent from batch to batch; the attachments go next to the script
+    if output.sqlPath != "" {
+        if im.extractedDir == "" {
+            scratchDir, err := os.MkdirTemp(tempfiles.Root, "simplex_sql_")
+            if err != nil {
+                return fmt.Errorf("failed to create temp directory: %w", err)
+            }
+            tempfiles.Register(scratchDir)
+            scratchPath := filepath.Join(scratchDir, filepath.Base(im.dbPath))
+            if err := archive.CopyFile(im.dbPath, scratchPath); err != nil {
+                return fmt.Errorf("failed to copy SimpleX database: %w", err)
+            }
+            im.dbPath = scratchPath
+        }
+        im.filesDir = sqlScriptFilesDir(output.sqlPath)
+        if err := os.MkdirAll(im.filesDir, 0755); err != nil {
+            return fmt.Errorf("failed to create directory for the script's attachments: %w", err)
+        }
+    }
+    fmt.Printf("Using files directory: %s\n", im.filesDir)
+    return nil
+}
+
+// Imports that change SimpleX record each committed batch, so one that stops part-way can be
+// resumed. The contact is only looked up later, so the checkpoint keeps it as given
+func (im *importer) loadCheckpoint() error {
+    target, safety := &im.target, &im.safety
+    if safety.dryRun || im.output.sqlPath != "" {
+        return nil
+    }
+    contactRef := target.contactName
+    if target.contactID != 0 {
+        contactRef = fmt.Sprintf("contact ID %d", target.contactID)
+    }
+    if target.profile != "" {
+        contactRef += " of profile " + target.profile
+    }
+    im.resumePath = checkpointPath(target.zipPath, target.directDBPath, target.exportDirPath)
+    var fresh *importCheckpoint
+    if !safety.atomic {
+        fresh = &importCheckpoint{JSON: im.source.jsonPath, Contact: contactRef, Messages: im.messages.Len(), InMemory: target.inMemory}
+    }
+    var err error
+    im.checkpoint, im.leftoverIndexes, err = openCheckpoint(im.resumePath, safety.resume, fresh, im.source.jsonPath, contactRef, target.inMemory, im.messages)
+    return err
+}
+
+// The input is copied before anything is written, so a run that goes wrong never costs the
+// only export: -db, -dir and -desktop change the database in place. A resumed import was
+// backed up by its first run, a pulled Android export is a copy already and -in-place keeps
+// the original as <zip>.bak itself. The database of -dir is backed up next to the directory,
+// so the copy doesn't end up in a ZIP of it
+func (im *importer) backUpInput() error {
+    target, safety := &im.target, &im.safety
+    if safety.noBackup || safety.dryRun || im.output.sqlPath != "" || safety.resume || target.androidMode || im.output.inPlace {
+        return nil
+    }
+    input, backupName := target.zipPath, target.zipPath
+    if im.extractedDir == "" {
+        input, backupName = im.dbPath, im.dbPath
+    }
+    if target.exportDirPath != "" {
+        backupName = filepath.Clean(target.exportDirPath) + "." + filepath.Base(im.dbPath)
+    }
+    timed := activeTimings.Start("backing up the input")
+    backupPath, err := backupInput(input, backupName)
+    if err != nil {
+        return fmt.Errorf("failed to back up %s: %w (use -no-backup to import without a backup)", input, err)
+    }
+    timed()
+    fmt.Printf("Backed up %s to: %s\n", input, backupPath)
+    return nil
+}
+
+// Open the database and find the profile and contact to import into
+func (im *importer) openChat() error {
+    target, report := &im.target, im.report
+    timed := activeTimings.Start("opening the database")
+    db, err := openDatabase(im.dbPath, im.password, false)
+    if err != nil {
+        return err
+    }
+    timed()
+    im.db = db
+
+    schema, err := simplexdb.CheckSchema(db, im.media.encryptFiles)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Schema version: %s\n", schema)
+    report.Target.Schema = schema.Latest
+    if schema.Untested() {
+        log.Printf("Warning: the database is from a newer SimpleX version than this importer was tested with; everything it writes is there, but check the imported chat in the app")
+    }
+
+    var profileName string
+    im.userID, profileName, err = simplexdb.ProfileUserID(db, target.profile)
+    if err != nil {
+        return fmt.Errorf("failed to find user profile: %w", err)
+    }
+    if target.profile != "" {
+        fmt.Printf("Profile: %s (ID: %d)\n", profileName, im.userID)
+    }
+    report.Target.UserID = im.userID
+
+    im.contactID, err = findContact(
+        func(name string) (int, error) { return simplexdb.ContactIDByName(db, im.userID, name) },
+        func(id int) (string, error) { return simplexdb.ContactNameByID(db, im.userID, id) },
+        &target.contactName, target.contactID)
+    if err != nil {
+        return fmt.Errorf("failed to find contact: %w", err)
+    }
+    fmt.Printf("Contact: %s (ID: %d)\n", target.contactName, im.contactID)
+    report.Target.Contact = target.contactName
+    report.Target.ContactID = im.contactID
+
+    // Get starting message ID
+    im.startMessageID, err = simplexdb.NextMessageID(db)
+    if err != nil {
+        return fmt.Errorf("failed to get starting message ID: %w", err)
+    }
+
+    fmt.Printf("Starting message ID: %d\n", im.startMessageID)
+    return nil
+}
+
+// Leave out the messages the chat has already, and warn about history older than what it has
+func (im *importer) skipImported() error {
+    messages, report := im.messages, im.report
+    skipping := activeTimings.Start("skipping imported messages")
+    if im.source.sinceLastImport {
+        older := 0
+        err := messages.Update(func(chunk []universal.Message) ([]universal.Message, error) {
+            kept, chunkOlder, err := simplexdb.SinceLastImport(im.db, im.contactID, chunk)
+            older += chunkOlder
+            return kept, err
+        })
+        if err != nil {
+            return fmt.Errorf("-since-last-import: %w", err)
+        }
+        report.Skipped.OlderThanLastImport = older
+        fmt.Printf("Skipped %d messages older than the last import\n", older)
+    }
+
+    // Messages already in the chat, from an earlier import of the same export or the batches a
+    // stopped import committed, are left out. Reactions they have now that they didn't then are
+    // added to them after the import
+    if !im.safety.allowDuplicates {
+        alreadyImported := 0
+        err := messages.Update(func(chunk []universal.Message) ([]universal.Message, error) {
+            kept, chunkImported, err := simplexdb.SkipImported(im.db, im.contactID, chunk)
+            alreadyImported += chunkImported
+            if err == nil && chunkImported > 0 {
+                im.reactedImported = append(im.reactedImported, reactedLeftOut(chunk, kept)...)
+            }
+            return kept, err
+        })
+        if err != nil {
+            return err
+        }
+        report.Skipped.AlreadyImported = alreadyImported
+        if alreadyImported > 0 {
+            fmt.Printf("Skipped %d messages already in the chat (use -allow-duplicates to import them again)\n", alreadyImported)
+        }
+    }
+    skipping()
+    if im.safety.resume {
+        report.Insert.FirstMessageID, report.Insert.FirstChatItemID = im.checkpoint.FirstMessageID, im.checkpoint.FirstChatItemID
+    }
+
+    // Imported messages get the highest IDs, and SimpleX shows the item with the highest ID as
+    // the chat's last message, so history older than what the chat has needs renumbering
+    if messages.Len() > 0 && im.target.mergeMode == simplexdb.MergeAppend {
+        var oldest time.Time
+        eachMessages(messages, func(chunk []universal.Message) {
+            for _, msg := range chunk {
+                if oldest.IsZero() || msg.Timestamp.Before(oldest) {
+                    oldest = msg.Timestamp
+                }
+            }
+        })
+        newer, err := simplexdb.NewerItems(im.db, im.contactID, oldest)
+        if err != nil {
+            return err
+        }
+        if newer > 0 {
+            fmt.Printf("Warning: %d messages already in the chat are newer than the oldest imported one and will come before the import by ID; use -merge renumber to renumber the chat in time order\n", newer)
+        }
+    }
+    return nil
+}
+
+// Write the messages into the chat, record the import and check the database. An atomic import
+// writes the batches, the ledger and the renumbering into one transaction, committed once the
+// database checks out and rolled back when anything fails before
+func (im *importer) writeChat() (err error) {
+    safety, output, report := &im.safety, &im.output, im.report
+    totalMessages := im.messages.Len()
+    insertOptions := simplexdb.InsertOptions{
+        ContactID:     im.contactID,
+        UserID:        im.userID,
+        JSONDir:       im.jsonDir,
+        FilesDir:      im.filesDir,
+        EncryptFiles:  im.media.encryptFiles,
+        ReactionEmoji: im.source.reactionEmoji,
+        DryRun:        safety.dryRun,
+        Report:        report.Insert,
+        Timings:       activeTimings,
+    }
+    report.setMessages(im.messages)
+    if output.sqlPath != "" {
+        im.script, err = createSQLScript(output.sqlPath, im.target.contactName, im.contactID, totalMessages)
+        if err != nil {
+            return fmt.Errorf("failed to create SQL script: %w", err)
+        }
+        insertOptions.SQLScript = im.script
+    }
+    var idMap *os.File
+    var atomicIDMap bytes.Buffer
+    if output.idMapPath != "" && !safety.dryRun {
+        idMap, err = openIDMap(output.idMapPath)
+        if err != nil {
+            return fmt.Errorf("failed to open ID map: %w", err)
+        }
+        defer idMap.Close()
+        insertOptions.IDMap = idMap
+        if safety.atomic {
+            // The IDs are only committed at the end too
+            insertOptions.IDMap = &atomicIDMap
+        }
+    }
+
+    var database simplexdb.Database = im.db
+    var atomicTx *sql.Tx
+    if safety.atomic {
+        atomicTx, err = simplexdb.BeginTx(im.ctx, im.db)
+        if err != nil {
+            return fmt.Errorf("failed to begin the import: %w", err)
+        }
+        defer func() {
+            if atomicTx != nil {
+                atomicTx.Rollback()
+                fmt.Println("The import was atomic, nothing was committed to the database")
+            }
+        }()
+        database = atomicTx
+    }
+
+    // A big import drops the indexes of the tables it fills and builds them again at the end; a
+    // resumed or started over one also builds those the earlier run dropped. One that fails
+    // before the end builds them on the way out, an atomic one rolls the drop back instead
+    droppedIndexes := im.leftoverIndexes
+    if !safety.dryRun && im.script == nil && totalMessages > 0 &&
+        (safety.dropIndexes == simplexdb.DropIndexesAlways || (safety.dropIndexes == simplexdb.DropIndexesAuto && totalMessages >= simplexdb.BulkLoadMessages)) {
+        timed := activeTimings.Start("dropping indexes")
+        dropped, err := simplexdb.DropIndexes(database)
+        timed()
+        if err != nil {
+            return err
+        }
+        for _, index := range dropped {
+            known := false
+            for _, earlier := range droppedIndexes {
+                known = known || earlier.Name == index.Name
+            }
+            if !known {
+                droppedIndexes = append(droppedIndexes, index)
+            }
+        }
+        if im.checkpoint != nil {
+            im.checkpoint.Indexes = droppedIndexes
+            if err := saveCheckpoint(im.resumePath, im.checkpoint); err != nil {
+                return fmt.Errorf("failed to save checkpoint: %w", err)
+            }
+        }
+        fmt.Printf("Dropped %d indexes for the bulk load\n", len(dropped))
+    }
+    rebuilding := false
+    defer func() {
+        if err != nil && !rebuilding && len(droppedIndexes) > 0 && !safety.atomic {
+            if err := simplexdb.RestoreIndexes(im.db, droppedIndexes); err != nil {
+                log.Printf("Warning: failed to rebuild the indexes dropped for the import: %v", err)
+            }
+        }
+    }()
+
+    if err := im.insertBatches(database, insertOptions); err != nil {
+        return err
+    }
+
+    if len(droppedIndexes) > 0 {
+        fmt.Printf("Rebuilding %d indexes...\n", len(droppedIndexes))
+        rebuilding = true
+        timed := activeTimings.Start("rebuilding indexes")
+        if err := simplexdb.RestoreIndexes(database, droppedIndexes); err != nil {
+            return err
+        }
+        timed()
+        if im.checkpoint != nil {
+            im.checkpoint.Indexes = nil
+            if err := saveCheckpoint(im.resumePath, im.checkpoint); err != nil {
+                return fmt.Errorf("failed to save checkpoint: %w", err)
+            }
+        }
+    }
+
+    if err := im.recordImport(database); err != nil {
+        return err
+    }
+    if err := im.updateChat(database); err != nil {
+        return err
+    }
+    if err := im.checkDatabase(database); err != nil {
+        return err
+    }
+
+    if atomicTx != nil {
+        timed := activeTimings.Start("commit")
+        if err := atomicTx.Commit(); err != nil {
+            return fmt.Errorf("failed to commit the import: %w", err)
+        }
+        timed()
+        atomicTx = nil
+        if idMap != nil {
+            if _, err := atomicIDMap.WriteTo(idMap); err != nil {
+                return fmt.Errorf("failed to write ID map: %w", err)
+            }
+        }
+    }
+    return nil
+}
+
+// Insert the messages in batches, with the media of the next batch made while one is inserted,
+// and add the reactions of messages imported before
+func (im *importer) insertBatches(database simplexdb.Database, insertOptions simplexdb.InsertOptions) error {
+    safety, report := &im.safety, im.report
+    totalMessages, batchSize := im.messages.Len(), safety.batchSize
+    fmt.Printf("Processing %d messages in batches of %d...\n", totalMessages, batchSize)
+    barLabel := "Inserting messages"
+    if safety.dryRun {
+        barLabel = "Checking messages"
+    }
+    insertOptions.Progress = progress.New(barLabel, totalMessages)
+
+    // The same file posted more than once is previewed only once, across runs with -media-cache
+    var resultCacheDir string
+    if im.media.cache {
+        resultCacheDir = filepath.Join(im.media.cacheDir, "previews")
+    }
+    resultCache := media.NewResultCache(resultCacheDir)
+    mediaPool := simplexdb.NewMediaPool(im.media.workers, im.jsonDir, im.filesDir, im.media.encryptFiles, !safety.dryRun, resultCache, activeTimings)
+    // Batches are read from the messages as they go, from disk when -max-memory spilled them
+    batches, err := im.messages.Reader()
+    if err != nil {
+        return err
+    }
+    defer batches.Close()
+    var nextBatch []universal.Message
+    var nextMedia *simplexdb.BatchMedia
+    if totalMessages > 0 {
+        if nextBatch, err = batches.Next(batchSize); err != nil {
+            return err
+        }
+        nextMedia = mediaPool.Prefetch(nextBatch)
+    }
+    // The messages a batch's reactions come with take IDs after its own, so the next batch
+    // starts where the last one ended
+    im.nextMessageID = im.startMessageID
+    for i := 0; i < totalMessages; i += batchSize {
+        end := i + batchSize
+        if end > totalMessages {
+            end = totalMessages
+        }
+
+        batch := nextBatch
+
+        insertOptions.Media = nextMedia
+        if end < totalMessages {
+            if nextBatch, err = batches.Next(batchSize); err != nil {
+                return err
+            }
+            nextMedia = mediaPool.Prefetch(nextBatch)
+        }
+
+        im.nextMessageID, err = simplexdb.InsertMessages(im.ctx, database, batch, im.nextMessageID, insertOptions)
+        if err != nil {
+            return fmt.Errorf("failed to insert batch %d-%d: %w", i+1, end, err)
+        }
+
+        if checkpoint := im.checkpoint; checkpoint != nil {
+            // A kept work directory must outlive a failure, or the checkpoint would point at nothing
+            if im.extractedDir != "" && checkpoint.WorkDir == "" {
+                checkpoint.WorkDir = im.extractedDir
+                tempfiles.Keep(im.extractedDir)
+            }
+            checkpoint.Done += len(batch)
+            checkpoint.LastMessageID = batch[len(batch)-1].ID
+            checkpoint.FirstMessageID, checkpoint.FirstChatItemID = report.Insert.FirstMessageID, report.Insert.FirstChatItemID
+            if err := saveCheckpoint(im.resumePath, checkpoint); err != nil {
+                return fmt.Errorf("failed to save checkpoint: %w", err)
+            }
+        }
+    }
+    insertOptions.Progress.Finish()
+    mediaPool.Close()
+
+    if len(im.reactedImported) > 0 {
+        timed := activeTimings.Start("insert: chat_item_reactions")
+        var added int
+        im.nextMessageID, added, err = simplexdb.AddReactions(im.ctx, database, im.reactedImported, im.nextMessageID, insertOptions)
+        if err != nil {
+            return fmt.Errorf("failed to add reactions: %w", err)
+        }
+        timed()
+        report.Insert.ReactionsAdded = added
+        switch {
+        case added > 0 && safety.dryRun:
+            fmt.Printf("Would add %d reactions to messages imported before\n", added)
+        case added > 0:
+            fmt.Printf("Added %d reactions to messages imported before\n", added)
+        }
+    }
+    if hits := resultCache.Hits(); hits > 0 {
+        fmt.Printf("Attachment previews and durations: %d reused instead of made again\n", hits)
+    }
+    return nil
+}
+
+// Record the import in the database's import_runs ledger; a resumed import is one run
+func (im *importer) recordImport(database simplexdb.Database) error {
+    report, checkpoint := im.report, im.checkpoint
+    if im.safety.dryRun || report.Insert.FirstMessageID == 0 || (checkpoint != nil && checkpoint.Recorded) {
+        return nil
+    }
+    timed := activeTimings.Start("recording the import")
+    run := simplexdb.ImportRun{
+        ToolVersion:     version,
+        SourcePlatform:  im.source.platform,
+        SourcePath:      im.source.jsonPath,
+        ChatName:        im.info.ChatName,
+        ContactID:       im.contactID,
+        Items:           report.Insert.LastChatItemID - report.Insert.FirstChatItemID + 1,
+        FirstMessageID:  report.Insert.FirstMessageID,
+        LastMessageID:   report.Insert.LastMessageID,
+        FirstChatItemID: report.Insert.FirstChatItemID,
+        LastChatItemID:  report.Insert.LastChatItemID,
+        StartedAt:       report.StartedAt,
+        FinishedAt:      time.Now(),
+    }
+    if im.source.jsonPath != "" {
+        var err error
+        run.SourceSHA256, err = fileSHA256(im.source.jsonPath)
+        if err != nil {
+            return fmt.Errorf("failed to hash the export: %w", err)
+        }
+    }
+    if err := simplexdb.RecordImportRun(database, run, im.scriptWriter()); err != nil {
+        return err
+    }
+    timed()
+    if checkpoint != nil {
+        checkpoint.Recorded = true
+        if err := saveCheckpoint(im.resumePath, checkpoint); err != nil {
+            return fmt.Errorf("failed to save checkpoint: %w", err)
+        }
+    }
+    return nil
+}
+
+// The -sql-output script for the statements after the inserts, nil when there's none
+func (im *importer) scriptWriter() io.Writer {
+    if im.script == nil {
+        return nil
+    }
+    return im.script
+}
+
+// Renumber the chat, update the contact's profile and tag the chat, as asked
+func (im *importer) updateChat(database simplexdb.Database) error {
+    target, report := &im.target, im.report
+    if target.mergeMode == simplexdb.MergeRenumber && !im.safety.dryRun && report.Insert.FirstChatItemID != 0 {
+        timed := activeTimings.Start("renumbering")
+        renumbered, err := simplexdb.RenumberByTime(database, im.contactID, report.Insert.FirstChatItemID, report.Insert.LastChatItemID, im.scriptWriter())
+        timed()
+        if err != nil {
+            return fmt.Errorf("failed to renumber the chat: %w", err)
+        }
+        if renumbered > 0 {
+            fmt.Printf("Renumbered %d chat items so their IDs follow their time\n", renumbered)
+        }
+    }
+
+    if im.contactProfile != (simplexdb.ContactProfile{}) {
+        changed, err := simplexdb.UpdateContactProfile(database, im.contactID, im.contactProfile, im.scriptWriter())
+        if err != nil {
+            return err
+        }
+        if len(changed) > 0 {
+            fmt.Printf("Updated the contact's profile from the export: %s\n", strings.Join(changed, ", "))
+            report.Target.ProfileUpdated = changed
+        }
+    }
+
+    if target.chatTag != "" && !im.safety.dryRun {
+        created, tagged, err := simplexdb.TagChat(database, im.userID, im.contactID, target.chatTag, im.scriptWriter())
+        if err != nil {
+            log.Printf("Warning: -tag: %v", err)
+        } else {
+            report.Target.Tag = target.chatTag
+            if created {
+                fmt.Printf("Created chat tag: %s\n", target.chatTag)
+            }
+            if tagged {
+                fmt.Printf("Tagged the chat with %s\n", target.chatTag)
+            }
+        }
+    }
+    return nil
+}
+
+// A database that doesn't add up after the import isn't handed back to the app
+func (im *importer) checkDatabase(database simplexdb.Database) error {
+    if im.safety.dryRun || im.report.Insert.FirstChatItemID == 0 {
+        return nil
+    }
+    fmt.Println("Checking the database...")
+    timed := activeTimings.Start("checking the database")
+    problems, err := simplexdb.CheckConsistency(database, im.contactID, im.report.Insert.FirstChatItemID)
+    timed()
+    if err != nil {
+        return fmt.Errorf("failed to check the database: %w", err)
+    }
+    if len(problems) == 0 {
+        return nil
+    }
+    for _, problem := range problems {
+        fmt.Printf("  %s\n", problem)
+    }
+    if im.extractedDir == "" && im.target.exportDirPath == "" && im.script == nil && !im.safety.atomic {
+        return fmt.Errorf("%s is inconsistent after the import (%d problems); restore it from a backup", im.dbPath, len(problems))
+    }
+    return fmt.Errorf("the database is inconsistent after the import (%d problems), no output was written", len(problems))
+}
+
+// Hand the result back: the script, the updated database or directory, or the archive made
+// from it, pushed to the device for -android
+func (im *importer) writeOutput() error {
+    target, output, report := &im.target, &im.output, im.report
+    if im.script != nil {
+        if err := im.script.finish(); err != nil {
+            return fmt.Errorf("failed to write SQL script: %w", err)
+        }
+        fmt.Printf("Wrote SQL script: %s\n", output.sqlPath)
+        fmt.Printf("Attachments it refers to are in: %s\n", im.filesDir)
+        if err := im.writeChecksums("", filepath.Dir(output.sqlPath), im.filesDir); err != nil {
+            return err
+        }
+        fmt.Printf("Nothing was changed in SimpleX; review the script and apply it with sqlcipher.\n")
+        report.finish("sql-script")
+        return nil
+    }
+
+    if im.safety.dryRun {
+        printDryRunReport(im.messages, im.jsonDir)
+        fmt.Printf("Dry run: would insert %d messages into the chat with %s (message IDs %d-%d); nothing was written\n", im.messages.Len(), target.contactName, im.startMessageID, im.nextMessageID-1)
+        report.finish("dry-run")
+        return nil
+    }
+
+    // Close database connection before creating ZIP
+    timed := activeTimings.Start("closing the database")
+    if err := closeDatabase(im.db, im.dbPath, output.vacuum, im.extractedDir != "" || target.exportDirPath != ""); err != nil {
+        return err
+    }
+    timed()
+
+    extractedDir := im.extractedDir
+    if target.exportDirPath != "" {
+        if output.zipPath == "" {
+            fmt.Printf("Updated SimpleX export directory: %s\n", target.exportDirPath)
+            if err := im.writeChecksums("", target.exportDirPath, im.filesDir); err != nil {
+                return err
+            }
+            clearCheckpoint(im.resumePath, im.checkpoint)
+            fmt.Printf("Import complete! Zip its contents to import it back into SimpleX Chat.\n")
+            report.finish("complete")
+            return nil
+        }
+        extractedDir = target.exportDirPath
+    }
+
+    if extractedDir == "" {
+        fmt.Printf("Updated SimpleX database: %s\n", im.dbPath)
+        if err := im.writeChecksums("", filepath.Dir(im.dbPath), im.filesDir); err != nil {
+            return err
+        }
+        clearCheckpoint(im.resumePath, im.checkpoint)
+        fmt.Printf("Import complete!\n")
+        report.finish("complete")
+        return nil
+    }
+
+    if output.dirPath != "" {
+        timed = activeTimings.Start("writing the output directory")
+        renamed, err := moveExportDir(extractedDir, output.dirPath)
+        if err != nil {
+            return fmt.Errorf("failed to write the output directory: %w", err)
+        }
+        timed()
+        clearCheckpoint(im.resumePath, im.checkpoint)
+        if renamed {
+            tempfiles.Keep(extractedDir)
+        }
+        fmt.Printf("Wrote updated SimpleX export directory: %s\n", output.dirPath)
+        if relFilesDir, err := filepath.Rel(extractedDir, im.filesDir); err == nil {
+            if err := im.writeChecksums("", output.dirPath, filepath.Join(output.dirPath, relFilesDir)); err != nil {
+                return err
+            }
+        }
+        fmt.Printf("Import complete! Zip its contents to import it back into SimpleX Chat.\n")
+        report.Target.Output = output.dirPath
+        report.finish("complete")
+        return nil
+    }
+
+    // Create output ZIP with updated database and files; what the import didn't change is copied
+    // from the original archive (there's none for -dir) without compressing it again
+    baseZipPath := target.zipPath
+    timed = activeTimings.Start("zip")
+    if output.inPlace {
+        fmt.Printf("Updating SimpleX ZIP export in place: %s\n", target.zipPath)
+        bar := progress.NewBytes("Writing ZIP", 0)
+        backupPath, err := archive.Replace(im.ctx, extractedDir, target.zipPath, baseZipPath, bar)
+        bar.Finish()
+        if err != nil {
+            return fmt.Errorf("failed to update ZIP in place: %w", err)
+        }
+        fmt.Printf("Original export kept as: %s\n", backupPath)
+        output.zipPath = target.zipPath
+    } else {
+        fmt.Printf("Creating updated SimpleX ZIP export: %s\n", output.zipPath)
+        bar := progress.NewBytes("Writing ZIP", 0)
+        err := archive.Create(im.ctx, extractedDir, output.zipPath, baseZipPath, bar)
+        bar.Finish()
+        if err != nil {
+            return fmt.Errorf("failed to create output ZIP: %w", err)
+        }
+    }
+    timed()
+
+    fmt.Printf("Successfully created updated SimpleX export: %s\n", output.zipPath)
+    if err := im.writeChecksums(output.zipPath, extractedDir, im.filesDir); err != nil {
+        return err
+    }
+    report.Target.Output = output.zipPath
+    clearCheckpoint(im.resumePath, im.checkpoint)
+
+    if target.androidMode {
+        remotePath, err := pushAndroidExport(output.zipPath, target.androidDir)
+        if err != nil {
+            return fmt.Errorf("failed to push updated export to the device: %w", err)
+        }
+        fmt.Printf("Pushed updated export to the device: %s\n", remotePath)
+        fmt.Printf("Import complete! Import it in SimpleX Chat with Settings > Database > Import database.\n")
+        report.finish("complete")
+        return nil
+    }
+
+    fmt.Printf("Import complete! You can now import this ZIP file back into SimpleX Chat.\n")
+    report.finish("complete")
+    return nil
+}
+
+// -manifest is written once the output is, with the paths of the files relative to root, the
+// top of the export
+func (im *importer) writeChecksums(archivePath, root, filesDir string) error {
+    manifestPath := im.output.manifestPath
+    if manifestPath == "" {
+        return nil
+    }
+    timed := activeTimings.Start("manifest")
+    if err := writeManifest(manifestPath, archivePath, root, filesDir, im.report.Insert.Files); err != nil {
+        return fmt.Errorf("failed to write -manifest: %w", err)
+    }
+    timed()
+    fmt.Printf("Wrote checksums to: %s\n", manifestPath)
+    return nil
+}
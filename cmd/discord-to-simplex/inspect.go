@@ -0,0 +1,473 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "text/tabwriter"
+    "time"
+
+    "github.com/ritiek/discord-to-simplex/pkg/discord"
+    "github.com/ritiek/discord-to-simplex/pkg/simplexdb"
+    "github.com/ritiek/discord-to-simplex/pkg/tempfiles"
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// inspect: summarize an export (-json) or a SimpleX database (-zip, -db or -dir) without changing it
+func runInspect(args []string) {
+    fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+    jsonFilePath := fs.String("json", "", "Export file to summarize")
+    sourcePlatform := fs.String("source", "discord", "Platform the -json export comes from: "+strings.Join(universal.SourcePlatforms(), ", "))
+    var target simplexTarget
+    target.register(fs)
+    fs.Parse(args)
+
+    if *jsonFilePath != "" {
+        if target.given() {
+            log.Fatal("-json can't be used together with -zip, -db or -dir, inspect one at a time.")
+        }
+        inspectExport(*jsonFilePath, *sourcePlatform)
+        return
+    }
+    target.check()
+
+    cleanUpOnInterrupt()
+    defer tempfiles.Cleanup()
+    inspectSimplex(target, false)
+}
+
+// inspect-simplex: show a SimpleX database in detail without changing it, to check the target
+// of an import before and after
+func runInspectSimplex(args []string) {
+    fs := flag.NewFlagSet("inspect-simplex", flag.ExitOnError)
+    var target simplexTarget
+    target.register(fs)
+    fs.Parse(args)
+    target.check()
+
+    cleanUpOnInterrupt()
+    defer tempfiles.Cleanup()
+    inspectSimplex(target, true)
+}
+
+// Print what an export contains: its date range, who wrote how much and the media in it
+func inspectExport(jsonFilePath, platform string) {
+    loaded, err := loadMessages(context.Background(), platform, universal.SourceConfig{Path: jsonFilePath, Location: time.Local}, messageFilter{ImportDeleted: true})
+    if err != nil {
+        log.Fatalf("%v", err)
+    }
+    messages, err := loaded.Messages.All()
+    if err != nil {
+        log.Fatalf("%v", err)
+    }
+
+    authors := make(map[string]int)
+    deleted, attachments, replies, reactions := 0, 0, 0, 0
+    for _, msg := range messages {
+        authors[msg.Author.Username]++
+        if msg.IsDeleted {
+            deleted++
+        }
+        if msg.ReplyToID != nil {
+            replies++
+        }
+        attachments += len(msg.Attachments)
+        reactions += len(msg.Reactions)
+    }
+
+    fmt.Println()
+    fmt.Printf("Messages: %d (%d deleted)\n", len(messages), deleted)
+    if len(messages) > 0 {
+        fmt.Printf("From: %s\n", messages[0].Timestamp.Format("2006-01-02 15:04:05"))
+        fmt.Printf("To: %s\n", messages[len(messages)-1].Timestamp.Format("2006-01-02 15:04:05"))
+    }
+    fmt.Printf("Replies: %d\n", replies)
+    fmt.Printf("Attachments: %d\n", attachments)
+    fmt.Printf("Reactions: %d\n", reactions)
+
+    names := make([]string, 0, len(authors))
+    for name := range authors {
+        names = append(names, name)
+    }
+    sort.Slice(names, func(i, j int) bool {
+        if authors[names[i]] != authors[names[j]] {
+            return authors[names[i]] > authors[names[j]]
+        }
+        return names[i] < names[j]
+    })
+    fmt.Println("Authors:")
+    for _, name := range names {
+        fmt.Printf("  %s: %d messages\n", name, authors[name])
+    }
+}
+
+// inspect-discord: analyze a DiscordChatExporter export in detail, to plan filters before importing
+func runInspectDiscord(args []string) {
+    fs := flag.NewFlagSet("inspect-discord", flag.ExitOnError)
+    jsonFilePath := fs.String("json", "", "DiscordChatExporter JSON export to analyze (required)")
+    fs.Parse(args)
+
+    if *jsonFilePath == "" {
+        log.Fatal("JSON file path is required. Use -json flag.")
+    }
+    export, err := discord.LoadExport(*jsonFilePath)
+    if err != nil {
+        log.Fatalf("Failed to load Discord export: %v", err)
+    }
+    jsonDir := filepath.Dir(*jsonFilePath)
+
+    type authorStats struct {
+        messages, attachments int
+        bot                   bool
+    }
+    type mediaStats struct {
+        count int
+        size  int64
+    }
+    authors := make(map[string]*authorStats)
+    media := make(map[string]*mediaStats)
+    systemTypes := make(map[string]int)
+    var replies, reactions, customReactions, pinned, deleted, edited int
+    var stickers, extraEmbeds, embedsWithoutImage, empty, missingFiles int
+    var first, last time.Time
+
+    for _, msg := range export.Messages {
+        if timestamp, err := universal.ParseTimestamp(msg.Timestamp, time.Local); err == nil {
+            if first.IsZero() || timestamp.Before(first) {
+                first = timestamp
+            }
+            if timestamp.After(last) {
+                last = timestamp
+            }
+        }
+
+        author := authors[msg.Author.Name]
+        if author == nil {
+            author = &authorStats{bot: msg.Author.IsBot}
+            authors[msg.Author.Name] = author
+        }
+        author.messages++
+        author.attachments += len(msg.Attachments)
+
+        if msg.Type != "Default" && msg.Type != "Reply" {
+            systemTypes[msg.Type]++
+        }
+        if msg.Reference != nil {
+            replies++
+        }
+        if msg.IsPinned {
+            pinned++
+        }
+        if msg.IsDeleted {
+            deleted++
+        }
+        if msg.TimestampEdited != nil {
+            edited++
+        }
+        stickers += len(msg.Stickers)
+
+        for _, att := range msg.Attachments {
+            kind := universal.AttachmentMessageType(att.FileName)
+            if media[kind] == nil {
+                media[kind] = &mediaStats{}
+            }
+            media[kind].count++
+            media[kind].size += int64(att.FileSizeBytes)
+            if !universal.IsRemoteURL(att.URL) {
+                if _, err := os.Stat(universal.ResolveExportPath(jsonDir, att.URL)); err != nil {
+                    missingFiles++
+                }
+            }
+        }
+
+        // Only the first link embed with an image becomes a link preview, and only without attachments
+        previews := 0
+        for _, embed := range msg.Embeds {
+            if embed.URL == "" {
+                continue
+            }
+            if (embed.Thumbnail == nil || embed.Thumbnail.URL == "") && len(embed.Images) == 0 {
+                embedsWithoutImage++
+                continue
+            }
+            previews++
+        }
+        if len(msg.Attachments) > 0 {
+            extraEmbeds += previews
+        } else if previews > 1 {
+            extraEmbeds += previews - 1
+        }
+        if strings.TrimSpace(msg.Content) == "" && len(msg.Attachments) == 0 && previews == 0 {
+            empty++
+        }
+
+        for _, reaction := range msg.Reactions {
+            count := int(reaction.Count)
+            if count == 0 {
+                count = 1
+            }
+            reactions += count
+            if reaction.Emoji.ID != "" {
+                customReactions += count
+            }
+        }
+    }
+
+    fmt.Printf("Channel: %s\n", export.Channel.Name)
+    fmt.Printf("Messages: %d (%d edited, %d pinned, %d deleted)\n", len(export.Messages), edited, pinned, deleted)
+    if len(export.ParseErrors) > 0 {
+        fmt.Printf("Malformed messages: %d (%d left out)\n", len(export.ParseErrors), countSkippedParseErrors(export.ParseErrors))
+        for _, parseError := range export.ParseErrors {
+            fmt.Printf("  #%d %s: %s\n", parseError.Index, parseError.ID, parseError.Reason)
+        }
+    }
+    if !first.IsZero() {
+        fmt.Printf("From: %s\n", first.Format("2006-01-02 15:04:05"))
+        fmt.Printf("To: %s\n", last.Format("2006-01-02 15:04:05"))
+    }
+    fmt.Printf("Replies: %d\n", replies)
+    fmt.Printf("Reactions: %d (%d with custom emoji)\n", reactions, customReactions)
+
+    names := make([]string, 0, len(authors))
+    for name := range authors {
+        names = append(names, name)
+    }
+    sort.Slice(names, func(i, j int) bool {
+        if authors[names[i]].messages != authors[names[j]].messages {
+            return authors[names[i]].messages > authors[names[j]].messages
+        }
+        return names[i] < names[j]
+    })
+    fmt.Println("Authors:")
+    for _, name := range names {
+        author := authors[name]
+        bot := ""
+        if author.bot {
+            bot = " (bot)"
+        }
+        fmt.Printf("  %s%s: %d messages, %d attachments\n", name, bot, author.messages, author.attachments)
+    }
+
+    kinds := make([]string, 0, len(media))
+    for kind := range media {
+        kinds = append(kinds, kind)
+    }
+    sort.Strings(kinds)
+    fmt.Println("Attachments:")
+    for _, kind := range kinds {
+        fmt.Printf("  %s: %d (%s)\n", kind, media[kind].count, universal.FormatByteSize(media[kind].size))
+    }
+
+    // What doesn't make it into SimpleX as it is in Discord
+    fmt.Println("Not imported as-is:")
+    systemNames := make([]string, 0, len(systemTypes))
+    for name := range systemTypes {
+        systemNames = append(systemNames, name)
+    }
+    sort.Strings(systemNames)
+    for _, name := range systemNames {
+        fmt.Printf("  %s system messages: %d (imported as plain text)\n", name, systemTypes[name])
+    }
+    fmt.Printf("  Stickers: %d (left out)\n", stickers)
+    fmt.Printf("  Link embeds without an image: %d (no preview, the link stays in the text)\n", embedsWithoutImage)
+    fmt.Printf("  Link previews past the first or next to an attachment: %d (left out)\n", extraEmbeds)
+    fmt.Printf("  Messages with nothing to show: %d (imported as empty items)\n", empty)
+    fmt.Printf("  Reactions with custom emoji: %d (see -custom-emoji)\n", customReactions)
+    fmt.Printf("  Attachments missing from the export folder: %d\n", missingFiles)
+}
+
+// Print the users of a SimpleX database and how many contacts, groups and chat items it has
+func inspectSimplex(target simplexTarget, detailed bool) {
+    db, filesDir, err := target.open(context.Background(), detailed)
+    if err != nil {
+        fatalf("%v", err)
+    }
+    defer db.Close()
+
+    rows, err := db.Query("SELECT local_display_name FROM users ORDER BY user_id")
+    if err != nil {
+        fatalf("Failed to read users: %v", err)
+    }
+    var users []string
+    for rows.Next() {
+        var name string
+        if err := rows.Scan(&name); err != nil {
+            rows.Close()
+            fatalf("Failed to read users: %v", err)
+        }
+        users = append(users, name)
+    }
+    rows.Close()
+
+    counts := []struct {
+        label string
+        query string
+    }{
+        {"Contacts", "SELECT COUNT(*) FROM contacts WHERE deleted = 0 AND is_user = 0"},
+        {"Groups", "SELECT COUNT(*) FROM groups"},
+        {"Chat items", "SELECT COUNT(*) FROM chat_items"},
+        {"Files", "SELECT COUNT(*) FROM files"},
+    }
+
+    fmt.Printf("Users: %s\n", strings.Join(users, ", "))
+    for _, count := range counts {
+        var n int
+        if err := db.QueryRow(count.query).Scan(&n); err != nil {
+            fatalf("Failed to count %s: %v", strings.ToLower(count.label), err)
+        }
+        fmt.Printf("%s: %d\n", count.label, n)
+    }
+
+    // Imports recorded in the import_runs ledger
+    runs, err := simplexdb.ImportRuns(db)
+    if err != nil {
+        fatalf("Failed to read import runs: %v", err)
+    }
+    if len(runs) > 0 {
+        fmt.Printf("Imports: %d\n", len(runs))
+    }
+    for _, run := range runs {
+        source := run.SourcePath
+        if source == "" {
+            source = run.ChatName
+        }
+        fmt.Printf("  #%d %s: %d items from %s %s into contact %d (chat items %d-%d, %s %s)\n",
+            run.ID, run.FinishedAt.Local().Format("2006-01-02 15:04"), run.Items, run.SourcePlatform, source,
+            run.ContactID, run.FirstChatItemID, run.LastChatItemID, run.ToolVersion, shortHash(run.SourceSHA256))
+    }
+
+    if detailed {
+        printSimplexDetails(db, filesDir)
+    }
+}
+
+// Print the schema version, user profiles, every chat with its item count and newest item,
+// and the size of the files directory
+func printSimplexDetails(db *sql.DB, filesDir string) {
+    schema, err := simplexdb.ReadSchemaVersion(db)
+    if err != nil {
+        fatalf("%v", err)
+    }
+    fmt.Printf("\nSchema version: %s\n", schema)
+    if _, err := simplexdb.CheckSchema(db, false); err != nil {
+        fmt.Printf("Can't import into it: %v\n", err)
+    }
+
+    rows, err := db.Query(`SELECT u.user_id, u.local_display_name, COALESCE(cp.full_name, ''), u.active_user
+                           FROM users u
+                           LEFT JOIN contacts c ON c.contact_id = u.contact_id
+                           LEFT JOIN contact_profiles cp ON cp.contact_profile_id = c.contact_profile_id
+                           ORDER BY u.user_id`)
+    if err != nil {
+        fatalf("Failed to read user profiles: %v", err)
+    }
+    fmt.Println("\nUser profiles:")
+    out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(out, "ID\tNAME\tFULL NAME\tACTIVE")
+    for rows.Next() {
+        var userID int
+        var name, fullName string
+        var active bool
+        if err := rows.Scan(&userID, &name, &fullName, &active); err != nil {
+            fatalf("Failed to read user profiles: %v", err)
+        }
+        activeMark := ""
+        if active {
+            activeMark = "yes"
+        }
+        fmt.Fprintf(out, "%d\t%s\t%s\t%s\n", userID, name, fullName, activeMark)
+    }
+    rows.Close()
+    out.Flush()
+
+    chats, err := listChats(db)
+    if err != nil {
+        fatalf("Failed to read chats: %v", err)
+    }
+    fmt.Println("\nChats:")
+    out = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(out, "TYPE\tNAME\tUSER\tITEMS\tLAST ITEM")
+    for _, chat := range chats {
+        fmt.Fprintf(out, "%s\t%s\t%d\t%d\t%s\n", chat.Kind, chat.Name, chat.UserID, chat.Items, chat.LastItem)
+    }
+    out.Flush()
+
+    files, size, err := dirUsage(filesDir)
+    switch {
+    case os.IsNotExist(err):
+        fmt.Printf("\nFiles directory: none\n")
+    case err != nil:
+        fatalf("Failed to read files directory: %v", err)
+    default:
+        fmt.Printf("\nFiles directory: %d files, %s\n", files, universal.FormatByteSize(size))
+    }
+}
+
+// Number and total size of the regular files under dir
+func dirUsage(dir string) (int, int64, error) {
+    var files int
+    var size int64
+    err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+        if err != nil {
+            return err
+        }
+        if info.Mode().IsRegular() {
+            files++
+            size += info.Size()
+        }
+        return nil
+    })
+    return files, size, err
+}
+
+// Direct or group chat with how many items it has
+type chatSummary struct {
+    Kind     string // direct or group
+    Name     string
+    UserID   int
+    Items    int
+    LastItem string // item_ts of the newest item, empty for an empty chat
+}
+
+// Every direct and group chat of a SimpleX database, direct chats first
+func listChats(db *sql.DB) ([]chatSummary, error) {
+    rows, err := db.Query(`SELECT 'direct', c.local_display_name, c.user_id, COUNT(ci.chat_item_id), COALESCE(MAX(ci.item_ts), '')
+                           FROM contacts c
+                           LEFT JOIN chat_items ci ON ci.contact_id = c.contact_id
+                           WHERE c.deleted = 0 AND c.is_user = 0
+                           GROUP BY c.contact_id
+                           UNION ALL
+                           SELECT 'group', g.local_display_name, g.user_id, COUNT(ci.chat_item_id), COALESCE(MAX(ci.item_ts), '')
+                           FROM groups g
+                           LEFT JOIN chat_items ci ON ci.group_id = g.group_id
+                           GROUP BY g.group_id
+                           ORDER BY 1, 2`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var chats []chatSummary
+    for rows.Next() {
+        var chat chatSummary
+        if err := rows.Scan(&chat.Kind, &chat.Name, &chat.UserID, &chat.Items, &chat.LastItem); err != nil {
+            return nil, err
+        }
+        chats = append(chats, chat)
+    }
+    return chats, rows.Err()
+}
+
+// First 12 hex digits of a hash, which is plenty to tell exports apart
+func shortHash(hash string) string {
+    if len(hash) > 12 {
+        return hash[:12]
+    }
+    return hash
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "os/signal"
+    "strings"
+    "syscall"
+
+    "github.com/ritiek/discord-to-simplex/pkg/tempfiles"
+    _ "github.com/xeodou/go-sqlcipher"
+)
+
+// Flag that can be given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+    return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+    *l = append(*l, value)
+    return nil
+}
+
+// Set at build time with -ldflags "-X main.version=..."
+var version = "0.1.0"
+
+// log.Fatalf skips deferred calls, so fatal errors clean up temp data (and write the -report) first
+func fatalf(format string, v ...interface{}) {
+    if activeReport != nil {
+        activeReport.Error = fmt.Sprintf(format, v...)
+        activeReport.finish("failed")
+    }
+    tempfiles.Cleanup()
+    log.Fatalf(format, v...)
+}
+
+const usage = `Usage: discord-to-simplex <command> [flags]
+
+Commands:
+  import           Import a chat export into SimpleX (the default when the first argument is a flag)
+  wizard           Import step by step, answering questions instead of passing flags
+  convert          Convert a chat export to universal messages as JSON, without touching SimpleX
+  inspect          Summarize a chat export or a SimpleX database
+  inspect-discord  Analyze a Discord export in detail: authors, media, and what won't import as-is
+  inspect-simplex  Show a SimpleX database in detail: schema version, profiles, chats and files
+  anonymize        Write a scrubbed copy of an export or SimpleX database to share in a bug report
+  diff             Compare two SimpleX databases: schema, rows per table, chats and files
+  verify           Check a SimpleX database for corruption and missing attachment files
+  rollback         Put back the archive an -in-place import replaced
+  list-contacts    List the contacts of a SimpleX database
+  serve            Run imports over an HTTP API
+
+Run 'discord-to-simplex <command> -h' for the flags of a command.
+`
+
+func main() {
+    // Plain flags are an import, like before there were commands
+    if len(os.Args) > 1 && strings.HasPrefix(os.Args[1], "-") {
+        runImport(os.Args[1:])
+        return
+    }
+    if len(os.Args) < 2 {
+        fmt.Fprint(os.Stderr, usage)
+        os.Exit(2)
+    }
+
+    args := os.Args[2:]
+    switch os.Args[1] {
+    case "import":
+        runImport(args)
+    case "convert":
+        runConvert(args)
+    case "inspect":
+        runInspect(args)
+    case "anonymize":
+        runAnonymize(args)
+    case "diff":
+        runDiff(args)
+    case "verify":
+        runVerify(args)
+    case "rollback":
+        runRollback(args)
+    case "inspect-discord":
+        runInspectDiscord(args)
+    case "inspect-simplex":
+        runInspectSimplex(args)
+    case "wizard":
+        runWizard(args)
+    case "list-contacts":
+        runListContacts(args)
+    case "serve":
+        runServer(args)
+    case "help":
+        fmt.Print(usage)
+    default:
+        fmt.Fprintf(os.Stderr, "Unknown command '%s'\n\n%s", os.Args[1], usage)
+        os.Exit(2)
+    }
+}
+
+// Clean up temp data on Ctrl+C too
+func cleanUpOnInterrupt() {
+    interrupts := make(chan os.Signal, 1)
+    signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+    go func() {
+        <-interrupts
+        fmt.Println("\nInterrupted, cleaning up temporary data...")
+        tempfiles.Cleanup()
+        os.Exit(130)
+    }()
+}
+
+// Ctrl+C cancels the returned context, so the import stops at the next step and undoes what it
+// set up on the way out like on any failure; a second one cleans up temp data and exits at once.
+// It takes over from the handler of the wizard in front of the import
+func cancelOnInterrupt() context.Context {
+    ctx, cancel := context.WithCancel(context.Background())
+    signal.Reset(os.Interrupt, syscall.SIGTERM)
+    interrupts := make(chan os.Signal, 1)
+    signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+    go func() {
+        <-interrupts
+        fmt.Println("\nInterrupted, stopping the import (again to quit right away)...")
+        cancel()
+        <-interrupts
+        fmt.Println("\nInterrupted, cleaning up temporary data...")
+        tempfiles.Cleanup()
+        os.Exit(130)
+    }()
+    return ctx
+}
+
+// import: convert a chat export and add it to a SimpleX database, archive, device or running client
+func runImport(args []string) {
+    fs := flag.NewFlagSet("import", flag.ExitOnError)
+    var opts importOptions
+    opts.register(fs)
+    fs.Parse(args)
+    opts.check()
+
+    ctx := cancelOnInterrupt()
+    // Temp data the import registers is cleaned up once it's done, by fatalf when it failed
+    defer tempfiles.Cleanup()
+
+    im := &importer{importOptions: opts, ctx: ctx}
+    if err := im.run(); err != nil {
+        if ctx.Err() != nil {
+            fmt.Println("Interrupted, cleaning up temporary data...")
+            tempfiles.Cleanup()
+            os.Exit(130)
+        }
+        fatalf("%v", err)
+    }
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// Write -manifest: SHA-256 checksums in the format of sha256sum, of the output archive by its
+// file name (none for outputs that aren't one) and of the files the import added to filesDir by
+// their path from root, the top of the export. `sha256sum -c --ignore-missing` next to the
+// archive checks it after a transfer, and in the extracted export the files
+func writeManifest(path, archivePath, root, filesDir string, files []string) error {
+    var b strings.Builder
+    if archivePath != "" {
+        sum, err := fileSHA256(archivePath)
+        if err != nil {
+            return fmt.Errorf("failed to hash %s: %w", archivePath, err)
+        }
+        fmt.Fprintf(&b, "%s  %s\n", sum, filepath.Base(archivePath))
+    }
+
+    for _, name := range files {
+        filePath := filepath.Join(filesDir, name)
+        sum, err := fileSHA256(filePath)
+        if err != nil {
+            return fmt.Errorf("failed to hash %s: %w", name, err)
+        }
+        // Files outside the export, like a -files-dir elsewhere, are listed by their full path
+        entry, err := filepath.Rel(root, filePath)
+        if err != nil || entry == ".." || strings.HasPrefix(entry, ".."+string(filepath.Separator)) {
+            entry, _ = filepath.Abs(filePath)
+        }
+        fmt.Fprintf(&b, "%s  %s\n", sum, filepath.ToSlash(entry))
+    }
+
+    return os.WriteFile(path, []byte(b.String()), 0644)
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "runtime"
+    "strings"
+    "time"
+
+    "github.com/ritiek/discord-to-simplex/pkg/archive"
+    "github.com/ritiek/discord-to-simplex/pkg/discord"
+    "github.com/ritiek/discord-to-simplex/pkg/media"
+    "github.com/ritiek/discord-to-simplex/pkg/simplexdb"
+    "github.com/ritiek/discord-to-simplex/pkg/tempfiles"
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// Flags of import, grouped by what they're about
+type importOptions struct {
+    source sourceOptions
+    target targetOptions
+    media  mediaOptions
+    safety safetyOptions
+    output outputOptions
+}
+
+// What's imported: the export or Discord channel, which of its messages and how they're converted
+type sourceOptions struct {
+    jsonPath         string
+    platform         string
+    myUsername       string
+    transforms       stringList
+    redact           stringList
+    dropMatching     stringList
+    discordChannelID string
+    discordToken     string
+    discordUserToken bool
+    timezone         string
+    after            string
+    before           string
+    skipBots         bool
+    skipWebhooks     bool
+    importDeleted    bool
+    strict           bool
+    parseErrorsPath  string
+    customEmojiMode  string
+    reactionEmoji    string
+    pinnedMode       string
+    missingReplies   string
+    maxMemory        string
+    sinceLastImport  bool
+    location         *time.Location // Set by check
+    filter           messageFilter  // Set by check
+}
+
+// Where the messages go: the SimpleX archive, database, device or client, the chat in it and
+// what the import changes about the contact
+type targetOptions struct {
+    zipPath           string
+    directDBPath      string // Set to the database of SimpleX Desktop by check for -desktop
+    exportDirPath     string
+    filesDirPath      string
+    androidMode       bool
+    androidDir        string
+    desktopMode       bool
+    liveURL           string
+    bridgeMode        bool
+    bridgeStatePath   string
+    pollInterval      time.Duration
+    contactName       string
+    contactID         int
+    profile           string
+    keyFile           string
+    keyCmd            string
+    keyringService    string
+    inMemory          bool
+    maxArchiveSize    string
+    updateContactName string
+    contactIdentity   bool
+    chatTag           string
+    mergeMode         string
+    maxArchiveBytes   int64 // Set by check
+}
+
+// What's done to the attachments on the way
+type mediaOptions struct {
+    cacheDir           string
+    transcodeAudio     bool
+    convertImages      string
+    stripMetadata      bool
+    maxAttachmentSize  string
+    noAttachments      bool
+    noAvatar           bool
+    encryptFiles       bool
+    workers            int
+    cache              bool
+    maxAttachmentBytes int64 // Set by check
+}
+
+// How careful the import is with the database: what it writes at once, what it keeps to go back
+// to and what it checks first
+type safetyOptions struct {
+    dryRun          bool
+    noBackup        bool
+    atomic          bool
+    resume          bool
+    allowDuplicates bool
+    batchSize       int
+    dropIndexes     string
+}
+
+// What the import writes besides the database: the updated archive, scripts, reports and profiles
+type outputOptions struct {
+    zipPath      string
+    dirPath      string
+    inPlace      bool
+    sqlPath      string
+    manifestPath string
+    idMapPath    string
+    reportPath   string
+    pprofTarget  string
+    vacuum       bool
+}
+
+func (o *importOptions) register(fs *flag.FlagSet) {
+    o.source.register(fs)
+    o.target.register(fs)
+    o.media.register(fs)
+    o.safety.register(fs)
+    o.output.register(fs)
+}
+
+func (o *sourceOptions) register(fs *flag.FlagSet) {
+    fs.StringVar(&o.jsonPath, "json", "", "Path to the export file, a DiscordChatExporter JSON export for -source discord (required)")
+    fs.StringVar(&o.platform, "source", "discord", "Platform the -json export comes from: "+strings.Join(universal.SourcePlatforms(), ", ")+" (optional)")
+    fs.Var(&o.transforms, "transform", "Run every message through this program (or .wasm module) as JSON before importing, may be given more than once (optional)")
+    fs.StringVar(&o.myUsername, "me", "", "Your Discord username to identify sent messages (required)")
+    fs.StringVar(&o.discordChannelID, "discord-channel", "", "Fetch the history of this Discord channel ID with the Discord API instead of reading -json (optional)")
+    fs.StringVar(&o.discordToken, "discord-token", os.Getenv("DISCORD_TOKEN"), "Discord bot token for the Discord API (optional, defaults to the DISCORD_TOKEN environment variable)")
+    fs.BoolVar(&o.discordUserToken, "discord-user-token", false, "-discord-token is a user account token rather than a bot token (optional)")
+    fs.BoolVar(&o.strict, "strict", false, "Stop when a message of the export can't be read in full instead of leaving it out (optional)")
+    fs.StringVar(&o.parseErrorsPath, "parse-errors", "", "Write the messages of the export that can't be read in full, with the reasons, to this file; defaults to the -json file with .errors.jsonl appended (optional)")
+    fs.BoolVar(&o.sinceLastImport, "since-last-import", false, "Only import messages newer than what the last recorded import into the chat brought in, to top up the history from a newer export (optional)")
+    fs.StringVar(&o.timezone, "timezone", "Local", "IANA time zone used to render Discord timestamp markup and for export times without a UTC offset, e.g. Europe/Berlin (optional)")
+    fs.StringVar(&o.after, "after", "", "Only import messages from this time (RFC3339) or date (YYYY-MM-DD, midnight in -timezone) on (optional)")
+    fs.StringVar(&o.before, "before", "", "Only import messages before this time (RFC3339) or date (YYYY-MM-DD, midnight in -timezone) (optional)")
+    fs.BoolVar(&o.skipBots, "skip-bots", false, "Leave out messages of bots, webhooks included (optional)")
+    fs.BoolVar(&o.skipWebhooks, "skip-webhooks", false, "Leave out messages posted through webhooks, keeping other bots (optional)")
+    fs.Var(&o.redact, "redact", "Replace matches of a regular expression in message text with a replacement, given as pattern=replacement, may be given more than once (optional)")
+    fs.Var(&o.dropMatching, "drop-matching", "Leave out messages whose text matches this regular expression, may be given more than once (optional)")
+    fs.BoolVar(&o.importDeleted, "import-deleted", false, "Import messages marked deleted in the export as 'marked deleted' items instead of skipping them (optional)")
+    fs.StringVar(&o.customEmojiMode, "custom-emoji", discord.CustomEmojiText, "How to import reactions with Discord custom emoji: text, unicode or skip (optional)")
+    fs.StringVar(&o.reactionEmoji, "reaction-emoji", simplexdb.ReactionEmojiStrip, "How to import reactions with emoji SimpleX doesn't show, like skin tones, ZWJ sequences, keycaps and flags: strip (to the base emoji, leaving out keycaps and flags) or drop (keep only SimpleX's own reactions) (optional)")
+    fs.StringVar(&o.pinnedMode, "pinned", discord.PinnedNone, "How to mark pinned Discord messages: marker (prepend 📌) or none (optional)")
+    fs.StringVar(&o.missingReplies, "missing-replies", discord.MissingRepliesPlaceholder, "What replies to messages that aren't in the export quote: placeholder (\"message not in export\") or drop (no quote) (optional)")
+    fs.StringVar(&o.maxMemory, "max-memory", "", "Keep at most this much of the converted messages in memory (e.g. 512MB) and spill the rest to a temporary file in -temp-dir that the import streams from (optional)")
+}
+
+func (o *targetOptions) register(fs *flag.FlagSet) {
+    fs.StringVar(&o.contactName, "contact", "", "SimpleX contact name to import messages to (required unless -contact-id is used)")
+    fs.IntVar(&o.contactID, "contact-id", 0, "ID of the SimpleX contact to import messages to instead of -contact, as shown by list-contacts (optional)")
+    fs.StringVar(&o.profile, "profile", "", "SimpleX user profile the contact belongs to, by name or ID, for databases with more than one (optional, defaults to the active profile)")
+    fs.StringVar(&o.zipPath, "zip", "", "Path to SimpleX export ZIP file (required unless -db is used)")
+    fs.StringVar(&o.directDBPath, "db", "", "Update this simplex_v1_chat.db directly instead of a ZIP export, e.g. a copy of the desktop data directory (optional)")
+    fs.StringVar(&o.exportDirPath, "dir", "", "Update an already extracted SimpleX export directory in place, or write it to -output as a ZIP (optional)")
+    fs.BoolVar(&o.androidMode, "android", false, "Pull the newest SimpleX database export from a device connected over adb and push the updated archive back (optional)")
+    fs.StringVar(&o.androidDir, "android-dir", defaultAndroidExportDir, "Directory on the Android device holding SimpleX database exports (optional)")
+    fs.BoolVar(&o.desktopMode, "desktop", false, "Import straight into the local SimpleX Desktop data directory (the app must be closed) (optional)")
+    fs.StringVar(&o.liveURL, "live", "", "Send the history as new messages through a running simplex-chat WebSocket API, e.g. ws://localhost:5225, instead of editing a database; original timestamps are only kept in the text (optional)")
+    fs.BoolVar(&o.bridgeMode, "bridge", false, "Keep running and mirror new messages of -discord-channel into the -live simplex-chat (optional)")
+    fs.StringVar(&o.bridgeStatePath, "bridge-state", "", "File where -bridge remembers what it mirrored (optional, defaults to bridge-<channel>.json in -cache-dir)")
+    fs.DurationVar(&o.pollInterval, "poll-interval", 15*time.Second, "How often -bridge checks Discord for new messages (optional)")
+    fs.StringVar(&o.filesDirPath, "files-dir", "", "SimpleX files directory to copy attachments to with -db (optional, defaults to simplex_v1_files next to the database)")
+    fs.StringVar(&o.mergeMode, "merge", simplexdb.MergeAppend, "How to import into a chat that has newer messages already: append (imported messages get the highest IDs) or renumber (renumber the chat in time order) (optional)")
+    fs.StringVar(&o.keyFile, "key-file", "", "Read the database password from the first line of this file (optional)")
+    fs.StringVar(&o.keyCmd, "key-cmd", "", "Run this command and use the first line of its output as the database password, e.g. \"pass show simplex\" (optional)")
+    fs.StringVar(&o.keyringService, "keyring", "", "Look up the database password under this service name in the OS keychain (optional)")
+    fs.StringVar(&o.updateContactName, "update-contact-name", "", "Set the contact's display name, full name or both to the Discord nickname of the other side of the chat: display, full or both (optional)")
+    fs.BoolVar(&o.contactIdentity, "contact-identity", false, "Put the other side's Discord username and ID into the contact's full name (optional)")
+    fs.StringVar(&o.chatTag, "tag", "", "Tag the chat with this SimpleX chat tag, e.g. discord-import, creating it when there's none (optional)")
+    fs.BoolVar(&o.inMemory, "in-memory", false, "Extract only the databases, to a memory-backed directory, and stream the existing files into the output ZIP to minimize plaintext left on disk (optional)")
+    fs.StringVar(&tempfiles.Root, "temp-dir", "", "Directory for temporary extraction and generated media, e.g. a RAM disk (optional, defaults to the system temp directory)")
+    fs.StringVar(&tempfiles.Wipe, "wipe", tempfiles.WipeOverwrite, "How to remove temporary data on exit: overwrite (zero files before deleting), delete or keep (optional)")
+    fs.StringVar(&o.maxArchiveSize, "max-archive-size", "64GB", "Refuse SimpleX archives whose contents add up to more than this, 0 for no limit (optional)")
+}
+
+func (o *mediaOptions) register(fs *flag.FlagSet) {
+    fs.IntVar(&o.workers, "media-workers", runtime.NumCPU(), "Attachments made ready at once (previews, thumbnails, durations and copies), ahead of the batch being inserted; defaults to the number of CPUs (optional)")
+    fs.BoolVar(&o.cache, "media-cache", false, "Keep the previews, thumbnails and durations made for attachments in -cache-dir, so later imports of the same files reuse them instead of making them again (optional)")
+    fs.StringVar(&o.cacheDir, "cache-dir", media.DefaultCacheDir(), "Directory for caching attachments downloaded from the Discord CDN (optional)")
+    fs.BoolVar(&o.transcodeAudio, "transcode-audio", false, "Transcode ogg/opus, wav and mp3 voice messages to m4a/aac with ffmpeg for playback on all SimpleX clients (optional)")
+    fs.StringVar(&o.convertImages, "convert-images", media.ImageConvertJPEG, "Convert webp/heic/heif images to jpeg or png, or none to keep them as they are (optional)")
+    fs.BoolVar(&o.stripMetadata, "strip-metadata", false, "Remove EXIF/GPS and other metadata from imported JPEG and PNG images (optional)")
+    fs.StringVar(&o.maxAttachmentSize, "max-attachment-size", "", "Skip attachments larger than this (e.g. 25MB) and leave a text placeholder instead (optional)")
+    fs.BoolVar(&o.noAvatar, "no-avatar", false, "Don't give the contact a profile image from the Discord avatar of the other side of the chat when it has none (optional)")
+    fs.BoolVar(&o.noAttachments, "no-attachments", false, "Import only message text, quotes and reactions, skipping all media processing and file copying (optional)")
+    fs.BoolVar(&o.encryptFiles, "encrypt-files", false, "Encrypt copied attachments with per-file keys like SimpleX's \"encrypt local files\" setting (optional)")
+}
+
+func (o *safetyOptions) register(fs *flag.FlagSet) {
+    fs.IntVar(&o.batchSize, "batch-size", 500, "Messages inserted in each transaction: larger batches import faster, smaller ones hold the database for less time at once (optional)")
+    fs.BoolVar(&o.dryRun, "dry-run", false, "Do everything up to and including the inserts, then roll them back and report what would be imported instead of writing the database or an archive (optional)")
+    fs.BoolVar(&o.atomic, "atomic", false, "Import everything in one transaction, each batch a savepoint in it, so a failure leaves the database as it was instead of with the batches committed so far (optional)")
+    fs.BoolVar(&o.resume, "resume", false, "Continue an import that stopped part-way from the last batch it committed, using the checkpoint it left next to the -zip, -db or -dir (optional)")
+    fs.BoolVar(&o.allowDuplicates, "allow-duplicates", false, "Import messages even if the chat already has them from an earlier import, instead of skipping them (optional)")
+    fs.StringVar(&o.dropIndexes, "drop-indexes", simplexdb.DropIndexesAuto, fmt.Sprintf("Drop the secondary indexes of the tables the import fills and build them again at the end, which is faster for big imports: auto (with %d messages or more), always or never (optional)", simplexdb.BulkLoadMessages))
+    fs.BoolVar(&o.noBackup, "no-backup", false, "Don't copy the -zip, or the database of -db, -dir and -desktop, to a timestamped .bak next to it before the import (optional)")
+}
+
+func (o *outputOptions) register(fs *flag.FlagSet) {
+    fs.StringVar(&o.zipPath, "output", "", "Path for output SimpleX ZIP file (optional, defaults to input with '_updated' suffix)")
+    fs.StringVar(&o.dirPath, "output-dir", "", "Write the updated -zip export to this new or empty directory as its extracted files instead of a ZIP, to compress yourself or point SimpleX Desktop at (optional)")
+    fs.StringVar(&o.sqlPath, "sql-output", "", "Write the INSERT statements to this .sql file for review or applying with sqlcipher, instead of changing the database (optional)")
+    fs.StringVar(&o.manifestPath, "manifest", "", "Write SHA-256 checksums of the output archive and of the attachments the import added to its files directory to this file, in sha256sum's format (optional)")
+    fs.StringVar(&o.idMapPath, "id-map", "", "Append which shared_msg_id and chat_item_id every imported message got to this CSV file, by its source message ID (optional)")
+    fs.BoolVar(&o.vacuum, "vacuum", false, "VACUUM the database after the import to give back the space freed pages take up, which compacts the output after large imports but takes a while (optional)")
+    fs.StringVar(&o.reportPath, "report", "", "Write a report of what was imported, skipped and failed to this .json or .yaml file when done (optional)")
+    fs.BoolVar(&o.inPlace, "in-place", false, "Update the -zip archive itself (atomically, keeping the original as <zip>.bak) instead of writing an '_updated' copy (optional)")
+    fs.StringVar(&o.pprofTarget, "pprof", "", "Profile the import: serve net/http/pprof on this host:port while it runs, or write cpu.pprof and heap.pprof to this directory when it's done (optional)")
+    fs.BoolVar(&printTimings, "timings", false, "Print how long each phase took at the end: parsing, converting, media, the inserts into each table, zipping (optional)")
+}
+
+// Check the flags and parse the values they hold, finding the database of SimpleX Desktop for
+// -desktop; called before any temp data exists
+func (o *importOptions) check() {
+    source, target, safety, output := &o.source, &o.target, &o.safety, &o.output
+    live := target.liveURL != ""
+
+    if output.sqlPath != "" && (target.bridgeMode || live || safety.dryRun || output.inPlace || output.zipPath != "") {
+        log.Fatal("-sql-output writes a script instead of changing SimpleX and can't be combined with -live, -bridge, -dry-run, -in-place or -output.")
+    }
+    if output.manifestPath != "" && (live || safety.dryRun) {
+        log.Fatal("-manifest lists the files an import writes and can't be combined with -live, -bridge or -dry-run.")
+    }
+    if target.profile != "" && live {
+        log.Fatal("-profile can't be used with -live, which imports into the profile active in simplex-chat.")
+    }
+    switch target.updateContactName {
+    case "", "display", "full", "both":
+    default:
+        log.Fatalf("Invalid -update-contact-name value '%s': must be display, full or both", target.updateContactName)
+    }
+    if (target.updateContactName != "" || target.contactIdentity || target.chatTag != "") && live {
+        log.Fatal("-update-contact-name, -contact-identity and -tag change the contact in the database and can't be used with -live or -bridge.")
+    }
+    target.chatTag = strings.TrimSpace(target.chatTag)
+    if target.mergeMode != simplexdb.MergeAppend && target.mergeMode != simplexdb.MergeRenumber {
+        log.Fatalf("Invalid -merge value '%s': must be append or renumber", target.mergeMode)
+    }
+    if safety.dropIndexes != simplexdb.DropIndexesAuto && safety.dropIndexes != simplexdb.DropIndexesAlways && safety.dropIndexes != simplexdb.DropIndexesNever {
+        log.Fatalf("Invalid -drop-indexes value '%s': must be auto, always or never", safety.dropIndexes)
+    }
+    if target.mergeMode == simplexdb.MergeRenumber && live {
+        log.Fatal("-merge renumber can't be used with -live, which sends the messages as new ones.")
+    }
+    if output.idMapPath != "" && live {
+        log.Fatal("-id-map can't be used with -live, simplex-chat picks the IDs of the messages it sends.")
+    }
+    if source.sinceLastImport && live {
+        log.Fatal("-since-last-import reads the import_runs ledger of a database and can't be used with -live.")
+    }
+    if safety.resume && (safety.dryRun || output.sqlPath != "" || live || target.androidMode || safety.allowDuplicates) {
+        log.Fatal("-resume can't be used with -dry-run, -sql-output, -live, -android or -allow-duplicates.")
+    }
+    if safety.atomic && (safety.resume || live) {
+        log.Fatal("-atomic can't be used with -resume, an atomic import that stops leaves nothing to continue, or with -live.")
+    }
+    if target.bridgeMode && (safety.dryRun || output.reportPath != "") {
+        log.Fatal("-dry-run and -report can't be used with -bridge.")
+    }
+    if target.bridgeMode {
+        if !live || source.discordChannelID == "" {
+            log.Fatal("-bridge needs -live (the simplex-chat to mirror into) and -discord-channel.")
+        }
+    } else if source.jsonPath == "" && source.discordChannelID == "" {
+        log.Fatal("JSON file path is required. Use -json flag (or -discord-channel to fetch from Discord).")
+    }
+    if source.jsonPath != "" && source.discordChannelID != "" {
+        log.Fatal("-json and -discord-channel can't be used together.")
+    }
+    if (source.discordChannelID != "" || target.bridgeMode) && source.platform != "discord" {
+        log.Fatal("-discord-channel and -bridge only work with -source discord.")
+    }
+    if source.discordChannelID != "" && source.discordToken == "" {
+        log.Fatal("-discord-channel needs a Discord token. Use -discord-token or DISCORD_TOKEN.")
+    }
+    if source.myUsername == "" {
+        log.Fatal("Username is required. Use -me flag.")
+    }
+    if target.contactName == "" && target.contactID == 0 {
+        log.Fatal("Contact name is required. Use -contact flag (or -contact-id for a contact ID).")
+    }
+    if target.contactName != "" && target.contactID != 0 {
+        log.Fatal("Only one of -contact and -contact-id can be used.")
+    }
+    if target.contactID < 0 {
+        log.Fatalf("Invalid -contact-id value '%d': must be a positive number", target.contactID)
+    }
+    if safety.batchSize < 1 {
+        log.Fatalf("Invalid -batch-size value '%d': must be a positive number", safety.batchSize)
+    }
+    if o.media.workers < 1 {
+        log.Fatalf("Invalid -media-workers value '%d': must be a positive number", o.media.workers)
+    }
+    inputs := 0
+    for _, input := range []string{target.zipPath, target.directDBPath, target.exportDirPath} {
+        if input != "" {
+            inputs++
+        }
+    }
+    if target.androidMode {
+        inputs++
+    }
+    if target.desktopMode {
+        inputs++
+    }
+    if live {
+        inputs++
+    }
+    if inputs == 0 {
+        log.Fatal("SimpleX ZIP file path is required. Use -zip flag (or -db for a database file, -dir for an extracted export, -android for a connected phone, -desktop for SimpleX Desktop, -live for a running simplex-chat).")
+    }
+    if inputs > 1 {
+        log.Fatal("Only one of -zip, -db, -dir, -android, -desktop and -live can be used.")
+    }
+    if target.desktopMode {
+        dataDir, err := findDesktopDataDir()
+        if err != nil {
+            log.Fatalf("Failed to find SimpleX Desktop data: %v", err)
+        }
+        running, err := simplexDesktopRunning()
+        if err != nil {
+            log.Fatalf("Failed to check whether SimpleX Desktop is running: %v", err)
+        }
+        if running {
+            log.Fatal("SimpleX Desktop is running, quit it before importing into its database.")
+        }
+        fmt.Printf("Using SimpleX Desktop data directory: %s\n", dataDir)
+        target.directDBPath = filepath.Join(dataDir, "simplex_v1_chat.db")
+    }
+    if target.androidMode && output.inPlace {
+        log.Fatal("-in-place can't be used with -android, the updated archive is pushed next to the original.")
+    }
+    if target.androidMode {
+        if _, err := exec.LookPath("adb"); err != nil {
+            log.Fatal("-android requires adb (Android platform tools) to be installed")
+        }
+    }
+    if target.directDBPath != "" && (output.zipPath != "" || target.inMemory) {
+        log.Fatal("-output and -in-memory only apply to ZIP exports, -db updates the database in place.")
+    }
+    if output.inPlace && (target.zipPath == "" || output.zipPath != "") {
+        log.Fatal("-in-place updates the -zip archive and can't be combined with -output, -db or -dir.")
+    }
+    // The backup of an earlier -in-place import may be the only copy of the archive before it
+    if output.inPlace && !safety.dryRun {
+        if _, err := os.Lstat(archive.BackupPath(target.zipPath)); err == nil {
+            log.Fatalf("%s from an earlier -in-place import is still there; put it back with rollback or move it away first.", archive.BackupPath(target.zipPath))
+        }
+    }
+    if output.dirPath != "" && (target.zipPath == "" || output.zipPath != "" || output.inPlace || target.inMemory || target.androidMode || output.sqlPath != "") {
+        log.Fatal("-output-dir writes the -zip export as a directory and can't be combined with -output, -in-place, -in-memory, -android, -sql-output, -db, -dir, -desktop or -live.")
+    }
+    if output.dirPath != "" {
+        if entries, err := os.ReadDir(output.dirPath); err == nil && len(entries) > 0 {
+            log.Fatalf("-output-dir %s isn't empty; give a new or empty directory.", output.dirPath)
+        } else if err != nil && !os.IsNotExist(err) {
+            log.Fatalf("Invalid -output-dir: %v", err)
+        }
+    }
+    if target.exportDirPath != "" && target.inMemory {
+        log.Fatal("-in-memory only applies to ZIP exports, -dir is already extracted.")
+    }
+    if target.filesDirPath != "" && target.directDBPath == "" {
+        log.Fatal("-files-dir can only be used with -db.")
+    }
+
+    checkConvertModes(source.customEmojiMode, source.pinnedMode, source.missingReplies)
+    switch source.reactionEmoji {
+    case simplexdb.ReactionEmojiStrip, simplexdb.ReactionEmojiDrop:
+    default:
+        log.Fatalf("Invalid -reaction-emoji value '%s': must be strip or drop", source.reactionEmoji)
+    }
+
+    switch o.media.convertImages {
+    case media.ImageConvertJPEG, media.ImageConvertPNG, media.ImageConvertNone:
+    default:
+        log.Fatalf("Invalid -convert-images value '%s': must be jpeg, png or none", o.media.convertImages)
+    }
+
+    if o.media.transcodeAudio && !o.media.noAttachments {
+        if _, err := exec.LookPath("ffmpeg"); err != nil {
+            log.Fatal("-transcode-audio requires ffmpeg to be installed")
+        }
+    }
+
+    var err error
+    if o.media.maxAttachmentSize != "" {
+        o.media.maxAttachmentBytes, err = universal.ParseByteSize(o.media.maxAttachmentSize)
+        if err != nil {
+            log.Fatalf("Invalid -max-attachment-size: %v", err)
+        }
+    }
+
+    target.maxArchiveBytes, err = universal.ParseByteSize(target.maxArchiveSize)
+    if err != nil {
+        log.Fatalf("Invalid -max-archive-size: %v", err)
+    }
+
+    var maxMemoryBytes int64
+    if source.maxMemory != "" {
+        maxMemoryBytes, err = universal.ParseByteSize(source.maxMemory)
+        if err != nil {
+            log.Fatalf("Invalid -max-memory: %v", err)
+        }
+    }
+
+    checkWipeMode()
+
+    source.location, err = time.LoadLocation(source.timezone)
+    if err != nil {
+        log.Fatalf("Invalid time zone '%s': %v", source.timezone, err)
+    }
+    source.filter = messageFilter{
+        ImportDeleted: source.importDeleted,
+        Transforms:    source.transforms,
+        After:         parseTimeFlag("after", source.after, source.location),
+        Before:        parseTimeFlag("before", source.before, source.location),
+        SkipBots:      source.skipBots,
+        SkipWebhooks:  source.skipWebhooks,
+        Strict:        source.strict,
+        ParseErrors:   parseErrorsFile(source.parseErrorsPath, source.jsonPath),
+        MaxMemory:     maxMemoryBytes,
+    }
+    source.filter.Redactions, source.filter.DropMatching = parseContentRules(source.redact, source.dropMatching)
+}
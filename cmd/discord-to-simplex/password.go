@@ -0,0 +1,124 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "os"
+    "os/exec"
+    "runtime"
+    "strings"
+
+    "golang.org/x/term"
+)
+
+// Prompt for SimpleX database password securely
+func promptForPassword() (string, error) {
+    fmt.Print("Enter SimpleX database password: ")
+
+    // Check if we're running in a terminal (os.Stdin.Fd() is the console handle on Windows)
+    stdinFd := int(os.Stdin.Fd())
+    if term.IsTerminal(stdinFd) {
+        // Use secure password input (no echo)
+        passwordBytes, err := term.ReadPassword(stdinFd)
+        fmt.Println() // Print newline after password input
+        if err != nil {
+            return "", fmt.Errorf("failed to read password: %w", err)
+        }
+        return string(passwordBytes), nil
+    } else {
+        // Fallback for non-terminal input (testing, pipes, etc.)
+        reader := bufio.NewReader(os.Stdin)
+        password, err := reader.ReadString('\n')
+        if err != nil {
+            return "", fmt.Errorf("failed to read password: %w", err)
+        }
+        return strings.TrimSpace(password), nil
+    }
+}
+
+// Use the first line of a secret source, so files written with a trailing newline and
+// pass-style stores (password on the first line, notes after it) both work
+func firstLine(data []byte) string {
+    line := string(data)
+    if i := strings.IndexAny(line, "\r\n"); i >= 0 {
+        line = line[:i]
+    }
+    return line
+}
+
+// Read the database password from a file
+func readPasswordFile(path string) (string, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return "", fmt.Errorf("failed to read key file: %w", err)
+    }
+    return firstLine(data), nil
+}
+
+// Run a command (e.g. "pass show simplex") and use its output as the database password
+func runPasswordCommand(command string) (string, error) {
+    var cmd *exec.Cmd
+    if runtime.GOOS == "windows" {
+        cmd = exec.Command("cmd", "/C", command)
+    } else {
+        cmd = exec.Command("sh", "-c", command)
+    }
+    cmd.Stdin = os.Stdin
+    cmd.Stderr = os.Stderr
+
+    output, err := cmd.Output()
+    if err != nil {
+        return "", fmt.Errorf("key command failed: %w", err)
+    }
+    return firstLine(output), nil
+}
+
+// Look up the database password in the OS keychain: the macOS Keychain through `security`,
+// or the Secret Service (GNOME Keyring, KWallet) through `secret-tool` elsewhere
+func lookupKeyringPassword(service string) (string, error) {
+    var cmd *exec.Cmd
+    switch runtime.GOOS {
+    case "darwin":
+        cmd = exec.Command("security", "find-generic-password", "-s", service, "-w")
+    case "windows":
+        return "", fmt.Errorf("keyring lookup is not supported on Windows, use -key-cmd or -key-file instead")
+    default:
+        cmd = exec.Command("secret-tool", "lookup", "service", service)
+    }
+    cmd.Stderr = os.Stderr
+
+    output, err := cmd.Output()
+    if err != nil {
+        return "", fmt.Errorf("keyring lookup for %q failed: %w", service, err)
+    }
+    return firstLine(output), nil
+}
+
+// Get the database password from whichever source was given, falling back to
+// SQLCIPHER_KEY and then an interactive prompt
+func resolvePassword(keyFile, keyCmd, keyringService string) (string, error) {
+    sources := 0
+    for _, source := range []string{keyFile, keyCmd, keyringService} {
+        if source != "" {
+            sources++
+        }
+    }
+    if sources > 1 {
+        return "", fmt.Errorf("only one of -key-file, -key-cmd and -keyring can be used")
+    }
+
+    switch {
+    case keyFile != "":
+        return readPasswordFile(keyFile)
+    case keyCmd != "":
+        return runPasswordCommand(keyCmd)
+    case keyringService != "":
+        return lookupKeyringPassword(keyringService)
+    }
+
+    if password := os.Getenv("SQLCIPHER_KEY"); password != "" {
+        return password, nil
+    }
+    fmt.Println("SQLCIPHER_KEY environment variable not set.")
+    return promptForPassword()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "net/http/pprof"
+    "os"
+    "path/filepath"
+    "runtime"
+    runtimepprof "runtime/pprof"
+    "strconv"
+
+    "github.com/ritiek/discord-to-simplex/pkg/timing"
+)
+
+// Phases of the running import and how long they took; the report includes them and -timings
+// prints them at the end
+var activeTimings *timing.Timings
+var printTimings bool
+
+// Writes the profiles -pprof is recording into a directory, nil while there are none
+var activeProfileStop func()
+
+// Start -pprof: a host:port serves net/http/pprof there for the length of the import, anything
+// else is a directory that gets cpu.pprof of the whole import and heap.pprof at its end
+func startProfiling(target string) error {
+    if _, port, err := net.SplitHostPort(target); err == nil {
+        if _, err := strconv.Atoi(port); err == nil {
+            return serveProfiles(target)
+        }
+    }
+
+    if err := os.MkdirAll(target, 0755); err != nil {
+        return fmt.Errorf("failed to create profile directory: %w", err)
+    }
+    cpuPath := filepath.Join(target, "cpu.pprof")
+    cpuFile, err := os.Create(cpuPath)
+    if err != nil {
+        return fmt.Errorf("failed to create CPU profile: %w", err)
+    }
+    if err := runtimepprof.StartCPUProfile(cpuFile); err != nil {
+        cpuFile.Close()
+        return fmt.Errorf("failed to start CPU profile: %w", err)
+    }
+    activeProfileStop = func() {
+        runtimepprof.StopCPUProfile()
+        cpuFile.Close()
+
+        heapPath := filepath.Join(target, "heap.pprof")
+        heapFile, err := os.Create(heapPath)
+        if err != nil {
+            log.Printf("Warning: failed to write heap profile: %v", err)
+            return
+        }
+        defer heapFile.Close()
+        // Up to date statistics of what's still allocated
+        runtime.GC()
+        if err := runtimepprof.WriteHeapProfile(heapFile); err != nil {
+            log.Printf("Warning: failed to write heap profile: %v", err)
+            return
+        }
+        fmt.Printf("Wrote profiles: %s, %s\n", cpuPath, heapPath)
+    }
+    return nil
+}
+
+// Serve the pprof endpoints at addr, on a mux of their own so they never end up next to serve's
+func serveProfiles(addr string) error {
+    listener, err := net.Listen("tcp", addr)
+    if err != nil {
+        return fmt.Errorf("failed to listen for profiling: %w", err)
+    }
+    mux := http.NewServeMux()
+    mux.HandleFunc("/debug/pprof/", pprof.Index)
+    mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+    mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+    mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+    mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+    go http.Serve(listener, mux)
+    fmt.Printf("Serving profiles on http://%s/debug/pprof/\n", listener.Addr())
+    return nil
+}
+
+// Write the profiles and print the timings of an import that's done, successfully or not
+func finishProfiling() {
+    if stop := activeProfileStop; stop != nil {
+        activeProfileStop = nil
+        stop()
+    }
+    if printTimings {
+        fmt.Println()
+        activeTimings.Print(os.Stdout)
+    }
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strings"
+    "time"
+
+    "github.com/ritiek/discord-to-simplex/pkg/simplexdb"
+    "github.com/ritiek/discord-to-simplex/pkg/timing"
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// What an import did, written by -report for scripts and audits
+type importReport struct {
+    Tool       string    `json:"tool"`
+    Version    string    `json:"version"`
+    Status     string    `json:"status"` // complete, dry-run, sql-script or failed
+    Error      string    `json:"error,omitempty"`
+    StartedAt  time.Time `json:"startedAt"`
+    FinishedAt time.Time `json:"finishedAt"`
+
+    Source struct {
+        Platform string `json:"platform"`
+        Path     string `json:"path,omitempty"`
+        Chat     string `json:"chat"`
+        Messages int    `json:"messages"`
+    } `json:"source"`
+
+    Target struct {
+        Kind           string   `json:"kind"` // zip, db, dir, android, desktop, live or sql
+        Path           string   `json:"path,omitempty"`
+        Output         string   `json:"output,omitempty"`
+        OutputBytes    int64    `json:"outputBytes,omitempty"`
+        Contact        string   `json:"contact"`
+        ContactID      int      `json:"contactId,omitempty"`
+        ProfileUpdated []string `json:"profileUpdated,omitempty"` // Fields of the contact's profile filled in from the export
+        Tag            string   `json:"tag,omitempty"` // -tag the chat has
+        UserID         int      `json:"userId,omitempty"` // User profile the contact belongs to
+        Schema         string   `json:"schema,omitempty"` // Newest migration of the database
+    } `json:"target"`
+
+    Imported struct {
+        Items          int            `json:"items"`
+        ByType         map[string]int `json:"byType"`
+        Redacted       int            `json:"redacted"`       // Changed by -redact
+        Sanitized      int            `json:"sanitized"`      // Had invalid UTF-8 or control characters cleaned up
+        SanitizedFiles int            `json:"sanitizedFiles"` // Attachment file names cleaned up
+    } `json:"imported"`
+
+    Skipped struct {
+        Deleted              int `json:"deleted"`
+        Unreadable           int `json:"unreadable"` // Messages of the export that couldn't be read
+        OutOfRange           int `json:"outOfRange"` // Left out by -after and -before
+        Bots                 int `json:"bots"`       // Left out by -skip-bots and -skip-webhooks
+        Matching             int `json:"matching"`   // Left out by -drop-matching
+        DroppedByTransforms  int `json:"droppedByTransforms"`
+        AlreadyImported      int `json:"alreadyImported"` // Already in the chat from an earlier import
+        OlderThanLastImport  int `json:"olderThanLastImport"` // Left out by -since-last-import
+        OversizedAttachments int `json:"oversizedAttachments"`
+        Attachments          int `json:"attachments"` // Left out by -no-attachments
+    } `json:"skipped"`
+
+    // IDs used, files copied and attachments that failed
+    Insert *simplexdb.InsertReport `json:"insert,omitempty"`
+
+    // How long each phase took
+    Timings []timing.Phase `json:"timings,omitempty"`
+}
+
+// Report of the running import and where -report writes it; fatalf writes it as failed
+var activeReport *importReport
+var activeReportPath string
+
+// Start the report of an import that writes it to path
+func startReport(path string) *importReport {
+    report := &importReport{
+        Tool:      "discord-to-simplex",
+        Version:   version,
+        StartedAt: time.Now(),
+        Insert:    &simplexdb.InsertReport{},
+    }
+    report.Imported.ByType = make(map[string]int)
+    activeReport, activeReportPath = report, path
+    return report
+}
+
+// Count the messages that are being imported
+func (r *importReport) setMessages(messages *universal.MessageQueue) {
+    r.Imported.Items = messages.Len()
+    r.Imported.ByType = make(map[string]int)
+    eachMessages(messages, func(chunk []universal.Message) {
+        for _, msg := range chunk {
+            r.Imported.ByType[msg.MessageType]++
+        }
+    })
+}
+
+// Write the report with its final status, as YAML for .yaml/.yml paths and JSON otherwise, with
+// the profiles and timings of the import
+func (r *importReport) finish(status string) {
+    r.Status = status
+    r.FinishedAt = time.Now()
+    finishProfiling()
+    r.Timings = activeTimings.Phases()
+    if r.Target.Output != "" {
+        if info, err := os.Stat(r.Target.Output); err == nil && !info.IsDir() {
+            r.Target.OutputBytes = info.Size()
+        }
+    }
+    activeReport = nil
+    if activeReportPath == "" {
+        return
+    }
+
+    file, err := os.Create(activeReportPath)
+    if err != nil {
+        log.Printf("Warning: failed to write report: %v", err)
+        return
+    }
+    defer file.Close()
+
+    switch strings.ToLower(filepath.Ext(activeReportPath)) {
+    case ".yaml", ".yml":
+        err = writeYAML(file, r)
+    default:
+        encoder := json.NewEncoder(file)
+        encoder.SetIndent("", "  ")
+        err = encoder.Encode(r)
+    }
+    if err != nil {
+        log.Printf("Warning: failed to write report: %v", err)
+        return
+    }
+    fmt.Printf("Wrote import report: %s\n", activeReportPath)
+}
+
+var plainYAMLKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// Write v as YAML, in the field order of its JSON encoding. Strings are double-quoted, which
+// YAML reads the same way as JSON
+func writeYAML(w io.Writer, v interface{}) error {
+    data, err := json.Marshal(v)
+    if err != nil {
+        return err
+    }
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    decoder.UseNumber()
+
+    var b strings.Builder
+    if err := writeYAMLValue(decoder, &b, ""); err != nil {
+        return err
+    }
+    _, err = io.WriteString(w, strings.TrimPrefix(b.String(), "\n")+"\n")
+    return err
+}
+
+func writeYAMLValue(decoder *json.Decoder, b *strings.Builder, indent string) error {
+    token, err := decoder.Token()
+    if err != nil {
+        return err
+    }
+
+    switch t := token.(type) {
+    case json.Delim:
+        empty := true
+        for decoder.More() {
+            empty = false
+            if t == '[' {
+                b.WriteString("\n" + indent + "-")
+            } else {
+                key, err := decoder.Token()
+                if err != nil {
+                    return err
+                }
+                name := key.(string)
+                if !plainYAMLKey.MatchString(name) {
+                    quoted, _ := json.Marshal(name)
+                    name = string(quoted)
+                }
+                b.WriteString("\n" + indent + name + ":")
+            }
+            if err := writeYAMLValue(decoder, b, indent+"  "); err != nil {
+                return err
+            }
+        }
+        if _, err := decoder.Token(); err != nil {
+            return err
+        }
+        if empty && t == '[' {
+            b.WriteString(" []")
+        } else if empty {
+            b.WriteString(" {}")
+        }
+    case string:
+        quoted, _ := json.Marshal(t)
+        b.WriteString(" " + string(quoted))
+    case nil:
+        b.WriteString(" null")
+    default:
+        b.WriteString(fmt.Sprintf(" %v", t))
+    }
+    return nil
+}
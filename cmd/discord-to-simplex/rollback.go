@@ -0,0 +1,26 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "log"
+
+    "github.com/ritiek/discord-to-simplex/pkg/archive"
+)
+
+// rollback: undo an -in-place import by putting the <zip>.bak it kept back in place
+func runRollback(args []string) {
+    fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+    zipPath := fs.String("zip", "", "SimpleX export ZIP file that was updated with -in-place (required)")
+    fs.Parse(args)
+
+    if *zipPath == "" {
+        log.Fatal("SimpleX ZIP file path is required. Use -zip flag.")
+    }
+
+    backupPath, err := archive.Restore(*zipPath)
+    if err != nil {
+        log.Fatalf("Failed to roll back %s: %v", *zipPath, err)
+    }
+    fmt.Printf("Restored %s from %s\n", *zipPath, backupPath)
+}
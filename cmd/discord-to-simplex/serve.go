@@ -0,0 +1,198 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+
+    "github.com/ritiek/discord-to-simplex/pkg/archive"
+    "github.com/ritiek/discord-to-simplex/pkg/tempfiles"
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// Import options the HTTP service passes through from form fields to the import run
+var serveStringOptions = []string{"timezone", "drop-indexes", "custom-emoji", "reaction-emoji", "pinned", "missing-replies", "convert-images", "max-attachment-size", "batch-size", "media-workers", "max-memory", "after", "before", "profile", "update-contact-name", "tag"}
+var serveBoolOptions = []string{"strict", "atomic", "vacuum", "media-cache", "import-deleted", "skip-bots", "skip-webhooks", "no-attachments", "strip-metadata", "transcode-audio", "encrypt-files", "no-avatar", "contact-identity"}
+
+// Save an uploaded form file into dir
+func saveUpload(r *http.Request, field, dir string) (string, error) {
+    file, header, err := r.FormFile(field)
+    if err != nil {
+        return "", fmt.Errorf("missing %s upload: %w", field, err)
+    }
+    defer file.Close()
+
+    destPath := filepath.Join(dir, field+"_"+filepath.Base(header.Filename))
+    destFile, err := os.Create(destPath)
+    if err != nil {
+        return "", err
+    }
+    defer destFile.Close()
+
+    if _, err := io.Copy(destFile, file); err != nil {
+        return "", err
+    }
+    return destPath, nil
+}
+
+// A Discord upload is either the JSON export itself or a ZIP of the export with its media folder
+func prepareDiscordUpload(ctx context.Context, uploadPath, dir string, maxSize int64) (string, error) {
+    if strings.EqualFold(filepath.Ext(uploadPath), ".json") {
+        return uploadPath, nil
+    }
+
+    extractedDir, err := archive.Extract(ctx, uploadPath, dir, false, maxSize)
+    if err != nil {
+        return "", fmt.Errorf("discord upload is neither a JSON export nor a ZIP of one: %w", err)
+    }
+
+    var jsonPath string
+    filepath.Walk(extractedDir, func(path string, info os.FileInfo, err error) error {
+        if err == nil && jsonPath == "" && !info.IsDir() && strings.EqualFold(filepath.Ext(path), ".json") {
+            jsonPath = path
+        }
+        return nil
+    })
+    if jsonPath == "" {
+        return "", fmt.Errorf("no JSON export found in the discord upload")
+    }
+    return jsonPath, nil
+}
+
+// serve: run imports for uploads over HTTP. POST /import takes a multipart form with the
+// files "discord" and "simplex" and the fields "password", "me" and "contact" or "contact-id"
+// (plus optional import options named like the flags) and responds with the updated archive. Each import runs
+// as a child process of this binary, one at a time
+func runServer(args []string) {
+    fs := flag.NewFlagSet("serve", flag.ExitOnError)
+    listen := fs.String("listen", "127.0.0.1:8080", "Address to listen on")
+    token := fs.String("token", os.Getenv("SERVE_TOKEN"), "Require this bearer token on requests (defaults to the SERVE_TOKEN environment variable)")
+    maxUploadSize := fs.String("max-upload-size", "8GB", "Largest accepted request")
+    fs.StringVar(&tempfiles.Root, "temp-dir", "", "Directory for uploads and import runs (defaults to the system temp directory)")
+    fs.StringVar(&tempfiles.Wipe, "wipe", tempfiles.WipeOverwrite, "How to remove uploads after each request: overwrite, delete or keep")
+    fs.Parse(args)
+
+    maxUploadBytes, err := universal.ParseByteSize(*maxUploadSize)
+    if err != nil {
+        log.Fatalf("Invalid -max-upload-size: %v", err)
+    }
+    executable, err := os.Executable()
+    if err != nil {
+        log.Fatalf("Failed to find own executable: %v", err)
+    }
+    if *token == "" && !strings.HasPrefix(*listen, "127.0.0.1:") && !strings.HasPrefix(*listen, "localhost:") {
+        log.Printf("Warning: listening on %s without -token, anyone who can reach it can run imports", *listen)
+    }
+
+    // Not the default mux, which net/http/pprof adds its handlers to for -pprof
+    mux := http.NewServeMux()
+    var importLock sync.Mutex
+    mux.HandleFunc("/import", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+            http.Error(w, "use POST", http.StatusMethodNotAllowed)
+            return
+        }
+        if *token != "" && r.Header.Get("Authorization") != "Bearer "+*token {
+            http.Error(w, "unauthorized", http.StatusUnauthorized)
+            return
+        }
+
+        r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+        // Uploads go to disk, not memory
+        if err := r.ParseMultipartForm(32 << 20); err != nil {
+            http.Error(w, fmt.Sprintf("invalid upload: %v", err), http.StatusBadRequest)
+            return
+        }
+        defer r.MultipartForm.RemoveAll()
+
+        password, myUsername := r.FormValue("password"), r.FormValue("me")
+        contactArgs := []string{"-contact", r.FormValue("contact")}
+        if contactID := r.FormValue("contact-id"); contactID != "" {
+            contactArgs = []string{"-contact-id", contactID}
+        }
+        if password == "" || myUsername == "" || contactArgs[1] == "" {
+            http.Error(w, "password, me and contact (or contact-id) are required", http.StatusBadRequest)
+            return
+        }
+
+        workDir, err := os.MkdirTemp(tempfiles.Root, "simplex_serve_")
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        defer tempfiles.Remove(workDir)
+
+        discordPath, err := saveUpload(r, "discord", workDir)
+        if err == nil {
+            discordPath, err = prepareDiscordUpload(r.Context(), discordPath, workDir, maxUploadBytes)
+        }
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        simplexPath, err := saveUpload(r, "simplex", workDir)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        outputPath := filepath.Join(workDir, "output.zip")
+
+        // The upload is a copy already, it needs no backup
+        importArgs := []string{"import", "-json", discordPath, "-zip", simplexPath, "-output", outputPath, "-me", myUsername, "-wipe", tempfiles.Wipe, "-no-backup"}
+        importArgs = append(importArgs, contactArgs...)
+        if tempfiles.Root != "" {
+            importArgs = append(importArgs, "-temp-dir", tempfiles.Root)
+        }
+        for _, option := range serveStringOptions {
+            if value := r.FormValue(option); value != "" {
+                importArgs = append(importArgs, "-"+option, value)
+            }
+        }
+        for _, option := range serveBoolOptions {
+            if value, err := strconv.ParseBool(r.FormValue(option)); err == nil && value {
+                importArgs = append(importArgs, "-"+option)
+            }
+        }
+
+        importLock.Lock()
+        cmd := exec.CommandContext(r.Context(), executable, importArgs...)
+        cmd.Env = append(os.Environ(), "SQLCIPHER_KEY="+password)
+        output, err := cmd.CombinedOutput()
+        importLock.Unlock()
+
+        if err != nil {
+            log.Printf("Import for %s failed: %v", r.RemoteAddr, err)
+            http.Error(w, fmt.Sprintf("import failed: %v\n\n%s", err, output), http.StatusUnprocessableEntity)
+            return
+        }
+
+        result, err := os.Open(outputPath)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        defer result.Close()
+
+        name := strings.TrimSuffix(filepath.Base(simplexPath), filepath.Ext(simplexPath))
+        name = strings.TrimPrefix(name, "simplex_") + "_updated.zip"
+        w.Header().Set("Content-Type", "application/zip")
+        w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+        if info, err := result.Stat(); err == nil {
+            w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+        }
+        io.Copy(w, result)
+        log.Printf("Import for %s done", r.RemoteAddr)
+    })
+
+    fmt.Printf("Serving imports on http://%s/import\n", *listen)
+    log.Fatal(http.ListenAndServe(*listen, mux))
+}
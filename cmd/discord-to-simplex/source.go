@@ -0,0 +1,387 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "regexp"
+    "strings"
+    "time"
+
+    "github.com/ritiek/discord-to-simplex/pkg/discord"
+    "github.com/ritiek/discord-to-simplex/pkg/progress"
+    "github.com/ritiek/discord-to-simplex/pkg/tempfiles"
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// Check the Discord conversion modes given on the command line
+func checkConvertModes(customEmojiMode, pinnedMode, missingReplies string) {
+    switch customEmojiMode {
+    case discord.CustomEmojiText, discord.CustomEmojiUnicode, discord.CustomEmojiSkip:
+    default:
+        log.Fatalf("Invalid -custom-emoji value '%s': must be text, unicode or skip", customEmojiMode)
+    }
+
+    if pinnedMode != discord.PinnedMarker && pinnedMode != discord.PinnedNone {
+        log.Fatalf("Invalid -pinned value '%s': must be marker or none", pinnedMode)
+    }
+
+    if missingReplies != discord.MissingRepliesPlaceholder && missingReplies != discord.MissingRepliesDrop {
+        log.Fatalf("Invalid -missing-replies value '%s': must be placeholder or drop", missingReplies)
+    }
+}
+
+// Parse an -after or -before value, an RFC3339 time or a date meaning midnight in loc
+func parseTimeFlag(name, value string, loc *time.Location) time.Time {
+    if value == "" {
+        return time.Time{}
+    }
+    if t, err := time.Parse(time.RFC3339, value); err == nil {
+        return t
+    }
+    t, err := time.ParseInLocation("2006-01-02", value, loc)
+    if err != nil {
+        log.Fatalf("Invalid -%s value '%s': must be an RFC3339 time or a YYYY-MM-DD date", name, value)
+    }
+    return t
+}
+
+// Parse the -redact and -drop-matching rules
+func parseContentRules(redact, dropMatching []string) ([]universal.Redaction, []*regexp.Regexp) {
+    var redactions []universal.Redaction
+    for _, rule := range redact {
+        redaction, err := universal.ParseRedaction(rule)
+        if err != nil {
+            log.Fatalf("Invalid -redact value: %v", err)
+        }
+        redactions = append(redactions, redaction)
+    }
+    var patterns []*regexp.Regexp
+    for _, pattern := range dropMatching {
+        compiled, err := regexp.Compile(pattern)
+        if err != nil {
+            log.Fatalf("Invalid -drop-matching value '%s': %v", pattern, err)
+        }
+        patterns = append(patterns, compiled)
+    }
+    return redactions, patterns
+}
+
+// Which messages of an export loadMessages keeps, and what it does to them
+type messageFilter struct {
+    ImportDeleted bool
+    Transforms    []string
+    After         time.Time // Keep messages from this time on, zero for no limit
+    Before        time.Time // Keep messages before this time, zero for no limit
+    SkipBots      bool      // Leave out messages of bots, webhooks included
+    SkipWebhooks  bool      // Leave out messages posted through webhooks
+    DropMatching  []*regexp.Regexp
+    Redactions    []universal.Redaction
+    Strict        bool   // Fail on any message of the export that can't be read in full
+    ParseErrors   string // Write the messages that can't be read in full to this file, "" for none
+    MaxMemory     int64  // Bytes of converted messages kept in memory before the rest is spilled to disk, 0 for no limit
+}
+
+// An export converted by loadMessages
+type loadedExport struct {
+    Info           universal.SourceInfo
+    Messages       *universal.MessageQueue
+    SkippedDeleted int // Deleted messages left out
+    OutOfRange     int // Messages left out by -after and -before
+    SkippedBots    int // Bot and webhook messages left out
+    Matching       int // Messages left out by -drop-matching
+    Redacted       int // Messages -redact changed
+    Dropped        int // Messages the transforms dropped
+    Sanitized      universal.SanitizeStats
+    ParseErrors    []universal.ParseError // Messages of the export that couldn't be read in full
+}
+
+// Load an export with the platform's source and convert it, leaving out deleted messages unless
+// filter.ImportDeleted, those outside the date range, bot messages and ones matching a pattern if
+// asked to, redacting the rest and running them through the transforms. Text SimpleX clients
+// can't show is cleaned up last, whatever produced it
+func loadMessages(ctx context.Context, platform string, cfg universal.SourceConfig, filter messageFilter) (*loadedExport, error) {
+    timed := activeTimings.Start("parse")
+    source, err := universal.NewSource(ctx, platform, cfg)
+    if err != nil {
+        return nil, err
+    }
+    timed()
+
+    info := source.Info()
+    fmt.Printf("Loaded %s export for channel: %s (%d messages)\n", info.Platform, info.ChatName, info.MessageCount)
+    if len(info.ParseErrors) > 0 {
+        first := info.ParseErrors[0]
+        if filter.Strict {
+            return nil, fmt.Errorf("message #%d (%s) of the export can't be read: %s, %d messages have problems in all (leave out -strict to skip them)",
+                first.Index, first.ID, first.Reason, len(info.ParseErrors))
+        }
+        log.Printf("Warning: %d messages of the export couldn't be read in full, %d of them are left out", len(info.ParseErrors), countSkippedParseErrors(info.ParseErrors))
+        if filter.ParseErrors != "" {
+            if err := writeParseErrors(filter.ParseErrors, info.ParseErrors); err != nil {
+                return nil, err
+            }
+            fmt.Printf("Wrote them with the reasons to %s\n", filter.ParseErrors)
+        }
+    }
+
+    stream, err := source.Parse(ctx)
+    if err != nil {
+        return nil, fmt.Errorf("failed to convert messages: %w", err)
+    }
+
+    bar := progress.New("Converting messages", info.MessageCount)
+    timed = activeTimings.Start("convert")
+    messages := universal.NewMessageQueue(filter.MaxMemory, tempfiles.Root)
+    skippedDeleted, outOfRange, skippedBots := 0, 0, 0
+    for msg := range stream {
+        bar.Add(1)
+        if msg.IsDeleted && !filter.ImportDeleted {
+            skippedDeleted++
+            continue
+        }
+        if (!filter.After.IsZero() && msg.Timestamp.Before(filter.After)) || (!filter.Before.IsZero() && !msg.Timestamp.Before(filter.Before)) {
+            outOfRange++
+            continue
+        }
+        if (filter.SkipBots && msg.Author.IsBot) || (filter.SkipWebhooks && msg.Author.IsWebhook) {
+            skippedBots++
+            continue
+        }
+        if err := messages.Append(msg); err != nil {
+            return nil, err
+        }
+    }
+    bar.Finish()
+    timed()
+    if err := ctx.Err(); err != nil {
+        return nil, err
+    }
+    if messages.Spilled() {
+        fmt.Printf("Converted messages take up more than -max-memory, keeping them on disk in %s\n", messages.SpillDir())
+    }
+
+    var matching, redacted int
+    if len(filter.DropMatching) > 0 || len(filter.Redactions) > 0 {
+        timed := activeTimings.Start("drop-matching and redact")
+        err := messages.Update(func(chunk []universal.Message) ([]universal.Message, error) {
+            var dropped int
+            if len(filter.DropMatching) > 0 {
+                chunk, dropped = universal.DropMatching(chunk, filter.DropMatching)
+                matching += dropped
+            }
+            if len(filter.Redactions) > 0 {
+                redacted += universal.Redact(chunk, filter.Redactions)
+            }
+            return chunk, nil
+        })
+        if err != nil {
+            return nil, err
+        }
+        timed()
+    }
+
+    var dropped int
+    if len(filter.Transforms) > 0 {
+        fmt.Printf("Running messages through %d transforms...\n", len(filter.Transforms))
+        timed := activeTimings.Start("transforms")
+        dropped, err = universal.ApplyTransforms(ctx, messages, filter.Transforms)
+        timed()
+        if err != nil {
+            return nil, fmt.Errorf("transform failed: %w", err)
+        }
+        if dropped > 0 {
+            fmt.Printf("Transforms dropped %d messages\n", dropped)
+        }
+    }
+
+    var sanitized universal.SanitizeStats
+    timed = activeTimings.Start("sanitize")
+    err = messages.Update(func(chunk []universal.Message) ([]universal.Message, error) {
+        stats := universal.Sanitize(chunk)
+        sanitized.Messages += stats.Messages
+        sanitized.Files += stats.Files
+        return chunk, nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    timed()
+
+    return &loadedExport{Info: info, Messages: messages, SkippedDeleted: skippedDeleted, OutOfRange: outOfRange, SkippedBots: skippedBots, Matching: matching, Redacted: redacted, Dropped: dropped, Sanitized: sanitized, ParseErrors: info.ParseErrors}, nil
+}
+
+// Run a step over the messages, all at once or a chunk at a time when they're spilled to disk
+func updateMessages(messages *universal.MessageQueue, step func([]universal.Message) []universal.Message) {
+    err := messages.Update(func(chunk []universal.Message) ([]universal.Message, error) {
+        return step(chunk), nil
+    })
+    if err != nil {
+        fatalf("%v", err)
+    }
+}
+
+// Go over the messages without changing them, a chunk at a time like updateMessages
+func eachMessages(messages *universal.MessageQueue, fn func([]universal.Message)) {
+    err := messages.Each(func(chunk []universal.Message) error {
+        fn(chunk)
+        return nil
+    })
+    if err != nil {
+        fatalf("%v", err)
+    }
+}
+
+// Whether any attachment or preview image still has to be downloaded
+func hasRemoteAttachments(messages []universal.Message) bool {
+    for _, msg := range messages {
+        for _, attachment := range msg.Attachments {
+            if universal.IsRemoteURL(attachment.URL) {
+                return true
+            }
+        }
+        if msg.LinkPreview != nil && universal.IsRemoteURL(msg.LinkPreview.ImageURL) {
+            return true
+        }
+    }
+    return false
+}
+
+// Messages of chunk that a step left out of kept and that have reactions, with only what adding
+// the reactions to their items needs
+func reactedLeftOut(chunk, kept []universal.Message) []universal.Message {
+    keptIDs := make(map[string]bool, len(kept))
+    for _, msg := range kept {
+        keptIDs[msg.ID] = true
+    }
+    var leftOut []universal.Message
+    for _, msg := range chunk {
+        if len(msg.Reactions) == 0 || keptIDs[msg.ID] {
+            continue
+        }
+        leftOut = append(leftOut, universal.Message{ID: msg.ID, IsSent: msg.IsSent, Timestamp: msg.Timestamp, Reactions: msg.Reactions})
+    }
+    return leftOut
+}
+
+// Author on the other side of the chat: whoever wrote the most messages the user didn't send,
+// people before bots, as of their newest message. False when the user sent all of them
+func contactAuthor(messages *universal.MessageQueue) (universal.Author, bool) {
+    counts := make(map[string]int)
+    newest := make(map[string]universal.Message)
+    eachMessages(messages, func(chunk []universal.Message) {
+        for _, msg := range chunk {
+            if msg.IsSent {
+                continue
+            }
+            counts[msg.Author.ID]++
+            if latest, ok := newest[msg.Author.ID]; !ok || !msg.Timestamp.Before(latest.Timestamp) {
+                newest[msg.Author.ID] = msg
+            }
+        }
+    })
+
+    var best string
+    found := false
+    for authorID, count := range counts {
+        better := !found
+        if found {
+            isBot, bestIsBot := newest[authorID].Author.IsBot, newest[best].Author.IsBot
+            better = isBot != bestIsBot && !isBot ||
+                isBot == bestIsBot && (count > counts[best] || count == counts[best] && authorID < best)
+        }
+        if better {
+            best, found = authorID, true
+        }
+    }
+    return newest[best].Author, found
+}
+
+// Display name a SimpleX profile can have: without the leading @ or # the app reserves for
+// addressing contacts and groups, and on one line
+func profileDisplayName(name string) string {
+    name = strings.Join(strings.Fields(name), " ")
+    return strings.TrimLeft(name, "@# ")
+}
+
+// Identity on the source platform for the profile's full name, like "Discord @alice (1234)"
+func platformIdentity(platform string, author universal.Author) string {
+    if platform != "" {
+        platform = strings.ToUpper(platform[:1]) + platform[1:]
+    }
+    identity := fmt.Sprintf("%s @%s", platform, author.Username)
+    if author.ID != "" {
+        identity += fmt.Sprintf(" (%s)", author.ID)
+    }
+    return strings.TrimSpace(identity)
+}
+
+// Hex SHA-256 of an export file, for the import_runs ledger
+func fileSHA256(path string) (string, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return "", err
+    }
+    defer file.Close()
+
+    hash := sha256.New()
+    if _, err := io.Copy(hash, file); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Tell what Sanitize cleaned up
+func printSanitized(stats universal.SanitizeStats) {
+    if stats.Messages > 0 {
+        fmt.Printf("Sanitized %d messages with invalid UTF-8 or control characters\n", stats.Messages)
+    }
+    if stats.Files > 0 {
+        fmt.Printf("Sanitized %d attachment file names\n", stats.Files)
+    }
+}
+
+// Where the messages of an export that can't be read in full are written: the -parse-errors
+// path, or next to the export
+func parseErrorsFile(path, jsonFilePath string) string {
+    if path == "" && jsonFilePath != "" {
+        return jsonFilePath + ".errors.jsonl"
+    }
+    return path
+}
+
+// How many of the messages that couldn't be read in full were left out
+func countSkippedParseErrors(parseErrors []universal.ParseError) int {
+    skipped := 0
+    for _, parseError := range parseErrors {
+        if parseError.Skipped {
+            skipped++
+        }
+    }
+    return skipped
+}
+
+// Write the messages that couldn't be read in full to path, one JSON object per line with the
+// reason and the message as it is in the export
+func writeParseErrors(path string, parseErrors []universal.ParseError) error {
+    file, err := os.Create(path)
+    if err != nil {
+        return fmt.Errorf("failed to create parse errors file: %w", err)
+    }
+    encoder := json.NewEncoder(file)
+    for _, parseError := range parseErrors {
+        if err := encoder.Encode(parseError); err != nil {
+            file.Close()
+            return fmt.Errorf("failed to write parse errors: %w", err)
+        }
+    }
+    if err := file.Close(); err != nil {
+        return fmt.Errorf("failed to write parse errors: %w", err)
+    }
+    return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "github.com/ritiek/discord-to-simplex/pkg/simplexdb"
+)
+
+// Script -sql-output writes the import to instead of changing the database
+type sqlScript struct {
+    *bufio.Writer
+    file *os.File
+}
+
+// Directory next to the script for the attachment files its rows refer to
+func sqlScriptFilesDir(scriptPath string) string {
+    return strings.TrimSuffix(scriptPath, filepath.Ext(scriptPath)) + "_files"
+}
+
+// Create the script with a header explaining how to apply it, and open its transaction
+func createSQLScript(scriptPath, contactName string, contactID, messages int) (*sqlScript, error) {
+    file, err := os.Create(scriptPath)
+    if err != nil {
+        return nil, err
+    }
+    script := &sqlScript{Writer: bufio.NewWriter(file), file: file}
+
+    fmt.Fprintf(script, "-- Import of %d messages into the chat with %s (contact_id %d), written by discord-to-simplex.\n", messages, contactName, contactID)
+    fmt.Fprintf(script, "-- IDs continue from the database it was generated from, so apply it to that same database\n")
+    fmt.Fprintf(script, "-- before anything else changes it, e.g. in `sqlcipher simplex_v1_chat.db`:\n")
+    fmt.Fprintf(script, "--   PRAGMA key = 'your database password';\n")
+    fmt.Fprintf(script, "--   .read %s\n", filepath.Base(scriptPath))
+    fmt.Fprintf(script, "-- and copy the files in %s into the SimpleX files directory.\n", filepath.Base(sqlScriptFilesDir(scriptPath)))
+    fmt.Fprintf(script, "BEGIN TRANSACTION;\n")
+    return script, nil
+}
+
+// Close the transaction and the file
+func (s *sqlScript) finish() error {
+    fmt.Fprintf(s, "COMMIT;\n")
+    if err := s.Flush(); err != nil {
+        s.file.Close()
+        return err
+    }
+    return s.file.Close()
+}
+
+// Open the -id-map file for appending, so several imports can share one, starting it with its
+// header when it's new
+func openIDMap(path string) (*os.File, error) {
+    file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+    if err != nil {
+        return nil, err
+    }
+    info, err := file.Stat()
+    if err == nil && info.Size() == 0 {
+        err = simplexdb.WriteIDMapHeader(file)
+    }
+    if err != nil {
+        file.Close()
+        return nil, err
+    }
+    return file, nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "flag"
+    "fmt"
+    "log"
+    "net/url"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "github.com/ritiek/discord-to-simplex/pkg/archive"
+    "github.com/ritiek/discord-to-simplex/pkg/simplexdb"
+    "github.com/ritiek/discord-to-simplex/pkg/tempfiles"
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// Check the -wipe mode given on the command line
+func checkWipeMode() {
+    if tempfiles.Wipe != tempfiles.WipeOverwrite && tempfiles.Wipe != tempfiles.WipeDelete && tempfiles.Wipe != tempfiles.WipeKeep {
+        log.Fatalf("Invalid -wipe value '%s': must be overwrite, delete or keep", tempfiles.Wipe)
+    }
+}
+
+// Files directory next to a database, named after the layout the database file belongs to
+func defaultFilesDir(dbPath string) string {
+    filesDirName := archive.Layouts[0].FilesDir
+    for _, layout := range archive.Layouts {
+        if filepath.Base(dbPath) == layout.ChatDB {
+            filesDirName = layout.FilesDir
+        }
+    }
+    return filepath.Join(filepath.Dir(dbPath), filesDirName)
+}
+
+// Open a SimpleX database with its passphrase and make sure the passphrase is right. A readOnly
+// connection refuses every write, for the commands that only look at a database. The others take
+// the write lock as soon as a transaction begins, so another process holding the database makes
+// a batch wait (and retry) before it writes anything rather than fail halfway
+func openDatabase(dbPath, password string, readOnly bool) (*sql.DB, error) {
+    // The driver reads the DSN's query with url.ParseQuery and puts the key in a double-quoted
+    // PRAGMA key, so passphrases with +, &, %, # or quotes need escaping for both
+    key := url.QueryEscape(strings.ReplaceAll(password, `"`, `""`))
+    dsn := fmt.Sprintf("%s?_key=%s&_busy_timeout=5000", dbPath, key)
+    if readOnly {
+        dsn += "&_query_only=1"
+    } else {
+        dsn += "&_txlock=exclusive"
+    }
+    db, err := sql.Open("sqlite3", dsn)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open database: %w", err)
+    }
+    if err := simplexdb.RetryBusy(context.Background(), db.Ping); err != nil {
+        db.Close()
+        return nil, fmt.Errorf("failed to connect to database: %w", err)
+    }
+    return db, nil
+}
+
+// Copy the archive or database an import is about to use to <name>.<time>.bak, with the -wal of a
+// database that has one, and return the copy's path
+func backupInput(path, name string) (string, error) {
+    backupPath := fmt.Sprintf("%s.%s.bak", name, time.Now().Format("20060102-150405"))
+    if err := archive.CopyFile(path, backupPath); err != nil {
+        os.Remove(backupPath)
+        return "", err
+    }
+    if _, err := os.Stat(path + "-wal"); err == nil {
+        if err := archive.CopyFile(path+"-wal", backupPath+"-wal"); err != nil {
+            os.Remove(backupPath)
+            os.Remove(backupPath + "-wal")
+            return "", err
+        }
+    }
+    return backupPath, nil
+}
+
+// Put an extracted export at outputDir, which doesn't exist or is empty: renamed there when it's
+// on the same filesystem, copied otherwise. Whether it was renamed, so it's no longer cleaned up as
+// temporary data
+func moveExportDir(extractedDir, outputDir string) (bool, error) {
+    if err := os.MkdirAll(filepath.Dir(filepath.Clean(outputDir)), 0755); err != nil {
+        return false, err
+    }
+    // Checked to be empty up front
+    os.Remove(outputDir)
+    if err := os.Rename(extractedDir, outputDir); err == nil {
+        return true, nil
+    }
+    return false, archive.CopyDir(extractedDir, outputDir)
+}
+
+// Close a database the import wrote to so the file alone holds all of it: the WAL is
+// checkpointed into it and with vacuum it's rebuilt to give back the space of freed pages. A -wal
+// file left behind with changes in it is an error; empty -wal and -shm files are removed when
+// removeLeftovers, for a copy no app has open that is about to be zipped up
+func closeDatabase(db *sql.DB, dbPath string, vacuum, removeLeftovers bool) error {
+    var busy, logFrames, checkpointed int
+    if err := db.QueryRow("PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &logFrames, &checkpointed); err != nil {
+        db.Close()
+        return fmt.Errorf("failed to checkpoint database: %w", err)
+    }
+    if busy != 0 {
+        db.Close()
+        return fmt.Errorf("failed to checkpoint database: it is in use by another connection")
+    }
+
+    if vacuum {
+        before := fileSize(dbPath)
+        fmt.Println("Vacuuming database...")
+        if _, err := db.Exec("VACUUM"); err != nil {
+            db.Close()
+            return fmt.Errorf("failed to vacuum database: %w", err)
+        }
+        fmt.Printf("Database size: %s -> %s\n", universal.FormatByteSize(before), universal.FormatByteSize(fileSize(dbPath)))
+    }
+
+    if err := db.Close(); err != nil {
+        return fmt.Errorf("failed to close database: %w", err)
+    }
+    for _, suffix := range []string{"-wal", "-shm"} {
+        info, err := os.Stat(dbPath + suffix)
+        if err != nil {
+            continue
+        }
+        if suffix == "-wal" && info.Size() > 0 {
+            return fmt.Errorf("%s still has changes that aren't in the database", filepath.Base(dbPath+suffix))
+        }
+        if !removeLeftovers {
+            continue
+        }
+        if err := os.Remove(dbPath + suffix); err != nil {
+            return fmt.Errorf("failed to remove %s: %w", filepath.Base(dbPath+suffix), err)
+        }
+    }
+    return nil
+}
+
+// Size of a file, 0 when it can't be read
+func fileSize(path string) int64 {
+    info, err := os.Stat(path)
+    if err != nil {
+        return 0
+    }
+    return info.Size()
+}
+
+// SimpleX database a command only reads: a ZIP export, a database file or an extracted export
+// directory, and where its password comes from
+type simplexTarget struct {
+    zipPath        string
+    dbPath         string
+    dirPath        string
+    keyFile        string
+    keyCmd         string
+    keyringService string
+    maxArchiveSize string
+    password       string // Set by open once a password worked
+}
+
+func (t *simplexTarget) register(fs *flag.FlagSet) {
+    fs.StringVar(&t.zipPath, "zip", "", "Path to SimpleX export ZIP file")
+    fs.StringVar(&t.dbPath, "db", "", "SimpleX database file, e.g. simplex_v1_chat.db of the desktop data directory")
+    fs.StringVar(&t.dirPath, "dir", "", "Already extracted SimpleX export directory")
+    t.registerAccess(fs)
+}
+
+// Register the password, archive size and temp data flags only, for commands that take the
+// databases as arguments
+func (t *simplexTarget) registerAccess(fs *flag.FlagSet) {
+    fs.StringVar(&t.keyFile, "key-file", "", "Read the database password from the first line of this file")
+    fs.StringVar(&t.keyCmd, "key-cmd", "", "Run this command and use the first line of its output as the database password")
+    fs.StringVar(&t.keyringService, "keyring", "", "Look up the database password under this service name in the OS keychain")
+    fs.StringVar(&t.maxArchiveSize, "max-archive-size", "64GB", "Refuse SimpleX archives whose contents add up to more than this, 0 for no limit")
+    fs.StringVar(&tempfiles.Root, "temp-dir", "", "Directory for the temporary extraction (defaults to the system temp directory)")
+    fs.StringVar(&tempfiles.Wipe, "wipe", tempfiles.WipeOverwrite, "How to remove temporary data on exit: overwrite, delete or keep")
+}
+
+// Point the target at a ZIP export, an extracted export directory or a database file, by what
+// path is. Returns the flag that does the same
+func (t *simplexTarget) setPath(path string) string {
+    t.zipPath, t.dbPath, t.dirPath = "", "", ""
+    if info, err := os.Stat(path); err == nil && info.IsDir() {
+        t.dirPath = path
+        return "-dir"
+    }
+    if strings.EqualFold(filepath.Ext(path), ".zip") {
+        t.zipPath = path
+        return "-zip"
+    }
+    t.dbPath = path
+    return "-db"
+}
+
+// Whether one of -zip, -db and -dir was given
+func (t *simplexTarget) given() bool {
+    return t.zipPath != "" || t.dbPath != "" || t.dirPath != ""
+}
+
+// Check the flags; called before any temp data exists
+func (t *simplexTarget) check() {
+    inputs := 0
+    for _, input := range []string{t.zipPath, t.dbPath, t.dirPath} {
+        if input != "" {
+            inputs++
+        }
+    }
+    if inputs == 0 {
+        log.Fatal("SimpleX database is required. Use -zip flag (or -db for a database file, -dir for an extracted export).")
+    }
+    if inputs > 1 {
+        log.Fatal("Only one of -zip, -db and -dir can be used.")
+    }
+    t.checkAccess()
+}
+
+// Check the flags registerAccess adds
+func (t *simplexTarget) checkAccess() {
+    if _, err := universal.ParseByteSize(t.maxArchiveSize); err != nil {
+        log.Fatalf("Invalid -max-archive-size: %v", err)
+    }
+    checkWipeMode()
+}
+
+// Open the database. Archives are extracted to a temporary directory registered with tempfiles;
+// withFiles extracts the attachments too, otherwise only the databases. Returns the database and
+// its files directory
+func (t *simplexTarget) open(ctx context.Context, withFiles bool) (*sql.DB, string, error) {
+    var dbPath, filesDir string
+    var err error
+    switch {
+    case t.dirPath != "":
+        dbPath, filesDir, err = archive.Locate(t.dirPath)
+    case t.dbPath != "":
+        if _, err = os.Stat(t.dbPath); err == nil {
+            dbPath, filesDir = t.dbPath, defaultFilesDir(t.dbPath)
+        }
+    default:
+        maxArchiveBytes, _ := universal.ParseByteSize(t.maxArchiveSize)
+        var extractedDir string
+        extractedDir, err = archive.Extract(ctx, t.zipPath, tempfiles.Root, !withFiles, maxArchiveBytes)
+        if err != nil {
+            return nil, "", fmt.Errorf("failed to extract SimpleX ZIP: %w", err)
+        }
+        tempfiles.Register(extractedDir)
+        dbPath, filesDir, err = archive.Locate(extractedDir)
+    }
+    if err != nil {
+        return nil, "", fmt.Errorf("failed to find SimpleX database: %w", err)
+    }
+
+    password := t.password
+    if password == "" {
+        password, err = resolvePassword(t.keyFile, t.keyCmd, t.keyringService)
+        if err != nil {
+            return nil, "", fmt.Errorf("failed to get database password: %w", err)
+        }
+        if password == "" {
+            return nil, "", fmt.Errorf("database password is required")
+        }
+    }
+
+    db, err := openDatabase(dbPath, password, true)
+    if err != nil {
+        return nil, "", err
+    }
+    t.password = password
+    return db, filesDir, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "flag"
+    "fmt"
+    "os"
+    "path/filepath"
+
+    "github.com/ritiek/discord-to-simplex/pkg/tempfiles"
+)
+
+// verify: check that a SimpleX database is intact before importing it back into the app: SQLite's
+// integrity and foreign key checks, and an existing file for every attachment it references.
+// Exits with status 1 when anything is wrong
+func runVerify(args []string) {
+    fs := flag.NewFlagSet("verify", flag.ExitOnError)
+    var target simplexTarget
+    target.register(fs)
+    fs.Parse(args)
+    target.check()
+
+    cleanUpOnInterrupt()
+    defer tempfiles.Cleanup()
+
+    db, filesDir, err := target.open(context.Background(), true)
+    if err != nil {
+        fatalf("%v", err)
+    }
+    defer db.Close()
+
+    problems := 0
+    report := func(format string, v ...interface{}) {
+        problems++
+        fmt.Printf("  "+format+"\n", v...)
+    }
+
+    fmt.Println("Checking database integrity...")
+    rows, err := db.Query("PRAGMA integrity_check")
+    if err != nil {
+        fatalf("Integrity check failed: %v", err)
+    }
+    for rows.Next() {
+        var result string
+        if err := rows.Scan(&result); err != nil {
+            rows.Close()
+            fatalf("Integrity check failed: %v", err)
+        }
+        if result != "ok" {
+            report("%s", result)
+        }
+    }
+    rows.Close()
+
+    fmt.Println("Checking foreign keys...")
+    rows, err = db.Query("PRAGMA foreign_key_check")
+    if err != nil {
+        fatalf("Foreign key check failed: %v", err)
+    }
+    for rows.Next() {
+        var table, parent string
+        var rowID sql.NullInt64
+        var fkID int
+        if err := rows.Scan(&table, &rowID, &parent, &fkID); err != nil {
+            rows.Close()
+            fatalf("Foreign key check failed: %v", err)
+        }
+        report("%s row %d references a missing %s row", table, rowID.Int64, parent)
+    }
+    rows.Close()
+
+    fmt.Println("Checking attachment files...")
+    rows, err = db.Query("SELECT file_id, file_path FROM files WHERE file_path IS NOT NULL AND file_path != ''")
+    if err != nil {
+        fatalf("Failed to read files: %v", err)
+    }
+    for rows.Next() {
+        var fileID int
+        var filePath string
+        if err := rows.Scan(&fileID, &filePath); err != nil {
+            rows.Close()
+            fatalf("Failed to read files: %v", err)
+        }
+        if _, err := os.Stat(filepath.Join(filesDir, filePath)); err != nil {
+            report("file %d: %s is missing from %s", fileID, filePath, filepath.Base(filesDir))
+        }
+    }
+    rows.Close()
+
+    if problems > 0 {
+        fatalf("Found %d problems", problems)
+    }
+    fmt.Println("No problems found.")
+}
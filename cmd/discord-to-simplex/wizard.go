@@ -0,0 +1,205 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/ritiek/discord-to-simplex/pkg/tempfiles"
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+    "golang.org/x/term"
+)
+
+// Number of converted messages the wizard shows before asking to import
+const wizardPreviewSize = 5
+
+// wizard: ask for the export, the SimpleX database, its passphrase and the contact step by step,
+// show a few converted messages and run the import once confirmed
+func runWizard(args []string) {
+    fs := flag.NewFlagSet("wizard", flag.ExitOnError)
+    sourcePlatform := fs.String("source", "discord", "Platform the export comes from: "+strings.Join(universal.SourcePlatforms(), ", "))
+    fs.Parse(args)
+
+    if !term.IsTerminal(int(os.Stdin.Fd())) {
+        log.Fatal("The wizard needs a terminal, run 'discord-to-simplex import' with flags instead.")
+    }
+    cleanUpOnInterrupt()
+    defer tempfiles.Cleanup()
+    in := bufio.NewReader(os.Stdin)
+
+    fmt.Println("Step 1 of 5: chat export")
+    jsonPath := askPath(in, "Path to the export file: ", false)
+    loaded, err := loadMessages(context.Background(), *sourcePlatform, universal.SourceConfig{Path: jsonPath, Location: time.Local}, messageFilter{})
+    if err != nil {
+        fatalf("%v", err)
+    }
+    messages, err := loaded.Messages.All()
+    if err != nil {
+        fatalf("%v", err)
+    }
+    if len(messages) == 0 {
+        fatalf("The export has no messages to import")
+    }
+
+    fmt.Println("\nStep 2 of 5: which of the authors you are")
+    authors := make(map[string]int)
+    for _, msg := range messages {
+        authors[msg.Author.Username]++
+    }
+    names := make([]string, 0, len(authors))
+    for name := range authors {
+        names = append(names, name)
+    }
+    sort.Slice(names, func(i, j int) bool { return authors[names[i]] > authors[names[j]] })
+    for i, name := range names {
+        fmt.Printf("  %d) %s (%d messages)\n", i+1, name, authors[name])
+    }
+    me := names[askChoice(in, "Your username: ", len(names))]
+
+    fmt.Println("\nStep 3 of 5: SimpleX database")
+    simplexPath := askPath(in, "Path to the SimpleX export ZIP, database file or extracted export directory: ", true)
+    var target simplexTarget
+    targetFlag := target.setPath(simplexPath)
+    target.maxArchiveSize = "64GB"
+
+    // The passphrase gets to the import through the environment, like SQLCIPHER_KEY given by hand
+    fmt.Println("\nStep 4 of 5: passphrase and contact")
+    var contacts []contactRow
+    for {
+        if os.Getenv("SQLCIPHER_KEY") == "" {
+            password, err := promptForPassword()
+            if err != nil {
+                fatalf("%v", err)
+            }
+            os.Setenv("SQLCIPHER_KEY", password)
+        }
+        db, _, err := target.open(context.Background(), false)
+        if err == nil {
+            contacts, err = listContacts(db)
+            db.Close()
+            if err != nil {
+                fatalf("Failed to list contacts: %v", err)
+            }
+            break
+        }
+        fmt.Printf("Couldn't open the database: %v\n", err)
+        os.Unsetenv("SQLCIPHER_KEY")
+    }
+    if len(contacts) == 0 {
+        fatalf("The SimpleX database has no contacts to import into")
+    }
+    for i, contact := range contacts {
+        fmt.Printf("  %d) %s", i+1, contact.LocalName)
+        if contact.FullName != "" {
+            fmt.Printf(" (%s)", contact.FullName)
+        }
+        fmt.Println()
+    }
+    contact := contacts[askChoice(in, "Contact to import the chat into: ", len(contacts))]
+    profiles := make(map[int]bool)
+    for _, c := range contacts {
+        profiles[c.UserID] = true
+    }
+
+    fmt.Println("\nStep 5 of 5: preview")
+    printWizardPreview(messages, me, contact.LocalName)
+
+    importArgs := []string{"-json", jsonPath, "-source", *sourcePlatform, "-me", me, "-contact-id", strconv.Itoa(contact.ID), targetFlag, simplexPath}
+    if len(profiles) > 1 {
+        importArgs = append(importArgs, "-profile", strconv.Itoa(contact.UserID))
+    }
+    if target.zipPath != "" {
+        ext := filepath.Ext(simplexPath)
+        output := strings.TrimSuffix(simplexPath, ext) + "_updated" + ext
+        if answer := askLine(in, fmt.Sprintf("Write the updated export to [%s]: ", output)); answer != "" {
+            output = answer
+        }
+        importArgs = append(importArgs, "-output", output)
+    } else {
+        fmt.Printf("%s will be updated in place.\n", simplexPath)
+    }
+
+    fmt.Printf("\nSame as: discord-to-simplex import %s\n", strings.Join(importArgs, " "))
+    if !askYesNo(in, "Import now? [y/N]: ") {
+        fmt.Println("Nothing was imported.")
+        return
+    }
+    tempfiles.Cleanup()
+    runImport(importArgs)
+}
+
+// Show how the first and last messages will look in SimpleX
+func printWizardPreview(messages []universal.Message, me, contact string) {
+    sample := messages
+    if len(messages) > 2*wizardPreviewSize {
+        sample = append(append([]universal.Message{}, messages[:wizardPreviewSize]...), messages[len(messages)-wizardPreviewSize:]...)
+    }
+    for i, msg := range sample {
+        if len(sample) < len(messages) && i == wizardPreviewSize {
+            fmt.Printf("  ... %d more ...\n", len(messages)-len(sample))
+        }
+        from := contact
+        if msg.Author.Username == me {
+            from = "you"
+        }
+        text := strings.Join(strings.Fields(msg.Content), " ")
+        if len([]rune(text)) > 60 {
+            text = string([]rune(text)[:57]) + "..."
+        }
+        for _, attachment := range msg.Attachments {
+            text += fmt.Sprintf(" [%s]", attachment.Filename)
+        }
+        fmt.Printf("  %s  %-8s %s\n", msg.Timestamp.Local().Format("2006-01-02 15:04"), from+":", text)
+    }
+}
+
+func askLine(in *bufio.Reader, prompt string) string {
+    fmt.Print(prompt)
+    line, err := in.ReadString('\n')
+    if err != nil {
+        fmt.Println()
+        fatalf("Failed to read answer: %v", err)
+    }
+    return strings.TrimSpace(line)
+}
+
+// Ask until the answer is an existing file, or a directory if dirOK
+func askPath(in *bufio.Reader, prompt string, dirOK bool) string {
+    for {
+        path := askLine(in, prompt)
+        info, err := os.Stat(path)
+        switch {
+        case path == "":
+        case err != nil:
+            fmt.Printf("Can't use %s: %v\n", path, err)
+        case info.IsDir() && !dirOK:
+            fmt.Printf("%s is a directory\n", path)
+        default:
+            return path
+        }
+    }
+}
+
+// Ask until the answer is a number from 1 to n, returned as an index
+func askChoice(in *bufio.Reader, prompt string, n int) int {
+    for {
+        choice, err := strconv.Atoi(askLine(in, prompt))
+        if err == nil && choice >= 1 && choice <= n {
+            return choice - 1
+        }
+        fmt.Printf("Enter a number from 1 to %d\n", n)
+    }
+}
+
+func askYesNo(in *bufio.Reader, prompt string) bool {
+    answer := strings.ToLower(askLine(in, prompt))
+    return answer == "y" || answer == "yes"
+}
@@ -1,4 +1,4 @@
-{ pkgs ? import <nixpkgs> {}, vendorHash ? "sha256-vrPw9C/Tm4MeEva8u1HtuOrMNAOnBOnvPJaQvdeEaJ0=" }:
+{ pkgs ? import <nixpkgs> {}, vendorHash ? "sha256-N9VvX6LSLTue+jV+gRcjMo0kQAWFw0kmmELAj4IK2g4=" }:
 pkgs.buildGoModule {
   pname = "discord-to-simplex";
   version = "0.1.0";
@@ -7,6 +7,8 @@ pkgs.buildGoModule {
 
   inherit vendorHash;
 
+  subPackages = [ "cmd/discord-to-simplex" ];
+
   nativeBuildInputs = with pkgs; [
     pkg-config
   ];
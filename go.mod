@@ -4,6 +4,9 @@ go 1.24.0
 
 require (
 	github.com/xeodou/go-sqlcipher v0.0.0-20200727080346-d681773ef093
+	golang.org/x/crypto v0.42.0
+	golang.org/x/image v0.32.0
+	golang.org/x/net v0.44.0
 	golang.org/x/term v0.35.0
 )
 
@@ -0,0 +1,509 @@
+// Package archive extracts and rebuilds SimpleX database export archives, and finds the
+// databases in extracted archives and app data directories.
+package archive
+
+import (
+    "archive/zip"
+    "bytes"
+    "context"
+    "fmt"
+    "hash/crc32"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "github.com/ritiek/discord-to-simplex/pkg/progress"
+    "github.com/ritiek/discord-to-simplex/pkg/tempfiles"
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// SimpleX archives are always plain ZIP files; the passphrase only encrypts the databases inside
+// them (with SQLCipher), and those stay encrypted with the same passphrase in the output archive.
+// Check the signature up front so other files get a clear error instead of a generic ZIP one
+func Check(zipPath string) error {
+    file, err := os.Open(zipPath)
+    if err != nil {
+        return fmt.Errorf("failed to open archive: %w", err)
+    }
+    defer file.Close()
+
+    header := make([]byte, 16)
+    n, _ := io.ReadFull(file, header)
+    header = header[:n]
+
+    switch {
+    case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+        return nil
+    case bytes.HasPrefix(header, []byte("SQLite format 3\x00")):
+        return fmt.Errorf("%s is an unencrypted SQLite database, not a SimpleX archive; export the chat database from SimpleX (Settings > Database > Export database)", zipPath)
+    default:
+        return fmt.Errorf("%s is not a ZIP archive; SimpleX exports are ZIP files whose databases are encrypted with your database passphrase, export them with Settings > Database > Export database", zipPath)
+    }
+}
+
+// Whether a ZIP entry lives in a SimpleX files directory of one of the known layouts
+func isSimplexFilesEntry(name string) bool {
+    parts := strings.Split(strings.TrimSuffix(name, "/"), "/")
+    for _, dir := range parts[:len(parts)-1] {
+        for _, layout := range Layouts {
+            if dir == layout.FilesDir {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// Resolve a ZIP entry name inside the extraction directory, rejecting names that could escape it
+// ("../", absolute paths, drive letters) and Windows separators that filepath.Join would treat as such
+func safeArchivePath(root, name string) (string, error) {
+    if name == "" || strings.Contains(name, "\\") || strings.HasPrefix(name, "/") || filepath.VolumeName(name) != "" || (len(name) > 1 && name[1] == ':') {
+        return "", fmt.Errorf("unsafe path in archive: %q", name)
+    }
+    for _, part := range strings.Split(name, "/") {
+        if part == ".." {
+            return "", fmt.Errorf("unsafe path in archive: %q", name)
+        }
+    }
+
+    path := filepath.Join(root, filepath.FromSlash(name))
+    rel, err := filepath.Rel(root, path)
+    if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+        return "", fmt.Errorf("unsafe path in archive: %q", name)
+    }
+    return path, nil
+}
+
+// Check every entry before extracting anything: no unsafe paths, no symlinks or other special
+// files, and a declared total size within maxSize (0 means no limit)
+func validateArchiveEntries(files []*zip.File, root string, maxSize int64) error {
+    var total uint64
+    for _, f := range files {
+        if _, err := safeArchivePath(root, f.Name); err != nil {
+            return err
+        }
+
+        mode := f.Mode()
+        if mode&os.ModeSymlink != 0 {
+            return fmt.Errorf("archive contains a symlink: %q", f.Name)
+        }
+        if !mode.IsRegular() && !mode.IsDir() {
+            return fmt.Errorf("archive contains a special file: %q", f.Name)
+        }
+
+        total += f.UncompressedSize64
+        if maxSize > 0 && total > uint64(maxSize) {
+            return fmt.Errorf("archive contents exceed %s (raise -max-archive-size if it is legitimate)", universal.FormatByteSize(maxSize))
+        }
+    }
+    return nil
+}
+
+// Extract SimpleX ZIP export to temporary directory. With databasesOnly the contents of the files
+// directory are left in the archive (only its directories are created) and Create copies
+// them over from the original archive instead
+func Extract(ctx context.Context, zipPath, tempRoot string, databasesOnly bool, maxSize int64) (string, error) {
+    if err := Check(zipPath); err != nil {
+        return "", err
+    }
+
+    // Create temporary directory
+    tempDir, err := os.MkdirTemp(tempRoot, "simplex_import_")
+    if err != nil {
+        return "", fmt.Errorf("failed to create temp directory: %w", err)
+    }
+
+    // Open ZIP file
+    r, err := zip.OpenReader(zipPath)
+    if err != nil {
+        tempfiles.Remove(tempDir)
+        return "", fmt.Errorf("failed to open ZIP file: %w", err)
+    }
+    defer r.Close()
+
+    if err := validateArchiveEntries(r.File, tempDir, maxSize); err != nil {
+        tempfiles.Remove(tempDir)
+        return "", err
+    }
+
+    // Extract files
+    for _, f := range r.File {
+        if err := ctx.Err(); err != nil {
+            tempfiles.Remove(tempDir)
+            return "", err
+        }
+
+        rc, err := f.Open()
+        if err != nil {
+            tempfiles.Remove(tempDir)
+            return "", fmt.Errorf("failed to open file in ZIP: %w", err)
+        }
+
+        path, _ := safeArchivePath(tempDir, f.Name)
+
+        if databasesOnly && !f.FileInfo().IsDir() && isSimplexFilesEntry(f.Name) {
+            rc.Close()
+            if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+                tempfiles.Remove(tempDir)
+                return "", fmt.Errorf("failed to create directory: %w", err)
+            }
+            continue
+        }
+
+        if f.FileInfo().IsDir() {
+            os.MkdirAll(path, 0755)
+            rc.Close()
+            continue
+        }
+
+        // Create directory if needed
+        if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+            rc.Close()
+            tempfiles.Remove(tempDir)
+            return "", fmt.Errorf("failed to create directory: %w", err)
+        }
+
+        // Extract file
+        outFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.FileInfo().Mode().Perm()|0600)
+        if err != nil {
+            rc.Close()
+            tempfiles.Remove(tempDir)
+            return "", fmt.Errorf("failed to create output file: %w", err)
+        }
+
+        // Never write more than the entry declares, so a forged header can't fill the disk
+        written, err := io.Copy(outFile, io.LimitReader(rc, int64(f.UncompressedSize64)+1))
+        if err == nil && uint64(written) > f.UncompressedSize64 {
+            err = fmt.Errorf("%s is larger than its declared size", f.Name)
+        }
+        outFile.Close()
+        rc.Close()
+
+        if err != nil {
+            tempfiles.Remove(tempDir)
+            return "", fmt.Errorf("failed to extract file: %w", err)
+        }
+    }
+
+    return tempDir, nil
+}
+
+// Create new SimpleX ZIP export from directory. Entries of baseZipPath, the archive the directory
+// was extracted from, whose files are unchanged are copied over as they are, without being
+// decompressed and compressed again, and so are the ones of the files directory that weren't
+// extracted; only changed and new files are compressed. Copied entries keep their headers
+// (modification time, permissions, compression method) byte for byte, changed ones keep the
+// permissions and method, and the archive keeps its comment. bar (may be nil) counts the bytes
+// written and gets its total set
+func Create(ctx context.Context, sourceDir, outputZipPath, baseZipPath string, bar *progress.Bar) error {
+    // Create output ZIP file
+    zipFile, err := os.Create(outputZipPath)
+    if err != nil {
+        return fmt.Errorf("failed to create ZIP file: %w", err)
+    }
+    // Only for returning early, the end closes both and checks them
+    defer zipFile.Close()
+
+    zipWriter := zip.NewWriter(zipFile)
+    defer zipWriter.Close()
+
+    var total int64
+    if bar != nil {
+        filepath.Walk(sourceDir, func(_ string, info os.FileInfo, err error) error {
+            if err == nil && !info.IsDir() {
+                total += info.Size()
+            }
+            return nil
+        })
+        bar.SetTotal(total)
+    }
+
+    // Entries written so far, by name
+    written := make(map[string]bool)
+
+    if baseZipPath != "" {
+        base, err := zip.OpenReader(baseZipPath)
+        if err != nil {
+            return fmt.Errorf("failed to open original ZIP file: %w", err)
+        }
+        defer base.Close()
+        if err := zipWriter.SetComment(base.Comment); err != nil {
+            return fmt.Errorf("failed to copy the comment of the original ZIP: %w", err)
+        }
+
+        for _, f := range base.File {
+            if f.FileInfo().IsDir() || written[f.Name] {
+                continue
+            }
+            if _, err := os.Stat(filepath.Join(sourceDir, filepath.FromSlash(f.Name))); err != nil && isSimplexFilesEntry(f.Name) {
+                total += int64(f.CompressedSize64)
+            }
+        }
+        bar.SetTotal(total)
+
+        // The original's entries go first, in its order
+        for _, f := range base.File {
+            if err := ctx.Err(); err != nil {
+                return err
+            }
+            if written[f.Name] {
+                continue
+            }
+            filePath := filepath.Join(sourceDir, filepath.FromSlash(f.Name))
+            info, err := os.Stat(filePath)
+            if err != nil {
+                // Files left in the archive by Extract's databasesOnly; anything else was removed
+                // since, like the -wal of a database that was checkpointed
+                if f.FileInfo().IsDir() || !isSimplexFilesEntry(f.Name) {
+                    continue
+                }
+                if err := zipWriter.Copy(f); err != nil {
+                    return fmt.Errorf("failed to copy %s from original ZIP: %w", f.Name, err)
+                }
+                bar.Add(int64(f.CompressedSize64))
+                written[f.Name] = true
+                continue
+            }
+            if info.IsDir() != f.FileInfo().IsDir() {
+                continue
+            }
+
+            same, err := unchanged(f, filePath, info)
+            if err != nil {
+                return err
+            }
+            if same {
+                if err := zipWriter.Copy(f); err != nil {
+                    return fmt.Errorf("failed to copy %s from original ZIP: %w", f.Name, err)
+                }
+                bar.Add(info.Size())
+            } else if err := addFile(zipWriter, f.Name, filePath, info, f, bar); err != nil {
+                return err
+            }
+            written[f.Name] = true
+        }
+    }
+
+    // Walk through source directory for what the original doesn't have
+    err = filepath.Walk(sourceDir, func(filePath string, info os.FileInfo, err error) error {
+        if err != nil {
+            return err
+        }
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+
+        // Get relative path from source directory
+        relPath, err := filepath.Rel(sourceDir, filePath)
+        if err != nil {
+            return err
+        }
+
+        // Skip root directory itself
+        if relPath == "." {
+            return nil
+        }
+
+        // ZIP entries always use forward slashes, also when building the archive on Windows
+        name := filepath.ToSlash(relPath)
+        if info.IsDir() {
+            name += "/"
+        }
+        if written[name] {
+            return nil
+        }
+        return addFile(zipWriter, name, filePath, info, nil, bar)
+    })
+    if err != nil {
+        return err
+    }
+
+    // Closing the writer writes the central directory, without which the archive can't be read
+    if err := zipWriter.Close(); err != nil {
+        return fmt.Errorf("failed to finish ZIP file: %w", err)
+    }
+    if err := zipFile.Close(); err != nil {
+        return fmt.Errorf("failed to finish ZIP file: %w", err)
+    }
+    return nil
+}
+
+// Write a file of the directory (or a directory) to the archive as name, compressed. A file that
+// changed since it was extracted from original (nil for new files) keeps the entry's permissions
+// and compression method, the ones SimpleX gave it
+func addFile(zipWriter *zip.Writer, name, filePath string, info os.FileInfo, original *zip.File, bar *progress.Bar) error {
+    // Create header
+    header, err := zip.FileInfoHeader(info)
+    if err != nil {
+        return err
+    }
+    header.Name = name
+    if !info.IsDir() {
+        header.Method = zip.Deflate
+    }
+    if original != nil {
+        header.SetMode(original.Mode())
+        header.Comment = original.Comment
+        if original.Method == zip.Store {
+            header.Method = zip.Store
+        }
+    }
+
+    // Create file in ZIP
+    writer, err := zipWriter.CreateHeader(header)
+    if err != nil {
+        return err
+    }
+    if info.IsDir() {
+        return nil
+    }
+
+    // Copy file content
+    file, err := os.Open(filePath)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    _, err = io.Copy(io.MultiWriter(writer, bar), file)
+    return err
+}
+
+// Whether an extracted file still has the content of the archive entry it came from, by its
+// size and CRC-32, so the compressed entry can be copied instead
+func unchanged(f *zip.File, filePath string, info os.FileInfo) (bool, error) {
+    if info.IsDir() {
+        return true, nil
+    }
+    if uint64(info.Size()) != f.UncompressedSize64 {
+        return false, nil
+    }
+    file, err := os.Open(filePath)
+    if err != nil {
+        return false, err
+    }
+    defer file.Close()
+
+    hash := crc32.NewIEEE()
+    if _, err := io.Copy(hash, file); err != nil {
+        return false, err
+    }
+    return hash.Sum32() == f.CRC32, nil
+}
+
+// Replace zipPath with an updated archive without ever leaving a half-written file in its place:
+// the new archive is written next to it and fsynced, the original is kept as <zip>.bak and the
+// new one is renamed over it. A <zip>.bak that's already there may be the only copy of the
+// archive before an earlier import, so it's never replaced: Replace refuses instead. bar is
+// passed on to Create
+func Replace(ctx context.Context, sourceDir, zipPath, baseZipPath string, bar *progress.Bar) (string, error) {
+    backupPath := BackupPath(zipPath)
+    if _, err := os.Lstat(backupPath); err == nil {
+        return "", fmt.Errorf("%s is already there, from an earlier import; restore it with rollback or move it away first", backupPath)
+    }
+
+    tempPath := fmt.Sprintf("%s.tmp-%d", zipPath, os.Getpid())
+    if err := Create(ctx, sourceDir, tempPath, baseZipPath, bar); err != nil {
+        os.Remove(tempPath)
+        return "", err
+    }
+
+    tempFile, err := os.OpenFile(tempPath, os.O_RDWR, 0)
+    if err == nil {
+        err = tempFile.Sync()
+        tempFile.Close()
+    }
+    if err != nil {
+        os.Remove(tempPath)
+        return "", fmt.Errorf("failed to flush updated ZIP: %w", err)
+    }
+
+    // Hard link the original as the backup when possible, otherwise copy it
+    if err := os.Link(zipPath, backupPath); err != nil {
+        if err := CopyFile(zipPath, backupPath); err != nil {
+            os.Remove(tempPath)
+            return "", fmt.Errorf("failed to back up original ZIP: %w", err)
+        }
+    }
+
+    if err := os.Rename(tempPath, zipPath); err != nil {
+        os.Remove(tempPath)
+        return "", fmt.Errorf("failed to replace original ZIP: %w", err)
+    }
+
+    // Persist the rename itself (not possible on Windows, where directories can't be opened)
+    if dir, err := os.Open(filepath.Dir(zipPath)); err == nil {
+        dir.Sync()
+        dir.Close()
+    }
+
+    return backupPath, nil
+}
+
+// Backup Replace keeps of the original archive
+func BackupPath(zipPath string) string {
+    return zipPath + ".bak"
+}
+
+// Undo Replace by moving <zip>.bak back over zipPath. Returns the backup that was restored
+func Restore(zipPath string) (string, error) {
+    backupPath := BackupPath(zipPath)
+    if _, err := os.Stat(backupPath); err != nil {
+        return "", fmt.Errorf("no backup to restore: %w", err)
+    }
+    if err := Check(backupPath); err != nil {
+        return "", fmt.Errorf("backup is not usable: %w", err)
+    }
+
+    if err := os.Rename(backupPath, zipPath); err != nil {
+        return "", fmt.Errorf("failed to restore backup: %w", err)
+    }
+    if dir, err := os.Open(filepath.Dir(zipPath)); err == nil {
+        dir.Sync()
+        dir.Close()
+    }
+    return backupPath, nil
+}
+
+// Copy a directory tree to destDir, which mustn't exist yet, syncing the files
+func CopyDir(sourceDir, destDir string) error {
+    return filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+        if err != nil {
+            return err
+        }
+        relPath, err := filepath.Rel(sourceDir, path)
+        if err != nil {
+            return err
+        }
+        destPath := filepath.Join(destDir, relPath)
+        if info.IsDir() {
+            return os.MkdirAll(destPath, info.Mode().Perm()|0700)
+        }
+        return CopyFile(path, destPath)
+    })
+}
+
+// Copy a file, syncing the copy to disk
+func CopyFile(sourcePath, destPath string) error {
+    sourceFile, err := os.Open(sourcePath)
+    if err != nil {
+        return err
+    }
+    defer sourceFile.Close()
+
+    destFile, err := os.Create(destPath)
+    if err != nil {
+        return err
+    }
+
+    if _, err := io.Copy(destFile, sourceFile); err != nil {
+        destFile.Close()
+        return err
+    }
+    if err := destFile.Sync(); err != nil {
+        destFile.Close()
+        return err
+    }
+    return destFile.Close()
+}
@@ -0,0 +1,169 @@
+package archive
+
+import (
+    "archive/zip"
+    "context"
+    "io"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+// Write files, by slash-separated name, under dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+    t.Helper()
+    for name, content := range files {
+        path := filepath.Join(dir, filepath.FromSlash(name))
+        if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+            t.Fatal(err)
+        }
+        if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+// Contents of the archive's files, by entry name
+func readZip(t *testing.T, zipPath string) map[string]string {
+    t.Helper()
+    r, err := zip.OpenReader(zipPath)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer r.Close()
+
+    files := make(map[string]string)
+    for _, f := range r.File {
+        if f.FileInfo().IsDir() {
+            continue
+        }
+        rc, err := f.Open()
+        if err != nil {
+            t.Fatal(err)
+        }
+        content, err := io.ReadAll(rc)
+        rc.Close()
+        if err != nil {
+            t.Fatal(err)
+        }
+        files[f.Name] = string(content)
+    }
+    return files
+}
+
+func checkFiles(t *testing.T, got, want map[string]string) {
+    t.Helper()
+    if len(got) != len(want) {
+        t.Fatalf("got %d files %v, want %d", len(got), got, len(want))
+    }
+    for name, content := range want {
+        if got[name] != content {
+            t.Fatalf("%s is %q, want %q", name, got[name], content)
+        }
+    }
+}
+
+var exportFiles = map[string]string{
+    "simplex_v1_chat.db":         "chat database",
+    "simplex_v1_agent.db":        "agent database",
+    "simplex_v1_files/photo.jpg": "photo",
+    "simplex_v1_files/notes.txt": "notes",
+}
+
+func TestCreateRoundTrip(t *testing.T) {
+    tests := []struct {
+        name          string
+        databasesOnly bool
+        changes       map[string]string // Files written to the extracted directory before Create
+    }{
+        {"unchanged", false, nil},
+        {"changed database", false, map[string]string{"simplex_v1_chat.db": "chat database with messages"}},
+        {"new file", false, map[string]string{"simplex_v1_files/new.png": "new"}},
+        {"databases only", true, map[string]string{"simplex_v1_chat.db": "imported", "simplex_v1_files/new.png": "new"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            dir := t.TempDir()
+            source := filepath.Join(dir, "source")
+            writeFiles(t, source, exportFiles)
+            basePath := filepath.Join(dir, "base.zip")
+            if err := Create(context.Background(), source, basePath, "", nil); err != nil {
+                t.Fatal(err)
+            }
+            checkFiles(t, readZip(t, basePath), exportFiles)
+
+            extracted, err := Extract(context.Background(), basePath, dir, tt.databasesOnly, 0)
+            if err != nil {
+                t.Fatal(err)
+            }
+            writeFiles(t, extracted, tt.changes)
+
+            outputPath := filepath.Join(dir, "output.zip")
+            if err := Create(context.Background(), extracted, outputPath, basePath, nil); err != nil {
+                t.Fatal(err)
+            }
+            want := make(map[string]string)
+            for name, content := range exportFiles {
+                want[name] = content
+            }
+            for name, content := range tt.changes {
+                want[name] = content
+            }
+            checkFiles(t, readZip(t, outputPath), want)
+        })
+    }
+}
+
+func TestReplace(t *testing.T) {
+    dir := t.TempDir()
+    source := filepath.Join(dir, "source")
+    writeFiles(t, source, exportFiles)
+    zipPath := filepath.Join(dir, "simplex.zip")
+    if err := Create(context.Background(), source, zipPath, "", nil); err != nil {
+        t.Fatal(err)
+    }
+
+    extracted, err := Extract(context.Background(), zipPath, dir, true, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    writeFiles(t, extracted, map[string]string{"simplex_v1_chat.db": "imported"})
+
+    backupPath, err := Replace(context.Background(), extracted, zipPath, zipPath, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if backupPath != BackupPath(zipPath) {
+        t.Fatalf("backup is %s, want %s", backupPath, BackupPath(zipPath))
+    }
+    checkFiles(t, readZip(t, backupPath), exportFiles)
+    updated := map[string]string{"simplex_v1_chat.db": "imported"}
+    for name, content := range exportFiles {
+        if _, ok := updated[name]; !ok {
+            updated[name] = content
+        }
+    }
+    checkFiles(t, readZip(t, zipPath), updated)
+
+    // A second Replace would lose the backup of the first, so it leaves both archives alone
+    writeFiles(t, extracted, map[string]string{"simplex_v1_chat.db": "imported again"})
+    if _, err := Replace(context.Background(), extracted, zipPath, zipPath, nil); err == nil {
+        t.Fatal("Replace replaced an existing backup")
+    }
+    checkFiles(t, readZip(t, backupPath), exportFiles)
+    checkFiles(t, readZip(t, zipPath), updated)
+    if matches, _ := filepath.Glob(zipPath + ".tmp-*"); len(matches) > 0 {
+        t.Fatalf("temp archives left behind: %v", matches)
+    }
+
+    if _, err := Restore(zipPath); err != nil {
+        t.Fatal(err)
+    }
+    checkFiles(t, readZip(t, zipPath), exportFiles)
+    if _, err := os.Stat(backupPath); !os.IsNotExist(err) {
+        t.Fatalf("backup is still there after Restore: %v", err)
+    }
+    if _, err := Restore(zipPath); err == nil {
+        t.Fatal("Restore without a backup succeeded")
+    }
+}
@@ -0,0 +1,72 @@
+package archive
+
+import (
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// Directory layouts a SimpleX database can come in
+type Layout struct {
+    Name     string
+    ChatDB   string
+    AgentDB  string
+    FilesDir string
+}
+
+// Known layouts, in the order they are tried
+var Layouts = []Layout{
+    // "Export database" archives (from any platform) and the desktop data directory
+    {Name: "export/desktop", ChatDB: "simplex_v1_chat.db", AgentDB: "simplex_v1_agent.db", FilesDir: "simplex_v1_files"},
+    // Android app data directory (files/ in the app's data)
+    {Name: "android", ChatDB: "files_chat.db", AgentDB: "files_agent.db", FilesDir: "app_files"},
+}
+
+// Detect which layout a directory uses. Archives re-zipped by other tools sometimes wrap
+// everything in a single folder, so one level below the root is checked too
+func DetectLayout(root string) (Layout, string, error) {
+    dirs := []string{root}
+    if entries, err := os.ReadDir(root); err == nil {
+        for _, entry := range entries {
+            if entry.IsDir() {
+                dirs = append(dirs, filepath.Join(root, entry.Name()))
+            }
+        }
+    }
+
+    for _, dir := range dirs {
+        for _, layout := range Layouts {
+            if info, err := os.Stat(filepath.Join(dir, layout.ChatDB)); err == nil && !info.IsDir() {
+                return layout, dir, nil
+            }
+        }
+    }
+
+    names := []string{}
+    for _, layout := range Layouts {
+        names = append(names, layout.ChatDB)
+    }
+    return Layout{}, "", fmt.Errorf("no SimpleX database (%s) found in %s", strings.Join(names, " or "), root)
+}
+
+// Find the chat database and the files directory (created if missing) following the detected layout
+func Locate(root string) (string, string, error) {
+    layout, dir, err := DetectLayout(root)
+    if err != nil {
+        return "", "", err
+    }
+    fmt.Printf("Detected %s layout\n", layout.Name)
+
+    if _, err := os.Stat(filepath.Join(dir, layout.AgentDB)); err != nil {
+        log.Printf("Warning: %s not found next to %s, the archive may not import back into SimpleX", layout.AgentDB, layout.ChatDB)
+    }
+
+    filesDir := filepath.Join(dir, layout.FilesDir)
+    if err := os.MkdirAll(filesDir, 0755); err != nil {
+        return "", "", fmt.Errorf("failed to create files directory: %w", err)
+    }
+
+    return filepath.Join(dir, layout.ChatDB), filesDir, nil
+}
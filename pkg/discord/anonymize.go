@@ -0,0 +1,168 @@
+package discord
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "net/url"
+    "path"
+    "strings"
+
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// Scrubs an export, naming users and roles by the order they first show up
+type anonymizer struct {
+    users map[string]string
+    roles map[string]string
+}
+
+// Scrub a DiscordChatExporter export so it can be shared to reproduce a bug: message and embed
+// text become same-length placeholders, attachment names are hashed and authors, roles, the
+// server and the channel are renamed. IDs, timestamps and every other field stay as they are, so
+// the export converts the same way. The attachment files themselves aren't part of it
+func Anonymize(data []byte) ([]byte, error) {
+    var export map[string]interface{}
+    if err := json.Unmarshal(data, &export); err != nil {
+        return nil, fmt.Errorf("failed to parse JSON: %w", err)
+    }
+
+    a := &anonymizer{users: make(map[string]string), roles: make(map[string]string)}
+    if guild, ok := export["guild"].(map[string]interface{}); ok {
+        guild["name"] = "Server"
+        guild["iconUrl"] = ""
+    }
+    if channel, ok := export["channel"].(map[string]interface{}); ok {
+        channel["name"] = "channel"
+        if _, ok := channel["category"].(string); ok {
+            channel["category"] = "Category"
+        }
+        if topic, ok := channel["topic"].(string); ok {
+            channel["topic"] = universal.Placeholder(topic)
+        }
+    }
+
+    messages, _ := export["messages"].([]interface{})
+    for _, item := range messages {
+        msg, ok := item.(map[string]interface{})
+        if !ok {
+            continue
+        }
+        if content, ok := msg["content"].(string); ok {
+            msg["content"] = universal.Placeholder(content)
+        }
+        a.user(msg["author"])
+        for _, mention := range objects(msg["mentions"]) {
+            a.user(mention)
+        }
+        for _, reaction := range objects(msg["reactions"]) {
+            for _, user := range objects(reaction["users"]) {
+                a.user(user)
+            }
+        }
+        for _, attachment := range objects(msg["attachments"]) {
+            if name, ok := attachment["fileName"].(string); ok {
+                attachment["fileName"] = universal.HashedName(name)
+            }
+            if link, ok := attachment["url"].(string); ok {
+                attachment["url"] = hashedURL(link)
+            }
+        }
+        for _, embed := range objects(msg["embeds"]) {
+            scrubEmbed(embed)
+        }
+    }
+
+    // Keep <@id> tokens readable like DiscordChatExporter writes them
+    var buf bytes.Buffer
+    encoder := json.NewEncoder(&buf)
+    encoder.SetEscapeHTML(false)
+    encoder.SetIndent("", "  ")
+    if err := encoder.Encode(export); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+// The objects of a JSON array, skipping anything else
+func objects(value interface{}) []map[string]interface{} {
+    items, _ := value.([]interface{})
+    var result []map[string]interface{}
+    for _, item := range items {
+        if object, ok := item.(map[string]interface{}); ok {
+            result = append(result, object)
+        }
+    }
+    return result
+}
+
+// Rename an author, mention or reacting user to userN, the same N wherever the ID shows up
+func (a *anonymizer) user(value interface{}) {
+    user, ok := value.(map[string]interface{})
+    if !ok {
+        return
+    }
+    id, _ := user["id"].(string)
+    name, found := a.users[id]
+    if !found {
+        name = fmt.Sprintf("user%d", len(a.users)+1)
+        a.users[id] = name
+    }
+    user["name"] = name
+    if _, ok := user["nickname"].(string); ok {
+        user["nickname"] = name
+    }
+    if _, ok := user["avatarUrl"].(string); ok {
+        user["avatarUrl"] = ""
+    }
+    for _, role := range objects(user["roles"]) {
+        roleID, _ := role["id"].(string)
+        roleName, found := a.roles[roleID]
+        if !found {
+            roleName = fmt.Sprintf("role%d", len(a.roles)+1)
+            a.roles[roleID] = roleName
+        }
+        role["name"] = roleName
+    }
+}
+
+// Replace every text of an embed, its nested author, footer and fields included, with
+// placeholders and hash its links
+func scrubEmbed(embed map[string]interface{}) {
+    for key, value := range embed {
+        switch v := value.(type) {
+        case string:
+            switch {
+            case key == "timestamp" || key == "color" || key == "type":
+            case strings.HasSuffix(strings.ToLower(key), "url"):
+                embed[key] = hashedURL(v)
+            default:
+                embed[key] = universal.Placeholder(v)
+            }
+        case map[string]interface{}:
+            scrubEmbed(v)
+        case []interface{}:
+            for _, item := range objects(v) {
+                scrubEmbed(item)
+            }
+        }
+    }
+}
+
+// Link with its file name hashed. Web links point to example.com and paths into the export
+// folder to an attachments folder, as the host and the folder name (named after the channel by
+// DiscordChatExporter) could give the chat away
+func hashedURL(link string) string {
+    if link == "" {
+        return ""
+    }
+    if universal.IsRemoteURL(link) {
+        parsed, err := url.Parse(link)
+        name := link
+        if err == nil {
+            name = path.Base(parsed.Path)
+        }
+        return "https://example.com/" + universal.HashedName(name)
+    }
+    return "attachments/" + universal.HashedName(path.Base(strings.ReplaceAll(link, "\\", "/")))
+}
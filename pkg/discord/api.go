@@ -0,0 +1,346 @@
+package discord
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "net/url"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Discord REST API, used to fetch history with -discord-channel and to follow a channel for -bridge
+const apiBaseURL = "https://discord.com/api/v10"
+
+// Client for the Discord REST API
+type APIClient struct {
+    Authorization string
+    HTTP          *http.Client
+}
+
+// Bot tokens are sent as "Bot <token>" unless userToken is set
+func NewAPIClient(token string, userToken bool) *APIClient {
+    authorization := token
+    if !userToken && !strings.HasPrefix(token, "Bot ") {
+        authorization = "Bot " + token
+    }
+    return &APIClient{Authorization: authorization, HTTP: &http.Client{Timeout: 60 * time.Second}}
+}
+
+// Wait for d, or less if ctx is done first
+func sleep(ctx context.Context, d time.Duration) error {
+    timer := time.NewTimer(d)
+    defer timer.Stop()
+    select {
+    case <-ctx.Done():
+        return ctx.Err()
+    case <-timer.C:
+        return nil
+    }
+}
+
+// GET an API path into out, waiting out rate limits and retrying server errors
+func (c *APIClient) Get(ctx context.Context, path string, out interface{}) error {
+    for attempt := 0; ; attempt++ {
+        req, err := http.NewRequestWithContext(ctx, "GET", apiBaseURL+path, nil)
+        if err != nil {
+            return err
+        }
+        req.Header.Set("Authorization", c.Authorization)
+        req.Header.Set("User-Agent", "DiscordBot (https://github.com/ritiek/discord-to-simplex, 1.0)")
+
+        resp, err := c.HTTP.Do(req)
+        if err != nil {
+            return err
+        }
+
+        if resp.StatusCode == http.StatusTooManyRequests {
+            var body struct {
+                RetryAfter float64 `json:"retry_after"`
+            }
+            json.NewDecoder(resp.Body).Decode(&body)
+            resp.Body.Close()
+
+            wait := time.Duration(body.RetryAfter * float64(time.Second))
+            if wait <= 0 {
+                wait = time.Second
+            }
+            log.Printf("Rate limited by Discord, waiting %s", wait)
+            if err := sleep(ctx, wait); err != nil {
+                return err
+            }
+            continue
+        }
+        if resp.StatusCode >= 500 && attempt < 5 {
+            resp.Body.Close()
+            if err := sleep(ctx, time.Duration(1<<attempt)*time.Second); err != nil {
+                return err
+            }
+            continue
+        }
+        if resp.StatusCode != http.StatusOK {
+            body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+            resp.Body.Close()
+            return fmt.Errorf("Discord API %s: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+        }
+
+        err = json.NewDecoder(resp.Body).Decode(out)
+        resp.Body.Close()
+
+        // Pause before the bucket runs out instead of running into 429s
+        if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+            if resetAfter, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Reset-After"), 64); err == nil {
+                sleep(ctx, time.Duration(resetAfter*float64(time.Second)))
+            }
+        }
+        return err
+    }
+}
+
+// Discord API message objects, only the fields the importer uses
+type APIUser struct {
+    ID            string  `json:"id"`
+    Username      string  `json:"username"`
+    GlobalName    *string `json:"global_name"`
+    Discriminator string  `json:"discriminator"`
+    Avatar        *string `json:"avatar"`
+    Bot           bool    `json:"bot"`
+}
+
+type APIMessage struct {
+    ID              string          `json:"id"`
+    ChannelID       string          `json:"channel_id"`
+    Type            int             `json:"type"`
+    Content         string          `json:"content"`
+    Timestamp       string          `json:"timestamp"`
+    EditedTimestamp *string         `json:"edited_timestamp"`
+    Pinned          bool            `json:"pinned"`
+    Author          APIUser         `json:"author"`
+    WebhookID       string          `json:"webhook_id"`
+    Flags           int             `json:"flags"`
+    Attachments     []APIAttachment `json:"attachments"`
+    Embeds []struct {
+        Title       string             `json:"title"`
+        URL         string             `json:"url"`
+        Description string             `json:"description"`
+        Thumbnail   *EmbedImage        `json:"thumbnail"`
+        Image       *EmbedImage        `json:"image"`
+    } `json:"embeds"`
+    Reactions []struct {
+        Count int `json:"count"`
+        Emoji struct {
+            ID   *string `json:"id"`
+            Name string  `json:"name"`
+        } `json:"emoji"`
+    } `json:"reactions"`
+    Mentions         []APIUser        `json:"mentions"`
+    MessageReference *struct {
+        MessageID string `json:"message_id"`
+        ChannelID string `json:"channel_id"`
+        GuildID   string `json:"guild_id"`
+        Type      int    `json:"type"`
+    } `json:"message_reference"`
+    ReferencedMessage *APIMessage        `json:"referenced_message"`
+    // What a forwarded message forwards; its own content and attachments are empty
+    MessageSnapshots []struct {
+        Message struct {
+            Content     string          `json:"content"`
+            Attachments []APIAttachment `json:"attachments"`
+        } `json:"message"`
+    } `json:"message_snapshots"`
+}
+
+type APIAttachment struct {
+    ID       string `json:"id"`
+    Filename string `json:"filename"`
+    Size     int64  `json:"size"`
+    URL      string `json:"url"`
+}
+
+func (u APIUser) toExportAuthor() Author {
+    author := Author{
+        ID:            u.ID,
+        Name:          u.Username,
+        Discriminator: u.Discriminator,
+        Nickname:      u.Username,
+        IsBot:         u.Bot,
+    }
+    if u.GlobalName != nil && *u.GlobalName != "" {
+        author.Nickname = *u.GlobalName
+    }
+    if u.Avatar != nil {
+        author.AvatarURL = fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s.png", u.ID, *u.Avatar)
+    }
+    return author
+}
+
+// Convert an API message into the DiscordChatExporter shape the rest of the importer reads
+func (m APIMessage) ToExportMessage() Message {
+    msg := Message{
+        ID:              m.ID,
+        Type:            "Default",
+        Timestamp:       m.Timestamp,
+        TimestampEdited: m.EditedTimestamp,
+        IsPinned:        m.Pinned,
+        Content:         m.Content,
+        Author:          m.Author.toExportAuthor(),
+    }
+    if m.Type == 19 {
+        msg.Type = "Reply"
+    }
+    if m.WebhookID != "" {
+        msg.Author.IsBot = true
+        msg.Author.Discriminator = "0000"
+    }
+
+    attachments := m.Attachments
+    if len(m.MessageSnapshots) > 0 && m.Content == "" && len(attachments) == 0 {
+        msg.Content = m.MessageSnapshots[0].Message.Content
+        attachments = m.MessageSnapshots[0].Message.Attachments
+    }
+    for _, att := range attachments {
+        msg.Attachments = append(msg.Attachments, Attachment{
+            ID:            att.ID,
+            URL:           att.URL,
+            FileName:      att.Filename,
+            FileSizeBytes: LenientInt(att.Size),
+        })
+    }
+
+    for _, embed := range m.Embeds {
+        exportEmbed := Embed{
+            Title:       embed.Title,
+            URL:         embed.URL,
+            Description: embed.Description,
+            Thumbnail:   embed.Thumbnail,
+        }
+        if embed.Image != nil {
+            exportEmbed.Images = []EmbedImage{*embed.Image}
+        }
+        msg.Embeds = append(msg.Embeds, exportEmbed)
+    }
+
+    for _, reaction := range m.Reactions {
+        emojiID := ""
+        if reaction.Emoji.ID != nil {
+            emojiID = *reaction.Emoji.ID
+        }
+        msg.Reactions = append(msg.Reactions, Reaction{
+            Emoji: Emoji{ID: emojiID, Name: reaction.Emoji.Name},
+            Count: LenientInt(reaction.Count),
+        })
+    }
+
+    for _, user := range m.Mentions {
+        author := user.toExportAuthor()
+        msg.Mentions = append(msg.Mentions, Mention{
+            ID:            author.ID,
+            Name:          author.Name,
+            Discriminator: author.Discriminator,
+            Nickname:      author.Nickname,
+            IsBot:         author.IsBot,
+            AvatarURL:     author.AvatarURL,
+        })
+    }
+
+    if m.MessageReference != nil && m.MessageReference.MessageID != "" {
+        msg.Reference = &Reference{
+            MessageID: m.MessageReference.MessageID,
+            ChannelID: m.MessageReference.ChannelID,
+            GuildID:   m.MessageReference.GuildID,
+            Type:      ReferenceDefault,
+        }
+        // Message flag 2 (IS_CROSSPOST) marks a copy from a followed channel, reference type 1 a forward
+        switch {
+        case m.Flags&2 != 0:
+            msg.Reference.Type = ReferenceCrosspost
+        case m.MessageReference.Type == 1:
+            msg.Reference.Type = ReferenceForward
+        }
+    }
+
+    return msg
+}
+
+// Fetch up to 100 messages of a channel; query takes before/after/around and limit
+func (c *APIClient) ChannelMessages(ctx context.Context, channelID string, query url.Values) ([]APIMessage, error) {
+    var messages []APIMessage
+    err := c.Get(ctx, fmt.Sprintf("/channels/%s/messages?%s", channelID, query.Encode()), &messages)
+    return messages, err
+}
+
+// Download a channel's whole history, oldest first, in the same shape as a DiscordChatExporter export
+func FetchExport(ctx context.Context, api *APIClient, channelID string) (*Export, error) {
+    var channel struct {
+        ID         string           `json:"id"`
+        Name       string           `json:"name"`
+        Recipients []APIUser        `json:"recipients"`
+    }
+    if err := api.Get(ctx, "/channels/"+channelID, &channel); err != nil {
+        return nil, err
+    }
+
+    export := &Export{}
+    export.Channel.ID = channel.ID
+    export.Channel.Name = channel.Name
+    // DMs have no name, DiscordChatExporter names them after the other user
+    if export.Channel.Name == "" && len(channel.Recipients) > 0 {
+        export.Channel.Name = channel.Recipients[0].Username
+    }
+
+    // Pages come newest first; walk backwards with before=<oldest seen>
+    before := ""
+    for {
+        query := url.Values{"limit": {"100"}}
+        if before != "" {
+            query.Set("before", before)
+        }
+        page, err := api.ChannelMessages(ctx, channelID, query)
+        if err != nil {
+            return nil, err
+        }
+        if len(page) == 0 {
+            break
+        }
+
+        for _, apiMsg := range page {
+            export.Messages = append(export.Messages, apiMsg.ToExportMessage())
+            if before == "" || SnowflakeLess(apiMsg.ID, before) {
+                before = apiMsg.ID
+            }
+        }
+        fmt.Printf("Fetched %d messages...\n", len(export.Messages))
+
+        if len(page) < 100 {
+            break
+        }
+    }
+
+    sort.Slice(export.Messages, func(i, j int) bool {
+        return SnowflakeLess(export.Messages[i].ID, export.Messages[j].ID)
+    })
+    return export, nil
+}
+
+// Creation time encoded in a Discord snowflake ID: milliseconds since the start of 2015 in its
+// upper bits. Not ok if id isn't a snowflake
+func SnowflakeTime(id string) (time.Time, bool) {
+    snowflake, err := strconv.ParseUint(id, 10, 64)
+    if err != nil {
+        return time.Time{}, false
+    }
+    return time.UnixMilli(int64(snowflake>>22) + 1420070400000).UTC(), true
+}
+
+// Compare Discord snowflake IDs, which sort by creation time
+func SnowflakeLess(a, b string) bool {
+    if len(a) != len(b) {
+        return len(a) < len(b)
+    }
+    return a < b
+}
@@ -0,0 +1,140 @@
+package discord
+
+import (
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+    "unicode/utf8"
+
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// Discord spoiler markup: ||hidden text||
+var discordSpoilerRegex = regexp.MustCompile(`(?s)\|\|(.+?)\|\|`)
+
+// Convert Discord ||spoiler|| markup into SimpleX secret text (#secret#)
+func convertDiscordSpoilers(content string) string {
+    return discordSpoilerRegex.ReplaceAllStringFunc(content, func(match string) string {
+        // SimpleX markdown doesn't allow the secret to start or end with whitespace
+        inner := strings.TrimSpace(match[2 : len(match)-2])
+        if inner == "" {
+            return match
+        }
+        return "#" + inner + "#"
+    })
+}
+
+// Discord timestamp markup: <t:1618953630> or <t:1618953630:R>
+var discordTimestampRegex = regexp.MustCompile(`<t:(-?\d+)(?::([tTdDfFR]))?>`)
+
+// Layouts matching how the Discord client renders each timestamp style
+var discordTimestampLayouts = map[string]string{
+    "t": "3:04 PM",
+    "T": "3:04:05 PM",
+    "d": "01/02/2006",
+    "D": "January 2, 2006",
+    "f": "January 2, 2006 3:04 PM",
+    "F": "Monday, January 2, 2006 3:04 PM",
+    // Relative times would be stale once imported, so render them as absolute times
+    "R": "January 2, 2006 3:04 PM",
+}
+
+// Expand Discord <t:unix:style> timestamp tokens into human-readable times
+func convertDiscordTimestamps(content string, loc *time.Location) string {
+    if loc == nil {
+        loc = time.Local
+    }
+    return discordTimestampRegex.ReplaceAllStringFunc(content, func(match string) string {
+        parts := discordTimestampRegex.FindStringSubmatch(match)
+        unixSeconds, err := strconv.ParseInt(parts[1], 10, 64)
+        if err != nil {
+            return match
+        }
+        style := parts[2]
+        if style == "" {
+            style = "f" // Discord's default style
+        }
+        return time.Unix(unixSeconds, 0).In(loc).Format(discordTimestampLayouts[style])
+    })
+}
+
+// Discord user mention markup: <@123> or the legacy nickname form <@!123>
+var discordUserMentionRegex = regexp.MustCompile(`<@!?(\d+)>`)
+
+// Format a name as SimpleX mention markdown, quoting names that contain spaces
+func formatSimplexMention(name string) string {
+    if strings.ContainsAny(name, " \t") {
+        return "@'" + name + "'"
+    }
+    return "@" + name
+}
+
+// Replace Discord <@id> tokens with @DisplayName and record where each mention ends up in the text
+func convertDiscordMentions(content string, discordMentions []Mention) (string, []universal.Mention) {
+    mentionedUsers := make(map[string]Mention)
+    for _, mention := range discordMentions {
+        mentionedUsers[mention.ID] = mention
+    }
+
+    var result strings.Builder
+    var mentions []universal.Mention
+    last := 0
+    for _, loc := range discordUserMentionRegex.FindAllStringSubmatchIndex(content, -1) {
+        user, exists := mentionedUsers[content[loc[2]:loc[3]]]
+        if !exists {
+            // Leave tokens for users missing from the export untouched
+            continue
+        }
+
+        displayName := user.Nickname
+        if displayName == "" {
+            displayName = user.Name
+        }
+        mentionText := formatSimplexMention(displayName)
+
+        result.WriteString(content[last:loc[0]])
+        mentions = append(mentions, universal.Mention{
+            UserID:   user.ID,
+            Username: user.Name,
+            Start:    utf8.RuneCountInString(result.String()),
+            Length:   utf8.RuneCountInString(mentionText),
+        })
+        result.WriteString(mentionText)
+        last = loc[1]
+    }
+    result.WriteString(content[last:])
+
+    return result.String(), mentions
+}
+
+// Discord role and channel mention markup: <@&123> and <#123>
+var discordRoleMentionRegex = regexp.MustCompile(`<@&(\d+)>`)
+var discordChannelMentionRegex = regexp.MustCompile(`<#(\d+)>`)
+
+// Replace Discord role and channel mention tokens with @role / #channel names
+func convertDiscordRoleAndChannelMentions(content string, roleNames, channelNames map[string]string) string {
+    content = discordRoleMentionRegex.ReplaceAllStringFunc(content, func(match string) string {
+        roleName, exists := roleNames[discordRoleMentionRegex.FindStringSubmatch(match)[1]]
+        if !exists {
+            roleName = "deleted-role" // Same placeholder the Discord client shows
+        }
+        return formatSimplexMention(roleName)
+    })
+    return discordChannelMentionRegex.ReplaceAllStringFunc(content, func(match string) string {
+        channelName, exists := channelNames[discordChannelMentionRegex.FindStringSubmatch(match)[1]]
+        if !exists {
+            channelName = "deleted-channel"
+        }
+        return "#" + channelName
+    })
+}
+
+// Convert Discord-specific markup in message content to its SimpleX equivalent
+func convertDiscordContent(content string, discordMentions []Mention, opts ConvertOptions) (string, []universal.Mention) {
+    content = convertDiscordSpoilers(content)
+    content = convertDiscordTimestamps(content, opts.Location)
+    content = convertDiscordRoleAndChannelMentions(content, opts.RoleNames, opts.ChannelNames)
+    // User mentions go last so their positions refer to the final text
+    return convertDiscordMentions(content, discordMentions)
+}
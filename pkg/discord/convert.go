@@ -0,0 +1,384 @@
+package discord
+
+import (
+    "fmt"
+    "regexp"
+    "strings"
+    "time"
+
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// Options controlling how Discord messages are converted
+type ConvertOptions struct {
+    // Time zone used to render Discord timestamp markup
+    Location *time.Location
+    // Channel and role names known from the export, keyed by Discord ID
+    ChannelNames map[string]string
+    RoleNames    map[string]string
+    // How reactions with Discord custom emoji are imported
+    CustomEmojiMode string
+    // How pinned Discord messages are marked
+    PinnedMode string
+    // Convert messages marked deleted into deleted items instead of skipping them
+    ImportDeleted bool
+    // What replies to messages that aren't in the export quote
+    MissingReplies string
+}
+
+// Pinned message modes; SimpleX has no per-message pins, so pins are kept visible in the text
+const (
+    PinnedMarker = "marker" // Prepend a 📌 marker to the message text
+    PinnedNone   = "none"   // Import pinned messages like any other
+)
+
+// Modes for replies to messages that aren't in the export
+const (
+    MissingRepliesPlaceholder = "placeholder" // Quote a placeholder in place of the message
+    MissingRepliesDrop        = "drop"        // Import the reply without a quote
+)
+
+// Text quoted by replies to messages that aren't in the export
+const missingReplyText = "message not in export"
+
+// Custom emoji reaction modes
+const (
+    CustomEmojiText    = "text"    // Append :name: to the message text
+    CustomEmojiUnicode = "unicode" // React with the closest SimpleX-supported emoji
+    CustomEmojiSkip    = "skip"    // Drop the reaction
+)
+
+// Keywords used to pick the closest SimpleX reaction for a custom emoji name
+var simplexReactionKeywords = []struct {
+    Keywords []string
+    Emoji    string
+}{
+    {[]string{"heart", "love", "luv", "kiss"}, "❤"},
+    {[]string{"cry", "sad", "tear", "sob", "pensive"}, "😢"},
+    {[]string{"laugh", "lol", "lmao", "kek", "joy", "smile", "grin", "happy", "haha"}, "😀"},
+    {[]string{"rocket", "hype", "launch", "pog", "fire"}, "🚀"},
+    {[]string{"check", "done", "tick", "yes", "ok", "correct"}, "✅"},
+    {[]string{"thumb", "like", "up", "agree"}, "👍"},
+}
+
+// Map a custom emoji name to the closest of SimpleX's supported reactions
+func nearestSimplexReaction(name string) string {
+    lowerName := strings.ToLower(name)
+    for _, candidate := range simplexReactionKeywords {
+        for _, keyword := range candidate.Keywords {
+            if strings.Contains(lowerName, keyword) {
+                return candidate.Emoji
+            }
+        }
+    }
+    return "👍"
+}
+
+// Discord message links: https://discord.com/channels/<guild or @me>/<channel>/<message>
+var discordMessageLinkRegex = regexp.MustCompile(`https?://(?:(?:ptb|canary)\.)?discord(?:app)?\.com/channels/(@me|\d+)/(\d+)/(\d+)`)
+
+// Build the quote of a Discord message that exists in the export
+func buildQuotedMessage(quotedDiscordMsg Message, myUsername string, opts ConvertOptions) *universal.QuotedMessage {
+    quotedTimestamp, _ := universal.ParseTimestamp(quotedDiscordMsg.Timestamp, opts.Location)
+    quotedIsSent := quotedDiscordMsg.Author.Name == myUsername
+    quotedContent, _ := convertDiscordContent(quotedDiscordMsg.Content, quotedDiscordMsg.Mentions, opts)
+
+    return &universal.QuotedMessage{
+        SourceID: quotedDiscordMsg.ID,
+        SentAt:   quotedTimestamp,
+        Content:  quotedContent,
+        IsSent:   quotedIsSent,
+    }
+}
+
+// Platform-specific converters
+func ConvertMessage(discordMsg Message, myUsername string, discordMessages map[string]*Message, jsonDir string, opts ConvertOptions) universal.Message {
+    timestamp, _ := universal.ParseTimestamp(discordMsg.Timestamp, opts.Location)
+    var editedAt *time.Time
+    if discordMsg.TimestampEdited != nil {
+        if parsed, err := universal.ParseTimestamp(*discordMsg.TimestampEdited, opts.Location); err == nil {
+            editedAt = &parsed
+        }
+    }
+
+    var deletedAt *time.Time
+    if discordMsg.IsDeleted {
+        deletedAt = &timestamp
+        if discordMsg.TimestampDeleted != nil {
+            if parsed, err := universal.ParseTimestamp(*discordMsg.TimestampDeleted, opts.Location); err == nil {
+                deletedAt = &parsed
+            }
+        }
+    }
+
+    // Handle attachments
+    var attachments []universal.Attachment
+    var messageType string = "text"
+    if len(discordMsg.Attachments) > 0 {
+        for _, att := range discordMsg.Attachments {
+            // Nothing to import without a file
+            if att.URL == "" {
+                continue
+            }
+            attachments = append(attachments, universal.Attachment{
+                ID:       att.ID,
+                Filename: att.FileName,
+                URL:      att.URL,
+                Size:     int64(att.FileSizeBytes),
+            })
+        }
+
+        // The message type follows the first attachment, others are split off later
+        if len(attachments) > 0 {
+            messageType = universal.AttachmentMessageType(attachments[0].Filename)
+        }
+    }
+
+    // Convert link embeds into a link preview (SimpleX previews require an image)
+    var linkPreview *universal.LinkPreview
+    if len(attachments) == 0 {
+        for _, embed := range discordMsg.Embeds {
+            if embed.URL == "" {
+                continue
+            }
+            imageURL := ""
+            if embed.Thumbnail != nil && embed.Thumbnail.URL != "" {
+                imageURL = embed.Thumbnail.URL
+            } else if len(embed.Images) > 0 {
+                imageURL = embed.Images[0].URL
+            }
+            if imageURL == "" {
+                continue
+            }
+
+            title := embed.Title
+            if title == "" {
+                title = embed.URL
+            }
+            linkPreview = &universal.LinkPreview{
+                URL:         embed.URL,
+                Title:       title,
+                Description: embed.Description,
+                ImageURL:    imageURL,
+            }
+            messageType = "link"
+            break
+        }
+    }
+
+    // Convert reactions
+    var reactions []universal.Reaction
+    var customEmojiText []string
+    for _, react := range discordMsg.Reactions {
+        emoji := react.Emoji.Name
+        count := int(react.Count)
+        if emoji == "" {
+            continue
+        }
+
+        // Custom emoji have a Discord ID and a name instead of a unicode character
+        if react.Emoji.ID != "" {
+            switch opts.CustomEmojiMode {
+            case CustomEmojiText:
+                token := ":" + emoji + ":"
+                if count > 1 {
+                    token += fmt.Sprintf(" x%d", count)
+                }
+                customEmojiText = append(customEmojiText, token)
+                continue
+            case CustomEmojiUnicode:
+                emoji = nearestSimplexReaction(emoji)
+            default: // CustomEmojiSkip
+                continue
+            }
+        }
+
+        var userIDs []string
+        for _, user := range react.Users {
+            userIDs = append(userIDs, user.ID)
+        }
+
+        reactions = append(reactions, universal.Reaction{
+            Emoji:   emoji,
+            Count:   count,
+            UserIDs: userIDs,
+        })
+    }
+
+    rawContent := discordMsg.Content
+
+    // Forwarded and cross-posted messages reference their original too
+    var forwardedFrom *universal.ForwardedFrom
+    if reference := discordMsg.Reference; reference != nil && reference.forwarded(discordMsg.Type) {
+        forwardedFrom = &universal.ForwardedFrom{
+            ChatName: opts.ChannelNames[reference.ChannelID],
+            // DMs have no guild
+            IsGroup:  reference.GuildID != nil && reference.GuildID != "",
+        }
+        if _, found := discordMessages[reference.MessageID]; found {
+            forwardedFrom.MessageID = reference.MessageID
+        }
+    }
+
+    // Handle reply reference; the quote names the Discord message, the insert finds its item
+    var replyToID *string
+    var quotedMessage *universal.QuotedMessage
+    missingReference := ""
+    if discordMsg.Reference != nil && forwardedFrom == nil {
+        referencedDiscordID := discordMsg.Reference.MessageID
+        quotedDiscordMsg, found := discordMessages[referencedDiscordID]
+        if found {
+            replyToID = &referencedDiscordID
+            quotedMessage = buildQuotedMessage(*quotedDiscordMsg, myUsername, opts)
+        } else {
+            // The referenced message is outside the export (or was deleted)
+            missingReference = referencedDiscordID
+        }
+    }
+
+    // A message can only carry one quote, so links are only converted when it isn't a reply, and
+    // SimpleX doesn't quote in forwarded messages
+    if quotedMessage == nil && forwardedFrom == nil {
+        for _, match := range discordMessageLinkRegex.FindAllStringSubmatch(rawContent, -1) {
+            linkedDiscordID := match[3]
+            linkedDiscordMsg, exists := discordMessages[linkedDiscordID]
+            if !exists || linkedDiscordID == discordMsg.ID {
+                continue
+            }
+
+            quotedMessage = buildQuotedMessage(*linkedDiscordMsg, myUsername, opts)
+            replyToID = &linkedDiscordID
+
+            // The quote replaces the link, so drop it from the text
+            rawContent = strings.TrimSpace(strings.Replace(rawContent, match[0], "", 1))
+            break
+        }
+    }
+
+    // A reply to a message outside the export quotes a placeholder, unless a link gave it a
+    // real quote. All that's known of the message is its ID, which also tells when it was sent
+    if quotedMessage == nil && missingReference != "" && opts.MissingReplies != MissingRepliesDrop {
+        replyToID = &missingReference
+        sentAt, ok := SnowflakeTime(missingReference)
+        if !ok {
+            sentAt = timestamp
+        }
+        quotedMessage = &universal.QuotedMessage{
+            SourceID: missingReference,
+            SentAt:   sentAt,
+            Content:  missingReplyText,
+        }
+    }
+
+    if discordMsg.IsPinned && opts.PinnedMode == PinnedMarker {
+        rawContent = strings.TrimSpace("📌 " + rawContent)
+    }
+
+    content, mentions := convertDiscordContent(rawContent, discordMsg.Mentions, opts)
+
+    // Custom emoji reactions that can't be SimpleX reactions are kept as text
+    if len(customEmojiText) > 0 {
+        if content != "" {
+            content += "\n"
+        }
+        content += strings.Join(customEmojiText, " ")
+    }
+
+    // SimpleX shows the link from the text, so make sure the embedded URL is part of it
+    if linkPreview != nil && !strings.Contains(content, linkPreview.URL) {
+        if content != "" {
+            content += "\n"
+        }
+        content += linkPreview.URL
+    }
+
+    // Determine display name (prefer nickname, fallback to name)
+    displayName := discordMsg.Author.Nickname
+    if displayName == "" {
+        displayName = discordMsg.Author.Name
+    }
+
+    // Check if this message was sent by the specified user
+    isSent := discordMsg.Author.Name == myUsername
+
+    return universal.Message{
+        ID:            discordMsg.ID,
+        Content:       content,
+        Timestamp:     timestamp,
+        EditedAt:      editedAt,
+        MessageType:   messageType,
+        Attachments:   attachments,
+        Platform:      "discord",
+        QuotedMessage: quotedMessage,
+        ForwardedFrom: forwardedFrom,
+        LinkPreview:   linkPreview,
+        Author: universal.Author{
+            ID:          discordMsg.Author.ID,
+            Username:    discordMsg.Author.Name,
+            DisplayName: displayName,
+            AvatarURL:   &discordMsg.Author.AvatarURL,
+            IsBot:       discordMsg.Author.IsBot,
+            // DiscordChatExporter writes webhook authors as bots with discriminator 0000
+            IsWebhook:   discordMsg.Author.IsBot && discordMsg.Author.Discriminator == "0000",
+            PlatformData: map[string]interface{}{
+                "discriminator": discordMsg.Author.Discriminator,
+                "roles":        discordMsg.Author.Roles,
+                "color":        discordMsg.Author.Color,
+            },
+        },
+        Mentions:  mentions,
+        Reactions: reactions,
+        ReplyToID: replyToID,
+        IsPinned:  discordMsg.IsPinned,
+        IsSent:    isSent,
+        IsDeleted: discordMsg.IsDeleted,
+        DeletedAt: deletedAt,
+        PlatformData: map[string]interface{}{
+            "embeds":       discordMsg.Embeds,
+            "stickers":     discordMsg.Stickers,
+            "inlineEmojis": discordMsg.InlineEmojis,
+            "reference":    discordMsg.Reference,
+        },
+    }
+}
+
+// Convert a whole export, oldest message first. Replies are resolved against the other messages
+// of the export, and channel and role names are collected from it unless opts already has them.
+// Returns the converted messages and how many deleted messages were skipped
+func ConvertExport(export *Export, myUsername, jsonDir string, opts ConvertOptions) ([]universal.Message, int) {
+    messages := make([]universal.Message, 0, len(export.Messages))
+    skippedDeleted := convertEach(export, myUsername, jsonDir, opts, func(msg universal.Message) bool {
+        messages = append(messages, msg)
+        return true
+    })
+    return messages, skippedDeleted
+}
+
+// Convert an export like ConvertExport, handing each message to emit as soon as it's converted
+// instead of collecting them; emit returns false to stop. Returns how many deleted messages were
+// skipped
+func convertEach(export *Export, myUsername, jsonDir string, opts ConvertOptions, emit func(universal.Message) bool) int {
+    if opts.ChannelNames == nil && opts.RoleNames == nil {
+        opts.ChannelNames, opts.RoleNames = CollectNames(export)
+    }
+
+    // First pass: map every Discord message ID to the message in the export (not a copy of it)
+    // for quoting
+    discordMessages := make(map[string]*Message, len(export.Messages))
+    for i := range export.Messages {
+        discordMessages[export.Messages[i].ID] = &export.Messages[i]
+    }
+
+    // Second pass: convert with replies pointing at those IDs
+    skippedDeleted := 0
+    for _, discordMsg := range export.Messages {
+        if discordMsg.IsDeleted && !opts.ImportDeleted {
+            skippedDeleted++
+            continue
+        }
+        if !emit(ConvertMessage(discordMsg, myUsername, discordMessages, jsonDir, opts)) {
+            break
+        }
+    }
+    return skippedDeleted
+}
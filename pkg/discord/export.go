@@ -0,0 +1,315 @@
+// Package discord reads DiscordChatExporter exports and channel history from the Discord API
+// and converts them to universal messages.
+package discord
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "reflect"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// Discord JSON export structure
+type Export struct {
+    Channel  struct {
+        ID   string `json:"id"`
+        Name string `json:"name"`
+    } `json:"channel"`
+    Messages []Message        `json:"messages"`
+
+    // Messages LoadExport left out or couldn't read in full
+    ParseErrors []universal.ParseError `json:"-"`
+}
+
+// Updated Discord message structures to match the JSON format
+type Message struct {
+    ID                   string            `json:"id"`
+    Type                 string            `json:"type"`
+    Timestamp            string            `json:"timestamp"`
+    TimestampEdited      *string           `json:"timestampEdited"`
+    CallEndedTimestamp   *string           `json:"callEndedTimestamp"`
+    IsPinned             bool              `json:"isPinned"`
+    // Set by export tools that keep deleted messages (not DiscordChatExporter)
+    IsDeleted            bool              `json:"isDeleted"`
+    TimestampDeleted     *string           `json:"timestampDeleted"`
+    Content              string            `json:"content"`
+    Author               Author            `json:"author"`
+    Attachments          []Attachment      `json:"attachments"`
+    Embeds               []Embed           `json:"embeds"`
+    Stickers             []interface{}     `json:"stickers"`
+    Reactions            []Reaction        `json:"reactions"`
+    Mentions             []Mention         `json:"mentions"`
+    Reference            *Reference        `json:"reference,omitempty"`
+    InlineEmojis         []interface{}     `json:"inlineEmojis"`
+
+    // What was wrong with the message and its JSON, when something was
+    problem string
+    raw     json.RawMessage
+}
+
+// Decode a message as far as it goes. A field of the wrong type (say an object where a string
+// belongs) is left empty instead of failing the whole export, and the problem is kept for
+// LoadExport to report
+func (m *Message) UnmarshalJSON(data []byte) error {
+    type message Message
+    err := json.Unmarshal(data, (*message)(m))
+    var typeErr *json.UnmarshalTypeError
+    if errors.As(err, &typeErr) {
+        if typeErr.Field == "" {
+            m.problem = fmt.Sprintf("message is %s instead of an object", jsonKind(typeErr.Value))
+        } else {
+            m.problem = fmt.Sprintf("%s is %s instead of %s", typeErr.Field, jsonKind(typeErr.Value), goKind(typeErr.Type))
+        }
+        err = nil
+    }
+    if err == nil && (m.problem != "" || m.ID == "") {
+        m.raw = append(json.RawMessage(nil), data...)
+    }
+    return err
+}
+
+// JSON value kind as UnmarshalTypeError names it, with an article
+func jsonKind(value string) string {
+    switch value {
+    case "object", "array", "number":
+        return "an " + strings.Replace(value, "array", "list", 1)
+    }
+    if strings.HasPrefix(value, "number") {
+        return "a number"
+    }
+    return "a " + value
+}
+
+// JSON kind a Go type is decoded from, with an article
+func goKind(t reflect.Type) string {
+    switch t.Kind() {
+    case reflect.Struct, reflect.Map:
+        return "an object"
+    case reflect.Slice, reflect.Array:
+        return "a list"
+    case reflect.String:
+        return "a string"
+    case reflect.Bool:
+        return "a boolean"
+    }
+    return "a number"
+}
+
+// Attached file; URL is relative to the export or a remote URL
+type Attachment struct {
+    ID            string     `json:"id"`
+    URL           string     `json:"url"`
+    FileName      string     `json:"fileName"`
+    FileSizeBytes LenientInt `json:"fileSizeBytes"`
+}
+
+// Message author as written by DiscordChatExporter
+type Author struct {
+    ID           string      `json:"id"`
+    Name         string      `json:"name"`
+    Discriminator string     `json:"discriminator"`
+    Nickname     string      `json:"nickname"`
+    Color        interface{} `json:"color"`
+    IsBot        bool        `json:"isBot"`
+    Roles        []Role        `json:"roles"`
+    AvatarURL    string      `json:"avatarUrl"`
+}
+
+// Server role of an author
+type Role struct {
+    ID       string      `json:"id"`
+    Name     string      `json:"name"`
+    Color    interface{} `json:"color"`
+    Position int         `json:"position"`
+}
+
+// User mentioned in a message
+type Mention struct {
+    ID           string      `json:"id"`
+    Name         string      `json:"name"`
+    Discriminator string     `json:"discriminator"`
+    Nickname     string      `json:"nickname"`
+    Color        interface{} `json:"color"`
+    IsBot        bool        `json:"isBot"`
+    Roles        []Role        `json:"roles"`
+    AvatarURL    string      `json:"avatarUrl"`
+}
+
+// Message a reply points to, or the original of a forwarded or cross-posted message. Type says
+// which when the export has it; without it a reference on a message of type "Default" is taken
+// for a forward or cross-post, since Discord types replies "Reply"
+type Reference struct {
+    MessageID string        `json:"messageId"`
+    ChannelID string        `json:"channelId"`
+    GuildID   interface{}   `json:"guildId"`
+    Type      ReferenceType `json:"type,omitempty"`
+}
+
+// Kinds of reference; empty when the export doesn't say
+type ReferenceType string
+
+const (
+    ReferenceDefault   ReferenceType = "Default"   // A reply, pin notice and the like
+    ReferenceForward   ReferenceType = "Forward"   // The original of a forwarded message
+    ReferenceCrosspost ReferenceType = "Crosspost" // The original of a message cross-posted from a followed channel
+)
+
+// Decode the kind by name in any case, or as Discord's number for it (0 default, 1 forward)
+func (t *ReferenceType) UnmarshalJSON(data []byte) error {
+    var value interface{}
+    if err := json.Unmarshal(data, &value); err != nil {
+        return err
+    }
+    *t = ""
+    switch v := value.(type) {
+    case float64:
+        switch v {
+        case 0:
+            *t = ReferenceDefault
+        case 1:
+            *t = ReferenceForward
+        }
+    case string:
+        for _, known := range []ReferenceType{ReferenceDefault, ReferenceForward, ReferenceCrosspost} {
+            if strings.EqualFold(strings.TrimSpace(v), string(known)) {
+                *t = known
+            }
+        }
+    }
+    return nil
+}
+
+// Whether the reference is to the original of a forwarded or cross-posted message rather than
+// a message replied to; messageType is the type of the message it's on
+func (r *Reference) forwarded(messageType string) bool {
+    if r.Type != "" {
+        return r.Type == ReferenceForward || r.Type == ReferenceCrosspost
+    }
+    return messageType == "Default"
+}
+
+// Link embed; only the fields used for link previews
+type Embed struct {
+    Title       string              `json:"title"`
+    URL         string              `json:"url"`
+    Description string              `json:"description"`
+    Thumbnail   *EmbedImage         `json:"thumbnail"`
+    Images      []EmbedImage        `json:"images"`
+}
+
+// Image of an embed
+type EmbedImage struct {
+    URL    string `json:"url"`
+    Width  int    `json:"width"`
+    Height int    `json:"height"`
+}
+
+// Reaction with the users who reacted
+type Reaction struct {
+    Emoji Emoji            `json:"emoji"`
+    Count LenientInt       `json:"count"`
+    Users []Author         `json:"users"`
+}
+
+// Reaction emoji; custom emoji have an ID and image URL
+type Emoji struct {
+    ID         string `json:"id"`
+    Name       string `json:"name"`
+    Code       string `json:"code"`
+    IsAnimated bool   `json:"isAnimated"`
+    ImageURL   string `json:"imageUrl"`
+}
+
+// Integer that some exports write as a string or null; anything but a number or a numeric
+// string decodes to 0
+type LenientInt int64
+
+func (n *LenientInt) UnmarshalJSON(data []byte) error {
+    var value interface{}
+    if err := json.Unmarshal(data, &value); err != nil {
+        return err
+    }
+    *n = 0
+    switch v := value.(type) {
+    case float64:
+        *n = LenientInt(v)
+    case string:
+        if parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+            *n = LenientInt(parsed)
+        }
+    }
+    return nil
+}
+
+// Collect channel and role names from export metadata for resolving mention tokens
+func CollectNames(export *Export) (map[string]string, map[string]string) {
+    channelNames := make(map[string]string)
+    roleNames := make(map[string]string)
+
+    if export.Channel.ID != "" {
+        channelNames[export.Channel.ID] = export.Channel.Name
+    }
+
+    addRoles := func(roles []Role) {
+        for _, role := range roles {
+            if role.ID != "" && role.Name != "" {
+                roleNames[role.ID] = role.Name
+            }
+        }
+    }
+    for _, msg := range export.Messages {
+        addRoles(msg.Author.Roles)
+        for _, mention := range msg.Mentions {
+            addRoles(mention.Roles)
+        }
+    }
+
+    return channelNames, roleNames
+}
+
+// Read a DiscordChatExporter JSON export
+func LoadExport(filePath string) (*Export, error) {
+    data, err := os.ReadFile(filePath)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read file: %w", err)
+    }
+
+    var export Export
+    err = json.Unmarshal(data, &export)
+    if err != nil {
+        return nil, fmt.Errorf("failed to parse JSON: %w", err)
+    }
+
+    // A message without an ID can't be told apart from the others or replied to, and one without
+    // a time can't be put in order. Others with a problem are kept without what was wrong
+    messages := export.Messages[:0]
+    for i, msg := range export.Messages {
+        parseError := universal.ParseError{Index: i, ID: msg.ID, Reason: msg.problem, Raw: msg.raw}
+        if msg.ID == "" {
+            if parseError.Reason == "" {
+                parseError.Reason = "message has no ID"
+            }
+            parseError.Skipped = true
+        } else if _, err := universal.ParseTimestamp(msg.Timestamp, time.UTC); err != nil {
+            parseError.Reason, parseError.Skipped = fmt.Sprintf("invalid timestamp '%s'", msg.Timestamp), true
+            if parseError.Raw == nil {
+                parseError.Raw, _ = json.Marshal(msg)
+            }
+        }
+        if parseError.Reason != "" {
+            export.ParseErrors = append(export.ParseErrors, parseError)
+        }
+        if !parseError.Skipped {
+            messages = append(messages, msg)
+        }
+    }
+    export.Messages = messages
+
+    return &export, nil
+}
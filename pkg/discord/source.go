@@ -0,0 +1,92 @@
+package discord
+
+import (
+    "context"
+    "fmt"
+    "path/filepath"
+
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+func init() {
+    universal.RegisterSource("discord", NewSource)
+}
+
+// A DiscordChatExporter export (or channel history fetched from the API) as a universal.Source
+type Source struct {
+    Export     *Export
+    MyUsername string
+    BaseDir    string
+    Options    ConvertOptions
+}
+
+// Source for the "discord" platform. cfg.Path is a DiscordChatExporter JSON export; with the
+// "channel" option the history is fetched from the Discord API instead, using the "token" option
+// ("user-token" set to "true" for user account tokens). "custom-emoji", "pinned" and
+// "missing-replies" take the CustomEmoji*, Pinned* and MissingReplies* modes
+func NewSource(ctx context.Context, cfg universal.SourceConfig) (universal.Source, error) {
+    var export *Export
+    var err error
+    if channelID := cfg.Options["channel"]; channelID != "" {
+        if cfg.Options["token"] == "" {
+            return nil, fmt.Errorf("fetching a Discord channel needs a token")
+        }
+        fmt.Printf("Fetching history of Discord channel %s...\n", channelID)
+        export, err = FetchExport(ctx, NewAPIClient(cfg.Options["token"], cfg.Options["user-token"] == "true"), channelID)
+        if err != nil {
+            return nil, fmt.Errorf("failed to fetch Discord history: %w", err)
+        }
+    } else {
+        fmt.Printf("Loading Discord export from: %s\n", cfg.Path)
+        export, err = LoadExport(cfg.Path)
+        if err != nil {
+            return nil, fmt.Errorf("failed to load Discord export: %w", err)
+        }
+    }
+
+    customEmojiMode := cfg.Options["custom-emoji"]
+    if customEmojiMode == "" {
+        customEmojiMode = CustomEmojiText
+    }
+
+    return &Source{
+        Export:     export,
+        MyUsername: cfg.MyUsername,
+        BaseDir:    filepath.Dir(cfg.Path),
+        Options: ConvertOptions{
+            Location:        cfg.Location,
+            CustomEmojiMode: customEmojiMode,
+            PinnedMode:      cfg.Options["pinned"],
+            MissingReplies:  cfg.Options["missing-replies"],
+            ImportDeleted:   true,
+        },
+    }, nil
+}
+
+func (s *Source) Info() universal.SourceInfo {
+    return universal.SourceInfo{
+        Platform:     "discord",
+        ChatName:     s.Export.Channel.Name,
+        MessageCount: len(s.Export.Messages),
+        BaseDir:      s.BaseDir,
+        ParseErrors:  s.Export.ParseErrors,
+    }
+}
+
+// Messages are converted as they're taken from the channel instead of all up front, so a reader
+// that spills them to disk never has all of them in memory
+func (s *Source) Parse(ctx context.Context) (<-chan universal.Message, error) {
+    stream := make(chan universal.Message)
+    go func() {
+        defer close(stream)
+        convertEach(s.Export, s.MyUsername, s.BaseDir, s.Options, func(msg universal.Message) bool {
+            select {
+            case stream <- msg:
+                return true
+            case <-ctx.Done():
+                return false
+            }
+        })
+    }()
+    return stream, nil
+}
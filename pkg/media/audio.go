@@ -0,0 +1,222 @@
+package media
+
+import (
+    "bytes"
+    "context"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "log"
+    "math"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+
+    "github.com/ritiek/discord-to-simplex/pkg/progress"
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// Get a voice message's duration in seconds, using ffprobe when installed and
+// parsing the audio container natively otherwise
+func AudioDuration(audioPath string) (int, error) {
+    if _, err := exec.LookPath("ffprobe"); err == nil {
+        if duration, err := ffprobeDuration(audioPath); err == nil && duration > 0 {
+            return int(math.Round(duration)), nil
+        }
+    }
+
+    file, err := os.Open(audioPath)
+    if err != nil {
+        return 0, err
+    }
+    defer file.Close()
+
+    header := make([]byte, 12)
+    if _, err := io.ReadFull(file, header); err != nil {
+        return 0, fmt.Errorf("failed to read header: %w", err)
+    }
+    if _, err := file.Seek(0, io.SeekStart); err != nil {
+        return 0, err
+    }
+
+    var seconds float64
+    switch {
+    case string(header[:4]) == "OggS":
+        seconds, err = probeOggDuration(file)
+    case string(header[:4]) == "RIFF" && string(header[8:12]) == "WAVE":
+        seconds, err = probeWAVDuration(file)
+    case string(header[4:8]) == "ftyp":
+        seconds, err = probeMP4Duration(file)
+    case string(header[:3]) == "ID3" || (header[0] == 0xFF && header[1]&0xE0 == 0xE0):
+        seconds, err = probeMP3Duration(file)
+    default:
+        return 0, fmt.Errorf("unsupported audio format")
+    }
+    if err != nil {
+        return 0, err
+    }
+    return int(math.Round(seconds)), nil
+}
+
+// Ogg duration is the granule position of the last page divided by the sample rate,
+// which is fixed at 48kHz for Opus and stored in the identification header for Vorbis
+func probeOggDuration(file *os.File) (float64, error) {
+    data, err := io.ReadAll(file)
+    if err != nil {
+        return 0, err
+    }
+
+    var sampleRate float64
+    var preSkip float64
+    if index := bytes.Index(data, []byte("OpusHead")); index >= 0 && index+12 <= len(data) {
+        sampleRate = 48000
+        preSkip = float64(binary.LittleEndian.Uint16(data[index+10 : index+12]))
+    } else if index := bytes.Index(data, []byte("\x01vorbis")); index >= 0 && index+16 <= len(data) {
+        sampleRate = float64(binary.LittleEndian.Uint32(data[index+12 : index+16]))
+    }
+    if sampleRate == 0 {
+        return 0, fmt.Errorf("unknown Ogg codec")
+    }
+
+    lastPage := bytes.LastIndex(data, []byte("OggS"))
+    if lastPage < 0 || lastPage+14 > len(data) {
+        return 0, fmt.Errorf("no Ogg page found")
+    }
+    granule := float64(binary.LittleEndian.Uint64(data[lastPage+6 : lastPage+14]))
+    return math.Max(0, granule-preSkip) / sampleRate, nil
+}
+
+// WAV duration is the data chunk size divided by the byte rate from the fmt chunk
+func probeWAVDuration(file *os.File) (float64, error) {
+    if _, err := file.Seek(12, io.SeekStart); err != nil {
+        return 0, err
+    }
+
+    var byteRate uint32
+    for {
+        var chunkHeader [8]byte
+        if _, err := io.ReadFull(file, chunkHeader[:]); err != nil {
+            return 0, fmt.Errorf("no data chunk found: %w", err)
+        }
+        chunkSize := binary.LittleEndian.Uint32(chunkHeader[4:8])
+        switch string(chunkHeader[:4]) {
+        case "fmt ":
+            fmtChunk := make([]byte, chunkSize)
+            if _, err := io.ReadFull(file, fmtChunk); err != nil {
+                return 0, err
+            }
+            if len(fmtChunk) >= 12 {
+                byteRate = binary.LittleEndian.Uint32(fmtChunk[8:12])
+            }
+        case "data":
+            if byteRate == 0 {
+                return 0, fmt.Errorf("missing fmt chunk")
+            }
+            return float64(chunkSize) / float64(byteRate), nil
+        default:
+            // Chunks are padded to an even size
+            if _, err := file.Seek(int64(chunkSize+chunkSize%2), io.SeekCurrent); err != nil {
+                return 0, err
+            }
+        }
+    }
+}
+
+// Estimate MP3 duration from the file size and the bitrate of the first frame,
+// which is exact for constant bitrate files
+func probeMP3Duration(file *os.File) (float64, error) {
+    info, err := file.Stat()
+    if err != nil {
+        return 0, err
+    }
+
+    header := make([]byte, 10)
+    if _, err := io.ReadFull(file, header); err != nil {
+        return 0, err
+    }
+    audioStart := int64(0)
+    if string(header[:3]) == "ID3" {
+        // Skip the ID3v2 tag, its size is a 28-bit syncsafe integer
+        tagSize := int64(header[6])<<21 | int64(header[7])<<14 | int64(header[8])<<7 | int64(header[9])
+        audioStart = 10 + tagSize
+    }
+
+    frameHeader := make([]byte, 4)
+    if _, err := file.ReadAt(frameHeader, audioStart); err != nil {
+        return 0, fmt.Errorf("failed to read MP3 frame header: %w", err)
+    }
+    if frameHeader[0] != 0xFF || frameHeader[1]&0xE0 != 0xE0 {
+        return 0, fmt.Errorf("no MP3 frame sync found")
+    }
+
+    // Bitrates in kbit/s for MPEG-1 and MPEG-2/2.5 Layer III
+    mpeg1Bitrates := []int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}
+    mpeg2Bitrates := []int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0}
+    bitrateIndex := frameHeader[2] >> 4
+    bitrate := mpeg2Bitrates[bitrateIndex]
+    if (frameHeader[1]>>3)&0x03 == 0x03 {
+        bitrate = mpeg1Bitrates[bitrateIndex]
+    }
+    if bitrate == 0 {
+        return 0, fmt.Errorf("unsupported MP3 bitrate")
+    }
+
+    return float64(info.Size()-audioStart) * 8 / float64(bitrate*1000), nil
+}
+
+// Voice formats that play on every SimpleX client without transcoding
+func isPortableAudio(filename string) bool {
+    ext := strings.ToLower(filepath.Ext(filename))
+    return ext == ".m4a" || ext == ".aac"
+}
+
+// Transcode voice attachments in other formats (ogg/opus, wav, mp3) to AAC in an m4a
+// container with ffmpeg, so they play on iOS and Android clients. Transcoded files are
+// written to outputDir and the attachments are pointed at them. bar (may be nil) counts the
+// messages gone through.
+func TranscodeVoiceAttachments(ctx context.Context, messages []universal.Message, jsonDir, outputDir string, bar *progress.Bar) (int, error) {
+    if _, err := exec.LookPath("ffmpeg"); err != nil {
+        return 0, fmt.Errorf("ffmpeg is required for audio transcoding: %w", err)
+    }
+
+    transcoded := 0
+    for i := range messages {
+        bar.Add(1)
+        if messages[i].MessageType != "voice" {
+            continue
+        }
+        for j, attachment := range messages[i].Attachments {
+            if isPortableAudio(attachment.Filename) {
+                continue
+            }
+
+            sourcePath := universal.ResolveExportPath(jsonDir, attachment.URL)
+            baseName := strings.TrimSuffix(attachment.Filename, filepath.Ext(attachment.Filename))
+            outputPath := filepath.Join(outputDir, fmt.Sprintf("%s_%d.m4a", baseName, transcoded))
+
+            cmd := exec.CommandContext(ctx, "ffmpeg", "-i", sourcePath, "-vn", "-c:a", "aac", "-b:a", "64k", outputPath, "-y")
+            cmd.Stderr = nil // Suppress ffmpeg output
+            if err := cmd.Run(); err != nil {
+                if ctx.Err() != nil {
+                    return transcoded, ctx.Err()
+                }
+                log.Printf("Warning: failed to transcode %s, keeping the original: %v", attachment.Filename, err)
+                continue
+            }
+
+            info, err := os.Stat(outputPath)
+            if err != nil {
+                log.Printf("Warning: failed to read transcoded %s, keeping the original: %v", attachment.Filename, err)
+                continue
+            }
+
+            messages[i].Attachments[j].URL = outputPath
+            messages[i].Attachments[j].Filename = baseName + ".m4a"
+            messages[i].Attachments[j].MimeType = "audio/mp4"
+            messages[i].Attachments[j].Size = info.Size()
+            transcoded++
+        }
+    }
+    return transcoded, nil
+}
@@ -0,0 +1,59 @@
+package media
+
+import (
+    "context"
+    "fmt"
+    "image"
+    "image/draw"
+    "net/http"
+    "os"
+    "time"
+
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// Largest profile image SimpleX apps set, as the base64 data URI stored in contact_profiles
+const maxProfileImageSize = 12500
+
+// Profile image for a contact from an author's avatar: a path in the export (jsonDir) or a URL
+// on the Discord CDN, which is downloaded into cacheDir like remote attachments
+func ContactAvatar(ctx context.Context, avatarURL, jsonDir, cacheDir string) (string, error) {
+    path := universal.ResolveExportPath(jsonDir, avatarURL)
+    if universal.IsRemoteURL(avatarURL) {
+        path = attachmentCachePath(cacheDir, "", avatarURL)
+        client := &http.Client{Timeout: time.Minute}
+        if _, err := downloadToCache(ctx, client, avatarURL, path); err != nil {
+            return "", err
+        }
+    }
+    return ProfileImage(path)
+}
+
+// Profile image from an image file: cropped to a square around its middle, like the apps crop
+// the images they set, downscaled and encoded as a base64 JPEG within SimpleX's size limit
+func ProfileImage(imagePath string) (string, error) {
+    imageFile, err := os.Open(imagePath)
+    if err != nil {
+        return "", fmt.Errorf("failed to read image file %s: %w", imagePath, err)
+    }
+    defer imageFile.Close()
+
+    img, _, err := image.Decode(imageFile)
+    if err != nil {
+        return "", fmt.Errorf("failed to decode image %s: %w", imagePath, err)
+    }
+
+    bounds := img.Bounds()
+    side := min(bounds.Dx(), bounds.Dy())
+    square := image.NewRGBA(image.Rect(0, 0, side, side))
+    offset := image.Pt(bounds.Min.X+(bounds.Dx()-side)/2, bounds.Min.Y+(bounds.Dy()-side)/2)
+    // JPEG has no transparency, transparent avatars get a white background
+    draw.Draw(square, square.Bounds(), image.White, image.Point{}, draw.Src)
+    draw.Draw(square, square.Bounds(), img, offset, draw.Over)
+
+    profileImage, err := fitJPEG(square, []int{192, 160, 128, 96, 64}, maxProfileImageSize)
+    if err != nil {
+        return "", fmt.Errorf("failed to encode profile image for %s: %w", imagePath, err)
+    }
+    return profileImage, nil
+}
@@ -0,0 +1,139 @@
+package media
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "net/url"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "github.com/ritiek/discord-to-simplex/pkg/progress"
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// Default location of the persistent attachment download cache
+func DefaultCacheDir() string {
+    cacheDir, err := os.UserCacheDir()
+    if err != nil {
+        return filepath.Join(os.TempDir(), "discord-to-simplex-cache")
+    }
+    return filepath.Join(cacheDir, "discord-to-simplex", "attachments")
+}
+
+// Cache file path for a remote URL, keyed by attachment ID when known. The query string
+// is ignored because Discord signs CDN URLs differently on every export.
+func attachmentCachePath(cacheDir, attachmentID, rawURL string) string {
+    parsedURL, err := url.Parse(rawURL)
+    urlPath := rawURL
+    if err == nil {
+        urlPath = parsedURL.Host + parsedURL.Path
+    }
+
+    key := attachmentID
+    if key == "" {
+        hash := sha256.Sum256([]byte(urlPath))
+        key = hex.EncodeToString(hash[:16])
+    }
+    return filepath.Join(cacheDir, key+strings.ToLower(filepath.Ext(urlPath)))
+}
+
+// Download a remote file into the cache unless it's already there. Partial downloads are
+// kept as .part files and resumed with a Range request on the next run.
+func downloadToCache(ctx context.Context, client *http.Client, rawURL, cachePath string) (bool, error) {
+    if _, err := os.Stat(cachePath); err == nil {
+        return true, nil
+    }
+
+    if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+        return false, fmt.Errorf("failed to create cache directory: %w", err)
+    }
+
+    partPath := cachePath + ".part"
+    var offset int64
+    if info, err := os.Stat(partPath); err == nil {
+        offset = info.Size()
+    }
+
+    req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+    if err != nil {
+        return false, fmt.Errorf("failed to create request: %w", err)
+    }
+    if offset > 0 {
+        req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+    }
+
+    resp, err := client.Do(req)
+    if err != nil {
+        return false, fmt.Errorf("failed to download %s: %w", rawURL, err)
+    }
+    defer resp.Body.Close()
+
+    flags := os.O_WRONLY | os.O_CREATE
+    switch resp.StatusCode {
+    case http.StatusPartialContent:
+        flags |= os.O_APPEND
+    case http.StatusOK:
+        // Server ignored the range, start over
+        flags |= os.O_TRUNC
+    default:
+        return false, fmt.Errorf("failed to download %s: %s", rawURL, resp.Status)
+    }
+
+    partFile, err := os.OpenFile(partPath, flags, 0644)
+    if err != nil {
+        return false, fmt.Errorf("failed to open partial download: %w", err)
+    }
+    _, err = io.Copy(partFile, resp.Body)
+    partFile.Close()
+    if err != nil {
+        return false, fmt.Errorf("failed to download %s: %w", rawURL, err)
+    }
+
+    if err := os.Rename(partPath, cachePath); err != nil {
+        return false, fmt.Errorf("failed to finalize download: %w", err)
+    }
+    return false, nil
+}
+
+// Download remote attachments and preview images into the cache and point the
+// messages at the cached copies. bar (may be nil) counts the messages gone through
+func CacheRemoteAttachments(ctx context.Context, messages []universal.Message, cacheDir string, bar *progress.Bar) (int, int) {
+    client := &http.Client{Timeout: 10 * time.Minute}
+    downloaded, cached := 0, 0
+
+    fetch := func(attachmentID, rawURL string) string {
+        cachePath := attachmentCachePath(cacheDir, attachmentID, rawURL)
+        hit, err := downloadToCache(ctx, client, rawURL, cachePath)
+        if err != nil {
+            log.Printf("Warning: %v", err)
+            return rawURL
+        }
+        if hit {
+            cached++
+        } else {
+            downloaded++
+        }
+        return cachePath
+    }
+
+    for i := range messages {
+        bar.Add(1)
+        for j, attachment := range messages[i].Attachments {
+            if universal.IsRemoteURL(attachment.URL) {
+                messages[i].Attachments[j].URL = fetch(attachment.ID, attachment.URL)
+            }
+        }
+        if preview := messages[i].LinkPreview; preview != nil && universal.IsRemoteURL(preview.ImageURL) {
+            preview.ImageURL = fetch("", preview.ImageURL)
+        }
+    }
+
+    return downloaded, cached
+}
@@ -0,0 +1,410 @@
+// Package media prepares attachments for SimpleX: image previews, video thumbnails, durations,
+// format conversion, metadata stripping and downloads of remote attachments.
+package media
+
+import (
+    "bytes"
+    "encoding/base64"
+    "encoding/binary"
+    "fmt"
+    "image"
+    "image/color"
+    _ "image/gif"
+    "image/jpeg"
+    "image/png"
+    "log"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+
+    "github.com/ritiek/discord-to-simplex/pkg/progress"
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+    _ "golang.org/x/image/webp"
+)
+
+// Maximum size of the base64 image preview embedded in msg_body/item_content, in line
+// with the ~14KB previews SimpleX apps generate. The full image only lives in the files directory.
+const maxImagePreviewSize = 14000
+
+// Helper function to read an image and encode a small JPEG preview of it as base64.
+// Decoding uses Go's image packages only (JPEG, PNG, GIF, WebP), so no ffmpeg is needed.
+func ImagePreview(imagePath string) (string, error) {
+    imageFile, err := os.Open(imagePath)
+    if err != nil {
+        return "", fmt.Errorf("failed to read image file %s: %w", imagePath, err)
+    }
+    defer imageFile.Close()
+
+    img, _, err := image.Decode(imageFile)
+    if err != nil {
+        return "", fmt.Errorf("failed to decode image %s: %w", imagePath, err)
+    }
+
+    preview, err := fitJPEG(img, []int{480, 360, 240, 160, 96}, maxImagePreviewSize)
+    if err != nil {
+        return "", fmt.Errorf("failed to encode preview for %s: %w", imagePath, err)
+    }
+    return preview, nil
+}
+
+// Encode img as a base64 JPEG data URI, shrinking it step by step through maxDimensions and
+// lower qualities until it fits budget bytes
+func fitJPEG(img image.Image, maxDimensions []int, budget int) (string, error) {
+    var encoded string
+    for _, maxDimension := range maxDimensions {
+        resized := resizeImage(img, maxDimension)
+        for _, quality := range []int{75, 55, 35} {
+            var buf bytes.Buffer
+            if err := jpeg.Encode(&buf, resized, &jpeg.Options{Quality: quality}); err != nil {
+                return "", err
+            }
+            encoded = "data:image/jpg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+            if len(encoded) <= budget {
+                return encoded, nil
+            }
+        }
+    }
+
+    // Even the smallest attempt is only slightly over budget, use it anyway
+    return encoded, nil
+}
+
+// Downscale an image so that its longest side is at most maxDimension, averaging
+// the source pixels covered by each destination pixel
+func resizeImage(img image.Image, maxDimension int) image.Image {
+    bounds := img.Bounds()
+    srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+    if srcWidth <= maxDimension && srcHeight <= maxDimension {
+        return img
+    }
+
+    dstWidth, dstHeight := maxDimension, maxDimension
+    if srcWidth > srcHeight {
+        dstHeight = max(1, srcHeight*maxDimension/srcWidth)
+    } else {
+        dstWidth = max(1, srcWidth*maxDimension/srcHeight)
+    }
+
+    dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+    for y := 0; y < dstHeight; y++ {
+        y0 := bounds.Min.Y + y*srcHeight/dstHeight
+        y1 := max(y0+1, bounds.Min.Y+(y+1)*srcHeight/dstHeight)
+        for x := 0; x < dstWidth; x++ {
+            x0 := bounds.Min.X + x*srcWidth/dstWidth
+            x1 := max(x0+1, bounds.Min.X+(x+1)*srcWidth/dstWidth)
+
+            var r, g, b, a, n uint64
+            for sy := y0; sy < y1; sy++ {
+                for sx := x0; sx < x1; sx++ {
+                    pr, pg, pb, pa := img.At(sx, sy).RGBA()
+                    r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+                    n++
+                }
+            }
+            dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(b / n), uint16(a / n)})
+        }
+    }
+    return dst
+}
+
+// Image conversion targets for formats some SimpleX platforms render inconsistently
+const (
+    ImageConvertJPEG = "jpeg"
+    ImageConvertPNG  = "png"
+    ImageConvertNone = "none"
+)
+
+// Convert webp/heic/heif image attachments to JPEG or PNG. WebP is decoded natively,
+// HEIC/HEIF needs ffmpeg. Images that can't be converted are imported as plain files.
+// bar (may be nil) counts the messages gone through
+func ConvertImageAttachments(messages []universal.Message, jsonDir, outputDir, format string, bar *progress.Bar) int {
+    targetExt := ".jpg"
+    if format == ImageConvertPNG {
+        targetExt = ".png"
+    }
+
+    converted := 0
+    for i := range messages {
+        bar.Add(1)
+        for j, attachment := range messages[i].Attachments {
+            ext := strings.ToLower(filepath.Ext(attachment.Filename))
+            if ext != ".webp" && ext != ".heic" && ext != ".heif" {
+                continue
+            }
+
+            sourcePath := universal.ResolveExportPath(jsonDir, attachment.URL)
+            baseName := strings.TrimSuffix(attachment.Filename, filepath.Ext(attachment.Filename))
+            outputPath := filepath.Join(outputDir, fmt.Sprintf("%s_%d%s", baseName, converted, targetExt))
+
+            var err error
+            if ext == ".webp" {
+                err = convertImageNatively(sourcePath, outputPath, format)
+            } else {
+                cmd := exec.Command("ffmpeg", "-i", sourcePath, "-frames:v", "1", outputPath, "-y")
+                cmd.Stderr = nil // Suppress ffmpeg output
+                err = cmd.Run()
+            }
+
+            var info os.FileInfo
+            if err == nil {
+                info, err = os.Stat(outputPath)
+            }
+            if err != nil {
+                log.Printf("Warning: failed to convert %s, importing it as a file: %v", attachment.Filename, err)
+                if j == 0 {
+                    messages[i].MessageType = "file"
+                }
+                continue
+            }
+
+            messages[i].Attachments[j].URL = outputPath
+            messages[i].Attachments[j].Filename = baseName + targetExt
+            messages[i].Attachments[j].Size = info.Size()
+            if j == 0 {
+                messages[i].MessageType = "image"
+            }
+            converted++
+        }
+    }
+    return converted
+}
+
+// Re-encode an image decodable by Go's image packages as JPEG or PNG
+func convertImageNatively(sourcePath, outputPath, format string) error {
+    sourceFile, err := os.Open(sourcePath)
+    if err != nil {
+        return err
+    }
+    defer sourceFile.Close()
+
+    img, _, err := image.Decode(sourceFile)
+    if err != nil {
+        return fmt.Errorf("failed to decode image: %w", err)
+    }
+
+    outputFile, err := os.Create(outputPath)
+    if err != nil {
+        return err
+    }
+    defer outputFile.Close()
+
+    if format == ImageConvertPNG {
+        return png.Encode(outputFile, img)
+    }
+    return jpeg.Encode(outputFile, img, &jpeg.Options{Quality: 90})
+}
+
+// Write copies of JPEG and PNG image attachments without EXIF/GPS and other textual
+// metadata to outputDir and point the attachments at them. bar (may be nil) counts the messages
+// gone through
+func StripImageMetadata(messages []universal.Message, jsonDir, outputDir string, bar *progress.Bar) int {
+    stripped := 0
+    for i := range messages {
+        bar.Add(1)
+        for j, attachment := range messages[i].Attachments {
+            ext := strings.ToLower(filepath.Ext(attachment.Filename))
+            if ext != ".jpg" && ext != ".jpeg" && ext != ".png" {
+                continue
+            }
+
+            sourcePath := universal.ResolveExportPath(jsonDir, attachment.URL)
+            data, err := os.ReadFile(sourcePath)
+            if err != nil {
+                log.Printf("Warning: failed to read %s for metadata stripping: %v", attachment.Filename, err)
+                continue
+            }
+
+            var cleaned []byte
+            if ext == ".png" {
+                cleaned, err = stripPNGMetadata(data)
+            } else {
+                cleaned, err = stripJPEGMetadata(data)
+            }
+            if err != nil {
+                log.Printf("Warning: failed to strip metadata from %s, importing it as is: %v", attachment.Filename, err)
+                continue
+            }
+
+            outputPath := filepath.Join(outputDir, fmt.Sprintf("stripped_%d%s", stripped, ext))
+            if err := os.WriteFile(outputPath, cleaned, 0644); err != nil {
+                log.Printf("Warning: failed to write stripped copy of %s: %v", attachment.Filename, err)
+                continue
+            }
+
+            messages[i].Attachments[j].URL = outputPath
+            messages[i].Attachments[j].Size = int64(len(cleaned))
+            stripped++
+        }
+    }
+    return stripped
+}
+
+// Remove EXIF/XMP (APP1), IPTC (APP13) and comment segments from a JPEG. The EXIF
+// orientation is lost with the metadata, so rotated photos are re-encoded upright.
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+    if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+        return nil, fmt.Errorf("not a JPEG file")
+    }
+
+    if orientation := jpegOrientation(data); orientation > 1 && orientation <= 8 {
+        img, err := jpeg.Decode(bytes.NewReader(data))
+        if err != nil {
+            return nil, fmt.Errorf("failed to decode JPEG: %w", err)
+        }
+        var buf bytes.Buffer
+        if err := jpeg.Encode(&buf, applyOrientation(img, orientation), &jpeg.Options{Quality: 92}); err != nil {
+            return nil, fmt.Errorf("failed to encode JPEG: %w", err)
+        }
+        return buf.Bytes(), nil
+    }
+
+    result := []byte{0xFF, 0xD8}
+    pos := 2
+    for pos+4 <= len(data) {
+        if data[pos] != 0xFF {
+            return nil, fmt.Errorf("invalid JPEG marker at offset %d", pos)
+        }
+        marker := data[pos+1]
+        if marker == 0xDA {
+            // Start of scan: the rest is image data
+            return append(result, data[pos:]...), nil
+        }
+        if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) || marker == 0xFF {
+            // Markers without a length field (and fill bytes)
+            result = append(result, data[pos:pos+2]...)
+            pos += 2
+            continue
+        }
+
+        segmentLength := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+        end := pos + 2 + segmentLength
+        if segmentLength < 2 || end > len(data) {
+            return nil, fmt.Errorf("truncated JPEG segment at offset %d", pos)
+        }
+        if marker != 0xE1 && marker != 0xED && marker != 0xFE {
+            result = append(result, data[pos:end]...)
+        }
+        pos = end
+    }
+    return nil, fmt.Errorf("no image data found")
+}
+
+// Read the EXIF orientation tag of a JPEG, 0 if there is none
+func jpegOrientation(data []byte) int {
+    pos := 2
+    for pos+4 <= len(data) && data[pos] == 0xFF {
+        marker := data[pos+1]
+        if marker == 0xDA {
+            break
+        }
+        segmentLength := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+        end := pos + 2 + segmentLength
+        if segmentLength < 2 || end > len(data) {
+            break
+        }
+        segment := data[pos+4 : end]
+        if marker == 0xE1 && len(segment) > 14 && string(segment[:6]) == "Exif\x00\x00" {
+            return tiffOrientation(segment[6:])
+        }
+        pos = end
+    }
+    return 0
+}
+
+// Find the orientation tag (0x0112) in the first IFD of a TIFF structure
+func tiffOrientation(tiff []byte) int {
+    var order binary.ByteOrder
+    switch string(tiff[:2]) {
+    case "II":
+        order = binary.LittleEndian
+    case "MM":
+        order = binary.BigEndian
+    default:
+        return 0
+    }
+
+    ifdOffset := int(order.Uint32(tiff[4:8]))
+    if ifdOffset+2 > len(tiff) {
+        return 0
+    }
+    entries := int(order.Uint16(tiff[ifdOffset : ifdOffset+2]))
+    for i := 0; i < entries; i++ {
+        entry := ifdOffset + 2 + i*12
+        if entry+12 > len(tiff) {
+            return 0
+        }
+        if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+            return int(order.Uint16(tiff[entry+8 : entry+10]))
+        }
+    }
+    return 0
+}
+
+// Rotate/flip an image according to an EXIF orientation value (2-8)
+func applyOrientation(img image.Image, orientation int) image.Image {
+    bounds := img.Bounds()
+    width, height := bounds.Dx(), bounds.Dy()
+
+    dstWidth, dstHeight := width, height
+    if orientation >= 5 {
+        dstWidth, dstHeight = height, width
+    }
+    dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+
+    for y := 0; y < height; y++ {
+        for x := 0; x < width; x++ {
+            var dx, dy int
+            switch orientation {
+            case 2:
+                dx, dy = width-1-x, y
+            case 3:
+                dx, dy = width-1-x, height-1-y
+            case 4:
+                dx, dy = x, height-1-y
+            case 5:
+                dx, dy = y, x
+            case 6:
+                dx, dy = height-1-y, x
+            case 7:
+                dx, dy = height-1-y, width-1-x
+            case 8:
+                dx, dy = y, width-1-x
+            default:
+                dx, dy = x, y
+            }
+            dst.Set(dx, dy, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+        }
+    }
+    return dst
+}
+
+// Remove EXIF and textual metadata chunks from a PNG
+func stripPNGMetadata(data []byte) ([]byte, error) {
+    signature := []byte("\x89PNG\r\n\x1a\n")
+    if !bytes.HasPrefix(data, signature) {
+        return nil, fmt.Errorf("not a PNG file")
+    }
+
+    result := append([]byte{}, signature...)
+    pos := len(signature)
+    for pos+12 <= len(data) {
+        chunkLength := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+        chunkType := string(data[pos+4 : pos+8])
+        end := pos + 12 + chunkLength
+        if chunkLength < 0 || end > len(data) {
+            return nil, fmt.Errorf("truncated PNG chunk %s", chunkType)
+        }
+        switch chunkType {
+        case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+            // Metadata, drop it
+        default:
+            result = append(result, data[pos:end]...)
+        }
+        pos = end
+        if chunkType == "IEND" {
+            return result, nil
+        }
+    }
+    return nil, fmt.Errorf("missing IEND chunk")
+}
@@ -0,0 +1,168 @@
+package media
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "sync"
+    "sync/atomic"
+)
+
+// Previews and durations made for attachments, keyed by a hash of the file's content, so the same
+// file posted again is only previewed once per run. With a directory they're also kept on disk and
+// reused by later runs. A nil *ResultCache makes everything anew
+type ResultCache struct {
+    dir string // Where results are kept across runs, "" to keep them for this run only
+
+    mu      sync.Mutex
+    results map[string]*cachedResult
+
+    hits atomic.Int64
+}
+
+// Preview and duration of one file, ready once done is closed
+type cachedResult struct {
+    done     chan struct{}
+    Preview  string `json:"preview,omitempty"`
+    Duration int    `json:"duration,omitempty"`
+    err      error
+}
+
+// Cache results for this run, and in dir (created when needed) across runs when it isn't ""
+func NewResultCache(dir string) *ResultCache {
+    return &ResultCache{dir: dir, results: make(map[string]*cachedResult)}
+}
+
+// How many previews and durations were taken from the cache instead of being made
+func (c *ResultCache) Hits() int {
+    if c == nil {
+        return 0
+    }
+    return int(c.hits.Load())
+}
+
+func (c *ResultCache) ImagePreview(imagePath string) (string, error) {
+    result := c.get("image", imagePath, func(result *cachedResult) {
+        result.Preview, result.err = ImagePreview(imagePath)
+    })
+    return result.Preview, result.err
+}
+
+func (c *ResultCache) VideoThumbnail(videoPath string) (string, int, error) {
+    // Without ffmpeg videos get a placeholder, which mustn't stand in for a real thumbnail later
+    kind := "video"
+    if !ffmpegAvailable() {
+        kind = "video-placeholder"
+    }
+    result := c.get(kind, videoPath, func(result *cachedResult) {
+        result.Preview, result.Duration, result.err = VideoThumbnail(videoPath)
+    })
+    return result.Preview, result.Duration, result.err
+}
+
+func (c *ResultCache) AudioDuration(audioPath string) (int, error) {
+    result := c.get("audio", audioPath, func(result *cachedResult) {
+        result.Duration, result.err = AudioDuration(audioPath)
+    })
+    return result.Duration, result.err
+}
+
+// Result of kind for the file at path: the one cached for its content, or what produce makes of
+// it. Files that can't be read for hashing, and results that come with an error, aren't cached
+func (c *ResultCache) get(kind, path string, produce func(*cachedResult)) *cachedResult {
+    if c == nil {
+        result := &cachedResult{}
+        produce(result)
+        return result
+    }
+    hash, err := contentHash(path)
+    if err != nil {
+        result := &cachedResult{}
+        produce(result)
+        return result
+    }
+    key := kind + "-" + hash
+
+    c.mu.Lock()
+    if result, ok := c.results[key]; ok {
+        c.mu.Unlock()
+        <-result.done
+        if result.err == nil {
+            c.hits.Add(1)
+            return result
+        }
+        // Errors aren't reused, they'd name the file that was tried first
+        retry := &cachedResult{}
+        produce(retry)
+        return retry
+    }
+    result := &cachedResult{done: make(chan struct{})}
+    c.results[key] = result
+    c.mu.Unlock()
+    defer close(result.done)
+
+    if c.load(key, result) {
+        c.hits.Add(1)
+        return result
+    }
+    produce(result)
+    if result.err == nil {
+        c.save(key, result)
+    }
+    return result
+}
+
+// Read a result a previous run kept on disk
+func (c *ResultCache) load(key string, result *cachedResult) bool {
+    if c.dir == "" {
+        return false
+    }
+    data, err := os.ReadFile(filepath.Join(c.dir, key+".json"))
+    if err != nil {
+        return false
+    }
+    return json.Unmarshal(data, result) == nil
+}
+
+// Keep a result on disk for later runs; a result that can't be written is only cached for this run
+func (c *ResultCache) save(key string, result *cachedResult) {
+    if c.dir == "" {
+        return
+    }
+    data, err := json.Marshal(result)
+    if err != nil {
+        return
+    }
+    if err := os.MkdirAll(c.dir, 0755); err != nil {
+        return
+    }
+    // Written next to its final name and renamed, so a run that stops halfway leaves no partial file
+    cachePath := filepath.Join(c.dir, key+".json")
+    partPath := fmt.Sprintf("%s.%d.part", cachePath, os.Getpid())
+    if err := os.WriteFile(partPath, data, 0644); err != nil {
+        os.Remove(partPath)
+        return
+    }
+    if err := os.Rename(partPath, cachePath); err != nil {
+        os.Remove(partPath)
+    }
+}
+
+// SHA-256 of a file's content, hex encoded
+func contentHash(path string) (string, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return "", err
+    }
+    defer file.Close()
+
+    hash := sha256.New()
+    if _, err := io.Copy(hash, file); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(hash.Sum(nil)), nil
+}
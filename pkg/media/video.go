@@ -0,0 +1,400 @@
+package media
+
+import (
+    "bytes"
+    "encoding/base64"
+    "encoding/binary"
+    "fmt"
+    "image"
+    "image/color"
+    "image/jpeg"
+    "io"
+    "log"
+    "math"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+
+    "github.com/ritiek/discord-to-simplex/pkg/tempfiles"
+)
+
+// Duration used when a video's real duration can't be determined
+const defaultVideoDuration = 86
+
+// Thumbnails made so far, numbering the temporary frame files so videos previewed at the same
+// time by the media workers don't write over each other's
+var thumbnailCount atomic.Int64
+
+// Warn only once per run when falling back to placeholder video previews
+var ffmpegMissingWarning sync.Once
+
+// Check whether the ffmpeg tools needed for real video thumbnails are installed
+func ffmpegAvailable() bool {
+    if _, err := exec.LookPath("ffmpeg"); err != nil {
+        return false
+    }
+    if _, err := exec.LookPath("ffprobe"); err != nil {
+        return false
+    }
+    return true
+}
+
+// Function to generate video thumbnail using ffmpeg and get video duration
+func VideoThumbnail(videoPath string) (string, int, error) {
+    if !ffmpegAvailable() {
+        ffmpegMissingWarning.Do(func() {
+            log.Printf("Warning: ffmpeg/ffprobe not found, videos get a placeholder preview instead of a thumbnail")
+        })
+        return generateVideoPlaceholder(videoPath)
+    }
+
+    // Create temporary directory for thumbnail
+    tempDir := filepath.Join(tempfiles.Root, "video_thumbnails")
+    if tempfiles.Root == "" {
+        tempDir = filepath.Join(os.TempDir(), "video_thumbnails")
+    }
+    if err := os.MkdirAll(tempDir, 0755); err != nil {
+        return "", 0, fmt.Errorf("failed to create temp directory: %w", err)
+    }
+
+    // Generate unique thumbnail filename
+    thumbnailPath := filepath.Join(tempDir, fmt.Sprintf("thumb_%d_%d.jpg", os.Getpid(), thumbnailCount.Add(1)))
+    defer tempfiles.Remove(thumbnailPath)
+
+    // Get video duration first
+    var duration int
+    if durationFloat, err := ffprobeDuration(videoPath); err != nil {
+        return "", 0, fmt.Errorf("failed to get video duration: %w", err)
+    } else if durationFloat > 0 {
+        duration = int(durationFloat)
+    } else {
+        duration = defaultVideoDuration
+    }
+
+    // Use ffmpeg to extract a full-size frame at 1 second mark, scaling happens natively
+    // afterwards so the preview keeps the video's aspect ratio
+    cmd := exec.Command("ffmpeg", "-i", videoPath, "-ss", "00:00:01", "-vframes", "1", "-f", "image2", thumbnailPath, "-y")
+    cmd.Stderr = nil // Suppress ffmpeg output
+
+    if err := cmd.Run(); err != nil {
+        // If ffmpeg fails, try without seeking
+        cmd = exec.Command("ffmpeg", "-i", videoPath, "-vframes", "1", "-f", "image2", thumbnailPath, "-y")
+        cmd.Stderr = nil
+        if err := cmd.Run(); err != nil {
+            return "", 0, fmt.Errorf("failed to generate thumbnail with ffmpeg: %w", err)
+        }
+    }
+
+    // Downscale the extracted frame into a preview
+    thumbnailBase64, err := ImagePreview(thumbnailPath)
+    if err != nil {
+        return "", 0, fmt.Errorf("failed to read thumbnail: %w", err)
+    }
+
+    // Return base64 encoded thumbnail and duration
+    return thumbnailBase64, duration, nil
+}
+
+// Get a media file's duration in seconds using ffprobe (0 if ffprobe reports none)
+func ffprobeDuration(path string) (float64, error) {
+    durationCmd := exec.Command("ffprobe", "-v", "quiet", "-show_entries", "format=duration", "-of", "csv=p=0", path)
+    durationOutput, err := durationCmd.Output()
+    if err != nil {
+        return 0, err
+    }
+    return parseFloat(strings.TrimSpace(string(durationOutput))), nil
+}
+
+// Generate a generic video preview without ffmpeg, with the duration read from the
+// container metadata when the format is known
+func generateVideoPlaceholder(videoPath string) (string, int, error) {
+    duration, err := VideoDuration(videoPath)
+    if err != nil {
+        log.Printf("Warning: failed to read duration of %s: %v", filepath.Base(videoPath), err)
+        duration = defaultVideoDuration
+    }
+
+    // Dark 16:9 frame with a light play triangle in the middle
+    const width, height = 320, 180
+    placeholder := image.NewRGBA(image.Rect(0, 0, width, height))
+    background := color.RGBA{40, 40, 48, 255}
+    foreground := color.RGBA{230, 230, 235, 255}
+    for y := 0; y < height; y++ {
+        for x := 0; x < width; x++ {
+            placeholder.Set(x, y, background)
+        }
+    }
+    const triangleSize = 60
+    left, top := width/2-triangleSize/3, height/2-triangleSize/2
+    for dy := 0; dy < triangleSize; dy++ {
+        // Triangle pointing right: widest in the middle row
+        rowWidth := triangleSize/2 - abs(dy-triangleSize/2)
+        for dx := 0; dx < rowWidth; dx++ {
+            placeholder.Set(left+dx, top+dy, foreground)
+        }
+    }
+
+    var buf bytes.Buffer
+    if err := jpeg.Encode(&buf, placeholder, &jpeg.Options{Quality: 75}); err != nil {
+        return "", 0, fmt.Errorf("failed to encode placeholder preview: %w", err)
+    }
+    return "data:image/jpg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), duration, nil
+}
+
+func abs(x int) int {
+    if x < 0 {
+        return -x
+    }
+    return x
+}
+
+// Read a video's duration in seconds from its container metadata (MP4/MOV, WebM/MKV, AVI)
+func VideoDuration(videoPath string) (int, error) {
+    file, err := os.Open(videoPath)
+    if err != nil {
+        return 0, err
+    }
+    defer file.Close()
+
+    header := make([]byte, 12)
+    if _, err := io.ReadFull(file, header); err != nil {
+        return 0, fmt.Errorf("failed to read header: %w", err)
+    }
+    if _, err := file.Seek(0, io.SeekStart); err != nil {
+        return 0, err
+    }
+
+    var seconds float64
+    switch {
+    case string(header[4:8]) == "ftyp" || string(header[4:8]) == "moov":
+        seconds, err = probeMP4Duration(file)
+    case bytes.Equal(header[:4], []byte{0x1A, 0x45, 0xDF, 0xA3}):
+        seconds, err = probeEBMLDuration(file)
+    case string(header[:4]) == "RIFF" && string(header[8:12]) == "AVI ":
+        seconds, err = probeAVIDuration(file)
+    default:
+        return 0, fmt.Errorf("unsupported container format")
+    }
+    if err != nil {
+        return 0, err
+    }
+    return int(seconds), nil
+}
+
+// Walk ISO base media boxes down to moov/mvhd, which holds the timescale and duration
+func probeMP4Duration(file io.ReadSeeker) (float64, error) {
+    readBox := func() (string, int64, error) {
+        var header [8]byte
+        if _, err := io.ReadFull(file, header[:]); err != nil {
+            return "", 0, err
+        }
+        size := int64(binary.BigEndian.Uint32(header[:4]))
+        boxType := string(header[4:8])
+        headerSize := int64(8)
+        if size == 1 {
+            var largeSize [8]byte
+            if _, err := io.ReadFull(file, largeSize[:]); err != nil {
+                return "", 0, err
+            }
+            size = int64(binary.BigEndian.Uint64(largeSize[:]))
+            headerSize = 16
+        }
+        if size != 0 && size < headerSize {
+            return "", 0, fmt.Errorf("invalid %s box size", boxType)
+        }
+        // Size 0 means the box extends to the end of the file, return -1 for "rest"
+        if size == 0 {
+            return boxType, -1, nil
+        }
+        return boxType, size - headerSize, nil
+    }
+
+    for {
+        boxType, payloadSize, err := readBox()
+        if err != nil {
+            return 0, fmt.Errorf("no mvhd box found: %w", err)
+        }
+        switch boxType {
+        case "moov":
+            // Descend into the movie box
+            continue
+        case "mvhd":
+            var version [4]byte
+            if _, err := io.ReadFull(file, version[:]); err != nil {
+                return 0, err
+            }
+            if version[0] == 1 {
+                var fields [28]byte // creation(8) modification(8) timescale(4) duration(8)
+                if _, err := io.ReadFull(file, fields[:]); err != nil {
+                    return 0, err
+                }
+                timescale := binary.BigEndian.Uint32(fields[16:20])
+                if timescale == 0 {
+                    return 0, fmt.Errorf("invalid timescale")
+                }
+                return float64(binary.BigEndian.Uint64(fields[20:28])) / float64(timescale), nil
+            }
+            var fields [16]byte // creation(4) modification(4) timescale(4) duration(4)
+            if _, err := io.ReadFull(file, fields[:]); err != nil {
+                return 0, err
+            }
+            timescale := binary.BigEndian.Uint32(fields[8:12])
+            if timescale == 0 {
+                return 0, fmt.Errorf("invalid timescale")
+            }
+            return float64(binary.BigEndian.Uint32(fields[12:16])) / float64(timescale), nil
+        default:
+            if payloadSize < 0 {
+                return 0, fmt.Errorf("no mvhd box found")
+            }
+            if _, err := file.Seek(payloadSize, io.SeekCurrent); err != nil {
+                return 0, err
+            }
+        }
+    }
+}
+
+// Read an EBML variable-length integer; with keepMarker the length marker bit stays set (element IDs)
+func readEBMLVint(r io.Reader, keepMarker bool) (uint64, int, error) {
+    var first [1]byte
+    if _, err := io.ReadFull(r, first[:]); err != nil {
+        return 0, 0, err
+    }
+    length := 1
+    for mask := byte(0x80); mask != 0 && first[0]&mask == 0; mask >>= 1 {
+        length++
+    }
+    if length > 8 {
+        return 0, 0, fmt.Errorf("invalid EBML vint")
+    }
+
+    value := uint64(first[0])
+    if !keepMarker {
+        value &= uint64(0xFF >> length)
+    }
+    rest := make([]byte, length-1)
+    if _, err := io.ReadFull(r, rest); err != nil {
+        return 0, 0, err
+    }
+    allOnes := value == uint64(0xFF>>length)
+    for _, b := range rest {
+        value = value<<8 | uint64(b)
+        allOnes = allOnes && b == 0xFF
+    }
+    // A size with all value bits set means "unknown size"
+    if !keepMarker && allOnes {
+        return ^uint64(0), length, nil
+    }
+    return value, length, nil
+}
+
+// Walk EBML elements down to Segment/Info, which holds TimecodeScale and Duration
+func probeEBMLDuration(file io.ReadSeeker) (float64, error) {
+    const (
+        idSegment       = 0x18538067
+        idInfo          = 0x1549A966
+        idTimecodeScale = 0x2AD7B1
+        idDuration      = 0x4489
+    )
+
+    timecodeScale := uint64(1000000) // Default: milliseconds
+    duration := -1.0
+    inInfo := false
+    var infoEnd int64
+
+    for {
+        if inInfo {
+            position, _ := file.Seek(0, io.SeekCurrent)
+            if position >= infoEnd {
+                break
+            }
+        }
+
+        id, _, err := readEBMLVint(file, true)
+        if err != nil {
+            break
+        }
+        size, _, err := readEBMLVint(file, false)
+        if err != nil {
+            break
+        }
+
+        switch id {
+        case idSegment:
+            continue
+        case idInfo:
+            position, _ := file.Seek(0, io.SeekCurrent)
+            inInfo = true
+            infoEnd = position + int64(size)
+            continue
+        case idTimecodeScale:
+            data := make([]byte, size)
+            if _, err := io.ReadFull(file, data); err != nil {
+                return 0, err
+            }
+            timecodeScale = 0
+            for _, b := range data {
+                timecodeScale = timecodeScale<<8 | uint64(b)
+            }
+            continue
+        case idDuration:
+            data := make([]byte, size)
+            if _, err := io.ReadFull(file, data); err != nil {
+                return 0, err
+            }
+            switch size {
+            case 4:
+                duration = float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+            case 8:
+                duration = math.Float64frombits(binary.BigEndian.Uint64(data))
+            default:
+                return 0, fmt.Errorf("invalid Duration element size")
+            }
+            continue
+        }
+
+        if size == ^uint64(0) {
+            return 0, fmt.Errorf("unknown-size element before Info")
+        }
+        // The EBML header and everything else is skipped
+        if _, err := file.Seek(int64(size), io.SeekCurrent); err != nil {
+            return 0, err
+        }
+    }
+
+    if duration < 0 {
+        return 0, fmt.Errorf("no Duration element found")
+    }
+    return duration * float64(timecodeScale) / 1e9, nil
+}
+
+// Read the AVI main.main header (avih), which holds microseconds per frame and the frame count
+func probeAVIDuration(file io.ReadSeeker) (float64, error) {
+    header := make([]byte, 512)
+    n, err := io.ReadFull(file, header)
+    if err != nil && err != io.ErrUnexpectedEOF {
+        return 0, err
+    }
+    header = header[:n]
+
+    index := bytes.Index(header, []byte("avih"))
+    if index < 0 || index+8+20 > len(header) {
+        return 0, fmt.Errorf("no avih header found")
+    }
+    avih := header[index+8:]
+    microSecPerFrame := binary.LittleEndian.Uint32(avih[0:4])
+    totalFrames := binary.LittleEndian.Uint32(avih[16:20])
+    return float64(microSecPerFrame) * float64(totalFrames) / 1e6, nil
+}
+
+// Helper function to parse float from string
+func parseFloat(s string) float64 {
+    if f, err := strconv.ParseFloat(s, 64); err == nil {
+        return f
+    }
+    return 0
+}
@@ -0,0 +1,165 @@
+// Package progress shows how far the long-running steps of an import are: a bar with throughput
+// and ETA that redraws in place on a terminal, or a line every ten seconds when the output is
+// redirected.
+// A nil *Bar ignores every call, so code can take one without requiring it.
+package progress
+
+import (
+    "fmt"
+    "os"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+    "golang.org/x/term"
+)
+
+// Width of the bar itself, between the brackets
+const barWidth = 30
+
+// Progress of one phase, counted in items or bytes
+type Bar struct {
+    mu          sync.Mutex
+    label       string
+    total       int64
+    current     int64
+    bytes       bool
+    start       time.Time
+    lastDraw    time.Time
+    lineLength  int
+    out         *os.File
+    interactive bool
+    finished    bool
+}
+
+func newBar(label string, total int64, bytes bool) *Bar {
+    return &Bar{
+        label:       label,
+        total:       total,
+        bytes:       bytes,
+        start:       time.Now(),
+        lastDraw:    time.Now(),
+        out:         os.Stdout,
+        interactive: term.IsTerminal(int(os.Stdout.Fd())),
+    }
+}
+
+// Bar counting total items (0 when not known up front)
+func New(label string, total int) *Bar {
+    return newBar(label, int64(total), false)
+}
+
+// Bar counting total bytes (0 when not known up front)
+func NewBytes(label string, total int64) *Bar {
+    return newBar(label, total, true)
+}
+
+// Set the total once it's known
+func (b *Bar) SetTotal(total int64) {
+    if b == nil {
+        return
+    }
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.total = total
+    b.draw(false)
+}
+
+// Count n more items or bytes as done
+func (b *Bar) Add(n int64) {
+    if b == nil {
+        return
+    }
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.current += n
+    b.draw(false)
+}
+
+// Count written bytes, so a bar can be put in an io.MultiWriter or io.TeeReader
+func (b *Bar) Write(p []byte) (int, error) {
+    b.Add(int64(len(p)))
+    return len(p), nil
+}
+
+// End the phase, leaving its final state on screen
+func (b *Bar) Finish() {
+    if b == nil {
+        return
+    }
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if b.finished {
+        return
+    }
+    b.finished = true
+    b.draw(true)
+}
+
+func (b *Bar) amount(n int64) string {
+    if b.bytes {
+        return universal.FormatByteSize(n)
+    }
+    return fmt.Sprintf("%d", n)
+}
+
+// Redraw at most ten times a second; called with mu held
+func (b *Bar) draw(final bool) {
+    now := time.Now()
+    elapsed := now.Sub(b.start)
+    percent := int64(-1)
+    if b.total > 0 {
+        percent = b.current * 100 / b.total
+        if percent > 100 {
+            percent = 100
+        }
+    }
+
+    if !b.interactive {
+        // Redirected output gets a line every ten seconds and one at the end
+        if final {
+            fmt.Fprintf(b.out, "%s: %s in %s\n", b.label, b.amount(b.current), elapsed.Round(time.Second))
+        } else if percent >= 0 && now.Sub(b.lastDraw) >= 10*time.Second {
+            b.lastDraw = now
+            fmt.Fprintf(b.out, "%s: %d%% (%s/%s)\n", b.label, percent, b.amount(b.current), b.amount(b.total))
+        }
+        return
+    }
+    if !final && now.Sub(b.lastDraw) < 100*time.Millisecond {
+        return
+    }
+    b.lastDraw = now
+
+    var line strings.Builder
+    line.WriteString(b.label)
+    if percent >= 0 {
+        filled := int(percent * barWidth / 100)
+        fmt.Fprintf(&line, " [%s%s] %3d%% %s/%s", strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled), percent, b.amount(b.current), b.amount(b.total))
+    } else {
+        fmt.Fprintf(&line, " %s", b.amount(b.current))
+    }
+    if seconds := elapsed.Seconds(); seconds > 0 && b.current > 0 {
+        rate := float64(b.current) / seconds
+        fmt.Fprintf(&line, " %s/s", b.amount(int64(rate)))
+        if !final && percent >= 0 && rate > 0 {
+            eta := time.Duration(float64(b.total-b.current) / rate * float64(time.Second))
+            fmt.Fprintf(&line, " ETA %s", eta.Round(time.Second))
+        }
+    }
+    if final {
+        fmt.Fprintf(&line, " in %s", elapsed.Round(time.Second))
+    }
+
+    // Pad over what's left of a longer previous line
+    text := line.String()
+    padding := b.lineLength - len(text)
+    b.lineLength = len(text)
+    if padding < 0 {
+        padding = 0
+    }
+    fmt.Fprintf(b.out, "\r%s%s", text, strings.Repeat(" ", padding))
+    if final {
+        fmt.Fprintln(b.out)
+    }
+}
@@ -0,0 +1,152 @@
+// Package simplexchat sends messages through a running simplex-chat over its WebSocket API.
+package simplexchat
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "strconv"
+
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+    "golang.org/x/net/websocket"
+)
+
+// Client for the simplex-chat WebSocket API (simplex-chat -p <port>)
+type Client struct {
+    conn   *websocket.Conn
+    corrID int
+}
+
+// Connect to a simplex-chat WebSocket API, e.g. ws://localhost:5225
+func Dial(ctx context.Context, wsURL string) (*Client, error) {
+    config, err := websocket.NewConfig(wsURL, "http://localhost/")
+    if err != nil {
+        return nil, fmt.Errorf("invalid simplex-chat address %s: %w", wsURL, err)
+    }
+    conn, err := config.DialContext(ctx)
+    if err != nil {
+        return nil, fmt.Errorf("failed to connect to simplex-chat at %s: %w", wsURL, err)
+    }
+    return &Client{conn: conn}, nil
+}
+
+func (c *Client) Close() error {
+    return c.conn.Close()
+}
+
+// Send a command and wait for its response, skipping events pushed in between
+func (c *Client) Command(cmd string) (map[string]interface{}, error) {
+    c.corrID++
+    corrID := strconv.Itoa(c.corrID)
+    if err := websocket.JSON.Send(c.conn, map[string]string{"corrId": corrID, "cmd": cmd}); err != nil {
+        return nil, fmt.Errorf("failed to send command: %w", err)
+    }
+
+    for {
+        var reply struct {
+            CorrID *string                 `json:"corrId"`
+            Resp   map[string]interface{} `json:"resp"`
+        }
+        if err := websocket.JSON.Receive(c.conn, &reply); err != nil {
+            return nil, fmt.Errorf("failed to read response: %w", err)
+        }
+        if reply.CorrID == nil || *reply.CorrID != corrID {
+            continue
+        }
+
+        resp := reply.Resp
+        // Older versions wrap responses as Either
+        if right, ok := resp["Right"].(map[string]interface{}); ok {
+            resp = right
+        } else if left, ok := resp["Left"]; ok {
+            return nil, fmt.Errorf("command failed: %v", left)
+        }
+
+        respType, _ := resp["type"].(string)
+        if respType == "chatCmdError" || respType == "chatError" {
+            errorJSON, _ := json.Marshal(resp)
+            return nil, fmt.Errorf("command failed: %s", errorJSON)
+        }
+        return resp, nil
+    }
+}
+
+// Find a contact's ID by its local or profile display name, like simplexdb.ContactIDByName
+func (c *Client) ContactID(name string) (int, error) {
+    resp, err := c.Command("/contacts")
+    if err != nil {
+        return 0, err
+    }
+
+    var entries []universal.NamedEntry
+    contacts, _ := resp["contacts"].([]interface{})
+    for _, contact := range contacts {
+        contactMap, _ := contact.(map[string]interface{})
+        id, ok := contactMap["contactId"].(float64)
+        if !ok {
+            continue
+        }
+        localName, _ := contactMap["localDisplayName"].(string)
+        profile, _ := contactMap["profile"].(map[string]interface{})
+        displayName, _ := profile["displayName"].(string)
+        entries = append(entries, universal.NamedEntry{ID: int(id), Names: []string{localName, displayName}})
+    }
+    return universal.MatchName("contact", name, entries)
+}
+
+// Local display name of the contact with this ID
+func (c *Client) ContactName(contactID int) (string, error) {
+    resp, err := c.Command("/contacts")
+    if err != nil {
+        return "", err
+    }
+
+    contacts, _ := resp["contacts"].([]interface{})
+    for _, contact := range contacts {
+        contactMap, _ := contact.(map[string]interface{})
+        if id, ok := contactMap["contactId"].(float64); ok && int(id) == contactID {
+            name, _ := contactMap["localDisplayName"].(string)
+            return name, nil
+        }
+    }
+    return "", fmt.Errorf("no contact with ID %d", contactID)
+}
+
+// Send one composed message to a contact and return the new chat item's ID
+func (c *Client) SendMessage(contactID int, composed map[string]interface{}) (int, error) {
+    composedJSON, err := json.Marshal([]interface{}{composed})
+    if err != nil {
+        return 0, err
+    }
+
+    resp, err := c.Command(fmt.Sprintf("/_send @%d json %s", contactID, composedJSON))
+    if err != nil {
+        return 0, err
+    }
+
+    // newChatItems in current versions, newChatItem in older ones
+    var item interface{}
+    if items, ok := resp["chatItems"].([]interface{}); ok && len(items) > 0 {
+        item = items[0]
+    } else {
+        item = resp["chatItem"]
+    }
+    itemMap, _ := item.(map[string]interface{})
+    chatItem, _ := itemMap["chatItem"].(map[string]interface{})
+    meta, _ := chatItem["meta"].(map[string]interface{})
+    itemID, ok := meta["itemId"].(float64)
+    if !ok {
+        return 0, fmt.Errorf("unexpected response to send: %v", resp["type"])
+    }
+    return int(itemID), nil
+}
+
+// React to one of the contact's chat items with an emoji
+func (c *Client) React(contactID, itemID int, emoji string) error {
+    reaction, err := json.Marshal(map[string]string{"type": "emoji", "emoji": emoji})
+    if err != nil {
+        return err
+    }
+    _, err = c.Command(fmt.Sprintf("/_reaction @%d %d on %s", contactID, itemID, reaction))
+    return err
+}
@@ -0,0 +1,136 @@
+package simplexchat
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "path/filepath"
+    "time"
+
+    "github.com/ritiek/discord-to-simplex/pkg/simplexdb"
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// Text for a replayed message: the API sends everything as us at the current time, so the
+// original time (and author, for the contact's messages) go into the text
+func liveMessageText(msg universal.Message, loc *time.Location) string {
+    timestamp := msg.Timestamp.In(loc).Format("2006-01-02 15:04")
+    prefix := "[" + timestamp + "]"
+    if !msg.IsSent {
+        author := msg.Author.DisplayName
+        if author == "" {
+            author = msg.Author.Username
+        }
+        prefix = "[" + author + ", " + timestamp + "]"
+    }
+    if msg.Content == "" {
+        return prefix
+    }
+    return prefix + " " + msg.Content
+}
+
+// Sends converted messages through a running simplex-chat, remembering which chat item each
+// message became so replies can quote it and later reactions can be added to it
+type LiveSender struct {
+    Client    *Client
+    ContactID int
+    JSONDir   string
+    Location  *time.Location
+    ItemIDs   map[string]int             // source message ID -> replayed chat item ID
+    Reacted   map[string]map[string]bool // source message ID -> emoji already reacted with
+
+    // How reactions with emoji SimpleX doesn't show are sent, simplexdb.ReactionEmojiStrip (the
+    // default) or simplexdb.ReactionEmojiDrop
+    ReactionEmoji string
+}
+
+// Create a sender for one contact; jsonDir and loc work as for the database import
+func NewLiveSender(client *Client, contactID int, jsonDir string, loc *time.Location) *LiveSender {
+    return &LiveSender{
+        Client:    client,
+        ContactID: contactID,
+        JSONDir:   jsonDir,
+        Location:  loc,
+        ItemIDs:   make(map[string]int),
+        Reacted:   make(map[string]map[string]bool),
+    }
+}
+
+// Send one message; replies are sent as real quotes when the quoted message was sent before
+func (s *LiveSender) Send(msg universal.Message) error {
+    textMsg := msg
+    textMsg.Content = liveMessageText(msg, s.Location)
+
+    composed := map[string]interface{}{
+        "msgContent": simplexdb.MsgContent(textMsg, s.JSONDir),
+    }
+    if len(msg.Attachments) > 0 {
+        filePath, err := filepath.Abs(universal.ResolveExportPath(s.JSONDir, msg.Attachments[0].URL))
+        if err != nil {
+            return err
+        }
+        composed["fileSource"] = map[string]interface{}{"filePath": filePath}
+    }
+    if msg.QuotedMessage != nil {
+        if quotedItemID, ok := s.ItemIDs[msg.QuotedMessage.SourceID]; ok {
+            composed["quotedItemId"] = quotedItemID
+        }
+    }
+
+    itemID, err := s.Client.SendMessage(s.ContactID, composed)
+    if err != nil {
+        return fmt.Errorf("failed to send message %s: %w", msg.ID, err)
+    }
+    s.ItemIDs[msg.ID] = itemID
+
+    s.SyncReactions(msg)
+    return nil
+}
+
+// React to a sent message with any emoji it hasn't been reacted with yet. Reactions are sent as
+// our own, once per emoji, since the API can't react on behalf of others
+func (s *LiveSender) SyncReactions(msg universal.Message) {
+    itemID, ok := s.ItemIDs[msg.ID]
+    if !ok {
+        return
+    }
+    if s.Reacted[msg.ID] == nil {
+        s.Reacted[msg.ID] = make(map[string]bool)
+    }
+
+    for _, reaction := range msg.Reactions {
+        emoji, ok := simplexdb.NormalizeEmoji(reaction.Emoji, s.ReactionEmoji)
+        if !ok || s.Reacted[msg.ID][emoji] {
+            continue
+        }
+        s.Reacted[msg.ID][emoji] = true
+        if err := s.Client.React(s.ContactID, itemID, emoji); err != nil {
+            log.Printf("Warning: failed to react with %s to message %s: %v", emoji, msg.ID, err)
+        }
+    }
+}
+
+// Replay converted messages through a running simplex-chat instead of writing to the database
+func ImportMessages(ctx context.Context, client *Client, messages *universal.MessageQueue, contactID int, jsonDir string, loc *time.Location, reactionEmoji string) (int, error) {
+    sender := NewLiveSender(client, contactID, jsonDir, loc)
+    sender.ReactionEmoji = reactionEmoji
+    sent := 0
+
+    err := messages.Each(func(chunk []universal.Message) error {
+        for _, msg := range chunk {
+            if err := ctx.Err(); err != nil {
+                return err
+            }
+            if err := sender.Send(msg); err != nil {
+                return err
+            }
+            sent++
+
+            if sent%50 == 0 {
+                fmt.Printf("Sent %d/%d messages\n", sent, messages.Len())
+            }
+        }
+        return nil
+    })
+    return sent, err
+}
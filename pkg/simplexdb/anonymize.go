@@ -0,0 +1,308 @@
+package simplexdb
+
+import (
+    "bytes"
+    "context"
+    "database/sql"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "image"
+    "image/color"
+    "image/jpeg"
+    "os"
+    "path/filepath"
+
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// 1x1 white JPEG that replaces image previews and profile pictures
+var blankImage = func() string {
+    img := image.NewGray(image.Rect(0, 0, 1, 1))
+    img.Set(0, 0, color.White)
+    var buf bytes.Buffer
+    jpeg.Encode(&buf, img, nil)
+    return "data:image/jpg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}()
+
+// What Anonymize changed
+type AnonymizeStats struct {
+    ChatItems int
+    Messages  int
+    Files     int
+    Profiles  int
+    Names     int // Local display names replaced
+}
+
+// How Anonymize scrubs a column
+const (
+    scrubText = iota
+    scrubJSON
+    scrubFileName
+    scrubEmpty
+    scrubNull
+    scrubProfileName
+)
+
+// Columns Anonymize scrubs; tables and columns an older schema doesn't have are skipped
+var anonymizedColumns = []struct {
+    table   string
+    id      string
+    columns map[string]int
+}{
+    {"chat_items", "chat_item_id", map[string]int{"item_text": scrubText, "item_content": scrubJSON, "quoted_content": scrubJSON, "fwd_from_chat_name": scrubText}},
+    {"messages", "message_id", map[string]int{"msg_body": scrubJSON}},
+    {"files", "file_id", map[string]int{"file_name": scrubFileName, "file_path": scrubFileName}},
+    {"contact_profiles", "contact_profile_id", map[string]int{"display_name": scrubProfileName, "full_name": scrubEmpty, "image": scrubNull, "local_alias": scrubEmpty}},
+    {"group_profiles", "group_profile_id", map[string]int{"display_name": scrubProfileName, "full_name": scrubEmpty, "description": scrubNull, "image": scrubNull}},
+}
+
+// Scrub a SimpleX database in place so it can be shared to reproduce a bug: message text becomes
+// same-length placeholders, file names are hashed (and the files in filesDir renamed to match,
+// unless it's empty), profile names, pictures and local display names are replaced. Row IDs and
+// everything else stay, so the database is laid out as before
+func Anonymize(db *sql.DB, filesDir string) (AnonymizeStats, error) {
+    var stats AnonymizeStats
+    tx, err := BeginTx(context.Background(), db)
+    if err != nil {
+        return stats, fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    renamedFiles := make(map[string]string)
+    for _, table := range anonymizedColumns {
+        columns, err := getTableColumns(tx, table.table)
+        if err != nil {
+            return stats, fmt.Errorf("failed to read columns of %s: %w", table.table, err)
+        }
+        // A row counts once however many of its columns changed
+        rowsChanged := 0
+        for _, column := range columns {
+            kind, scrubbed := table.columns[column]
+            if !scrubbed {
+                continue
+            }
+            changed, err := anonymizeColumn(tx, table.table, table.id, column, kind, renamedFiles)
+            if err != nil {
+                return stats, fmt.Errorf("failed to anonymize %s.%s: %w", table.table, column, err)
+            }
+            rowsChanged = max(rowsChanged, changed)
+        }
+        switch table.table {
+        case "chat_items":
+            stats.ChatItems = rowsChanged
+        case "messages":
+            stats.Messages = rowsChanged
+        case "files":
+            stats.Files = rowsChanged
+        default:
+            stats.Profiles += rowsChanged
+        }
+    }
+
+    if stats.Names, err = anonymizeLocalNames(tx); err != nil {
+        return stats, err
+    }
+    if err := tx.Commit(); err != nil {
+        return stats, fmt.Errorf("failed to commit: %w", err)
+    }
+
+    if filesDir != "" {
+        for oldName, newName := range renamedFiles {
+            oldPath := filepath.Join(filesDir, oldName)
+            if _, err := os.Stat(oldPath); err != nil {
+                continue
+            }
+            if err := os.Rename(oldPath, filepath.Join(filesDir, newName)); err != nil {
+                return stats, fmt.Errorf("failed to rename %s: %w", oldName, err)
+            }
+        }
+    }
+    return stats, nil
+}
+
+// Scrub one column of every row. Returns how many rows changed
+func anonymizeColumn(tx *sql.Tx, table, idColumn, column string, kind int, renamedFiles map[string]string) (int, error) {
+    rows, err := tx.Query(fmt.Sprintf("SELECT %s, %s FROM %s WHERE %s IS NOT NULL", idColumn, column, table, column))
+    if err != nil {
+        return 0, err
+    }
+    type update struct {
+        id    int64
+        value interface{}
+    }
+    var updates []update
+    for rows.Next() {
+        var id int64
+        var value interface{}
+        if err := rows.Scan(&id, &value); err != nil {
+            rows.Close()
+            return 0, err
+        }
+
+        // Blobs go back as blobs and text as text
+        text, isBlob := "", false
+        switch v := value.(type) {
+        case []byte:
+            text, isBlob = string(v), true
+        case string:
+            text = v
+        default:
+            continue
+        }
+
+        var scrubbed interface{}
+        switch kind {
+        case scrubText:
+            scrubbed = universal.Placeholder(text)
+        case scrubJSON:
+            scrubbed = anonymizeJSON(text)
+        case scrubFileName:
+            name := universal.HashedName(text)
+            renamedFiles[text] = name
+            scrubbed = name
+        case scrubEmpty:
+            scrubbed = ""
+        case scrubNull:
+            scrubbed = nil
+        case scrubProfileName:
+            scrubbed = fmt.Sprintf("%s%d", table[:len(table)-len("_profiles")], id)
+        }
+        if s, ok := scrubbed.(string); ok && isBlob {
+            scrubbed = []byte(s)
+        }
+        updates = append(updates, update{id, scrubbed})
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return 0, err
+    }
+
+    query := fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ?", table, column, idColumn)
+    for _, u := range updates {
+        if _, err := tx.Exec(query, u.value, u.id); err != nil {
+            return 0, err
+        }
+    }
+    return len(updates), nil
+}
+
+// Scrub the texts, names, links and images in chat item content or a message body. Anything that
+// isn't JSON is replaced like plain text
+func anonymizeJSON(text string) string {
+    decoder := json.NewDecoder(bytes.NewReader([]byte(text)))
+    decoder.UseNumber()
+    var value interface{}
+    if err := decoder.Decode(&value); err != nil {
+        return universal.Placeholder(text)
+    }
+    data, err := json.Marshal(anonymizeValue("", value))
+    if err != nil {
+        return universal.Placeholder(text)
+    }
+    return string(data)
+}
+
+func anonymizeValue(key string, value interface{}) interface{} {
+    switch v := value.(type) {
+    case map[string]interface{}:
+        for k, item := range v {
+            v[k] = anonymizeValue(k, item)
+        }
+    case []interface{}:
+        for i, item := range v {
+            v[i] = anonymizeValue(key, item)
+        }
+    case string:
+        switch key {
+        case "text", "title", "description", "uri", "displayName", "fullName":
+            return universal.Placeholder(v)
+        case "fileName":
+            return universal.HashedName(v)
+        case "image":
+            return blankImage
+        }
+    }
+    return value
+}
+
+// Replace the local display names, which contacts, groups, members and users refer to by
+// (user_id, local_display_name). The display_names table, when there is one, is renamed first so
+// its foreign keys hold whether or not they cascade. Returns how many names were replaced
+func anonymizeLocalNames(tx *sql.Tx) (int, error) {
+    rows, err := tx.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name = 'display_names' DESC, name")
+    if err != nil {
+        return 0, fmt.Errorf("failed to list tables: %w", err)
+    }
+    var allTables []string
+    for rows.Next() {
+        var name string
+        if err := rows.Scan(&name); err != nil {
+            rows.Close()
+            return 0, fmt.Errorf("failed to list tables: %w", err)
+        }
+        allTables = append(allTables, name)
+    }
+    rows.Close()
+
+    var tables []string
+    hasBase := make(map[string]bool)
+    for _, table := range allTables {
+        columns, err := getTableColumns(tx, table)
+        if err != nil {
+            return 0, fmt.Errorf("failed to read columns of %s: %w", table, err)
+        }
+        hasUser, hasName := false, false
+        for _, column := range columns {
+            hasUser = hasUser || column == "user_id"
+            hasName = hasName || column == "local_display_name"
+            hasBase[table] = hasBase[table] || column == "ldn_base"
+        }
+        if hasUser && hasName {
+            tables = append(tables, table)
+        }
+    }
+
+    type localName struct {
+        userID int64
+        name   string
+    }
+    renamed := make(map[localName]string)
+    var order []localName
+    for _, table := range tables {
+        rows, err := tx.Query(fmt.Sprintf("SELECT DISTINCT user_id, local_display_name FROM %s WHERE user_id IS NOT NULL", table))
+        if err != nil {
+            return 0, fmt.Errorf("failed to read names of %s: %w", table, err)
+        }
+        for rows.Next() {
+            var name localName
+            if err := rows.Scan(&name.userID, &name.name); err != nil {
+                rows.Close()
+                return 0, fmt.Errorf("failed to read names of %s: %w", table, err)
+            }
+            if _, found := renamed[name]; !found {
+                renamed[name] = fmt.Sprintf("name%d", len(renamed)+1)
+                order = append(order, name)
+            }
+        }
+        rows.Close()
+    }
+
+    for _, table := range tables {
+        set := "local_display_name = ?"
+        if hasBase[table] {
+            set += ", ldn_base = ?"
+        }
+        query := fmt.Sprintf("UPDATE %s SET %s WHERE user_id = ? AND local_display_name = ?", table, set)
+        for _, name := range order {
+            args := []interface{}{renamed[name]}
+            if hasBase[table] {
+                args = append(args, renamed[name])
+            }
+            if _, err := tx.Exec(query, append(args, name.userID, name.name)...); err != nil {
+                return 0, fmt.Errorf("failed to rename in %s: %w", table, err)
+            }
+        }
+    }
+    return len(order), nil
+}
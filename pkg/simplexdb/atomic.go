@@ -0,0 +1,63 @@
+package simplexdb
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+)
+
+// Database the import writes to: a *sql.DB, where InsertMessages, RecordImportRun and
+// RenumberByTime commit a transaction of their own, or the *sql.Tx of an atomic import, where each
+// of them gets a savepoint that's undone with the rest of it when the import isn't committed
+type Database interface {
+    execer
+}
+
+// Transaction of one change written to a Database
+type change struct {
+    *sql.Tx
+    savepoint string // Set when the change is a savepoint in an outer transaction
+    done      bool
+}
+
+// Begin a change: a transaction of its own on a *sql.DB, a savepoint named name on a *sql.Tx
+func beginChange(ctx context.Context, db Database, name string) (*change, error) {
+    switch db := db.(type) {
+    case *sql.DB:
+        tx, err := BeginTx(ctx, db)
+        if err != nil {
+            return nil, err
+        }
+        return &change{Tx: tx}, nil
+    case *sql.Tx:
+        if _, err := db.Exec("SAVEPOINT " + name); err != nil {
+            return nil, fmt.Errorf("failed to begin savepoint: %w", err)
+        }
+        return &change{Tx: db, savepoint: name}, nil
+    }
+    return nil, fmt.Errorf("can't write to a %T, only a *sql.DB or *sql.Tx", db)
+}
+
+func (c *change) Commit() error {
+    if c.savepoint == "" {
+        return c.Tx.Commit()
+    }
+    if _, err := c.Tx.Exec("RELEASE " + c.savepoint); err != nil {
+        return err
+    }
+    c.done = true
+    return nil
+}
+
+// Undo the change; after Commit it does nothing, like sql.Tx.Rollback
+func (c *change) Rollback() error {
+    if c.savepoint == "" {
+        return c.Tx.Rollback()
+    }
+    if c.done {
+        return nil
+    }
+    c.done = true
+    _, err := c.Tx.Exec(fmt.Sprintf("ROLLBACK TO %[1]s; RELEASE %[1]s", c.savepoint))
+    return err
+}
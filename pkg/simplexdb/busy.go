@@ -0,0 +1,114 @@
+package simplexdb
+
+import (
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "log"
+    "strings"
+    "time"
+)
+
+// How many times a statement is tried again while the database is locked, and how long the
+// first retry waits; every retry waits twice as long as the one before
+const (
+    busyRetries = 5
+    busyBackoff = 500 * time.Millisecond
+)
+
+// The database stayed locked by another connection through every retry
+type BusyError struct {
+    Err error
+}
+
+func (e *BusyError) Error() string {
+    return fmt.Sprintf("the database is locked by another process, such as SimpleX Chat with it open: close it and try again (%v)", e.Err)
+}
+
+func (e *BusyError) Unwrap() error {
+    return e.Err
+}
+
+// Whether an error is SQLITE_BUSY or SQLITE_LOCKED, which go away once the connection holding
+// the lock lets go of it
+func isBusy(err error) bool {
+    if err == nil {
+        return false
+    }
+    message := err.Error()
+    return strings.Contains(message, "database is locked") ||
+        strings.Contains(message, "database table is locked") ||
+        strings.Contains(message, "database schema is locked")
+}
+
+// Run op again while it fails with the database locked, on top of the busy_timeout SQLite
+// waits itself, and return a *BusyError when it's still locked after busyRetries
+func RetryBusy(ctx context.Context, op func() error) error {
+    delay := busyBackoff
+    for attempt := 0; ; attempt++ {
+        err := op()
+        var busyErr *BusyError
+        if !isBusy(err) || errors.As(err, &busyErr) {
+            return err
+        }
+        if attempt == busyRetries {
+            return &BusyError{Err: err}
+        }
+        log.Printf("Warning: database is locked, retrying in %s", delay)
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-time.After(delay):
+        }
+        delay *= 2
+    }
+}
+
+// Begin a transaction, waiting for another connection to let go of the database. Connections
+// opened for writing take the write lock at BEGIN (_txlock=exclusive), so a batch either has the
+// database to itself from the start or doesn't start, instead of finding it locked halfway
+func BeginTx(ctx context.Context, db *sql.DB) (*sql.Tx, error) {
+    var tx *sql.Tx
+    err := RetryBusy(ctx, func() error {
+        var err error
+        tx, err = db.BeginTx(ctx, nil)
+        return err
+    })
+    return tx, err
+}
+
+// execer whose statements are tried again while the database is locked
+type busyRetryTx struct {
+    execer
+    ctx context.Context
+}
+
+func (t busyRetryTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+    var result sql.Result
+    err := RetryBusy(t.ctx, func() error {
+        var err error
+        result, err = t.execer.Exec(query, args...)
+        return err
+    })
+    return result, err
+}
+
+func (t busyRetryTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+    var rows *sql.Rows
+    err := RetryBusy(t.ctx, func() error {
+        var err error
+        rows, err = t.execer.Query(query, args...)
+        return err
+    })
+    return rows, err
+}
+
+func (t busyRetryTx) QueryRow(query string, args ...interface{}) *sql.Row {
+    var row *sql.Row
+    RetryBusy(t.ctx, func() error {
+        row = t.execer.QueryRow(query, args...)
+        return row.Err()
+    })
+    return row
+}
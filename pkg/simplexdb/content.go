@@ -0,0 +1,173 @@
+package simplexdb
+
+import (
+    "fmt"
+    "log"
+
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// Build SimpleX link msgContent with an embedded preview image
+func buildLinkPreviewContent(msg universal.Message, jsonDir string, batchMedia *BatchMedia) (map[string]interface{}, error) {
+    imagePath := universal.ResolveExportPath(jsonDir, msg.LinkPreview.ImageURL)
+    imageBase64, err := batchMedia.imagePreview(imagePath)
+    if err != nil {
+        return nil, err
+    }
+
+    return map[string]interface{}{
+        "type": "link",
+        "text": msg.Content,
+        "preview": map[string]interface{}{
+            "uri":         msg.LinkPreview.URL,
+            "title":       msg.LinkPreview.Title,
+            "description": msg.LinkPreview.Description,
+            "image":       imageBase64,
+        },
+    }, nil
+}
+
+// Build the SimpleX msgContent for a message, with previews and durations for media attachments
+// and a plainer type when those can't be generated
+func MsgContent(msg universal.Message, jsonDir string) map[string]interface{} {
+    return batchMsgContent(msg, jsonDir, nil)
+}
+
+// MsgContent with the previews and durations a MediaPool made for the batch
+func batchMsgContent(msg universal.Message, jsonDir string, batchMedia *BatchMedia) map[string]interface{} {
+    var msgContent map[string]interface{}
+
+    // Handle different message types with attachments
+    if len(msg.Attachments) > 0 {
+        attachment := msg.Attachments[0]
+
+        switch msg.MessageType {
+        case "image":
+            imagePath := universal.ResolveExportPath(jsonDir, attachment.URL)
+            imageBase64, err := batchMedia.imagePreview(imagePath)
+            if err != nil {
+                log.Printf("Warning: failed to encode image %s: %v", imagePath, err)
+                // Fallback to text with file info
+                msgContent = map[string]interface{}{
+                    "type": "text",
+                    "text": fmt.Sprintf("[Image: %s]%s", attachment.Filename,
+                        func() string { if msg.Content != "" { return "\n" + msg.Content }; return "" }()),
+                }
+            } else {
+                msgContent = map[string]interface{}{
+                    "type":  "image",
+                    "text":  msg.Content,
+                    "image": imageBase64,
+                }
+            }
+
+        case "video":
+            // For videos, try to generate thumbnail and get duration
+            if len(msg.Attachments) > 0 {
+                attachment := msg.Attachments[0]
+                videoPath := universal.ResolveExportPath(jsonDir, attachment.URL)
+                thumbnailBase64, duration, err := batchMedia.videoThumbnail(videoPath)
+                if err != nil {
+                    log.Printf("Warning: failed to generate video thumbnail for %s: %v", attachment.Filename, err)
+                    // Fallback to file type without thumbnail
+                    msgContent = map[string]interface{}{
+                        "type": "file",
+                        "text": msg.Content,
+                    }
+                } else {
+                    // Success - create video content with thumbnail and duration
+                    msgContent = map[string]interface{}{
+                        "type":     "video",
+                        "text":     msg.Content,
+                        "image":    thumbnailBase64,
+                        "duration": duration,
+                    }
+                }
+            } else {
+                msgContent = map[string]interface{}{
+                    "type": "file",
+                    "text": msg.Content,
+                }
+            }
+
+        case "voice":
+            voicePath := universal.ResolveExportPath(jsonDir, attachment.URL)
+            duration, err := batchMedia.audioDuration(voicePath)
+            if err != nil {
+                log.Printf("Warning: failed to get voice message duration for %s: %v", attachment.Filename, err)
+                msgContent = map[string]interface{}{
+                    "type": "file",
+                    "text": msg.Content,
+                }
+            } else {
+                msgContent = map[string]interface{}{
+                    "type":     "voice",
+                    "text":     msg.Content,
+                    "duration": duration,
+                }
+            }
+
+        default: // "file" or unknown
+            // Generic file attachment
+            msgContent = map[string]interface{}{
+                "type": "file",
+                "text": msg.Content,
+            }
+        }
+    } else if msg.LinkPreview != nil {
+        linkContent, err := buildLinkPreviewContent(msg, jsonDir, batchMedia)
+        if err != nil {
+            log.Printf("Warning: failed to build link preview for %s: %v", msg.LinkPreview.URL, err)
+            msgContent = map[string]interface{}{
+                "type": "text",
+                "text": msg.Content,
+            }
+        } else {
+            msgContent = linkContent
+        }
+    } else {
+        msgContent = map[string]interface{}{
+            "type": "text",
+            "text": msg.Content,
+        }
+    }
+
+    return msgContent
+}
+
+// Chat item that a reply quotes, or a forwarded message forwards
+type quotedItem struct {
+    ChatItemID  int
+    Sent        bool
+    SharedMsgID []byte
+    Content     map[string]interface{} // msgContent, nil if it couldn't be read
+    FileName    string                 // Attached file, empty if none
+}
+
+// msgContent of a quote the way the app builds it from the quoted message's: images, videos and
+// voice messages keep their preview and duration, and a file, which has no preview, is quoted
+// by name when it has no text. Anything else (or unknown content) quotes the text
+func quoteMsgContent(quoted map[string]interface{}, text, fileName string) map[string]interface{} {
+    contentType, _ := quoted["type"].(string)
+    if quotedText, ok := quoted["text"].(string); ok {
+        text = quotedText
+    }
+    if text == "" {
+        text = fileName
+    }
+
+    content := map[string]interface{}{
+        "type": "text",
+        "text": text,
+    }
+    switch contentType {
+    case "image", "video", "voice", "link":
+        for key, value := range quoted {
+            content[key] = value
+        }
+        content["text"] = text
+    case "file":
+        content["type"] = "file"
+    }
+    return content
+}
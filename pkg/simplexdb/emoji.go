@@ -0,0 +1,76 @@
+package simplexdb
+
+import "strings"
+
+// How to import reactions with emoji SimpleX doesn't show
+const (
+    ReactionEmojiStrip = "strip" // React with the base emoji, e.g. 👍 for 👍🏽 and 👨 for 👨‍👩‍👧
+    ReactionEmojiDrop  = "drop"  // Leave out reactions that aren't one of SimpleX's reactions
+)
+
+// The reactions the SimpleX apps show; any other emoji shows up as "?"
+var simplexReactions = map[string]bool{
+    "👍": true,
+    "🚀": true,
+    "❤": true,
+    "✅": true,
+    "😀": true,
+    "😢": true,
+}
+
+// Turn a Discord reaction into the single emoji SimpleX stores for a reaction. Variation
+// selectors, which Discord adds to many emoji, are always removed. With ReactionEmojiStrip skin
+// tones and tag sequences are dropped from the emoji and a ZWJ sequence is cut to its first
+// emoji; flags and keycaps have no emoji of their own to fall back to and are left out. With
+// ReactionEmojiDrop everything but SimpleX's own reactions is left out. Returns false for a
+// reaction to leave out
+func NormalizeEmoji(emoji, mode string) (string, bool) {
+    if mode == ReactionEmojiDrop {
+        normalized := strings.Map(func(r rune) rune {
+            if isVariationSelector(r) {
+                return -1
+            }
+            return r
+        }, emoji)
+        return normalized, simplexReactions[normalized]
+    }
+
+    // The first emoji of a ZWJ sequence is the one the others are joined to
+    if i := strings.IndexRune(emoji, '\u200D'); i >= 0 {
+        emoji = emoji[:i]
+    }
+    var runes []rune
+    for _, r := range emoji {
+        switch {
+        case isVariationSelector(r), isSkinTone(r), isTag(r):
+            continue
+        case r == '\u20E3' || isRegionalIndicator(r):
+            return "", false
+        }
+        runes = append(runes, r)
+    }
+    if len(runes) != 1 {
+        return "", false
+    }
+    return string(runes), true
+}
+
+// U+FE0E and U+FE0F pick text or emoji presentation
+func isVariationSelector(r rune) bool {
+    return r == '\uFE0E' || r == '\uFE0F'
+}
+
+// Fitzpatrick modifiers, 🏻 to 🏿
+func isSkinTone(r rune) bool {
+    return r >= 0x1F3FB && r <= 0x1F3FF
+}
+
+// Tag characters spelling out subdivision flags (England, Scotland, Wales) after a black flag
+func isTag(r rune) bool {
+    return r >= 0xE0020 && r <= 0xE007F
+}
+
+// Letters whose pairs make up country flags
+func isRegionalIndicator(r rune) bool {
+    return r >= 0x1F1E6 && r <= 0x1F1FF
+}
@@ -0,0 +1,371 @@
+package simplexdb
+
+import (
+    "crypto/rand"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+    "golang.org/x/crypto/poly1305"
+    "golang.org/x/crypto/salsa20/salsa"
+)
+
+// Name an attachment gets in the SimpleX files directory and its files row: truncated if too long
+// (filesystem limit is usually 255 chars), keeping the extension
+func simplexFileName(filename string) string {
+    if len(filename) > 200 {
+        ext := filepath.Ext(filename)
+        baseName := filename[:200-len(ext)]
+        filename = baseName + ext
+    }
+    return filename
+}
+
+// Create a file in the SimpleX files directory, truncating the name like the files row does.
+// The directory may be the app's own, with the files of other chats, so an existing file is never
+// replaced: like the app, the name gets a _1, _2, ... suffix before the extension until it's free.
+// Returns the name the file got, for its files row
+func createSimplexFile(filename, simplexFilesDir string) (*os.File, string, error) {
+    // Ensure SimpleX files directory exists
+    if err := os.MkdirAll(simplexFilesDir, 0755); err != nil {
+        return nil, "", fmt.Errorf("failed to create SimpleX files directory: %w", err)
+    }
+
+    name := simplexFileName(filename)
+    ext := filepath.Ext(name)
+    base := strings.TrimSuffix(name, ext)
+    for n := 1; ; n++ {
+        destFile, err := os.OpenFile(filepath.Join(simplexFilesDir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+        if err == nil {
+            return destFile, name, nil
+        }
+        if !os.IsExist(err) {
+            return nil, "", fmt.Errorf("failed to create destination file: %w", err)
+        }
+        name = fmt.Sprintf("%s_%d%s", base, n, ext)
+    }
+}
+
+// Helper function to copy video file to SimpleX files directory, returning the name it got
+func copyFileToSimplexDir(sourcePath, filename, simplexFilesDir string) (string, error) {
+    // Copy file
+    sourceFile, err := os.Open(sourcePath)
+    if err != nil {
+        return "", fmt.Errorf("failed to open source file: %w", err)
+    }
+    defer sourceFile.Close()
+
+    destFile, name, err := createSimplexFile(filename, simplexFilesDir)
+    if err != nil {
+        return "", err
+    }
+    defer destFile.Close()
+
+    _, err = io.Copy(destFile, sourceFile)
+    if err != nil {
+        return "", fmt.Errorf("failed to copy file: %w", err)
+    }
+    if err := destFile.Close(); err != nil {
+        return "", fmt.Errorf("failed to copy file: %w", err)
+    }
+
+    return name, nil
+}
+
+// Copy a file to the SimpleX files directory encrypted the way the app's "encrypt local files"
+// setting does it, returning the name it got and the per-file key and nonce for
+// file_crypto_key/file_crypto_nonce
+func encryptFileToSimplexDir(sourcePath, filename, simplexFilesDir string) (string, []byte, []byte, error) {
+    var key [32]byte
+    var nonce [24]byte
+    if _, err := rand.Read(key[:]); err != nil {
+        return "", nil, nil, fmt.Errorf("failed to generate file key: %w", err)
+    }
+    if _, err := rand.Read(nonce[:]); err != nil {
+        return "", nil, nil, fmt.Errorf("failed to generate file nonce: %w", err)
+    }
+
+    sourceFile, err := os.Open(sourcePath)
+    if err != nil {
+        return "", nil, nil, fmt.Errorf("failed to open source file: %w", err)
+    }
+    defer sourceFile.Close()
+
+    destFile, name, err := createSimplexFile(filename, simplexFilesDir)
+    if err != nil {
+        return "", nil, nil, err
+    }
+    defer destFile.Close()
+
+    if err := encryptSimplexFile(destFile, sourceFile, &key, &nonce); err != nil {
+        return "", nil, nil, fmt.Errorf("failed to encrypt file: %w", err)
+    }
+    if err := destFile.Close(); err != nil {
+        return "", nil, nil, fmt.Errorf("failed to encrypt file: %w", err)
+    }
+
+    return name, key[:], nonce[:], nil
+}
+
+// Stream XSalsa20-Poly1305 (NaCl secretbox) over a file. SimpleX writes the ciphertext first and
+// appends the 16-byte auth tag at the end instead of prepending it, so the file can be written in one pass
+func encryptSimplexFile(dst io.Writer, src io.Reader, key *[32]byte, nonce *[24]byte) error {
+    // XSalsa20: derive a subkey from the first 16 nonce bytes, the rest goes into the counter block
+    var subKey [32]byte
+    var hNonce [16]byte
+    copy(hNonce[:], nonce[:16])
+    salsa.HSalsa20(&subKey, &hNonce, key, &salsa.Sigma)
+
+    var counter [16]byte
+    copy(counter[:8], nonce[16:])
+
+    // The first 32 keystream bytes become the Poly1305 key, so the first chunk is offset by them.
+    // Chunks are whole 64-byte Salsa20 blocks except for the last one
+    buf := make([]byte, 64*1024)
+    offset := 32
+    var mac *poly1305.MAC
+    for {
+        n, err := io.ReadFull(src, buf[offset:])
+        if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+            return err
+        }
+        chunk := buf[:offset+n]
+        salsa.XORKeyStream(chunk, chunk, &counter, &subKey)
+
+        if mac == nil {
+            var polyKey [32]byte
+            copy(polyKey[:], chunk[:32])
+            mac = poly1305.New(&polyKey)
+            chunk = chunk[32:]
+        }
+        if len(chunk) > 0 {
+            mac.Write(chunk)
+            if _, werr := dst.Write(chunk); werr != nil {
+                return werr
+            }
+        }
+
+        if err != nil {
+            break
+        }
+        offset = 0
+        binary.LittleEndian.PutUint64(counter[8:], binary.LittleEndian.Uint64(counter[8:])+uint64(len(buf)/64))
+    }
+
+    _, err := dst.Write(mac.Sum(nil))
+    return err
+}
+
+// Helper function to insert file attachment and return file_id and the name of its file
+func insertFileAttachment(tx execer, attachment universal.Attachment, chatItemID int, isSent bool, jsonDir string, messageType string, contactID, userID, connectionID int, simplexFilesDir string, encryptFiles, dryRun bool, batchMedia *BatchMedia) (int, string, error) {
+    filePath := universal.ResolveExportPath(jsonDir, attachment.URL)
+
+    // Check if file exists
+    if _, err := os.Stat(filePath); os.IsNotExist(err) {
+        return 0, "", fmt.Errorf("file not found: %s", filePath)
+    }
+
+    // Get template file row for default values
+    templateRow, err := getTemplateRow(tx, "files", "file_id")
+    if err != nil {
+        return 0, "", fmt.Errorf("failed to get template file row: %w", err)
+    }
+
+    // Get next file_id
+    var nextFileID int
+    err = tx.QueryRow("SELECT COALESCE(MAX(file_id), 0) + 1 FROM files").Scan(&nextFileID)
+    if err != nil {
+        return 0, "", fmt.Errorf("failed to get next file_id: %w", err)
+    }
+
+    // Insert into files table
+    columns, err := getTableColumns(tx, "files")
+    if err != nil {
+        return 0, "", err
+    }
+
+    // Truncate filename if too long (same logic as copyFileToSimplexDir). The copy may get a
+    // suffix on top to keep it from replacing a file that's there, which file_path records
+    truncatedFilename := simplexFileName(attachment.Filename)
+    storedFilename := truncatedFilename
+
+    // Copy all files to SimpleX files directory so they are accessible/downloadable.
+    // Without encryption the crypto columns stay NULL, which the app reads as a plaintext local file
+    var cryptoKey, cryptoNonce interface{}
+    if dryRun {
+        // Only checked for existence above, nothing is copied until the real import
+    } else {
+        name, key, nonce, err := batchMedia.copyAttachment(filePath, attachment.Filename, simplexFilesDir, encryptFiles)
+        if err != nil && encryptFiles {
+            return 0, "", fmt.Errorf("failed to encrypt file to SimpleX directory: %w", err)
+        }
+        if err != nil {
+            return 0, "", fmt.Errorf("failed to copy file to SimpleX directory: %w", err)
+        }
+        storedFilename = name
+        if key != nil {
+            cryptoKey, cryptoNonce = key, nonce
+        }
+    }
+
+    // Set file status and protocol based on message type
+    var fileStatus string
+    var protocol string
+    if messageType == "video" {
+        // Videos use local storage without transfer records
+        fileStatus = "snd_stored"  // Local storage, not transferred
+        protocol = "local"         // Local protocol, not smp/xftp
+    } else if messageType == "image" || messageType == "voice" {
+        // Images and voice use xftp protocol like original SimpleX files
+        if isSent {
+            fileStatus = "snd_complete"
+        } else {
+            fileStatus = "rcv_complete"
+        }
+        protocol = "xftp"
+    } else {
+        // For other files, use standard transfer status
+        if isSent {
+            fileStatus = "snd_complete"
+        } else {
+            fileStatus = "rcv_complete"
+        }
+        protocol = "smp"
+    }
+
+    overrideFields := map[string]interface{}{
+        "file_id":        nextFileID,
+        "contact_id":     contactID, // Associate with specified contact
+        "file_name":      truncatedFilename, // Use truncated filename
+        "file_path":      storedFilename, // Name of the copy in the files directory
+        "file_size":      attachment.Size,
+        "chunk_size":     16384, // Standard chunk size
+        "user_id":        userID,
+        "chat_item_id":   chatItemID,
+        "ci_file_status": fileStatus,
+        "protocol":       protocol,
+        "created_at":     formatTime(time.Now()),
+        "updated_at":     formatTime(time.Now()),
+        "file_crypto_key":   cryptoKey,
+        "file_crypto_nonce": cryptoNonce,
+    }
+
+    rowValues := make([]interface{}, len(columns))
+    for i, col := range columns {
+        if val, override := overrideFields[col]; override {
+            rowValues[i] = val
+        } else {
+            rowValues[i] = templateRow[col]
+        }
+    }
+
+    placeholders := "(" + strings.Repeat("?,", len(columns)-1) + "?)"
+    query := fmt.Sprintf("INSERT INTO files (%s) VALUES %s",
+        strings.Join(columns, ", "), placeholders)
+
+    _, err = tx.Exec(query, rowValues...)
+    if err != nil {
+        return 0, "", fmt.Errorf("failed to insert file: %w", err)
+    }
+
+    // Only videos don't need snd_files/rcv_files entries (they use local protocol)
+    // Images and voice messages need these entries (they use xftp protocol)
+    if messageType != "video" {
+        // Insert into snd_files or rcv_files table
+        if isSent {
+            err = insertSndFile(tx, nextFileID, connectionID)
+        } else {
+            err = insertRcvFile(tx, nextFileID)
+        }
+        if err != nil {
+            return 0, "", fmt.Errorf("failed to insert file transfer record: %w", err)
+        }
+    }
+
+    return nextFileID, storedFilename, nil
+}
+
+func insertSndFile(tx execer, fileID, connectionID int) error {
+    templateRow, err := getTemplateRow(tx, "snd_files", "file_id")
+    if err != nil {
+        return err
+    }
+
+    columns, err := getTableColumns(tx, "snd_files")
+    if err != nil {
+        return err
+    }
+
+    // Generate unique last_inline_msg_delivery_id to avoid constraint violations
+    var nextDeliveryID int
+    err = tx.QueryRow("SELECT COALESCE(MAX(last_inline_msg_delivery_id), 0) + 1 FROM snd_files").Scan(&nextDeliveryID)
+    if err != nil {
+        return fmt.Errorf("failed to get next delivery ID: %w", err)
+    }
+
+    overrideFields := map[string]interface{}{
+        "file_id":                     fileID,
+        "connection_id":               connectionID,
+        "file_status":                 "complete",
+        "last_inline_msg_delivery_id": nextDeliveryID,
+        "created_at":                  formatTime(time.Now()),
+        "updated_at":                  formatTime(time.Now()),
+    }
+
+    rowValues := make([]interface{}, len(columns))
+    for i, col := range columns {
+        if val, override := overrideFields[col]; override {
+            rowValues[i] = val
+        } else {
+            rowValues[i] = templateRow[col]
+        }
+    }
+
+    placeholders := "(" + strings.Repeat("?,", len(columns)-1) + "?)"
+    query := fmt.Sprintf("INSERT INTO snd_files (%s) VALUES %s",
+        strings.Join(columns, ", "), placeholders)
+
+    _, err = tx.Exec(query, rowValues...)
+    return err
+}
+
+func insertRcvFile(tx execer, fileID int) error {
+    templateRow, err := getTemplateRow(tx, "rcv_files", "file_id")
+    if err != nil {
+        return err
+    }
+
+    columns, err := getTableColumns(tx, "rcv_files")
+    if err != nil {
+        return err
+    }
+
+    overrideFields := map[string]interface{}{
+        "file_id":                fileID,
+        "file_status":            "complete",
+        "user_approved_relays":   0, // Set to 0 for imported files
+        "created_at":             formatTime(time.Now()),
+        "updated_at":             formatTime(time.Now()),
+    }
+
+    rowValues := make([]interface{}, len(columns))
+    for i, col := range columns {
+        if val, override := overrideFields[col]; override {
+            rowValues[i] = val
+        } else {
+            rowValues[i] = templateRow[col]
+        }
+    }
+
+    placeholders := "(" + strings.Repeat("?,", len(columns)-1) + "?)"
+    query := fmt.Sprintf("INSERT INTO rcv_files (%s) VALUES %s",
+        strings.Join(columns, ", "), placeholders)
+
+    _, err = tx.Exec(query, rowValues...)
+    return err
+}
@@ -0,0 +1,132 @@
+package simplexdb
+
+import (
+    "database/sql"
+    "fmt"
+    "strings"
+)
+
+// Tables InsertMessages adds rows to that have foreign keys
+var insertedTables = []string{"messages", "chat_items", "chat_item_messages", "msg_deliveries", "chat_item_reactions", "files", "snd_files", "rcv_files"}
+
+// Row an import added whose foreign key points at nothing
+type ForeignKeyViolation struct {
+    Table  string
+    RowID  int64
+    Column string // Column (or columns, comma separated) of the foreign key
+    Parent string // Table it should point into
+}
+
+func (v ForeignKeyViolation) String() string {
+    return fmt.Sprintf("%s row %d: %s has no matching row in %s", v.Table, v.RowID, v.Column, v.Parent)
+}
+
+// Rows of an import that SimpleX would refuse, found before committing them
+type ForeignKeyError struct {
+    Violations []ForeignKeyViolation
+}
+
+func (e *ForeignKeyError) Error() string {
+    const shown = 10
+    var lines []string
+    for i, violation := range e.Violations {
+        if i == shown {
+            lines = append(lines, fmt.Sprintf("and %d more", len(e.Violations)-shown))
+            break
+        }
+        lines = append(lines, violation.String())
+    }
+    return fmt.Sprintf("%d inserted rows break foreign keys, nothing was committed:\n  %s", len(e.Violations), strings.Join(lines, "\n  "))
+}
+
+// Newest rowid of each table an import writes to, taken before it does, so checkForeignKeys
+// can tell the rows it added from ones already there
+func markRowids(querier Querier) (map[string]int64, error) {
+    tables, err := tableNames(querier)
+    if err != nil {
+        return nil, err
+    }
+    existing := make(map[string]bool, len(tables))
+    for _, table := range tables {
+        existing[table] = true
+    }
+
+    marks := make(map[string]int64)
+    for _, table := range insertedTables {
+        if !existing[table] {
+            continue
+        }
+        var mark sql.NullInt64
+        if err := querier.QueryRow(fmt.Sprintf("SELECT MAX(rowid) FROM %s", table)).Scan(&mark); err != nil {
+            return nil, fmt.Errorf("failed to read rowids of %s: %w", table, err)
+        }
+        marks[table] = mark.Int64
+    }
+    return marks, nil
+}
+
+// Run PRAGMA foreign_key_check on the tables in marks and return a *ForeignKeyError for the
+// rows added after their mark that point at nothing. Rows that were broken already are left
+// alone, they aren't the import's doing
+func checkForeignKeys(querier Querier, marks map[string]int64) error {
+    var violations []ForeignKeyViolation
+    for _, table := range insertedTables {
+        mark, ok := marks[table]
+        if !ok {
+            continue
+        }
+        columns, err := foreignKeyColumns(querier, table)
+        if err != nil {
+            return err
+        }
+
+        rows, err := querier.Query(fmt.Sprintf("PRAGMA foreign_key_check(%s)", table))
+        if err != nil {
+            return fmt.Errorf("failed to check foreign keys of %s: %w", table, err)
+        }
+        for rows.Next() {
+            var child, parent string
+            var rowID sql.NullInt64
+            var fkID int
+            if err := rows.Scan(&child, &rowID, &parent, &fkID); err != nil {
+                rows.Close()
+                return fmt.Errorf("failed to check foreign keys of %s: %w", table, err)
+            }
+            if rowID.Valid && rowID.Int64 <= mark {
+                continue
+            }
+            violations = append(violations, ForeignKeyViolation{Table: child, RowID: rowID.Int64, Column: columns[fkID], Parent: parent})
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            return fmt.Errorf("failed to check foreign keys of %s: %w", table, err)
+        }
+    }
+    if len(violations) > 0 {
+        return &ForeignKeyError{Violations: violations}
+    }
+    return nil
+}
+
+// Columns of each foreign key of the table, by the id foreign_key_check reports
+func foreignKeyColumns(querier Querier, table string) (map[int]string, error) {
+    rows, err := querier.Query(fmt.Sprintf("SELECT id, \"from\" FROM pragma_foreign_key_list('%s') ORDER BY id, seq", table))
+    if err != nil {
+        return nil, fmt.Errorf("failed to read foreign keys of %s: %w", table, err)
+    }
+    defer rows.Close()
+
+    columns := make(map[int]string)
+    for rows.Next() {
+        var id int
+        var column string
+        if err := rows.Scan(&id, &column); err != nil {
+            return nil, fmt.Errorf("failed to read foreign keys of %s: %w", table, err)
+        }
+        if columns[id] != "" {
+            column = columns[id] + ", " + column
+        }
+        columns[id] = column
+    }
+    return columns, rows.Err()
+}
@@ -0,0 +1,95 @@
+package simplexdb
+
+import (
+    "crypto/rand"
+    "database/sql"
+    "encoding/base64"
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "io"
+    "strconv"
+)
+
+// Which source message every imported chat item came from, as their shared_msg_id is random.
+// Lets a later import skip what's already there and quote messages of an earlier one; SimpleX
+// ignores tables it doesn't know, like the import_runs ledger
+const importedMessagesSchema = `CREATE TABLE IF NOT EXISTS imported_messages (
+    contact_id INTEGER NOT NULL,
+    source_id TEXT NOT NULL,
+    shared_msg_id BLOB NOT NULL,
+    chat_item_id INTEGER NOT NULL,
+    PRIMARY KEY (contact_id, source_id)
+)`
+
+// Random shared_msg_id, 12 bytes like the ones SimpleX clients generate
+func newSharedMsgID() ([]byte, error) {
+    id := make([]byte, 12)
+    if _, err := rand.Read(id); err != nil {
+        return nil, fmt.Errorf("failed to generate shared_msg_id: %w", err)
+    }
+    return id, nil
+}
+
+// Chat item an earlier import made of the source message sourceID in the contact's chat, with
+// its msgContent and file name for quoting it; nil if there's none. Items of versions that stored
+// the source ID as the shared_msg_id are found too
+func importedQuote(querier Querier, contactID int, sourceID string) (*quotedItem, error) {
+    var item quotedItem
+    var itemContent string
+    err := querier.QueryRow(`SELECT ci.chat_item_id, ci.item_sent, ci.shared_msg_id, ci.item_content, COALESCE(f.file_name, '') FROM chat_items ci
+                             LEFT JOIN files f ON f.chat_item_id = ci.chat_item_id
+                             WHERE ci.contact_id = ? AND ci.shared_msg_id IN (
+                                 SELECT shared_msg_id FROM imported_messages WHERE contact_id = ? AND source_id = ?
+                                 UNION SELECT CAST(? AS BLOB))
+                             ORDER BY ci.chat_item_id DESC LIMIT 1`,
+        contactID, contactID, sourceID, sourceID).Scan(&item.ChatItemID, &item.Sent, &item.SharedMsgID, &itemContent, &item.FileName)
+    if err == sql.ErrNoRows {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to read imported_messages: %w", err)
+    }
+
+    // item_content wraps the msgContent as {"sndMsgContent"|"rcvMsgContent": {"msgContent": ...}}
+    var wrapped map[string]struct {
+        MsgContent map[string]interface{} `json:"msgContent"`
+    }
+    if json.Unmarshal([]byte(itemContent), &wrapped) == nil {
+        for _, content := range wrapped {
+            item.Content = content.MsgContent
+        }
+    }
+    return &item, nil
+}
+
+// Remember which source message each chat item of the batch came from. Importing a message again
+// (-allow-duplicates) points its source ID at the new copy
+func recordImportedMessages(tx execer, data BulkInsertData, contactID int) error {
+    for _, msgData := range data.Messages {
+        _, err := tx.Exec("INSERT OR REPLACE INTO imported_messages (contact_id, source_id, shared_msg_id, chat_item_id) VALUES (?, ?, ?, ?)",
+            contactID, msgData.Message.ID, msgData.SharedMsgID, msgData.ChatItemID)
+        if err != nil {
+            return fmt.Errorf("failed to record imported message: %w", err)
+        }
+    }
+    return nil
+}
+
+// Header of the InsertOptions.IDMap sidecar, for a caller starting a new file
+func WriteIDMapHeader(w io.Writer) error {
+    out := csv.NewWriter(w)
+    out.Write([]string{"source_id", "shared_msg_id", "chat_item_id"})
+    out.Flush()
+    return out.Error()
+}
+
+// One line per message of the batch: its source ID, base64 shared_msg_id and chat_item_id
+func writeIDMap(w io.Writer, data BulkInsertData) error {
+    out := csv.NewWriter(w)
+    for _, msgData := range data.Messages {
+        out.Write([]string{msgData.Message.ID, base64.StdEncoding.EncodeToString(msgData.SharedMsgID), strconv.Itoa(msgData.ChatItemID)})
+    }
+    out.Flush()
+    return out.Error()
+}
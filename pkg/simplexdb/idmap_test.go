@@ -0,0 +1,134 @@
+package simplexdb
+
+import (
+    "bytes"
+    "encoding/base64"
+    "strings"
+    "testing"
+
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+var idMapSchema = []string{
+    `CREATE TABLE chat_items (
+        chat_item_id INTEGER PRIMARY KEY,
+        contact_id INTEGER NOT NULL,
+        item_sent INTEGER NOT NULL,
+        shared_msg_id BLOB,
+        item_content TEXT NOT NULL
+    )`,
+    `CREATE TABLE files (
+        file_id INTEGER PRIMARY KEY,
+        chat_item_id INTEGER REFERENCES chat_items ON DELETE CASCADE,
+        file_name TEXT NOT NULL
+    )`,
+    importedMessagesSchema,
+}
+
+func TestImportedQuote(t *testing.T) {
+    db := testDatabase(t, idMapSchema)
+    items := []struct {
+        id          int
+        contactID   int
+        sent        bool
+        sharedMsgID []byte
+        content     string
+        fileName    string
+    }{
+        {1, 1, true, []byte("random-id-1"), `{"sndMsgContent":{"msgContent":{"type":"text","text":"hello"}}}`, ""},
+        {2, 1, false, []byte("random-id-2"), `{"rcvMsgContent":{"msgContent":{"type":"file","text":""}}}`, "notes.txt"},
+        {3, 1, false, []byte("2002"), `{"rcvMsgContent":{"msgContent":{"type":"text","text":"stored by an old version"}}}`, ""},
+        {4, 1, true, []byte("random-id-4"), `{"sndMsgContent":{"msgContent":{"type":"text","text":"hello again"}}}`, ""},
+        {5, 2, true, []byte("random-id-5"), `{"sndMsgContent":{"msgContent":{"type":"text","text":"other chat"}}}`, ""},
+    }
+    for _, item := range items {
+        if _, err := db.Exec("INSERT INTO chat_items (chat_item_id, contact_id, item_sent, shared_msg_id, item_content) VALUES (?, ?, ?, ?, ?)",
+            item.id, item.contactID, item.sent, item.sharedMsgID, item.content); err != nil {
+            t.Fatal(err)
+        }
+        if item.fileName != "" {
+            if _, err := db.Exec("INSERT INTO files (chat_item_id, file_name) VALUES (?, ?)", item.id, item.fileName); err != nil {
+                t.Fatal(err)
+            }
+        }
+    }
+
+    // 2001 was imported twice (-allow-duplicates), the second time as item 4
+    batches := []BulkInsertData{
+        {Messages: []MessageInsertData{
+            {ChatItemID: 1, SharedMsgID: []byte("random-id-1"), Message: universal.Message{ID: "2001"}},
+            {ChatItemID: 2, SharedMsgID: []byte("random-id-2"), Message: universal.Message{ID: "2003"}},
+        }},
+        {Messages: []MessageInsertData{
+            {ChatItemID: 4, SharedMsgID: []byte("random-id-4"), Message: universal.Message{ID: "2001"}},
+        }},
+    }
+    for _, batch := range batches {
+        if err := recordImportedMessages(db, batch, 1); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := recordImportedMessages(db, BulkInsertData{Messages: []MessageInsertData{
+        {ChatItemID: 5, SharedMsgID: []byte("random-id-5"), Message: universal.Message{ID: "2005"}},
+    }}, 2); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name      string
+        contactID int
+        sourceID  string
+        want      *quotedItem // nil when there's no item
+        wantText  string
+    }{
+        {"imported again", 1, "2001", &quotedItem{ChatItemID: 4, Sent: true, SharedMsgID: []byte("random-id-4")}, "hello again"},
+        {"file", 1, "2003", &quotedItem{ChatItemID: 2, SharedMsgID: []byte("random-id-2"), FileName: "notes.txt"}, ""},
+        {"source ID as shared_msg_id", 1, "2002", &quotedItem{ChatItemID: 3, SharedMsgID: []byte("2002")}, "stored by an old version"},
+        {"never imported", 1, "2004", nil, ""},
+        {"other chat", 1, "2005", nil, ""},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := importedQuote(db, tt.contactID, tt.sourceID)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if tt.want == nil {
+                if got != nil {
+                    t.Fatalf("got item %d, want none", got.ChatItemID)
+                }
+                return
+            }
+            if got == nil {
+                t.Fatal("got no item")
+            }
+            if got.ChatItemID != tt.want.ChatItemID || got.Sent != tt.want.Sent || !bytes.Equal(got.SharedMsgID, tt.want.SharedMsgID) || got.FileName != tt.want.FileName {
+                t.Fatalf("got %+v, want %+v", got, tt.want)
+            }
+            if text, _ := got.Content["text"].(string); text != tt.wantText {
+                t.Fatalf("quoted text is %q, want %q", text, tt.wantText)
+            }
+        })
+    }
+}
+
+func TestWriteIDMap(t *testing.T) {
+    data := BulkInsertData{Messages: []MessageInsertData{
+        {ChatItemID: 7, SharedMsgID: []byte{1, 2, 3}, Message: universal.Message{ID: "2001"}},
+        {ChatItemID: 8, SharedMsgID: []byte{0xff, 0xfe}, Message: universal.Message{ID: "id, with a comma"}},
+    }}
+
+    var out strings.Builder
+    if err := WriteIDMapHeader(&out); err != nil {
+        t.Fatal(err)
+    }
+    if err := writeIDMap(&out, data); err != nil {
+        t.Fatal(err)
+    }
+    want := "source_id,shared_msg_id,chat_item_id\n" +
+        "2001," + base64.StdEncoding.EncodeToString([]byte{1, 2, 3}) + ",7\n" +
+        `"id, with a comma",` + base64.StdEncoding.EncodeToString([]byte{0xff, 0xfe}) + ",8\n"
+    if out.String() != want {
+        t.Fatalf("ID map is\n%s\nwant\n%s", out.String(), want)
+    }
+}
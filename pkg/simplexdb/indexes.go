@@ -0,0 +1,100 @@
+package simplexdb
+
+import (
+    "context"
+    "fmt"
+    "strings"
+)
+
+// When an import drops indexes for its bulk load
+const (
+    DropIndexesAuto   = "auto"   // For imports of BulkLoadMessages messages or more
+    DropIndexesAlways = "always"
+    DropIndexesNever  = "never"
+)
+
+// Imports of this many messages drop indexes with DropIndexesAuto; below it rebuilding the
+// indexes costs more than keeping them up to date does
+const BulkLoadMessages = 10000
+
+// Tables a bulk load drops the secondary indexes of, the ones every imported message adds a row to
+var bulkLoadTables = []string{"messages", "chat_items", "chat_item_messages", "msg_deliveries"}
+
+// Index a bulk load dropped, with the statement that builds it again
+type Index struct {
+    Name  string `json:"name"`
+    Table string `json:"table"`
+    SQL   string `json:"sql"`
+}
+
+// Drop the secondary indexes of the tables the import fills, so the inserts don't update them
+// row by row, and return them for RestoreIndexes to build in one go afterwards. Unique indexes
+// and those behind constraints stay, they keep checking the inserted rows
+func DropIndexes(db Database) ([]Index, error) {
+    placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(bulkLoadTables)), ", ")
+    args := make([]interface{}, len(bulkLoadTables))
+    for i, table := range bulkLoadTables {
+        args[i] = table
+    }
+    rows, err := db.Query(fmt.Sprintf(`SELECT m.name, m.tbl_name, m.sql FROM sqlite_master m
+        JOIN pragma_index_list(m.tbl_name) il ON il.name = m.name
+        WHERE m.type = 'index' AND m.sql IS NOT NULL AND il."unique" = 0 AND m.tbl_name IN (%s)
+        ORDER BY m.tbl_name, m.name`, placeholders), args...)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read indexes: %w", err)
+    }
+    var indexes []Index
+    for rows.Next() {
+        var index Index
+        if err := rows.Scan(&index.Name, &index.Table, &index.SQL); err != nil {
+            rows.Close()
+            return nil, fmt.Errorf("failed to read indexes: %w", err)
+        }
+        indexes = append(indexes, index)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("failed to read indexes: %w", err)
+    }
+
+    tx, err := beginChange(context.Background(), db, "drop_indexes")
+    if err != nil {
+        return nil, fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+    for _, index := range indexes {
+        if _, err := tx.Exec(fmt.Sprintf("DROP INDEX %q", index.Name)); err != nil {
+            return nil, fmt.Errorf("failed to drop index %s: %w", index.Name, err)
+        }
+    }
+    if err := tx.Commit(); err != nil {
+        return nil, fmt.Errorf("failed to commit dropping indexes: %w", err)
+    }
+    return indexes, nil
+}
+
+// Build the indexes DropIndexes dropped again. Ones that are there already, from an import that
+// was resumed, are left as they are
+func RestoreIndexes(db Database, indexes []Index) error {
+    tx, err := beginChange(context.Background(), db, "restore_indexes")
+    if err != nil {
+        return fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+    for _, index := range indexes {
+        var exists int
+        if err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = ?", index.Name).Scan(&exists); err != nil {
+            return fmt.Errorf("failed to read indexes: %w", err)
+        }
+        if exists != 0 {
+            continue
+        }
+        if _, err := tx.Exec(index.SQL); err != nil {
+            return fmt.Errorf("failed to rebuild index %s on %s: %w", index.Name, index.Table, err)
+        }
+    }
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("failed to commit rebuilt indexes: %w", err)
+    }
+    return nil
+}
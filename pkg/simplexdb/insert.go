@@ -0,0 +1,1116 @@
+// Package simplexdb writes universal messages into a SimpleX chat database. It works on an
+// open *sql.DB, so callers choose the SQLCipher driver (the command uses go-sqlcipher).
+package simplexdb
+
+import (
+    "context"
+    "database/sql"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/ritiek/discord-to-simplex/pkg/progress"
+    "github.com/ritiek/discord-to-simplex/pkg/timing"
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// Prepared insert data structures
+type MessageInsertData struct {
+    MessageID   int
+    ChatItemID  int
+    SharedMsgID []byte
+    Message     universal.Message
+    // msgContent of the message, the same in its chat item and the body of its messages row
+    Content map[string]interface{}
+    // shared_msg_id of the quoted message, the one it got in this batch or an earlier import,
+    // its source ID when it was never imported (nil when it isn't a reply)
+    QuotedSharedMsgID []byte
+    // msgContent of the quoted message, as the app quotes it (nil when it isn't a reply)
+    QuotedContent map[string]interface{}
+    // Chat item of the original of a forwarded message and whether the user sent it, when it's
+    // in the chat (0 when it isn't, or the message isn't forwarded)
+    ForwardedItemID int
+    ForwardedSent   bool
+    // created_at of its rows: its time, moved just past the message before when they'd tie
+    CreatedAt time.Time
+}
+
+type BulkInsertData struct {
+    Messages          []MessageInsertData
+    StartMessageID    int
+    StartChatItemID   int
+    StartDeliveryRowID int
+    // Mapping from source message ID to shared_msg_id
+    SharedMsgIDs map[string][]byte
+    // Connection of the contact that deliveries and sent files go through
+    ConnectionID int
+    // Whether it's ready, the only kind messages are delivered over: deliveries are only recorded
+    // then, like the app only records them for messages that went through a connection
+    ConnectionReady bool
+    // Previews and durations made ahead of the inserts (may be nil)
+    Media *BatchMedia
+}
+
+// Where and how InsertMessages writes messages
+type InsertOptions struct {
+    // Contact whose direct chat the messages go into
+    ContactID int
+    // User profile the contact belongs to
+    UserID int
+    // Directory that relative attachment paths are resolved against
+    JSONDir string
+    // SimpleX files directory that attachments are copied into
+    FilesDir string
+    // Also write every statement, with its values, to this SQL script
+    SQLScript io.Writer
+    // Gets a CSV line of source ID, shared_msg_id and chat_item_id for every committed message
+    // (may be nil)
+    IDMap io.Writer
+    // Counts the inserted messages (may be nil)
+    Progress *progress.Bar
+    // Gets the IDs used, the files copied and the attachments that failed added to it (may be nil)
+    Report *InsertReport
+    // Encrypt copied attachments like the app's "Encrypt local files" setting
+    EncryptFiles bool
+    // How reactions with emoji SimpleX doesn't show are imported, ReactionEmojiStrip or
+    // ReactionEmojiDrop
+    ReactionEmoji string
+    // Media of the batch a MediaPool is making ahead of the inserts, from Prefetch of the same
+    // messages (may be nil, then it's made as the inserts get to it)
+    Media *BatchMedia
+    // Gets the time the inserts into each table took, and the time they waited for Media, added
+    // to it (may be nil)
+    Timings *timing.Timings
+    // Run every insert but roll the transaction back and copy no attachments, to find out whether
+    // and how the messages would go in
+    DryRun bool
+}
+
+// Times are stored in UTC with as many fractional digits as they have, the way SimpleX writes
+// its own, so they order like the times even as strings
+const timeLayout = "2006-01-02 15:04:05.999999999"
+
+// Time as SimpleX stores it
+func formatTime(t time.Time) string {
+    return t.UTC().Format(timeLayout)
+}
+
+// First free message_id, where InsertMessages should start numbering
+func NextMessageID(db *sql.DB) (int, error) {
+    var messageID int
+    err := db.QueryRow("SELECT COALESCE(MAX(message_id), 0) + 1 FROM messages").Scan(&messageID)
+    return messageID, err
+}
+
+// Leave out messages that imported_messages records in the contact's chat, or whose source ID
+// is a shared_msg_id there as earlier versions stored it, which makes importing the same export
+// again (or a newer one overlapping it) add only what's missing. Messages whose chat item was
+// deleted since are imported again. Returns the rest and how many were left out
+func SkipImported(db *sql.DB, contactID int, messages []universal.Message) ([]universal.Message, int, error) {
+    var exists int
+    err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'imported_messages'").Scan(&exists)
+    if err != nil {
+        return nil, 0, fmt.Errorf("failed to read imported messages: %w", err)
+    }
+    query := "SELECT shared_msg_id FROM chat_items WHERE contact_id = ? AND shared_msg_id IS NOT NULL"
+    args := []interface{}{contactID}
+    if exists != 0 {
+        query += ` UNION SELECT CAST(im.source_id AS BLOB) FROM imported_messages im
+                   JOIN chat_items ci ON ci.contact_id = im.contact_id AND ci.shared_msg_id = im.shared_msg_id
+                   WHERE im.contact_id = ?`
+        args = append(args, contactID)
+    }
+    rows, err := db.Query(query, args...)
+    if err != nil {
+        return nil, 0, fmt.Errorf("failed to read imported messages: %w", err)
+    }
+    defer rows.Close()
+
+    imported := make(map[string]bool)
+    for rows.Next() {
+        var sharedMsgID []byte
+        if err := rows.Scan(&sharedMsgID); err != nil {
+            return nil, 0, fmt.Errorf("failed to read imported messages: %w", err)
+        }
+        imported[string(sharedMsgID)] = true
+    }
+    if err := rows.Err(); err != nil {
+        return nil, 0, fmt.Errorf("failed to read imported messages: %w", err)
+    }
+
+    kept := make([]universal.Message, 0, len(messages))
+    for _, msg := range messages {
+        if !imported[msg.ID] {
+            kept = append(kept, msg)
+        }
+    }
+    return kept, len(messages) - len(kept), nil
+}
+
+// User profile to import into: the one with this ID or local or profile display name, or the
+// active one when profile is empty. Returns its ID and local display name
+func ProfileUserID(db *sql.DB, profile string) (int, string, error) {
+    rows, err := db.Query(`SELECT u.user_id, u.local_display_name, COALESCE(cp.display_name, '') FROM users u
+                           LEFT JOIN contacts c ON c.contact_id = u.contact_id
+                           LEFT JOIN contact_profiles cp ON cp.contact_profile_id = c.contact_profile_id
+                           ORDER BY u.active_user DESC, u.user_id`)
+    if err != nil {
+        return 0, "", fmt.Errorf("failed to lookup user profile: %w", err)
+    }
+    defer rows.Close()
+
+    var profiles []universal.NamedEntry
+    for rows.Next() {
+        var userID int
+        var localName, displayName string
+        if err := rows.Scan(&userID, &localName, &displayName); err != nil {
+            return 0, "", fmt.Errorf("failed to lookup user profile: %w", err)
+        }
+        profiles = append(profiles, universal.NamedEntry{ID: userID, Names: []string{localName, displayName}})
+    }
+    if err := rows.Err(); err != nil {
+        return 0, "", fmt.Errorf("failed to lookup user profile: %w", err)
+    }
+    if len(profiles) == 0 {
+        return 0, "", fmt.Errorf("the database has no user profile")
+    }
+
+    userID := profiles[0].ID
+    if profile != "" {
+        userID, err = strconv.Atoi(profile)
+        if err != nil || !hasEntry(profiles, userID) {
+            if userID, err = universal.MatchName("profile", profile, profiles); err != nil {
+                return 0, "", err
+            }
+        }
+    }
+    for _, entry := range profiles {
+        if entry.ID == userID {
+            return userID, entry.Names[0], nil
+        }
+    }
+    return userID, "", nil
+}
+
+func hasEntry(entries []universal.NamedEntry, id int) bool {
+    for _, entry := range entries {
+        if entry.ID == id {
+            return true
+        }
+    }
+    return false
+}
+
+// Look up a contact of a user profile by local display name or profile display name, ignoring
+// case when only one contact matches that way. The error suggests close names when none does
+func ContactIDByName(db *sql.DB, userID int, contactName string) (int, error) {
+    rows, err := db.Query(`SELECT c.contact_id, c.local_display_name, COALESCE(cp.display_name, '') FROM contacts c
+                           LEFT JOIN contact_profiles cp ON c.contact_profile_id = cp.contact_profile_id
+                           WHERE c.deleted = 0 AND c.is_user = 0 AND c.user_id = ?
+                           ORDER BY c.contact_id`, userID)
+    if err != nil {
+        return 0, fmt.Errorf("failed to lookup contact: %w", err)
+    }
+    defer rows.Close()
+
+    var contacts []universal.NamedEntry
+    for rows.Next() {
+        var contactID int
+        var localName, displayName string
+        if err := rows.Scan(&contactID, &localName, &displayName); err != nil {
+            return 0, fmt.Errorf("failed to lookup contact: %w", err)
+        }
+        contacts = append(contacts, universal.NamedEntry{ID: contactID, Names: []string{localName, displayName}})
+    }
+    if err := rows.Err(); err != nil {
+        return 0, fmt.Errorf("failed to lookup contact: %w", err)
+    }
+    return universal.MatchName("contact", contactName, contacts)
+}
+
+// Local display name of a contact given by its ID, checking that it's a contact of the user
+// profile messages can be imported into
+func ContactNameByID(db *sql.DB, userID, contactID int) (string, error) {
+    var name string
+    err := db.QueryRow("SELECT local_display_name FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted = 0 AND is_user = 0", contactID, userID).Scan(&name)
+    if err == sql.ErrNoRows {
+        return "", fmt.Errorf("no contact with ID %d in this profile", contactID)
+    }
+    if err != nil {
+        return "", fmt.Errorf("failed to lookup contact: %w", err)
+    }
+    return name, nil
+}
+
+// Connection the messages of a contact go through: its newest ready one, or its newest one at all
+// (a contact gets a new connection when it switches servers), and whether it's ready
+func contactConnectionID(querier Querier, contactID int) (int, bool, error) {
+    var connectionID int
+    var ready bool
+    err := querier.QueryRow(`SELECT connection_id, conn_status IN ('ready', 'snd-ready') FROM connections WHERE contact_id = ?
+                             ORDER BY conn_status IN ('ready', 'snd-ready') DESC, connection_id DESC
+                             LIMIT 1`, contactID).Scan(&connectionID, &ready)
+    if err == sql.ErrNoRows {
+        return 0, false, fmt.Errorf("contact %d has no connection to record the messages as delivered through", contactID)
+    }
+    if err != nil {
+        return 0, false, fmt.Errorf("failed to lookup connection: %w", err)
+    }
+    return connectionID, ready, nil
+}
+
+// Interface for both *sql.DB and *sql.Tx
+type Querier interface {
+    QueryRow(query string, args ...interface{}) *sql.Row
+    Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Querier the inserts write through: the transaction, or a scriptTx copying it into a SQL script
+type execer interface {
+    Querier
+    Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Column of a table as PRAGMA table_info describes it
+type tableColumn struct {
+    Name    string
+    Type    string
+    NotNull bool
+    Default sql.NullString // SQL expression of the default value
+}
+
+func getTableColumnInfo(querier Querier, tableName string) ([]tableColumn, error) {
+    rows, err := querier.Query(fmt.Sprintf("PRAGMA table_info(%s);", tableName))
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var columns []tableColumn
+    for rows.Next() {
+        var cid int
+        var column tableColumn
+        var notnull, pk int
+
+        err = rows.Scan(&cid, &column.Name, &column.Type, &notnull, &column.Default, &pk)
+        if err != nil {
+            return nil, err
+        }
+        column.NotNull = notnull != 0
+        columns = append(columns, column)
+    }
+    return columns, rows.Err()
+}
+
+func getTableColumns(querier Querier, tableName string) ([]string, error) {
+    info, err := getTableColumnInfo(querier, tableName)
+    if err != nil {
+        return nil, err
+    }
+    columns := make([]string, len(info))
+    for i, column := range info {
+        columns[i] = column.Name
+    }
+    return columns, nil
+}
+
+// Row to take the columns an insert doesn't set from: the table's newest row, or for an empty
+// table one made up from the schema, where every column has its default value, or when it has
+// none 0 or an empty string or blob for NOT NULL columns (by type affinity) and NULL otherwise
+func getTemplateRow(querier Querier, tableName string, idColumn string) (map[string]interface{}, error) {
+    var templateID sql.NullInt64
+    query := fmt.Sprintf("SELECT MAX(%s) FROM %s", idColumn, tableName)
+    err := querier.QueryRow(query).Scan(&templateID)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get template %s: %w", idColumn, err)
+    }
+
+    if !templateID.Valid {
+        return schemaDefaultRow(querier, tableName)
+    }
+
+    columns, err := getTableColumns(querier, tableName)
+    if err != nil {
+        return nil, err
+    }
+
+    selectQuery := fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?",
+        strings.Join(columns, ", "), tableName, idColumn)
+
+    row := querier.QueryRow(selectQuery, templateID.Int64)
+
+    values := make([]interface{}, len(columns))
+    valuePtrs := make([]interface{}, len(columns))
+    for i := range values {
+        valuePtrs[i] = &values[i]
+    }
+
+    err = row.Scan(valuePtrs...)
+    if err != nil {
+        return nil, err
+    }
+
+    result := make(map[string]interface{})
+    for i, col := range columns {
+        result[col] = values[i]
+    }
+
+    return result, nil
+}
+
+// Row of the values SQLite would give each column of the table if an insert left it out, with
+// NOT NULL columns that have no default set to the zero value of their type
+func schemaDefaultRow(querier Querier, tableName string) (map[string]interface{}, error) {
+    columns, err := getTableColumnInfo(querier, tableName)
+    if err != nil {
+        return nil, err
+    }
+
+    row := make(map[string]interface{}, len(columns))
+    for _, column := range columns {
+        switch {
+        case column.Default.Valid:
+            var value interface{}
+            if err := querier.QueryRow("SELECT " + column.Default.String).Scan(&value); err != nil {
+                return nil, fmt.Errorf("failed to evaluate default of %s.%s: %w", tableName, column.Name, err)
+            }
+            row[column.Name] = value
+        case column.NotNull:
+            row[column.Name] = zeroValue(column.Type)
+        default:
+            row[column.Name] = nil
+        }
+    }
+    return row, nil
+}
+
+// Zero value for a column of the declared type, following SQLite's type affinity rules
+func zeroValue(declaredType string) interface{} {
+    declaredType = strings.ToUpper(declaredType)
+    switch {
+    case strings.Contains(declaredType, "INT"):
+        return 0
+    case strings.Contains(declaredType, "CHAR"), strings.Contains(declaredType, "CLOB"), strings.Contains(declaredType, "TEXT"):
+        return ""
+    case strings.Contains(declaredType, "BLOB"), declaredType == "":
+        return []byte{}
+    case strings.Contains(declaredType, "REAL"), strings.Contains(declaredType, "FLOA"), strings.Contains(declaredType, "DOUB"):
+        return 0.0
+    }
+    return 0
+}
+
+// Single-row INSERT of columns into table; the bulk inserts run the same string for every row,
+// so it's prepared once per batch (see preparedTx)
+func insertQuery(table string, columns []string) string {
+    return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), strings.Repeat("?, ", len(columns)-1)+"?")
+}
+
+func bulkInsertMessages(tx execer, data BulkInsertData, jsonDir string, contactID int) error {
+    // Get template row
+    templateRow, err := getTemplateRow(tx, "messages", "message_id")
+    if err != nil {
+        return fmt.Errorf("failed to get template row: %w", err)
+    }
+
+    columns, err := getTableColumns(tx, "messages")
+    if err != nil {
+        return err
+    }
+
+    // One prepared single-row INSERT, reused for every row of the batch
+    query := insertQuery("messages", columns)
+    for _, msgData := range data.Messages {
+        msg := msgData.Message
+
+        // Create message body with proper structure
+        encodedMsgID := base64.StdEncoding.EncodeToString(msgData.SharedMsgID)
+
+        // A file goes with every attachment but an image without a preview, which is sent as text
+        content := msgData.Content
+        var fileInfo map[string]interface{}
+        if len(msg.Attachments) > 0 && content["type"] != "text" {
+            attachment := msg.Attachments[0] // Use first attachment
+            fileInfo = map[string]interface{}{
+                "fileDescr": map[string]interface{}{
+                    "fileDescrComplete": false,
+                    "fileDescrPartNo":   0,
+                    "fileDescrText":     "",
+                },
+                "fileName": attachment.Filename,
+                "fileSize": attachment.Size,
+            }
+        }
+
+        // Build params object with correct structure
+        params := map[string]interface{}{
+            "content": content,
+        }
+
+        // Add file info for images
+        if fileInfo != nil {
+            params["file"] = fileInfo
+        }
+
+        // Add quote structure if this is a reply. The body is what its sender sent, so "sent"
+        // tells whether the sender wrote the quoted message: flipped for the contact's messages.
+        // There's no memberId, which only group quotes carry
+        if msg.QuotedMessage != nil {
+            params["quote"] = map[string]interface{}{
+                "content": msgData.QuotedContent,
+                "msgRef": map[string]interface{}{
+                    "msgId":  base64.StdEncoding.EncodeToString(msgData.QuotedSharedMsgID),
+                    "sent":   msg.QuotedMessage.IsSent == msg.IsSent,
+                    "sentAt": msg.QuotedMessage.SentAt.UTC().Format(time.RFC3339Nano),
+                },
+            }
+        }
+
+        // Forwarded messages are sent marked as such, without a quote
+        if msg.ForwardedFrom != nil && msg.QuotedMessage == nil {
+            params["forward"] = true
+        }
+
+        msgBody := map[string]interface{}{
+            "v":      "1-14",
+            "msgId":  encodedMsgID,
+            "event":  "x.msg.new",
+            "params": params,
+        }
+
+        msgBodyBytes, err := json.Marshal(msgBody)
+        if err != nil {
+            return fmt.Errorf("failed to marshal message body: %w", err)
+        }
+
+        msgSent := 0
+        if msg.IsSent {
+            msgSent = 1
+        }
+
+        overrideFields := map[string]interface{}{
+            "message_id":     msgData.MessageID,
+            "chat_msg_event": "x.msg.new",
+            "shared_msg_id":  msgData.SharedMsgID,
+            "msg_body":       msgBodyBytes,
+            "msg_sent":       msgSent,
+            "created_at":     formatTime(msgData.CreatedAt),
+            "updated_at":     formatTime(msgData.CreatedAt),
+        }
+
+        if msgSent == 1 {
+            overrideFields["shared_msg_id_user"] = 1
+        } else {
+            overrideFields["shared_msg_id_user"] = nil
+        }
+
+        // Build row values
+        rowValues := make([]interface{}, len(columns))
+        for k, col := range columns {
+            if val, override := overrideFields[col]; override {
+                rowValues[k] = val
+            } else {
+                rowValues[k] = templateRow[col]
+            }
+        }
+
+        if _, err := tx.Exec(query, rowValues...); err != nil {
+            return fmt.Errorf("failed to insert message %d: %w", msgData.MessageID, err)
+        }
+    }
+
+    return nil
+}
+
+func bulkInsertChatItems(tx execer, data BulkInsertData, opts InsertOptions) error {
+    templateRow, err := getTemplateRow(tx, "chat_items", "chat_item_id")
+    if err != nil {
+        return fmt.Errorf("failed to get template row: %w", err)
+    }
+
+    columns, err := getTableColumns(tx, "chat_items")
+    if err != nil {
+        return err
+    }
+
+    // One prepared single-row INSERT, reused for every row of the batch
+    query := insertQuery("chat_items", columns)
+    for _, msgData := range data.Messages {
+        msg := msgData.Message
+        opts.Progress.Add(1)
+
+        // Handle file attachments for all message types with attachments
+        if len(msg.Attachments) > 0 {
+            attachment := msg.Attachments[0]
+            _, fileName, err := insertFileAttachment(tx, attachment, msgData.ChatItemID, msg.IsSent, opts.JSONDir, msg.MessageType, opts.ContactID, opts.UserID, data.ConnectionID, opts.FilesDir, opts.EncryptFiles, opts.DryRun, opts.Media)
+            if err != nil {
+                log.Printf("Warning: failed to create file attachment for %s: %v", attachment.Filename, err)
+                opts.Report.addFailure(msg.ID, attachment.Filename, err)
+                // Continue without file attachment
+            } else {
+                opts.Report.addFile(fileName, attachment.Size)
+            }
+        }
+
+        var itemSent int
+        var itemContentTag string
+        var itemStatus string
+        if msg.IsSent {
+            itemSent = 1
+            itemContentTag = "sndMsgContent"
+            itemStatus = "snd_rcvd ok complete"
+        } else {
+            itemSent = 0
+            itemContentTag = "rcvMsgContent"
+            itemStatus = "rcv_read"
+        }
+
+        itemContent := map[string]interface{}{
+            itemContentTag: map[string]interface{}{
+                "msgContent": msgData.Content,
+            },
+        }
+
+        itemContentBytes, err := json.Marshal(itemContent)
+        if err != nil {
+            return fmt.Errorf("failed to marshal item_content: %w", err)
+        }
+
+        overrideFields := map[string]interface{}{
+            "chat_item_id":       msgData.ChatItemID,
+            "user_id":            opts.UserID,
+            "contact_id":         opts.ContactID, // Associate with specified contact
+            "created_by_msg_id":  msgData.MessageID,
+            "shared_msg_id":      msgData.SharedMsgID,
+            "item_content":       string(itemContentBytes),
+            "item_text":          msg.Content,
+            "item_content_tag":   itemContentTag,
+            "item_sent":          itemSent,
+            "item_status":        itemStatus,
+            "item_deleted":       0, // Not deleted
+            "item_deleted_ts":    nil,
+            "item_edited":        0, // Not edited (prevent edited icon)
+            "include_in_history": 1, // Include in history
+            "user_mention":       0, // Not a mention
+            "show_group_as_sender": 0, // Not a group message
+            // The template row may be a group item; don't attribute the item or its quote to a member
+            "group_id":           nil,
+            "group_member_id":    nil,
+            "quoted_member_id":   nil,
+            // "via_proxy":         nil,
+            "item_ts":            formatTime(msg.Timestamp),
+            "created_at":         formatTime(msgData.CreatedAt),
+            "updated_at":         formatTime(msgData.CreatedAt),
+        }
+
+        // Forwarded messages show the chat they came from, linked to the original when it's in
+        // this one. Elsewhere it's a chat SimpleX doesn't have, and a direct message doesn't say
+        // which side wrote it, so it counts as received
+        overrideFields["fwd_from_tag"] = nil
+        overrideFields["fwd_from_chat_name"] = nil
+        overrideFields["fwd_from_msg_dir"] = nil
+        overrideFields["fwd_from_contact_id"] = nil
+        overrideFields["fwd_from_group_id"] = nil
+        overrideFields["fwd_from_chat_item_id"] = nil
+        if forwarded := msg.ForwardedFrom; forwarded != nil {
+            switch {
+            case msgData.ForwardedItemID != 0:
+                fwdMsgDir := 0
+                if msgData.ForwardedSent {
+                    fwdMsgDir = 1
+                }
+                overrideFields["fwd_from_tag"] = "contact"
+                overrideFields["fwd_from_chat_name"] = forwarded.ChatName
+                overrideFields["fwd_from_msg_dir"] = fwdMsgDir
+                overrideFields["fwd_from_contact_id"] = opts.ContactID
+                overrideFields["fwd_from_chat_item_id"] = msgData.ForwardedItemID
+            case forwarded.ChatName != "" && forwarded.IsGroup:
+                overrideFields["fwd_from_tag"] = "group"
+                overrideFields["fwd_from_chat_name"] = forwarded.ChatName
+            case forwarded.ChatName != "":
+                overrideFields["fwd_from_tag"] = "contact"
+                overrideFields["fwd_from_chat_name"] = forwarded.ChatName
+                overrideFields["fwd_from_msg_dir"] = 0
+            default:
+                overrideFields["fwd_from_tag"] = "unknown"
+            }
+        }
+
+        // Tombstones for deleted Discord messages show up as "marked deleted", at the time they
+        // were deleted when the source knows it
+        if msg.IsDeleted {
+            deletedAt := msg.Timestamp
+            if msg.DeletedAt != nil {
+                deletedAt = *msg.DeletedAt
+            }
+            overrideFields["item_deleted"] = 1
+            overrideFields["item_deleted_ts"] = formatTime(deletedAt)
+        }
+
+        // Handle quoted message fields for Discord replies; quoted_sent is whether the user
+        // wrote the quoted message, whichever side the reply is on
+        if msg.QuotedMessage != nil {
+            quotedContentBytes, err := json.Marshal(msgData.QuotedContent)
+            if err != nil {
+                return fmt.Errorf("failed to marshal quoted_content: %w", err)
+            }
+
+            quotedSent := 0
+            if msg.QuotedMessage.IsSent {
+                quotedSent = 1
+            }
+
+            overrideFields["quoted_shared_msg_id"] = msgData.QuotedSharedMsgID
+            overrideFields["quoted_sent_at"] = formatTime(msg.QuotedMessage.SentAt)
+            overrideFields["quoted_content"] = string(quotedContentBytes)
+            overrideFields["quoted_sent"] = quotedSent
+        } else {
+            overrideFields["quoted_shared_msg_id"] = nil
+            overrideFields["quoted_sent_at"] = nil
+            overrideFields["quoted_content"] = nil
+            overrideFields["quoted_sent"] = nil
+        }
+
+        rowValues := make([]interface{}, len(columns))
+        for k, col := range columns {
+            if val, override := overrideFields[col]; override {
+                rowValues[k] = val
+            } else {
+                rowValues[k] = templateRow[col]
+            }
+        }
+
+        if _, err := tx.Exec(query, rowValues...); err != nil {
+            return fmt.Errorf("failed to insert chat item %d: %w", msgData.ChatItemID, err)
+        }
+    }
+
+    return nil
+}
+
+func bulkInsertChatItemMessages(tx execer, data BulkInsertData) error {
+    templateRow, err := getTemplateRow(tx, "chat_item_messages", "rowid")
+    if err != nil {
+        return fmt.Errorf("failed to get template row: %w", err)
+    }
+    columns, err := getTableColumns(tx, "chat_item_messages")
+    if err != nil {
+        return err
+    }
+
+    // Get next available rowid
+    var nextRowID int
+    err = tx.QueryRow("SELECT COALESCE(MAX(rowid), 0) + 1 FROM chat_item_messages").Scan(&nextRowID)
+    if err != nil {
+        return fmt.Errorf("failed to get next rowid: %w", err)
+    }
+
+    // One prepared single-row INSERT, reused for every row of the batch
+    query := insertQuery("chat_item_messages", columns)
+    for i, msgData := range data.Messages {
+        overrideFields := map[string]interface{}{
+            "rowid":        nextRowID + i,
+            "chat_item_id": msgData.ChatItemID,
+            "message_id":   msgData.MessageID,
+            "created_at":   formatTime(msgData.CreatedAt),
+            "updated_at":   formatTime(msgData.CreatedAt),
+        }
+        rowValues := make([]interface{}, len(columns))
+        for k, col := range columns {
+            if val, override := overrideFields[col]; override {
+                rowValues[k] = val
+            } else {
+                rowValues[k] = templateRow[col]
+            }
+        }
+        if _, err := tx.Exec(query, rowValues...); err != nil {
+            return fmt.Errorf("failed to insert the message of chat item %d: %w", msgData.ChatItemID, err)
+        }
+    }
+
+    return nil
+}
+
+// Deliveries of the messages over the contact's connection, when it's ready
+func bulkInsertMsgDeliveries(tx execer, data BulkInsertData) error {
+    if !data.ConnectionReady {
+        return nil
+    }
+    deliveries, err := newDeliveryWriter(tx)
+    if err != nil {
+        return err
+    }
+    for i, msgData := range data.Messages {
+        err := deliveries.insert(data.StartDeliveryRowID+i, msgData.MessageID, data.ConnectionID, msgData.Message.IsSent, msgData.Message.Timestamp, msgData.CreatedAt)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Writes msg_deliveries rows with one prepared INSERT, copying the columns the import doesn't
+// set from the newest row
+type deliveryWriter struct {
+    tx          execer
+    query       string
+    columns     []string
+    templateRow map[string]interface{}
+}
+
+func newDeliveryWriter(tx execer) (*deliveryWriter, error) {
+    templateRow, err := getTemplateRow(tx, "msg_deliveries", "msg_delivery_id")
+    if err != nil {
+        return nil, fmt.Errorf("failed to get template row: %w", err)
+    }
+    columns, err := getTableColumns(tx, "msg_deliveries")
+    if err != nil {
+        return nil, err
+    }
+    return &deliveryWriter{tx: tx, query: insertQuery("msg_deliveries", columns), columns: columns, templateRow: templateRow}, nil
+}
+
+// Record a message as delivered over the connection, per direction the way the app does: a sent
+// one with the contact's receipt, a received one acknowledged, with the metadata the agent hands
+// over for it. agent_msg_id stays NULL like it is for messages the agent hasn't taken yet, since
+// these never went through it: an ID made up here could be the one the agent gives a real
+// message on the connection later, and its receipts would land on the import
+func (w *deliveryWriter) insert(deliveryID, messageID, connectionID int, sent bool, ts, createdAt time.Time) error {
+    var deliveryStatus string
+    var agentMsgMeta interface{}
+    if sent {
+        deliveryStatus = "snd_rcvd ok"
+    } else {
+        deliveryStatus = "rcv_acknowledged"
+        meta, err := json.Marshal(map[string]interface{}{
+            "integrity": "OK",
+            "rcvId":     0,
+            "rcvTs":     ts.UTC().Format(time.RFC3339Nano),
+            "serverId":  "",
+            "serverTs":  ts.UTC().Format(time.RFC3339Nano),
+            "sndId":     0,
+        })
+        if err != nil {
+            return fmt.Errorf("failed to marshal agent_msg_meta: %w", err)
+        }
+        agentMsgMeta = string(meta)
+    }
+
+    overrideFields := map[string]interface{}{
+        "msg_delivery_id": deliveryID,
+        "message_id":      messageID,
+        "connection_id":   connectionID,
+        "agent_msg_id":    nil,
+        "agent_msg_meta":  agentMsgMeta,
+        "delivery_status": deliveryStatus,
+        "chat_ts":         formatTime(ts),
+        "created_at":      formatTime(createdAt),
+        "updated_at":      formatTime(createdAt),
+    }
+
+    rowValues := make([]interface{}, len(w.columns))
+    for k, col := range w.columns {
+        if val, override := overrideFields[col]; override {
+            rowValues[k] = val
+        } else {
+            rowValues[k] = w.templateRow[col]
+        }
+    }
+
+    if _, err := w.tx.Exec(w.query, rowValues...); err != nil {
+        return fmt.Errorf("failed to insert the delivery of message %d: %w", messageID, err)
+    }
+    return nil
+}
+
+// Bring the contact's chat up to date with the batch the way the app does when a message comes
+// in: chat_ts, which the chat list is sorted by, moves to the newest item unless the chat has a
+// newer one already. Imported items are read, so the unread counts stay as they are; the
+// chat's preview is its item with the highest ID
+func updateChatStats(tx execer, data BulkInsertData, contactID int) error {
+    var newest time.Time
+    for _, msgData := range data.Messages {
+        if msgData.Message.Timestamp.After(newest) {
+            newest = msgData.Message.Timestamp
+        }
+    }
+    if newest.IsZero() {
+        return nil
+    }
+
+    chatTS := formatTime(newest)
+    _, err := tx.Exec("UPDATE contacts SET chat_ts = ? WHERE contact_id = ? AND (chat_ts IS NULL OR chat_ts < ?)", chatTS, contactID, chatTS)
+    if err != nil {
+        return fmt.Errorf("failed to update chat_ts: %w", err)
+    }
+    return nil
+}
+
+// Start timing a step of the inserts; the returned func stops it. What the step spent waiting
+// for the media pool is left out, that's timed on its own
+func startInsert(opts InsertOptions, name string) func() {
+    started, waited := time.Now(), opts.Media.waited()
+    return func() {
+        opts.Timings.Add(name, time.Since(started)-(opts.Media.waited()-waited))
+    }
+}
+
+// Insert messages into the contact's direct chat in one transaction, with their items,
+// deliveries, reactions and attachments. Message IDs count up from startMessageID, the messages
+// the reactions came with after the batch's own. Returns the message ID after the last one used
+func InsertMessages(ctx context.Context, db Database, messages []universal.Message, startMessageID int, opts InsertOptions) (int, error) {
+    timed := startInsert(opts, "insert: preparing")
+
+    // Start transaction (a savepoint in an atomic import's); cancelling ctx rolls it back
+    tx, err := beginChange(ctx, db, "batch")
+    if err != nil {
+        return 0, fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    prepared := newPreparedTx(tx.Tx)
+    defer prepared.Close()
+    var writer execer = prepared
+    if opts.SQLScript != nil {
+        writer = scriptTx{execer: writer, script: opts.SQLScript}
+    }
+    writer = busyRetryTx{execer: writer, ctx: ctx}
+
+    // Get starting IDs
+    var maxChatItemID int
+    err = tx.QueryRow("SELECT COALESCE(MAX(chat_item_id), 0) FROM chat_items").Scan(&maxChatItemID)
+    if err != nil {
+        return 0, fmt.Errorf("failed to get max chat_item_id: %w", err)
+    }
+    connectionID, connectionReady, err := contactConnectionID(tx, opts.ContactID)
+    if err != nil {
+        return 0, err
+    }
+    var maxDeliveryID int
+    err = tx.QueryRow("SELECT COALESCE(MAX(msg_delivery_id), 0) FROM msg_deliveries").Scan(&maxDeliveryID)
+    if err != nil {
+        return 0, fmt.Errorf("failed to get max msg_delivery_id: %w", err)
+    }
+
+    // Prepare bulk insert data
+    bulkData := BulkInsertData{
+        Messages:        make([]MessageInsertData, len(messages)),
+        StartMessageID:  startMessageID,
+        StartChatItemID:    maxChatItemID + 1,
+        StartDeliveryRowID: maxDeliveryID + 1,
+        SharedMsgIDs:       make(map[string][]byte),
+        ConnectionID:       connectionID,
+        ConnectionReady:    connectionReady,
+        Media:              opts.Media,
+    }
+
+    if _, err := writer.Exec(importedMessagesSchema); err != nil {
+        return 0, fmt.Errorf("failed to create imported_messages table: %w", err)
+    }
+
+    // Messages sent within the same instant (split attachments, rapid-fire ones the export rounds
+    // to the millisecond) would tie on created_at, which the app orders the chat by, so each goes
+    // a microsecond after the one before. That includes the chat's newest item, the end of the
+    // previous batch; item_ts keeps the real time
+    var previous time.Time
+    var lastCreatedAt sql.NullString
+    err = tx.QueryRow("SELECT created_at FROM chat_items WHERE contact_id = ? ORDER BY chat_item_id DESC LIMIT 1", opts.ContactID).Scan(&lastCreatedAt)
+    if err != nil && err != sql.ErrNoRows {
+        return 0, fmt.Errorf("failed to get the chat's newest item: %w", err)
+    }
+    if lastCreatedAt.Valid {
+        previous, _ = time.ParseInLocation(timeLayout, lastCreatedAt.String, time.UTC)
+    }
+
+    for i, msg := range messages {
+        messageID := startMessageID + i
+        chatItemID := maxChatItemID + 1 + i
+        sharedMsgID, err := newSharedMsgID()
+        if err != nil {
+            return 0, err
+        }
+
+        createdAt := msg.Timestamp
+        if !createdAt.After(previous) && previous.Sub(createdAt) < time.Second {
+            createdAt = previous.Add(time.Microsecond)
+        }
+        previous = createdAt
+
+        bulkData.Messages[i] = MessageInsertData{
+            MessageID:   messageID,
+            ChatItemID:  chatItemID,
+            SharedMsgID: sharedMsgID,
+            Message:     msg,
+            Content:     batchMsgContent(msg, opts.JSONDir, opts.Media),
+            CreatedAt:   createdAt,
+        }
+
+        // Build the mapping from source message ID to the shared_msg_id that will be stored
+        bulkData.SharedMsgIDs[msg.ID] = sharedMsgID
+    }
+
+    // Quotes name the source message; point them at the shared_msg_id it got in this batch or an
+    // earlier import, and quote its content (an image with its preview, a file by name) like the
+    // app does. Ones never imported keep the source ID and quote the text
+    batchIndex := make(map[string]int, len(messages))
+    for i, msg := range messages {
+        batchIndex[msg.ID] = i
+    }
+    for i := range bulkData.Messages {
+        quoted := bulkData.Messages[i].Message.QuotedMessage
+        if quoted == nil {
+            continue
+        }
+        quotedSharedMsgID := []byte(quoted.SourceID)
+        var quotedContent map[string]interface{}
+        var fileName string
+        if index, found := batchIndex[quoted.SourceID]; found {
+            quotedMsg := bulkData.Messages[index].Message
+            quotedSharedMsgID = bulkData.Messages[index].SharedMsgID
+            quotedContent = bulkData.Messages[index].Content
+            if len(quotedMsg.Attachments) > 0 {
+                fileName = quotedMsg.Attachments[0].Filename
+            }
+        } else {
+            item, err := importedQuote(tx, opts.ContactID, quoted.SourceID)
+            if err != nil {
+                return 0, err
+            }
+            if item != nil {
+                quotedSharedMsgID = item.SharedMsgID
+                quotedContent, fileName = item.Content, item.FileName
+            }
+        }
+        bulkData.Messages[i].QuotedSharedMsgID = quotedSharedMsgID
+        bulkData.Messages[i].QuotedContent = quoteMsgContent(quotedContent, quoted.Content, fileName)
+    }
+
+    // Forwarded messages whose original is in the chat, in this batch or an earlier import, say
+    // which item it is, from the chat with the contact
+    var contactName string
+    for i := range bulkData.Messages {
+        forwarded := bulkData.Messages[i].Message.ForwardedFrom
+        if forwarded == nil || forwarded.MessageID == "" {
+            continue
+        }
+        if index, found := batchIndex[forwarded.MessageID]; found {
+            bulkData.Messages[i].ForwardedItemID = bulkData.Messages[index].ChatItemID
+            bulkData.Messages[i].ForwardedSent = bulkData.Messages[index].Message.IsSent
+        } else {
+            item, err := importedQuote(tx, opts.ContactID, forwarded.MessageID)
+            if err != nil {
+                return 0, err
+            }
+            if item == nil {
+                continue
+            }
+            bulkData.Messages[i].ForwardedItemID, bulkData.Messages[i].ForwardedSent = item.ChatItemID, item.Sent
+        }
+        if contactName == "" {
+            err := tx.QueryRow("SELECT local_display_name FROM contacts WHERE contact_id = ?", opts.ContactID).Scan(&contactName)
+            if err != nil {
+                return 0, fmt.Errorf("failed to get the contact's name: %w", err)
+            }
+        }
+        resolved := *forwarded
+        resolved.ChatName = contactName
+        bulkData.Messages[i].Message.ForwardedFrom = &resolved
+    }
+
+    marks, err := markRowids(tx)
+    if err != nil {
+        return 0, err
+    }
+
+    timed()
+
+    // Perform bulk inserts
+    timed = startInsert(opts, "insert: messages")
+    err = bulkInsertMessages(writer, bulkData, opts.JSONDir, opts.ContactID)
+    timed()
+    if err != nil {
+        return 0, fmt.Errorf("failed to bulk insert messages: %w", err)
+    }
+
+    timed = startInsert(opts, "insert: chat_items")
+    err = bulkInsertChatItems(writer, bulkData, opts)
+    timed()
+    if err != nil {
+        return 0, fmt.Errorf("failed to bulk insert chat items: %w", err)
+    }
+
+    timed = startInsert(opts, "insert: chat_item_messages")
+    err = bulkInsertChatItemMessages(writer, bulkData)
+    timed()
+    if err != nil {
+        return 0, fmt.Errorf("failed to bulk insert chat item messages: %w", err)
+    }
+
+    timed = startInsert(opts, "insert: msg_deliveries")
+    err = bulkInsertMsgDeliveries(writer, bulkData)
+    timed()
+    if err != nil {
+        return 0, fmt.Errorf("failed to bulk insert msg deliveries: %w", err)
+    }
+
+    timed = startInsert(opts, "insert: chat_item_reactions")
+    nextMessageID, err := bulkInsertReactions(writer, bulkData, opts.ContactID, opts.ReactionEmoji)
+    timed()
+    if err != nil {
+        return 0, fmt.Errorf("failed to bulk insert reactions: %w", err)
+    }
+
+    timed = startInsert(opts, "insert: imported_messages")
+    err = recordImportedMessages(writer, bulkData, opts.ContactID)
+    timed()
+    if err != nil {
+        return 0, err
+    }
+
+    timed = startInsert(opts, "insert: contacts")
+    err = updateChatStats(writer, bulkData, opts.ContactID)
+    timed()
+    if err != nil {
+        return 0, err
+    }
+
+    // Foreign keys aren't enforced on this connection, but SimpleX refuses an archive whose
+    // database breaks them, so rows pointing at nothing stop the import here instead
+    timed = startInsert(opts, "insert: foreign key check")
+    err = checkForeignKeys(tx, marks)
+    timed()
+    if err != nil {
+        return 0, err
+    }
+
+    opts.Report.addBatch(bulkData, nextMessageID)
+
+    // A dry run ends here, the deferred rollback undoes all of it
+    if opts.DryRun {
+        return nextMessageID, nil
+    }
+
+    // Commit transaction
+    timed = startInsert(opts, "insert: commit")
+    err = tx.Commit()
+    timed()
+    if isBusy(err) {
+        return 0, &BusyError{Err: err}
+    }
+    if err != nil {
+        return 0, fmt.Errorf("failed to commit transaction: %w", err)
+    }
+
+    if opts.IDMap != nil {
+        if err := writeIDMap(opts.IDMap, bulkData); err != nil {
+            return 0, fmt.Errorf("failed to write ID map: %w", err)
+        }
+    }
+
+    return nextMessageID, nil
+}
@@ -0,0 +1,177 @@
+package simplexdb
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "io"
+    "time"
+
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// Provenance ledger: one row per import, kept in the database it went into. SimpleX ignores
+// tables it doesn't know, so the ledger travels with exports of the database
+const importRunsSchema = `CREATE TABLE IF NOT EXISTS import_runs (
+    import_run_id INTEGER PRIMARY KEY,
+    tool_version TEXT NOT NULL,
+    source_platform TEXT NOT NULL,
+    source_path TEXT NOT NULL,
+    source_sha256 TEXT NOT NULL,
+    chat_name TEXT NOT NULL,
+    contact_id INTEGER NOT NULL,
+    items INTEGER NOT NULL,
+    first_message_id INTEGER NOT NULL,
+    last_message_id INTEGER NOT NULL,
+    first_chat_item_id INTEGER NOT NULL,
+    last_chat_item_id INTEGER NOT NULL,
+    started_at TEXT NOT NULL,
+    finished_at TEXT NOT NULL
+)`
+
+// An import recorded in the import_runs table
+type ImportRun struct {
+    ID              int
+    ToolVersion     string
+    SourcePlatform  string
+    SourcePath      string // Empty when fetched from an API
+    SourceSHA256    string // Hex SHA-256 of the export file, empty when fetched from an API
+    ChatName        string
+    ContactID       int
+    Items           int
+    FirstMessageID  int
+    LastMessageID   int
+    FirstChatItemID int
+    LastChatItemID  int
+    StartedAt       time.Time
+    FinishedAt      time.Time
+}
+
+// Add an import to the ledger, creating the table the first time. With script set the
+// statements are written to it too, like InsertOptions.SQLScript
+func RecordImportRun(db Database, run ImportRun, script io.Writer) error {
+    tx, err := beginChange(context.Background(), db, "import_run")
+    if err != nil {
+        return fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    var writer execer = tx
+    if script != nil {
+        writer = scriptTx{execer: tx, script: script}
+    }
+    writer = busyRetryTx{execer: writer, ctx: context.Background()}
+
+    if _, err := writer.Exec(importRunsSchema); err != nil {
+        return fmt.Errorf("failed to create import_runs table: %w", err)
+    }
+    _, err = writer.Exec(`INSERT INTO import_runs (
+        tool_version, source_platform, source_path, source_sha256, chat_name, contact_id, items,
+        first_message_id, last_message_id, first_chat_item_id, last_chat_item_id, started_at, finished_at
+    ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+        run.ToolVersion, run.SourcePlatform, run.SourcePath, run.SourceSHA256, run.ChatName, run.ContactID, run.Items,
+        run.FirstMessageID, run.LastMessageID, run.FirstChatItemID, run.LastChatItemID,
+        run.StartedAt.UTC().Format(time.RFC3339), run.FinishedAt.UTC().Format(time.RFC3339))
+    if err != nil {
+        return fmt.Errorf("failed to record import run: %w", err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("failed to commit import run: %w", err)
+    }
+    return nil
+}
+
+// Imports recorded in the ledger, oldest first; none if nothing was ever recorded
+func ImportRuns(db *sql.DB) ([]ImportRun, error) {
+    var exists int
+    err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'import_runs'").Scan(&exists)
+    if err != nil || exists == 0 {
+        return nil, err
+    }
+
+    rows, err := db.Query(`SELECT import_run_id, tool_version, source_platform, source_path, source_sha256,
+        chat_name, contact_id, items, first_message_id, last_message_id, first_chat_item_id, last_chat_item_id,
+        started_at, finished_at
+    FROM import_runs ORDER BY import_run_id`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var runs []ImportRun
+    for rows.Next() {
+        var run ImportRun
+        var startedAt, finishedAt string
+        err := rows.Scan(&run.ID, &run.ToolVersion, &run.SourcePlatform, &run.SourcePath, &run.SourceSHA256,
+            &run.ChatName, &run.ContactID, &run.Items, &run.FirstMessageID, &run.LastMessageID,
+            &run.FirstChatItemID, &run.LastChatItemID, &startedAt, &finishedAt)
+        if err != nil {
+            return nil, err
+        }
+        run.StartedAt, _ = time.Parse(time.RFC3339, startedAt)
+        run.FinishedAt, _ = time.Parse(time.RFC3339, finishedAt)
+        runs = append(runs, run)
+    }
+    return runs, rows.Err()
+}
+
+// Leave out messages older than the newest chat item an earlier recorded import put into the
+// contact's chat, for topping up the history from a newer export. Messages from that same
+// time are kept for SkipImported to sort out. Returns the rest and how many were left out
+func SinceLastImport(db *sql.DB, contactID int, messages []universal.Message) ([]universal.Message, int, error) {
+    runs, err := ImportRuns(db)
+    if err != nil {
+        return nil, 0, fmt.Errorf("failed to read import runs: %w", err)
+    }
+
+    found := false
+    for _, run := range runs {
+        found = found || run.ContactID == contactID
+    }
+    if !found {
+        return nil, 0, fmt.Errorf("no earlier import into this chat is recorded in import_runs")
+    }
+
+    // The imported items themselves, by imported_messages: -merge renumber widens the ranges of
+    // import_runs so they take in native items too
+    var newest sql.NullString
+    var imported int
+    if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'imported_messages'").Scan(&imported); err != nil {
+        return nil, 0, fmt.Errorf("failed to read imported messages: %w", err)
+    }
+    if imported != 0 {
+        err := db.QueryRow("SELECT MAX(ci.item_ts) FROM imported_messages im JOIN chat_items ci USING (chat_item_id) WHERE im.contact_id = ?",
+            contactID).Scan(&newest)
+        if err != nil {
+            return nil, 0, fmt.Errorf("failed to find the newest imported message: %w", err)
+        }
+    }
+
+    // Imports of versions that didn't record imported_messages only have the ranges
+    if !newest.Valid {
+        for _, run := range runs {
+            if run.ContactID != contactID {
+                continue
+            }
+            var itemTS sql.NullString
+            err := db.QueryRow("SELECT MAX(item_ts) FROM chat_items WHERE contact_id = ? AND chat_item_id BETWEEN ? AND ?",
+                contactID, run.FirstChatItemID, run.LastChatItemID).Scan(&itemTS)
+            if err != nil {
+                return nil, 0, fmt.Errorf("failed to find the newest imported message: %w", err)
+            }
+            if itemTS.Valid && itemTS.String > newest.String {
+                newest = itemTS
+            }
+        }
+    }
+
+    // item_ts is written by formatTime, so the strings order like the times
+    kept := make([]universal.Message, 0, len(messages))
+    for _, msg := range messages {
+        if formatTime(msg.Timestamp) >= newest.String {
+            kept = append(kept, msg)
+        }
+    }
+    return kept, len(messages) - len(kept), nil
+}
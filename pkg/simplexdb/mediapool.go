@@ -0,0 +1,258 @@
+package simplexdb
+
+import (
+    "sync"
+    "time"
+
+    "github.com/ritiek/discord-to-simplex/pkg/media"
+    "github.com/ritiek/discord-to-simplex/pkg/timing"
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// Workers that make the previews, thumbnails and durations of attachments and copy them into
+// the SimpleX files directory ahead of the inserts: while one batch is written, the media of the
+// next is made on all cores, and the inserts only pick up what's done
+type MediaPool struct {
+    jsonDir      string
+    filesDir     string
+    encryptFiles bool
+    copyFiles    bool
+    cache        *media.ResultCache
+    timings      *timing.Timings
+
+    jobs     chan *mediaJob
+    workers  sync.WaitGroup
+    senders  sync.WaitGroup
+    lastSent chan struct{} // Closed once the batch queued last is all handed to the workers
+}
+
+type mediaKind int
+
+const (
+    mediaImagePreview mediaKind = iota
+    mediaVideoThumbnail
+    mediaAudioDuration
+    mediaCopy
+)
+
+// Phases the workers' time is added to, by kind of job
+var mediaPhases = map[mediaKind]string{
+    mediaImagePreview:   "media: image previews",
+    mediaVideoThumbnail: "media: video thumbnails",
+    mediaAudioDuration:  "media: audio durations",
+    mediaCopy:           "media: copying files",
+}
+
+type mediaKey struct {
+    kind mediaKind
+    path string
+}
+
+// One preview, thumbnail, duration or copy, done once its channel is closed
+type mediaJob struct {
+    key      mediaKey
+    filename string // Name copies ask for in the files directory
+    done     chan struct{}
+    used     bool // A copy went to an attachment already; the next one of the same file copies it again
+
+    preview     string
+    duration    int
+    copiedName  string // Name the copy got, which differs from filename when that was taken
+    cryptoKey   []byte
+    cryptoNonce []byte
+    err         error
+}
+
+// Media of one batch, being made by a MediaPool. A nil *BatchMedia makes everything when it's
+// asked for, like an import without a pool
+type BatchMedia struct {
+    jobs      map[mediaKey]*mediaJob
+    cache     *media.ResultCache
+    timings   *timing.Timings
+    waitedFor time.Duration // Inserts spent waiting for jobs that weren't done yet
+}
+
+// Start workers for attachments of an export in jsonDir; with copyFiles they're also copied
+// (encrypted with encryptFiles) into filesDir. Previews and durations go through cache, and the
+// time the workers take is added to timings; both may be nil
+func NewMediaPool(workers int, jsonDir, filesDir string, encryptFiles, copyFiles bool, cache *media.ResultCache, timings *timing.Timings) *MediaPool {
+    if workers < 1 {
+        workers = 1
+    }
+    p := &MediaPool{
+        jsonDir:      jsonDir,
+        filesDir:     filesDir,
+        encryptFiles: encryptFiles,
+        copyFiles:    copyFiles,
+        cache:        cache,
+        timings:      timings,
+        jobs:         make(chan *mediaJob),
+    }
+    for i := 0; i < workers; i++ {
+        p.workers.Add(1)
+        go func() {
+            defer p.workers.Done()
+            for job := range p.jobs {
+                p.run(job)
+            }
+        }()
+    }
+    return p
+}
+
+// Queue the media of the messages' attachments and link previews and return right away; the
+// inserts of the batch wait for what isn't done yet when they get to it
+func (p *MediaPool) Prefetch(messages []universal.Message) *BatchMedia {
+    batch := &BatchMedia{jobs: make(map[mediaKey]*mediaJob), cache: p.cache, timings: p.timings}
+    var queue []*mediaJob
+    add := func(kind mediaKind, path, filename string) {
+        key := mediaKey{kind, path}
+        if _, ok := batch.jobs[key]; ok {
+            return
+        }
+        job := &mediaJob{key: key, filename: filename, done: make(chan struct{})}
+        batch.jobs[key] = job
+        queue = append(queue, job)
+    }
+
+    for _, msg := range messages {
+        if len(msg.Attachments) > 0 {
+            attachment := msg.Attachments[0]
+            path := universal.ResolveExportPath(p.jsonDir, attachment.URL)
+            switch msg.MessageType {
+            case "image":
+                add(mediaImagePreview, path, "")
+            case "video":
+                add(mediaVideoThumbnail, path, "")
+            case "voice":
+                add(mediaAudioDuration, path, "")
+            }
+            if p.copyFiles {
+                add(mediaCopy, path, attachment.Filename)
+            }
+        } else if msg.LinkPreview != nil {
+            add(mediaImagePreview, universal.ResolveExportPath(p.jsonDir, msg.LinkPreview.ImageURL), "")
+        }
+    }
+
+    // Batches go to the workers one after the other, so the media of the batch inserted next is
+    // made before that of the ones after it
+    previous, sent := p.lastSent, make(chan struct{})
+    p.lastSent = sent
+    p.senders.Add(1)
+    go func() {
+        defer p.senders.Done()
+        defer close(sent)
+        if previous != nil {
+            <-previous
+        }
+        for _, job := range queue {
+            p.jobs <- job
+        }
+    }()
+    return batch
+}
+
+func (p *MediaPool) run(job *mediaJob) {
+    defer close(job.done)
+    started := time.Now()
+    defer func() {
+        p.timings.AddParallel(mediaPhases[job.key.kind], time.Since(started))
+    }()
+
+    switch job.key.kind {
+    case mediaImagePreview:
+        job.preview, job.err = p.cache.ImagePreview(job.key.path)
+    case mediaVideoThumbnail:
+        job.preview, job.duration, job.err = p.cache.VideoThumbnail(job.key.path)
+    case mediaAudioDuration:
+        job.duration, job.err = p.cache.AudioDuration(job.key.path)
+    case mediaCopy:
+        job.copiedName, job.cryptoKey, job.cryptoNonce, job.err = copyAttachment(job.key.path, job.filename, p.filesDir, p.encryptFiles)
+    }
+}
+
+// Wait for the work queued so far and stop the workers
+func (p *MediaPool) Close() {
+    p.senders.Wait()
+    close(p.jobs)
+    p.workers.Wait()
+}
+
+// Job of the batch for key, finished, or nil when the batch didn't queue it
+func (b *BatchMedia) wait(kind mediaKind, path string) *mediaJob {
+    if b == nil {
+        return nil
+    }
+    job, ok := b.jobs[mediaKey{kind, path}]
+    if !ok {
+        return nil
+    }
+    select {
+    case <-job.done:
+    default:
+        started := time.Now()
+        <-job.done
+        waited := time.Since(started)
+        b.waitedFor += waited
+        b.timings.Add("insert: waiting for media", waited)
+    }
+    return job
+}
+
+// Time the inserts of the batch spent waiting for its media so far
+func (b *BatchMedia) waited() time.Duration {
+    if b == nil {
+        return 0
+    }
+    return b.waitedFor
+}
+
+// Cache of the pool the batch came from, nil without one
+func (b *BatchMedia) resultCache() *media.ResultCache {
+    if b == nil {
+        return nil
+    }
+    return b.cache
+}
+
+func (b *BatchMedia) imagePreview(path string) (string, error) {
+    if job := b.wait(mediaImagePreview, path); job != nil {
+        return job.preview, job.err
+    }
+    return b.resultCache().ImagePreview(path)
+}
+
+func (b *BatchMedia) videoThumbnail(path string) (string, int, error) {
+    if job := b.wait(mediaVideoThumbnail, path); job != nil {
+        return job.preview, job.duration, job.err
+    }
+    return b.resultCache().VideoThumbnail(path)
+}
+
+func (b *BatchMedia) audioDuration(path string) (int, error) {
+    if job := b.wait(mediaAudioDuration, path); job != nil {
+        return job.duration, job.err
+    }
+    return b.resultCache().AudioDuration(path)
+}
+
+// Copy an attachment into the files directory, or pick up the copy the pool made of it. Each
+// attachment gets a copy of its own, so deleting one item's file in the app leaves the others
+func (b *BatchMedia) copyAttachment(path, filename, filesDir string, encryptFiles bool) (string, []byte, []byte, error) {
+    if job := b.wait(mediaCopy, path); job != nil && job.filename == filename && !job.used {
+        job.used = true
+        return job.copiedName, job.cryptoKey, job.cryptoNonce, job.err
+    }
+    return copyAttachment(path, filename, filesDir, encryptFiles)
+}
+
+// Copy an attachment into the files directory, encrypted with encryptFiles, returning the name
+// the copy got; the key and nonce are nil for a plain copy
+func copyAttachment(path, filename, filesDir string, encryptFiles bool) (string, []byte, []byte, error) {
+    if encryptFiles {
+        return encryptFileToSimplexDir(path, filename, filesDir)
+    }
+    name, err := copyFileToSimplexDir(path, filename, filesDir)
+    return name, nil, nil, err
+}
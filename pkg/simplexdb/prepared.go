@@ -0,0 +1,34 @@
+package simplexdb
+
+import "database/sql"
+
+// Transaction that prepares every statement the first time it runs and reuses it after, so
+// the single-row INSERTs of a batch are compiled once per table instead of once per row
+type preparedTx struct {
+    *sql.Tx
+    stmts map[string]*sql.Stmt
+}
+
+func newPreparedTx(tx *sql.Tx) *preparedTx {
+    return &preparedTx{Tx: tx, stmts: make(map[string]*sql.Stmt)}
+}
+
+func (t *preparedTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+    stmt, ok := t.stmts[query]
+    if !ok {
+        var err error
+        stmt, err = t.Tx.Prepare(query)
+        if err != nil {
+            return nil, err
+        }
+        t.stmts[query] = stmt
+    }
+    return stmt.Exec(args...)
+}
+
+// Close the prepared statements; in an atomic import the transaction outlives the batch
+func (t *preparedTx) Close() {
+    for _, stmt := range t.stmts {
+        stmt.Close()
+    }
+}
@@ -0,0 +1,76 @@
+package simplexdb
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "time"
+)
+
+// What an import fills in of a contact's profile from the chat's source; empty fields are left
+// alone
+type ContactProfile struct {
+    DisplayName string
+    FullName    string
+    Image       string // Base64 data URI, only set when the profile has no image
+}
+
+// Fill in the contact's profile, so the app shows the chat the way it looked on the source
+// platform. The contact's local display name, which -contact and the terminal app address it
+// by, stays as it is. With script set the statements are written to it too, like
+// InsertOptions.SQLScript. Returns the fields that changed: "displayName", "fullName", "image"
+func UpdateContactProfile(db Database, contactID int, profile ContactProfile, script io.Writer) ([]string, error) {
+    tx, err := beginChange(context.Background(), db, "contact_profile")
+    if err != nil {
+        return nil, fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    var writer execer = tx
+    if script != nil {
+        writer = scriptTx{execer: tx, script: script}
+    }
+    writer = busyRetryTx{execer: writer, ctx: context.Background()}
+
+    updates := []struct {
+        field, column, value string
+        onlyEmpty            bool
+    }{
+        {"displayName", "display_name", profile.DisplayName, false},
+        {"fullName", "full_name", profile.FullName, false},
+        {"image", "image", profile.Image, true},
+    }
+    var changed []string
+    for _, update := range updates {
+        if update.value == "" {
+            continue
+        }
+        condition := update.column + " IS NOT ?"
+        if update.onlyEmpty {
+            condition = "(" + update.column + " IS NULL OR " + update.column + " = '')"
+        }
+        query := fmt.Sprintf(`UPDATE contact_profiles SET %s = ?, updated_at = ?
+                              WHERE contact_profile_id = (SELECT contact_profile_id FROM contacts WHERE contact_id = ?)
+                              AND %s`, update.column, condition)
+        args := []interface{}{update.value, formatTime(time.Now()), contactID}
+        if !update.onlyEmpty {
+            args = append(args, update.value)
+        }
+        result, err := writer.Exec(query, args...)
+        if err != nil {
+            return nil, fmt.Errorf("failed to update the contact's profile: %w", err)
+        }
+        updated, err := result.RowsAffected()
+        if err != nil {
+            return nil, fmt.Errorf("failed to update the contact's profile: %w", err)
+        }
+        if updated > 0 {
+            changed = append(changed, update.field)
+        }
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, fmt.Errorf("failed to commit the contact's profile: %w", err)
+    }
+    return changed, nil
+}
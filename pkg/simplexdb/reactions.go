@@ -0,0 +1,298 @@
+package simplexdb
+
+import (
+    "context"
+    "database/sql"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "time"
+
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+)
+
+// A reaction as SimpleX stores it in chat_item_reactions and sends it in x.msg.react, with the
+// fields in the app's order
+type emojiReaction struct {
+    Type  string `json:"type"`
+    Emoji string `json:"emoji"`
+}
+
+// Writes reactions the way the app stores one it sent or received: the chat_item_reactions row
+// with created_by_msg_id pointing at the x.msg.react message that carried it, and that message's
+// delivery when the connection is ready. Message, delivery and reaction IDs count up from where
+// it's started
+type reactionWriter struct {
+    tx           execer
+    contactID    int
+    emojiMode    string
+    connectionID int
+    deliveries   *deliveryWriter // nil while the connection isn't ready
+
+    messageQuery    string
+    messageColumns  []string
+    messageTemplate map[string]interface{}
+
+    nextReactionID int
+    nextMessageID  int
+    nextDeliveryID int
+    added          int
+}
+
+func newReactionWriter(tx execer, contactID int, emojiMode string, connectionID int, connectionReady bool, nextMessageID, nextDeliveryID int) (*reactionWriter, error) {
+    w := &reactionWriter{
+        tx:             tx,
+        contactID:      contactID,
+        emojiMode:      emojiMode,
+        connectionID:   connectionID,
+        nextMessageID:  nextMessageID,
+        nextDeliveryID: nextDeliveryID,
+    }
+    err := tx.QueryRow("SELECT COALESCE(MAX(chat_item_reaction_id), 0) + 1 FROM chat_item_reactions").Scan(&w.nextReactionID)
+    if err != nil {
+        return nil, fmt.Errorf("failed to get next reaction ID: %w", err)
+    }
+
+    w.messageTemplate, err = getTemplateRow(tx, "messages", "message_id")
+    if err != nil {
+        return nil, fmt.Errorf("failed to get template row: %w", err)
+    }
+    w.messageColumns, err = getTableColumns(tx, "messages")
+    if err != nil {
+        return nil, err
+    }
+    w.messageQuery = insertQuery("messages", w.messageColumns)
+
+    if connectionReady {
+        if w.deliveries, err = newDeliveryWriter(tx); err != nil {
+            return nil, err
+        }
+    }
+    return w, nil
+}
+
+// Add reactions to the item with sharedMsgID. Discord doesn't say who reacted in a way that maps
+// onto the chat, so the contact reacted to the user's messages and the user to the contact's.
+// Emoji that only differ by skin tone and such end up as one reaction. With existing set, ones
+// the item has already (the same emoji from the same side) are left out, like the app ignores
+// a reaction that's there
+func (w *reactionWriter) add(sharedMsgID []byte, itemSent bool, reactions []universal.Reaction, ts, createdAt time.Time, existing bool) error {
+    reactionSent := 0
+    if !itemSent {
+        reactionSent = 1
+    }
+
+    reacted := make(map[string]bool)
+    for _, reaction := range reactions {
+        normalizedEmoji, ok := NormalizeEmoji(reaction.Emoji, w.emojiMode)
+        if !ok || reacted[normalizedEmoji] {
+            continue
+        }
+        reacted[normalizedEmoji] = true
+
+        // Create SimpleX format reaction JSON
+        msgReaction := emojiReaction{Type: "emoji", Emoji: normalizedEmoji}
+        reactionBytes, err := json.Marshal(msgReaction)
+        if err != nil {
+            return fmt.Errorf("failed to marshal reaction: %w", err)
+        }
+        reactionJSON := string(reactionBytes)
+
+        if existing {
+            var found int
+            err := w.tx.QueryRow("SELECT COUNT(*) FROM chat_item_reactions WHERE contact_id = ? AND shared_msg_id = ? AND reaction = ? AND reaction_sent = ?",
+                w.contactID, sharedMsgID, reactionJSON, reactionSent).Scan(&found)
+            if err != nil {
+                return fmt.Errorf("failed to look up reactions: %w", err)
+            }
+            if found > 0 {
+                continue
+            }
+        }
+
+        messageID := w.nextMessageID
+        if err := w.insertMessage(messageID, sharedMsgID, msgReaction, reactionSent == 1, createdAt); err != nil {
+            return err
+        }
+        w.nextMessageID++
+        if w.deliveries != nil {
+            if err := w.deliveries.insert(w.nextDeliveryID, messageID, w.connectionID, reactionSent == 1, ts, createdAt); err != nil {
+                return err
+            }
+            w.nextDeliveryID++
+        }
+
+        _, err = w.tx.Exec(`
+            INSERT INTO chat_item_reactions (
+                chat_item_reaction_id,
+                shared_msg_id,
+                contact_id,
+                created_by_msg_id,
+                reaction,
+                reaction_sent,
+                reaction_ts,
+                created_at,
+                updated_at
+            ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+        `, w.nextReactionID, sharedMsgID, w.contactID, messageID, reactionJSON, reactionSent, formatTime(ts), formatTime(createdAt), formatTime(createdAt))
+        if err != nil {
+            return fmt.Errorf("failed to insert reaction: %w", err)
+        }
+        w.nextReactionID++
+        w.added++
+    }
+    return nil
+}
+
+// The x.msg.react message a reaction to the item with sharedMsgID came in or went out with
+func (w *reactionWriter) insertMessage(messageID int, itemSharedMsgID []byte, reaction emojiReaction, sent bool, createdAt time.Time) error {
+    sharedMsgID, err := newSharedMsgID()
+    if err != nil {
+        return err
+    }
+    msgBody, err := json.Marshal(map[string]interface{}{
+        "v":     "1-14",
+        "msgId": base64.StdEncoding.EncodeToString(sharedMsgID),
+        "event": "x.msg.react",
+        "params": map[string]interface{}{
+            "msgId":    base64.StdEncoding.EncodeToString(itemSharedMsgID),
+            "reaction": reaction,
+            "add":      true,
+        },
+    })
+    if err != nil {
+        return fmt.Errorf("failed to marshal reaction message body: %w", err)
+    }
+
+    msgSent := 0
+    if sent {
+        msgSent = 1
+    }
+    overrideFields := map[string]interface{}{
+        "message_id":     messageID,
+        "chat_msg_event": "x.msg.react",
+        "shared_msg_id":  sharedMsgID,
+        "msg_body":       msgBody,
+        "msg_sent":       msgSent,
+        "created_at":     formatTime(createdAt),
+        "updated_at":     formatTime(createdAt),
+    }
+    rowValues := make([]interface{}, len(w.messageColumns))
+    for k, col := range w.messageColumns {
+        if val, override := overrideFields[col]; override {
+            rowValues[k] = val
+        } else {
+            rowValues[k] = w.messageTemplate[col]
+        }
+    }
+    if _, err := w.tx.Exec(w.messageQuery, rowValues...); err != nil {
+        return fmt.Errorf("failed to insert the message of a reaction: %w", err)
+    }
+    return nil
+}
+
+// Reactions of the batch's messages, on the items just inserted
+func bulkInsertReactions(tx execer, data BulkInsertData, contactID int, emojiMode string) (int, error) {
+    next := data.StartMessageID + len(data.Messages)
+    hasReactions := false
+    for _, msgData := range data.Messages {
+        hasReactions = hasReactions || len(msgData.Message.Reactions) > 0
+    }
+    if !hasReactions {
+        return next, nil
+    }
+
+    reactions, err := newReactionWriter(tx, contactID, emojiMode, data.ConnectionID, data.ConnectionReady, next, data.StartDeliveryRowID+len(data.Messages))
+    if err != nil {
+        return 0, err
+    }
+    for _, msgData := range data.Messages {
+        msg := msgData.Message
+        if err := reactions.add(msgData.SharedMsgID, msg.IsSent, msg.Reactions, msg.Timestamp, msgData.CreatedAt, false); err != nil {
+            return 0, err
+        }
+    }
+    return reactions.nextMessageID, nil
+}
+
+// Add the reactions of messages imported before, which SkipImported left out, to their items,
+// leaving out the ones an item has already, so importing a newer export picks up the reactions
+// added since without doubling the others. The messages the reactions come with get IDs from
+// startMessageID on, like InsertMessages'. Returns the message ID after the last one used and
+// how many reactions were added
+func AddReactions(ctx context.Context, db Database, messages []universal.Message, startMessageID int, opts InsertOptions) (int, int, error) {
+    tx, err := beginChange(ctx, db, "reactions")
+    if err != nil {
+        return 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    var writer execer = tx
+    if opts.SQLScript != nil {
+        writer = scriptTx{execer: tx, script: opts.SQLScript}
+    }
+    writer = busyRetryTx{execer: writer, ctx: ctx}
+
+    connectionID, connectionReady, err := contactConnectionID(tx, opts.ContactID)
+    if err != nil {
+        return 0, 0, err
+    }
+    var nextDeliveryID int
+    if err := tx.QueryRow("SELECT COALESCE(MAX(msg_delivery_id), 0) + 1 FROM msg_deliveries").Scan(&nextDeliveryID); err != nil {
+        return 0, 0, fmt.Errorf("failed to get max msg_delivery_id: %w", err)
+    }
+    reactions, err := newReactionWriter(writer, opts.ContactID, opts.ReactionEmoji, connectionID, connectionReady, startMessageID, nextDeliveryID)
+    if err != nil {
+        return 0, 0, err
+    }
+
+    var imported int
+    if err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'imported_messages'").Scan(&imported); err != nil {
+        return 0, 0, fmt.Errorf("failed to read imported messages: %w", err)
+    }
+    // The item a message went into: by imported_messages, or by its source ID as earlier
+    // versions stored it in shared_msg_id
+    query := "SELECT shared_msg_id, item_sent, created_at FROM chat_items WHERE contact_id = ? AND shared_msg_id = CAST(? AS BLOB)"
+    if imported != 0 {
+        query += ` UNION ALL SELECT ci.shared_msg_id, ci.item_sent, ci.created_at FROM imported_messages im
+                   JOIN chat_items ci ON ci.contact_id = im.contact_id AND ci.shared_msg_id = im.shared_msg_id
+                   WHERE im.contact_id = ? AND im.source_id = ?`
+    }
+    query += " LIMIT 1"
+
+    for _, msg := range messages {
+        if len(msg.Reactions) == 0 {
+            continue
+        }
+        args := []interface{}{opts.ContactID, msg.ID}
+        if imported != 0 {
+            args = append(args, opts.ContactID, msg.ID)
+        }
+        var sharedMsgID []byte
+        var itemSent bool
+        var createdAt string
+        err := tx.QueryRow(query, args...).Scan(&sharedMsgID, &itemSent, &createdAt)
+        if err == sql.ErrNoRows {
+            continue
+        }
+        if err != nil {
+            return 0, 0, fmt.Errorf("failed to find the item of message %s: %w", msg.ID, err)
+        }
+        itemCreatedAt, err := time.ParseInLocation(timeLayout, createdAt, time.UTC)
+        if err != nil {
+            itemCreatedAt = msg.Timestamp
+        }
+        if err := reactions.add(sharedMsgID, itemSent, msg.Reactions, msg.Timestamp, itemCreatedAt, true); err != nil {
+            return 0, 0, err
+        }
+    }
+
+    // A dry run ends here, the deferred rollback undoes all of it
+    if opts.DryRun {
+        return reactions.nextMessageID, reactions.added, nil
+    }
+    if err := tx.Commit(); err != nil {
+        return 0, 0, fmt.Errorf("failed to commit reactions: %w", err)
+    }
+    return reactions.nextMessageID, reactions.added, nil
+}
@@ -0,0 +1,216 @@
+package simplexdb
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "io"
+    "strings"
+    "time"
+)
+
+// Ways to merge an import into a chat that already has newer messages
+const (
+    MergeAppend   = "append"   // Give imported items the next free IDs, after everything
+    MergeRenumber = "renumber" // Renumber the chat's items from the oldest imported one in time order
+)
+
+// How many items of the contact's chat are newer than since, which items imported with that time
+// would otherwise get lower IDs than. The app shows the chat item with the highest ID as the
+// chat's last message in the chat list
+func NewerItems(db *sql.DB, contactID int, since time.Time) (int, error) {
+    var newer int
+    err := db.QueryRow("SELECT COUNT(*) FROM chat_items WHERE contact_id = ? AND item_ts > ?",
+        contactID, formatTime(since)).Scan(&newer)
+    if err != nil {
+        return 0, fmt.Errorf("failed to look for newer chat items: %w", err)
+    }
+    return newer, nil
+}
+
+// Give the items of the contact's chat from the oldest one an import put between firstChatItemID
+// and lastChatItemID on new IDs above all others, in order of their time, so items imported into
+// a chat with newer history interleave with it by ID as well. Every column referring to a chat
+// item (files, chat_item_messages, imported_messages and the like) follows, and the chat item
+// ranges in import_runs are widened to where their items went. Does nothing when the IDs are in
+// order already. With script set the statements are written to it too, like
+// InsertOptions.SQLScript. Returns how many items got a new ID
+func RenumberByTime(db Database, contactID, firstChatItemID, lastChatItemID int, script io.Writer) (int, error) {
+    tx, err := beginChange(context.Background(), db, "renumber")
+    if err != nil {
+        return 0, fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    var writer execer = tx
+    if script != nil {
+        writer = scriptTx{execer: tx, script: script}
+    }
+    writer = busyRetryTx{execer: writer, ctx: context.Background()}
+
+    var since sql.NullString
+    err = tx.QueryRow("SELECT MIN(item_ts) FROM chat_items WHERE contact_id = ? AND chat_item_id BETWEEN ? AND ?",
+        contactID, firstChatItemID, lastChatItemID).Scan(&since)
+    if err != nil {
+        return 0, fmt.Errorf("failed to find the oldest imported item: %w", err)
+    }
+    if !since.Valid {
+        return 0, nil
+    }
+
+    rows, err := tx.Query("SELECT chat_item_id FROM chat_items WHERE contact_id = ? AND item_ts >= ? ORDER BY item_ts, created_at, chat_item_id",
+        contactID, since.String)
+    if err != nil {
+        return 0, fmt.Errorf("failed to read chat items: %w", err)
+    }
+    var ids []int
+    for rows.Next() {
+        var id int
+        if err := rows.Scan(&id); err != nil {
+            rows.Close()
+            return 0, fmt.Errorf("failed to read chat items: %w", err)
+        }
+        ids = append(ids, id)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return 0, fmt.Errorf("failed to read chat items: %w", err)
+    }
+
+    inOrder := true
+    for i := 1; i < len(ids); i++ {
+        if ids[i] < ids[i-1] {
+            inOrder = false
+            break
+        }
+    }
+    if inOrder {
+        return 0, nil
+    }
+
+    columns, err := chatItemReferences(tx)
+    if err != nil {
+        return 0, err
+    }
+
+    // New IDs are above every existing one, so none clashes with an item not yet moved
+    var maxChatItemID int
+    if err := tx.QueryRow("SELECT COALESCE(MAX(chat_item_id), 0) FROM chat_items").Scan(&maxChatItemID); err != nil {
+        return 0, fmt.Errorf("failed to get max chat_item_id: %w", err)
+    }
+    statements := []string{
+        "PRAGMA defer_foreign_keys = ON",
+        "CREATE TEMP TABLE chat_item_renumber (old_id INTEGER PRIMARY KEY, new_id INTEGER NOT NULL)",
+    }
+    for _, statement := range statements {
+        if _, err := writer.Exec(statement); err != nil {
+            return 0, fmt.Errorf("failed to prepare renumbering: %w", err)
+        }
+    }
+    for i, id := range ids {
+        if _, err := writer.Exec("INSERT INTO temp.chat_item_renumber (old_id, new_id) VALUES (?, ?)", id, maxChatItemID+1+i); err != nil {
+            return 0, fmt.Errorf("failed to prepare renumbering: %w", err)
+        }
+    }
+
+    for _, column := range append(columns, [2]string{"chat_items", "chat_item_id"}) {
+        _, err := writer.Exec(fmt.Sprintf(`UPDATE %[1]s SET %[2]s = (SELECT new_id FROM temp.chat_item_renumber WHERE old_id = %[1]s.%[2]s)
+                                           WHERE %[2]s IN (SELECT old_id FROM temp.chat_item_renumber)`, column[0], column[1]))
+        if err != nil {
+            return 0, fmt.Errorf("failed to renumber %s.%s: %w", column[0], column[1], err)
+        }
+    }
+
+    // A run's range covers the new IDs of its items, which are now spread out
+    var ledger int
+    if err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'import_runs'").Scan(&ledger); err != nil {
+        return 0, fmt.Errorf("failed to read import runs: %w", err)
+    }
+    if ledger != 0 {
+        _, err := writer.Exec(`UPDATE import_runs SET
+            first_chat_item_id = MIN(first_chat_item_id, COALESCE((SELECT MIN(new_id) FROM temp.chat_item_renumber WHERE old_id BETWEEN first_chat_item_id AND last_chat_item_id), first_chat_item_id)),
+            last_chat_item_id = MAX(last_chat_item_id, COALESCE((SELECT MAX(new_id) FROM temp.chat_item_renumber WHERE old_id BETWEEN first_chat_item_id AND last_chat_item_id), last_chat_item_id))
+        WHERE contact_id = ?`, contactID)
+        if err != nil {
+            return 0, fmt.Errorf("failed to update import runs: %w", err)
+        }
+    }
+
+    if _, err := writer.Exec("DROP TABLE temp.chat_item_renumber"); err != nil {
+        return 0, fmt.Errorf("failed to finish renumbering: %w", err)
+    }
+    if err := tx.Commit(); err != nil {
+        return 0, fmt.Errorf("failed to commit renumbering: %w", err)
+    }
+    return len(ids), nil
+}
+
+// Table and column of everything that refers to a chat_item_id: foreign keys to chat_items and
+// columns named like one, which imported_messages and some of SimpleX's own tables use without a
+// foreign key. import_runs ranges aren't references and are left out
+func chatItemReferences(querier Querier) ([][2]string, error) {
+    tables, err := tableNames(querier)
+    if err != nil {
+        return nil, err
+    }
+
+    var references [][2]string
+    seen := make(map[[2]string]bool)
+    add := func(table, column string) {
+        key := [2]string{table, column}
+        if !seen[key] && !(table == "chat_items" && column == "chat_item_id") {
+            seen[key] = true
+            references = append(references, key)
+        }
+    }
+    for _, table := range tables {
+        if table == "import_runs" {
+            continue
+        }
+        columns, err := getTableColumns(querier, table)
+        if err != nil {
+            return nil, err
+        }
+        for _, column := range columns {
+            if column == "chat_item_id" || strings.HasSuffix(column, "_chat_item_id") {
+                add(table, column)
+            }
+        }
+
+        rows, err := querier.Query(fmt.Sprintf("SELECT \"table\", \"from\" FROM pragma_foreign_key_list('%s')", table))
+        if err != nil {
+            return nil, fmt.Errorf("failed to read foreign keys of %s: %w", table, err)
+        }
+        for rows.Next() {
+            var parent, column string
+            if err := rows.Scan(&parent, &column); err != nil {
+                rows.Close()
+                return nil, fmt.Errorf("failed to read foreign keys of %s: %w", table, err)
+            }
+            if parent == "chat_items" {
+                add(table, column)
+            }
+        }
+        rows.Close()
+    }
+    return references, nil
+}
+
+// Names of the database's own tables
+func tableNames(querier Querier) ([]string, error) {
+    rows, err := querier.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+    if err != nil {
+        return nil, fmt.Errorf("failed to list tables: %w", err)
+    }
+    defer rows.Close()
+
+    var tables []string
+    for rows.Next() {
+        var table string
+        if err := rows.Scan(&table); err != nil {
+            return nil, fmt.Errorf("failed to list tables: %w", err)
+        }
+        tables = append(tables, table)
+    }
+    return tables, rows.Err()
+}
@@ -0,0 +1,316 @@
+package simplexdb
+
+import (
+    "database/sql"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/ritiek/discord-to-simplex/pkg/universal"
+
+    _ "github.com/xeodou/go-sqlcipher"
+)
+
+// The parts of the chat database RenumberByTime touches: chat items, a table referring to them
+// by foreign key, one by column name only and the import ledger
+var renumberSchema = []string{
+    `CREATE TABLE chat_items (
+        chat_item_id INTEGER PRIMARY KEY,
+        contact_id INTEGER NOT NULL,
+        item_ts TEXT NOT NULL,
+        created_at TEXT NOT NULL
+    )`,
+    `CREATE TABLE files (
+        file_id INTEGER PRIMARY KEY,
+        chat_item_id INTEGER REFERENCES chat_items ON DELETE CASCADE
+    )`,
+    importedMessagesSchema,
+    importRunsSchema,
+}
+
+type renumberItem struct {
+    id        int
+    contactID int
+    minute    int // item_ts, in minutes after the start
+}
+
+func (item renumberItem) time() time.Time {
+    return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Add(time.Duration(item.minute) * time.Minute)
+}
+
+func (item renumberItem) ts() string {
+    return formatTime(item.time())
+}
+
+// Unencrypted database in the test's temp directory with the tables of schema, on a single
+// connection so the foreign_keys pragma holds for every statement
+func testDatabase(t *testing.T, schema []string) *sql.DB {
+    t.Helper()
+    db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "chat.db"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { db.Close() })
+    db.SetMaxOpenConns(1)
+
+    statements := append([]string{"PRAGMA foreign_keys = ON"}, schema...)
+    for _, statement := range statements {
+        if _, err := db.Exec(statement); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return db
+}
+
+// Chat database with the items, each with a file and an imported_messages row of its own ID, and
+// an import run of each range
+func renumberFixture(t *testing.T, items []renumberItem, runs [][2]int) *sql.DB {
+    t.Helper()
+    db := testDatabase(t, renumberSchema)
+    for _, item := range items {
+        ts := item.ts()
+        if _, err := db.Exec("INSERT INTO chat_items (chat_item_id, contact_id, item_ts, created_at) VALUES (?, ?, ?, ?)", item.id, item.contactID, ts, ts); err != nil {
+            t.Fatal(err)
+        }
+        if _, err := db.Exec("INSERT INTO files (file_id, chat_item_id) VALUES (?, ?)", item.id, item.id); err != nil {
+            t.Fatal(err)
+        }
+        if _, err := db.Exec("INSERT INTO imported_messages (contact_id, source_id, shared_msg_id, chat_item_id) VALUES (?, ?, ?, ?)", item.contactID, item.id, []byte{byte(item.id)}, item.id); err != nil {
+            t.Fatal(err)
+        }
+    }
+    for _, run := range runs {
+        err := RecordImportRun(db, ImportRun{ContactID: 1, FirstChatItemID: run[0], LastChatItemID: run[1], Items: run[1] - run[0] + 1}, nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    return db
+}
+
+func TestRenumberByTime(t *testing.T) {
+    tests := []struct {
+        name     string
+        items    []renumberItem
+        first    int
+        last     int
+        atomic   bool        // Renumber in the transaction of an atomic import
+        want     int         // Items that get a new ID
+        newIDs   map[int]int // Where items went, the others keep their ID
+        runs     [][2]int
+        wantRuns [][2]int
+    }{
+        {
+            name:     "in order",
+            items:    []renumberItem{{1, 1, 1}, {2, 1, 2}, {3, 1, 3}, {4, 1, 4}},
+            first:    3,
+            last:     4,
+            runs:     [][2]int{{3, 4}},
+            wantRuns: [][2]int{{3, 4}},
+        },
+        {
+            name:     "nothing imported",
+            items:    []renumberItem{{1, 1, 5}, {2, 1, 1}},
+            first:    3,
+            last:     4,
+            runs:     [][2]int{{1, 2}},
+            wantRuns: [][2]int{{1, 2}},
+        },
+        {
+            name:     "interleaved",
+            items:    []renumberItem{{1, 1, 1}, {2, 1, 3}, {3, 1, 5}, {4, 1, 2}, {5, 1, 4}},
+            first:    4,
+            last:     5,
+            want:     4,
+            newIDs:   map[int]int{4: 6, 2: 7, 5: 8, 3: 9},
+            runs:     [][2]int{{1, 3}, {4, 5}},
+            wantRuns: [][2]int{{1, 9}, {4, 8}},
+        },
+        {
+            name:     "older than everything",
+            items:    []renumberItem{{1, 1, 2}, {2, 1, 3}, {3, 1, 1}},
+            first:    3,
+            last:     3,
+            want:     3,
+            newIDs:   map[int]int{3: 4, 1: 5, 2: 6},
+            runs:     [][2]int{{3, 3}},
+            wantRuns: [][2]int{{3, 4}},
+        },
+        {
+            name:     "other chats keep their IDs",
+            items:    []renumberItem{{1, 1, 3}, {2, 2, 2}, {3, 1, 1}, {4, 2, 0}},
+            first:    3,
+            last:     3,
+            want:     2,
+            newIDs:   map[int]int{3: 5, 1: 6},
+            runs:     [][2]int{{3, 3}},
+            wantRuns: [][2]int{{3, 5}},
+        },
+        {
+            name:     "atomic import",
+            items:    []renumberItem{{1, 1, 2}, {2, 1, 1}},
+            first:    2,
+            last:     2,
+            atomic:   true,
+            want:     2,
+            newIDs:   map[int]int{2: 3, 1: 4},
+            runs:     [][2]int{{2, 2}},
+            wantRuns: [][2]int{{2, 3}},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            sqlDB := renumberFixture(t, tt.items, tt.runs)
+            var db Database = sqlDB
+            var tx *sql.Tx
+            if tt.atomic {
+                var err error
+                if tx, err = sqlDB.Begin(); err != nil {
+                    t.Fatal(err)
+                }
+                db = tx
+            }
+
+            var script strings.Builder
+            renumbered, err := RenumberByTime(db, 1, tt.first, tt.last, &script)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if tx != nil {
+                if err := tx.Commit(); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            if renumbered != tt.want {
+                t.Fatalf("renumbered %d items, want %d", renumbered, tt.want)
+            }
+            if tt.want == 0 && script.Len() > 0 {
+                t.Fatalf("nothing to renumber but the script has %q", script.String())
+            }
+
+            // Every item is where it should be, and its file and imported_messages row with it
+            for _, item := range tt.items {
+                want := item.id
+                if id, ok := tt.newIDs[item.id]; ok {
+                    want = id
+                }
+                var itemID, fileItemID, importedItemID int
+                if err := sqlDB.QueryRow("SELECT chat_item_id FROM chat_items WHERE contact_id = ? AND item_ts = ?", item.contactID, item.ts()).Scan(&itemID); err != nil {
+                    t.Fatal(err)
+                }
+                if err := sqlDB.QueryRow("SELECT chat_item_id FROM files WHERE file_id = ?", item.id).Scan(&fileItemID); err != nil {
+                    t.Fatal(err)
+                }
+                if err := sqlDB.QueryRow("SELECT chat_item_id FROM imported_messages WHERE source_id = ?", item.id).Scan(&importedItemID); err != nil {
+                    t.Fatal(err)
+                }
+                if itemID != want || fileItemID != want || importedItemID != want {
+                    t.Fatalf("item %d is at %d with its file at %d and imported_messages at %d, want %d", item.id, itemID, fileItemID, importedItemID, want)
+                }
+            }
+
+            var violations int
+            if err := sqlDB.QueryRow("SELECT COUNT(*) FROM pragma_foreign_key_check").Scan(&violations); err != nil {
+                t.Fatal(err)
+            }
+            if violations > 0 {
+                t.Fatalf("%d foreign key violations", violations)
+            }
+
+            rows, err := sqlDB.Query("SELECT first_chat_item_id, last_chat_item_id FROM import_runs ORDER BY import_run_id")
+            if err != nil {
+                t.Fatal(err)
+            }
+            defer rows.Close()
+            var runs [][2]int
+            for rows.Next() {
+                var run [2]int
+                if err := rows.Scan(&run[0], &run[1]); err != nil {
+                    t.Fatal(err)
+                }
+                runs = append(runs, run)
+            }
+            if len(runs) != len(tt.wantRuns) {
+                t.Fatalf("import runs are %v, want %v", runs, tt.wantRuns)
+            }
+            for i := range runs {
+                if runs[i] != tt.wantRuns[i] {
+                    t.Fatalf("import runs are %v, want %v", runs, tt.wantRuns)
+                }
+            }
+        })
+    }
+}
+
+func TestSinceLastImportAfterRenumber(t *testing.T) {
+    tests := []struct {
+        name     string
+        items    []renumberItem
+        native   []int // Items the app made, that no import recorded in imported_messages
+        runs     [][2]int
+        renumber [2]int // Chat items of the import to renumber, none when 0
+        messages []int  // Times of the export's messages, in minutes
+        want     int    // How many of them are kept
+    }{
+        {
+            name:     "renumbered between native items",
+            items:    []renumberItem{{1, 1, 1}, {2, 1, 30}, {3, 1, 20}, {4, 1, 25}},
+            native:   []int{1, 2},
+            runs:     [][2]int{{3, 4}},
+            renumber: [2]int{3, 4},
+            messages: []int{20, 25, 26, 30, 31},
+            want:     4,
+        },
+        {
+            // Like the ranges -merge renumber widens, which can take in native items
+            name:     "native items inside the range of a run",
+            items:    []renumberItem{{1, 1, 1}, {2, 1, 10}, {3, 1, 5}, {4, 1, 30}},
+            native:   []int{1, 4},
+            runs:     [][2]int{{2, 4}},
+            messages: []int{5, 10, 20, 30},
+            want:     3,
+        },
+        {
+            name:     "imports of versions without imported_messages",
+            items:    []renumberItem{{1, 1, 1}, {2, 1, 10}, {3, 1, 20}},
+            native:   []int{1, 2, 3},
+            runs:     [][2]int{{2, 3}},
+            messages: []int{10, 20, 30},
+            want:     2,
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            db := renumberFixture(t, tt.items, tt.runs)
+            for _, id := range tt.native {
+                if _, err := db.Exec("DELETE FROM imported_messages WHERE chat_item_id = ?", id); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            if tt.renumber[0] != 0 {
+                if _, err := RenumberByTime(db, 1, tt.renumber[0], tt.renumber[1], nil); err != nil {
+                    t.Fatal(err)
+                }
+            }
+
+            var messages []universal.Message
+            for i, minute := range tt.messages {
+                messages = append(messages, universal.Message{ID: strconv.Itoa(100 + i), Timestamp: renumberItem{minute: minute}.time()})
+            }
+            kept, older, err := SinceLastImport(db, 1, messages)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(kept) != tt.want || older != len(messages)-tt.want {
+                t.Fatalf("kept %d messages and left out %d, want %d kept", len(kept), older, tt.want)
+            }
+        })
+    }
+
+    db := renumberFixture(t, []renumberItem{{1, 1, 1}}, nil)
+    if _, _, err := SinceLastImport(db, 1, nil); err == nil {
+        t.Fatal("SinceLastImport without an earlier import succeeded")
+    }
+}
@@ -0,0 +1,51 @@
+package simplexdb
+
+// What InsertMessages did, added up over all the batches it was passed to
+type InsertReport struct {
+    FirstMessageID  int             `json:"firstMessageId"`
+    LastMessageID   int             `json:"lastMessageId"`
+    FirstChatItemID int             `json:"firstChatItemId"`
+    LastChatItemID  int             `json:"lastChatItemId"`
+    FilesCopied     int             `json:"filesCopied"`
+    FileBytes       int64           `json:"fileBytes"`
+    ReactionsAdded  int             `json:"reactionsAdded,omitempty"` // To messages imported before
+    Failures        []InsertFailure `json:"failures,omitempty"`
+
+    // Names the copied files have in the files directory, in the order they were copied
+    Files []string `json:"-"`
+}
+
+// An attachment that couldn't be imported; its message went in without it
+type InsertFailure struct {
+    MessageID  string `json:"messageId"`
+    Attachment string `json:"attachment"`
+    Reason     string `json:"reason"`
+}
+
+func (r *InsertReport) addBatch(data BulkInsertData, nextMessageID int) {
+    if r == nil || len(data.Messages) == 0 {
+        return
+    }
+    if r.FirstMessageID == 0 {
+        r.FirstMessageID = data.StartMessageID
+        r.FirstChatItemID = data.StartChatItemID
+    }
+    r.LastMessageID = nextMessageID - 1
+    r.LastChatItemID = data.StartChatItemID + len(data.Messages) - 1
+}
+
+func (r *InsertReport) addFile(name string, size int64) {
+    if r == nil {
+        return
+    }
+    r.FilesCopied++
+    r.FileBytes += size
+    r.Files = append(r.Files, name)
+}
+
+func (r *InsertReport) addFailure(messageID, attachment string, err error) {
+    if r == nil {
+        return
+    }
+    r.Failures = append(r.Failures, InsertFailure{MessageID: messageID, Attachment: attachment, Reason: err.Error()})
+}
@@ -0,0 +1,143 @@
+package simplexdb
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// Schema of a SimpleX chat database, from its migrations table
+type SchemaVersion struct {
+    Latest     string // Name of the newest migration applied, e.g. 20250702_contact_requests_remove_cascade_delete
+    Migrations int    // How many migrations were applied
+}
+
+// Migrations that shipped with a SimpleX app release, oldest first, to tell which app a
+// database is from
+var releaseMigrations = []struct {
+    Migration  string
+    AppVersion string
+}{
+    {"20230511_reactions", "5.1"},
+    {"20230827_file_encryption", "5.3"},
+    {"20240102_note_folders", "5.5"},
+    {"20240402_item_forwarded", "5.7"},
+    {"20241128_business_chats", "6.2"},
+    {"20241223_chat_tags", "6.3"},
+}
+
+// Oldest schema the import can write to: reactions went in with 20230511_reactions, and every
+// other table and column it needs is older
+const oldestSupportedMigration = "20230511_reactions"
+
+// Newest schema the import was checked against; newer ones may have changed what it writes
+const newestTestedMigration = "20250702_contact_requests_remove_cascade_delete"
+
+// Tables and columns the import reads or writes by name. Other columns of the tables it inserts
+// into are copied from their newest row, so they can come and go between versions
+var requiredColumns = map[string][]string{
+    "users":               {"user_id", "local_display_name", "contact_id"},
+    "contacts":            {"contact_id", "user_id", "local_display_name", "contact_profile_id", "deleted", "is_user", "chat_ts"},
+    "connections":         {"connection_id", "contact_id"},
+    "messages":            {"message_id", "msg_sent", "chat_msg_event", "msg_body", "shared_msg_id", "created_at", "updated_at"},
+    "msg_deliveries":      {"msg_delivery_id", "message_id", "connection_id"},
+    "chat_items":          {"chat_item_id", "user_id", "contact_id", "item_ts", "item_sent", "item_content", "item_text", "shared_msg_id", "quoted_shared_msg_id", "quoted_sent_at", "quoted_content", "quoted_sent", "created_at", "updated_at"},
+    "chat_item_messages":  {"chat_item_id", "message_id"},
+    "chat_item_reactions": {"chat_item_reaction_id", "shared_msg_id", "contact_id", "created_by_msg_id", "reaction", "reaction_sent", "reaction_ts", "created_at", "updated_at"},
+    "files":               {"file_id", "contact_id", "chat_item_id", "user_id", "file_name", "file_path", "file_size", "chunk_size", "ci_file_status"},
+    "snd_files":           {"file_id", "connection_id", "file_status"},
+    "rcv_files":           {"file_id", "file_status"},
+}
+
+// Columns encrypted attachments are stored with, from 20230827_file_encryption
+var encryptionColumns = []string{"file_crypto_key", "file_crypto_nonce"}
+
+// Read the schema version from the migrations table
+func ReadSchemaVersion(querier Querier) (SchemaVersion, error) {
+    var version SchemaVersion
+    var latest *string
+    if err := querier.QueryRow("SELECT COUNT(*), MAX(name) FROM migrations").Scan(&version.Migrations, &latest); err != nil {
+        return version, fmt.Errorf("failed to read schema version: %w", err)
+    }
+    if latest != nil {
+        version.Latest = *latest
+    }
+    return version, nil
+}
+
+// The SimpleX app release the schema comes from, as "5.3 or later", or "" when it's older than
+// any release known here
+func (v SchemaVersion) AppVersion() string {
+    appVersion := ""
+    for _, release := range releaseMigrations {
+        if migrationBefore(v.Latest, release.Migration) {
+            break
+        }
+        appVersion = release.AppVersion + " or later"
+    }
+    return appVersion
+}
+
+// Schema version for messages, e.g. "20241223_chat_tags (SimpleX 6.3 or later, 130 migrations)"
+func (v SchemaVersion) String() string {
+    if appVersion := v.AppVersion(); appVersion != "" {
+        return fmt.Sprintf("%s (SimpleX %s, %d migrations)", v.Latest, appVersion, v.Migrations)
+    }
+    return fmt.Sprintf("%s (%d migrations)", v.Latest, v.Migrations)
+}
+
+// Whether the schema is newer than any the import was checked against
+func (v SchemaVersion) Untested() bool {
+    return migrationBefore(newestTestedMigration, v.Latest)
+}
+
+// Migration names start with their date, so they sort in the order they were applied
+func migrationBefore(a, b string) bool {
+    return a < b
+}
+
+// Make sure the import can write to the database before it starts: a schema older than the
+// import supports is refused, one newer than it was checked against only needs every table and
+// column the import uses by name, which are looked up either way (with the attachment encryption
+// ones for encryptFiles). Errors name what's missing instead of failing with "no such column"
+// halfway through an import
+func CheckSchema(querier Querier, encryptFiles bool) (SchemaVersion, error) {
+    version, err := ReadSchemaVersion(querier)
+    if err != nil {
+        return version, err
+    }
+    if migrationBefore(version.Latest, oldestSupportedMigration) {
+        return version, fmt.Errorf("database schema %s is too old, importing needs SimpleX %s or later (%s): update the app and open it once to upgrade the database",
+            version, releaseMigrations[0].AppVersion, oldestSupportedMigration)
+    }
+
+    required := make(map[string][]string, len(requiredColumns))
+    for table, columns := range requiredColumns {
+        required[table] = columns
+    }
+    if encryptFiles {
+        required["files"] = append(append([]string(nil), required["files"]...), encryptionColumns...)
+    }
+
+    var missing []string
+    for table, columns := range required {
+        existing, err := getTableColumns(querier, table)
+        if err != nil {
+            return version, err
+        }
+        have := make(map[string]bool, len(existing))
+        for _, column := range existing {
+            have[column] = true
+        }
+        for _, column := range columns {
+            if !have[column] {
+                missing = append(missing, table+"."+column)
+            }
+        }
+    }
+    if len(missing) > 0 {
+        sort.Strings(missing)
+        return version, fmt.Errorf("database schema %s doesn't have %s, which the import writes", version, strings.Join(missing, ", "))
+    }
+    return version, nil
+}
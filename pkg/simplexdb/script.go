@@ -0,0 +1,79 @@
+package simplexdb
+
+import (
+    "database/sql"
+    "fmt"
+    "io"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Transaction that also writes each successful statement to a SQL script, with the bound
+// values inlined so the script can be read and applied with the sqlcipher shell
+type scriptTx struct {
+    execer
+    script io.Writer
+}
+
+func (t scriptTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+    result, err := t.execer.Exec(query, args...)
+    if err != nil {
+        return result, err
+    }
+    if _, err := io.WriteString(t.script, inlineStatement(query, args)+";\n"); err != nil {
+        return result, fmt.Errorf("failed to write SQL script: %w", err)
+    }
+    return result, nil
+}
+
+// Replace the ? placeholders of a statement with its values as SQL literals
+func inlineStatement(query string, args []interface{}) string {
+    query = strings.TrimSpace(query)
+
+    var b strings.Builder
+    next := 0
+    inString := false
+    for _, r := range query {
+        switch {
+        case r == '\'':
+            inString = !inString
+        case r == '?' && !inString && next < len(args):
+            b.WriteString(sqlLiteral(args[next]))
+            next++
+            continue
+        }
+        b.WriteRune(r)
+    }
+    return b.String()
+}
+
+// Value as a SQLite literal
+func sqlLiteral(value interface{}) string {
+    switch v := value.(type) {
+    case nil:
+        return "NULL"
+    case []byte:
+        if v == nil {
+            return "NULL"
+        }
+        return fmt.Sprintf("X'%X'", v)
+    case string:
+        return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+    case bool:
+        if v {
+            return "1"
+        }
+        return "0"
+    case int:
+        return strconv.Itoa(v)
+    case int64:
+        return strconv.FormatInt(v, 10)
+    case float64:
+        return strconv.FormatFloat(v, 'g', -1, 64)
+    case time.Time:
+        return "'" + v.Format("2006-01-02 15:04:05") + "'"
+    default:
+        return sqlLiteral(fmt.Sprint(v))
+    }
+}
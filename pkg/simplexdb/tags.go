@@ -0,0 +1,64 @@
+package simplexdb
+
+import (
+    "context"
+    "fmt"
+    "io"
+)
+
+// Tag the contact's chat with the user profile's chat tag of this name, creating the tag after
+// the profile's others when it doesn't exist yet, so imported chats can be found by it in the
+// chat list. Databases from before SimpleX 6.3 (20241223_chat_tags) have no chat tags to use.
+// With script set the statements are written to it too, like InsertOptions.SQLScript. Returns
+// whether the tag was created and whether the chat didn't have it yet
+func TagChat(db Database, userID, contactID int, tag string, script io.Writer) (bool, bool, error) {
+    tx, err := beginChange(context.Background(), db, "chat_tag")
+    if err != nil {
+        return false, false, fmt.Errorf("failed to begin transaction: %w", err)
+    }
+    defer tx.Rollback()
+
+    var exists int
+    if err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('chat_tags', 'chat_tags_chats')").Scan(&exists); err != nil {
+        return false, false, fmt.Errorf("failed to look for chat tags: %w", err)
+    }
+    if exists != 2 {
+        return false, false, fmt.Errorf("the database has no chat tags, which came with SimpleX 6.3")
+    }
+
+    var writer execer = tx
+    if script != nil {
+        writer = scriptTx{execer: tx, script: script}
+    }
+    writer = busyRetryTx{execer: writer, ctx: context.Background()}
+
+    // Both statements look the tag up themselves, so a script does the same on its database
+    result, err := writer.Exec(`INSERT INTO chat_tags (user_id, chat_tag_text, chat_tag_emoji, tag_order)
+                                SELECT ?, ?, NULL, (SELECT COALESCE(MAX(tag_order), 0) + 1 FROM chat_tags WHERE user_id = ?)
+                                WHERE NOT EXISTS (SELECT 1 FROM chat_tags WHERE user_id = ? AND chat_tag_text = ?)`,
+        userID, tag, userID, userID, tag)
+    if err != nil {
+        return false, false, fmt.Errorf("failed to create chat tag: %w", err)
+    }
+    created, err := result.RowsAffected()
+    if err != nil {
+        return false, false, fmt.Errorf("failed to create chat tag: %w", err)
+    }
+
+    result, err = writer.Exec(`INSERT INTO chat_tags_chats (contact_id, chat_tag_id)
+                               SELECT ?, chat_tag_id FROM chat_tags t WHERE user_id = ? AND chat_tag_text = ?
+                               AND NOT EXISTS (SELECT 1 FROM chat_tags_chats WHERE contact_id = ? AND chat_tag_id = t.chat_tag_id)`,
+        contactID, userID, tag, contactID)
+    if err != nil {
+        return false, false, fmt.Errorf("failed to tag the chat: %w", err)
+    }
+    tagged, err := result.RowsAffected()
+    if err != nil {
+        return false, false, fmt.Errorf("failed to tag the chat: %w", err)
+    }
+
+    if err := tx.Commit(); err != nil {
+        return false, false, fmt.Errorf("failed to commit chat tag: %w", err)
+    }
+    return created > 0, tagged > 0, nil
+}
@@ -0,0 +1,87 @@
+package simplexdb
+
+import "fmt"
+
+// Check a database after an import, before it goes back into the app: SQLite's quick_check, and
+// that every chat item imported into the contact's chat from firstChatItemID on is there with its
+// message and the same shared_msg_id, and that every file row of those items has its chat item.
+// Earlier items are left out, the user may have deleted some in the app since. Returns what's
+// wrong, nothing when all is well
+func CheckConsistency(querier Querier, contactID, firstChatItemID int) ([]string, error) {
+    var problems []string
+
+    rows, err := querier.Query("PRAGMA quick_check")
+    if err != nil {
+        return nil, fmt.Errorf("quick check failed: %w", err)
+    }
+    for rows.Next() {
+        var result string
+        if err := rows.Scan(&result); err != nil {
+            rows.Close()
+            return nil, fmt.Errorf("quick check failed: %w", err)
+        }
+        if result != "ok" {
+            problems = append(problems, result)
+        }
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, fmt.Errorf("quick check failed: %w", err)
+    }
+
+    // Every query finds one kind of inconsistency, with the ID it's about
+    checks := []struct {
+        query    string
+        problem  string
+        imported bool // Goes by imported_messages, which only databases imported into have
+    }{
+        {`SELECT im.chat_item_id FROM imported_messages im
+          WHERE im.contact_id = ?1 AND im.chat_item_id >= ?2
+            AND NOT EXISTS (SELECT 1 FROM chat_items ci WHERE ci.chat_item_id = im.chat_item_id)`,
+            "imported chat item %d is missing", true},
+        {`SELECT im.chat_item_id FROM imported_messages im
+          WHERE im.contact_id = ?1 AND im.chat_item_id >= ?2
+            AND EXISTS (SELECT 1 FROM chat_items ci WHERE ci.chat_item_id = im.chat_item_id)
+            AND NOT EXISTS (SELECT 1 FROM chat_item_messages cim JOIN messages m ON m.message_id = cim.message_id
+                            WHERE cim.chat_item_id = im.chat_item_id)`,
+            "imported chat item %d has no message", true},
+        {`SELECT ci.chat_item_id FROM imported_messages im
+          JOIN chat_items ci ON ci.chat_item_id = im.chat_item_id
+          JOIN chat_item_messages cim ON cim.chat_item_id = ci.chat_item_id
+          JOIN messages m ON m.message_id = cim.message_id
+          WHERE im.contact_id = ?1 AND im.chat_item_id >= ?2 AND m.shared_msg_id IS NOT ci.shared_msg_id`,
+            "imported chat item %d has a different shared_msg_id than its message", true},
+        {`SELECT f.file_id FROM files f
+          WHERE f.contact_id = ?1 AND f.chat_item_id >= ?2
+            AND NOT EXISTS (SELECT 1 FROM chat_items ci WHERE ci.chat_item_id = f.chat_item_id)`,
+            "file %d has no chat item", false},
+    }
+
+    var imported int
+    if err := querier.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'imported_messages'").Scan(&imported); err != nil {
+        return nil, fmt.Errorf("failed to look for imported_messages: %w", err)
+    }
+
+    for _, check := range checks {
+        if check.imported && imported == 0 {
+            continue
+        }
+        rows, err := querier.Query(check.query, contactID, firstChatItemID)
+        if err != nil {
+            return nil, fmt.Errorf("consistency check failed: %w", err)
+        }
+        for rows.Next() {
+            var id int
+            if err := rows.Scan(&id); err != nil {
+                rows.Close()
+                return nil, fmt.Errorf("consistency check failed: %w", err)
+            }
+            problems = append(problems, fmt.Sprintf(check.problem, id))
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            return nil, fmt.Errorf("consistency check failed: %w", err)
+        }
+    }
+    return problems, nil
+}
@@ -0,0 +1,142 @@
+// Package tempfiles keeps track of temporary data so it can be wiped when a run finishes,
+// fails or is interrupted.
+package tempfiles
+
+import (
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "runtime"
+    "strings"
+    "sync"
+)
+
+// Directory for temporary data (extraction, thumbnails, converted media); empty means the OS default
+var Root string
+
+// Pick a RAM-backed directory for -in-memory so the decrypted database and generated media never touch the disk
+func MemoryRoot() (string, error) {
+    if runtime.GOOS == "linux" {
+        if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+            return "/dev/shm", nil
+        }
+    }
+    return "", fmt.Errorf("no memory-backed temp directory found on %s, pass -temp-dir pointing to a RAM disk", runtime.GOOS)
+}
+
+// Temp data wipe modes (-wipe)
+const (
+    WipeOverwrite = "overwrite" // Overwrite file contents with zeros before deleting
+    WipeDelete    = "delete"    // Plain delete
+    WipeKeep      = "keep"      // Leave temp data in place, e.g. for debugging
+)
+
+// How Remove and Cleanup get rid of temp data
+var Wipe = WipeOverwrite
+
+// Temp directories to clean up on exit, including fatal errors and interrupts
+var (
+    tempPathsMu sync.Mutex
+    tempPaths   []string
+)
+
+// Remember a temp file or directory for Cleanup
+func Register(path string) {
+    tempPathsMu.Lock()
+    defer tempPathsMu.Unlock()
+    tempPaths = append(tempPaths, path)
+}
+
+// Stop tracking a registered path, so Cleanup leaves it for a later run (-resume)
+func Keep(path string) {
+    tempPathsMu.Lock()
+    defer tempPathsMu.Unlock()
+    for i, registered := range tempPaths {
+        if registered == path {
+            tempPaths = append(tempPaths[:i], tempPaths[i+1:]...)
+            return
+        }
+    }
+}
+
+// Overwrite a file with zeros and flush it to disk before deleting it. This doesn't defeat
+// copy-on-write filesystems or SSD wear leveling, but keeps the plaintext out of reach of undelete
+func wipeFile(path string) error {
+    file, err := os.OpenFile(path, os.O_WRONLY, 0)
+    if err != nil {
+        return err
+    }
+
+    info, err := file.Stat()
+    if err != nil {
+        file.Close()
+        return err
+    }
+
+    zeros := make([]byte, 64*1024)
+    for remaining := info.Size(); remaining > 0; {
+        n := int64(len(zeros))
+        if remaining < n {
+            n = remaining
+        }
+        if _, err := file.Write(zeros[:n]); err != nil {
+            file.Close()
+            return err
+        }
+        remaining -= n
+    }
+
+    if err := file.Sync(); err != nil {
+        file.Close()
+        return err
+    }
+    file.Close()
+    return os.Remove(path)
+}
+
+// Remove a temporary file or directory according to -wipe
+func Remove(path string) error {
+    switch Wipe {
+    case WipeKeep:
+        return nil
+    case WipeDelete:
+        return os.RemoveAll(path)
+    }
+
+    err := filepath.Walk(path, func(filePath string, info os.FileInfo, err error) error {
+        if err != nil {
+            if os.IsNotExist(err) {
+                return nil
+            }
+            return err
+        }
+        // Symlinks are removed below without touching what they point to
+        if info.Mode().IsRegular() {
+            if err := wipeFile(filePath); err != nil {
+                log.Printf("Warning: failed to wipe %s: %v", filePath, err)
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        log.Printf("Warning: failed to wipe %s: %v", path, err)
+    }
+    return os.RemoveAll(path)
+}
+
+// Remove all registered temp directories, newest first
+func Cleanup() {
+    tempPathsMu.Lock()
+    defer tempPathsMu.Unlock()
+
+    if Wipe == WipeKeep && len(tempPaths) > 0 {
+        fmt.Printf("Keeping temporary data in: %s\n", strings.Join(tempPaths, ", "))
+    }
+    for i := len(tempPaths) - 1; i >= 0; i-- {
+        if err := Remove(tempPaths[i]); err != nil {
+            log.Printf("Warning: failed to remove %s: %v", tempPaths[i], err)
+        }
+    }
+    tempPaths = nil
+}
@@ -0,0 +1,142 @@
+// Package timing adds up how long the phases of an import take, so a slow import shows where the
+// time goes: parsing, converting, media, the inserts into each table, zipping.
+// A nil *Timings ignores every call, so code can take one without requiring it.
+package timing
+
+import (
+    "fmt"
+    "io"
+    "sync"
+    "time"
+)
+
+// Time spent in each phase since New, in the order the phases first ran
+type Timings struct {
+    start time.Time
+
+    mu     sync.Mutex
+    phases []*Phase
+    index  map[string]*Phase
+}
+
+// One phase, added up over every time it ran
+type Phase struct {
+    Name     string  `json:"name"`
+    Seconds  float64 `json:"seconds"`
+    Runs     int     `json:"runs"`
+    Parallel bool    `json:"parallel,omitempty"` // Ran on workers alongside the other phases, added up over them
+
+    duration time.Duration
+}
+
+func New() *Timings {
+    return &Timings{start: time.Now(), index: make(map[string]*Phase)}
+}
+
+// Start timing a phase; the returned func stops it, so `defer t.Start("zip")()` times the rest
+// of a function
+func (t *Timings) Start(name string) func() {
+    if t == nil {
+        return func() {}
+    }
+    started := time.Now()
+    return func() {
+        t.Add(name, time.Since(started))
+    }
+}
+
+// Add d to a phase of the import itself
+func (t *Timings) Add(name string, d time.Duration) {
+    t.add(name, d, false)
+}
+
+// Add d to a phase that runs on workers alongside the others, like making previews while the
+// inserts go on; it isn't part of the import's own time
+func (t *Timings) AddParallel(name string, d time.Duration) {
+    t.add(name, d, true)
+}
+
+func (t *Timings) add(name string, d time.Duration, parallel bool) {
+    if t == nil {
+        return
+    }
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    phase, ok := t.index[name]
+    if !ok {
+        phase = &Phase{Name: name, Parallel: parallel}
+        t.index[name] = phase
+        t.phases = append(t.phases, phase)
+    }
+    phase.duration += d
+    phase.Seconds = phase.duration.Seconds()
+    phase.Runs++
+}
+
+// Time since New
+func (t *Timings) Total() time.Duration {
+    if t == nil {
+        return 0
+    }
+    return time.Since(t.start)
+}
+
+// The phases timed so far, in the order they first ran
+func (t *Timings) Phases() []Phase {
+    if t == nil {
+        return nil
+    }
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    phases := make([]Phase, len(t.phases))
+    for i, phase := range t.phases {
+        phases[i] = *phase
+    }
+    return phases
+}
+
+// Write the phases as a table with their share of the total; the time nothing was timed in is
+// left over as "other"
+func (t *Timings) Print(w io.Writer) {
+    if t == nil {
+        return
+    }
+    total := t.Total()
+    phases := t.Phases()
+    width := len("other")
+    for _, phase := range phases {
+        width = max(width, len(phase.Name))
+    }
+    line := func(name string, d time.Duration, share bool) {
+        if share && total > 0 {
+            fmt.Fprintf(w, "  %-*s %10s %6.1f%%\n", width, name, d.Round(time.Millisecond), 100*d.Seconds()/total.Seconds())
+        } else {
+            fmt.Fprintf(w, "  %-*s %10s\n", width, name, d.Round(time.Millisecond))
+        }
+    }
+
+    fmt.Fprintln(w, "Time spent:")
+    timed := time.Duration(0)
+    for _, phase := range phases {
+        if !phase.Parallel {
+            line(phase.Name, phase.duration, true)
+            timed += phase.duration
+        }
+    }
+    if other := total - timed; other > 0 {
+        line("other", other, true)
+    }
+    line("total", total, false)
+
+    first := true
+    for _, phase := range phases {
+        if !phase.Parallel {
+            continue
+        }
+        if first {
+            fmt.Fprintln(w, "Alongside, added up over the workers:")
+            first = false
+        }
+        line(phase.Name, phase.duration, false)
+    }
+}
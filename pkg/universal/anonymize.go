@@ -0,0 +1,52 @@
+package universal
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "path"
+    "regexp"
+    "strings"
+    "unicode"
+)
+
+// Discord mention, channel, role, custom emoji and timestamp tokens, kept by Placeholder so the
+// structure of a message survives anonymizing
+var platformToken = regexp.MustCompile(`<(?:@[!&]?|#|a?:\w+:)\d+>|<t:\d+(?::[tTdDfFR])?>`)
+
+// Text of the same length with every letter replaced by x (X when uppercase) and every digit by 0.
+// Whitespace, punctuation, emoji and mention tokens stay, as bugs often depend on them
+func Placeholder(text string) string {
+    replace := func(s string) string {
+        return strings.Map(func(r rune) rune {
+            switch {
+            case unicode.IsUpper(r):
+                return 'X'
+            case unicode.IsLetter(r):
+                return 'x'
+            case unicode.IsDigit(r):
+                return '0'
+            }
+            return r
+        }, s)
+    }
+
+    var b strings.Builder
+    last := 0
+    for _, token := range platformToken.FindAllStringIndex(text, -1) {
+        b.WriteString(replace(text[last:token[0]]))
+        b.WriteString(text[token[0]:token[1]])
+        last = token[1]
+    }
+    b.WriteString(replace(text[last:]))
+    return b.String()
+}
+
+// File name replaced by the first 12 hex digits of its SHA-256, keeping the extension so files
+// are still handled by type. The same name always hashes the same
+func HashedName(name string) string {
+    if name == "" {
+        return ""
+    }
+    sum := sha256.Sum256([]byte(name))
+    return hex.EncodeToString(sum[:])[:12] + strings.ToLower(path.Ext(name))
+}
@@ -0,0 +1,134 @@
+package universal
+
+import (
+    "fmt"
+    "path/filepath"
+    "runtime"
+    "strings"
+)
+
+// Resolve an attachment path from the export; downloaded (cached) files are absolute
+func ResolveExportPath(jsonDir, path string) string {
+    if filepath.IsAbs(path) {
+        return path
+    }
+    // Exports made on Windows may use backslashes, which only Windows treats as separators
+    if runtime.GOOS != "windows" {
+        path = strings.ReplaceAll(path, "\\", "/")
+    }
+    return filepath.Join(jsonDir, filepath.FromSlash(path))
+}
+
+// Attachments of exports made without --media point at the Discord CDN
+func IsRemoteURL(path string) bool {
+    return strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "http://")
+}
+
+// Determine message type based on file extension
+func AttachmentMessageType(filename string) string {
+    ext := strings.ToLower(filepath.Ext(filename))
+    switch ext {
+    case ".jpg", ".jpeg", ".png", ".gif", ".webp":
+        return "image"
+    case ".mp4", ".webm", ".mov", ".avi":
+        return "video"
+    case ".mp3", ".wav", ".m4a", ".ogg":
+        return "voice"
+    default:
+        return "file"
+    }
+}
+
+// Split messages with several attachments into one message per attachment, since a
+// SimpleX chat item holds a single file. The first part keeps the caption, reactions, where it
+// was forwarded from and original ID (so replies still resolve); the other parts quote it to stay
+// connected.
+func SplitMultiAttachmentMessages(messages []Message) []Message {
+    result := make([]Message, 0, len(messages))
+    for _, msg := range messages {
+        if len(msg.Attachments) <= 1 {
+            result = append(result, msg)
+            continue
+        }
+
+        first := msg
+        first.Attachments = msg.Attachments[:1]
+        result = append(result, first)
+
+        for i, attachment := range msg.Attachments[1:] {
+            part := msg
+            part.ID = fmt.Sprintf("%s-%d", msg.ID, i+2)
+            part.Content = ""
+            part.Mentions = nil
+            part.Reactions = nil
+            part.LinkPreview = nil
+            part.ForwardedFrom = nil
+            part.MessageType = AttachmentMessageType(attachment.Filename)
+            part.Attachments = []Attachment{attachment}
+            part.QuotedMessage = &QuotedMessage{
+                SourceID: first.ID,
+                SentAt:   first.Timestamp,
+                Content:  first.Content,
+                IsSent:   first.IsSent,
+            }
+            replyToID := first.ID
+            part.ReplyToID = &replyToID
+            result = append(result, part)
+        }
+    }
+    return result
+}
+
+// Strip attachments and link previews for text-only imports, leaving the file names
+// in the text so the conversation still shows something was shared
+func DropAttachments(messages []Message) int {
+    dropped := 0
+    for i := range messages {
+        lines := []string{}
+        if messages[i].Content != "" {
+            lines = append(lines, messages[i].Content)
+        }
+        for _, attachment := range messages[i].Attachments {
+            lines = append(lines, fmt.Sprintf("[Attachment: %s]", attachment.Filename))
+            dropped++
+        }
+
+        messages[i].Content = strings.Join(lines, "\n")
+        messages[i].Attachments = nil
+        messages[i].LinkPreview = nil
+        messages[i].MessageType = "text"
+    }
+    return dropped
+}
+
+// Replace attachments larger than maxSize with a text placeholder naming the file,
+// its size and where it came from
+func ApplyAttachmentSizeLimit(messages []Message, maxSize int64) int {
+    skipped := 0
+    for i := range messages {
+        var kept []Attachment
+        var placeholders []string
+        for _, attachment := range messages[i].Attachments {
+            if attachment.Size <= maxSize {
+                kept = append(kept, attachment)
+                continue
+            }
+            placeholders = append(placeholders, fmt.Sprintf("[Attachment not imported: %s (%s) %s]",
+                attachment.Filename, FormatByteSize(attachment.Size), attachment.URL))
+            skipped++
+        }
+        if len(placeholders) == 0 {
+            continue
+        }
+
+        messages[i].Attachments = kept
+        if len(kept) == 0 {
+            messages[i].MessageType = "text"
+        }
+        if messages[i].Content != "" {
+            placeholders = append([]string{messages[i].Content}, placeholders...)
+        }
+        messages[i].Content = strings.Join(placeholders, "\n")
+    }
+    return skipped
+}
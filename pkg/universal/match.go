@@ -0,0 +1,109 @@
+package universal
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// Something looked up by name, e.g. a contact known by its local and its profile display name
+type NamedEntry struct {
+    ID    int
+    Names []string
+}
+
+// Find the entry called name: the first exact match, else the only case-insensitive one. When
+// there's none the error suggests the closest names; kind ("contact") starts the messages
+func MatchName(kind, name string, entries []NamedEntry) (int, error) {
+    for _, entry := range entries {
+        for _, candidate := range entry.Names {
+            if candidate == name {
+                return entry.ID, nil
+            }
+        }
+    }
+
+    var folded []string
+    foldedID := 0
+    for _, entry := range entries {
+        for _, candidate := range entry.Names {
+            if strings.EqualFold(candidate, name) {
+                folded = append(folded, fmt.Sprintf("'%s' (ID %d)", candidate, entry.ID))
+                foldedID = entry.ID
+                break
+            }
+        }
+    }
+    switch len(folded) {
+    case 0:
+    case 1:
+        return foldedID, nil
+    default:
+        return 0, fmt.Errorf("%s '%s' not found, but these differ only in case: %s", kind, name, strings.Join(folded, ", "))
+    }
+
+    suggestions := closestNames(name, entries)
+    if len(suggestions) == 0 {
+        return 0, fmt.Errorf("%s '%s' not found", kind, name)
+    }
+    for i, suggestion := range suggestions {
+        suggestions[i] = "'" + suggestion + "'"
+    }
+    return 0, fmt.Errorf("%s '%s' not found, did you mean %s?", kind, name, strings.Join(suggestions, " or "))
+}
+
+// Up to three names that contain name or are a few edits away from it, closest first
+func closestNames(name string, entries []NamedEntry) []string {
+    query := strings.ToLower(name)
+    limit := min(max(1, len([]rune(query))/2), 3)
+    distances := make(map[string]int)
+    for _, entry := range entries {
+        for _, candidate := range entry.Names {
+            lower := strings.ToLower(candidate)
+            distance := editDistance(query, lower)
+            if query != "" && (strings.Contains(lower, query) || strings.Contains(query, lower)) {
+                distance = min(distance, 1)
+            }
+            if _, seen := distances[candidate]; candidate != "" && distance <= limit && !seen {
+                distances[candidate] = distance
+            }
+        }
+    }
+
+    names := make([]string, 0, len(distances))
+    for candidate := range distances {
+        names = append(names, candidate)
+    }
+    sort.Slice(names, func(i, j int) bool {
+        if distances[names[i]] != distances[names[j]] {
+            return distances[names[i]] < distances[names[j]]
+        }
+        return names[i] < names[j]
+    })
+    if len(names) > 3 {
+        names = names[:3]
+    }
+    return names
+}
+
+// Levenshtein distance between a and b, counted in runes
+func editDistance(a, b string) int {
+    ra, rb := []rune(a), []rune(b)
+    previous := make([]int, len(rb)+1)
+    current := make([]int, len(rb)+1)
+    for j := range previous {
+        previous[j] = j
+    }
+    for i := 1; i <= len(ra); i++ {
+        current[0] = i
+        for j := 1; j <= len(rb); j++ {
+            cost := 1
+            if ra[i-1] == rb[j-1] {
+                cost = 0
+            }
+            current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+        }
+        previous, current = current, previous
+    }
+    return previous[len(rb)]
+}
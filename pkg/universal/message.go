@@ -0,0 +1,107 @@
+// Package universal defines the platform-neutral message format that source converters
+// produce and the SimpleX writers consume.
+package universal
+
+import "time"
+
+// Intermediary message format - universal structure
+type Message struct {
+    // Core message data
+    ID          string     `json:"id"`
+    Content     string     `json:"content"`
+    Timestamp   time.Time  `json:"timestamp"`
+    EditedAt    *time.Time `json:"editedAt,omitempty"`
+
+    // Author information
+    Author      Author          `json:"author"`
+
+    // Message metadata
+    MessageType string `json:"messageType"` // "text", "image", "file", "system", etc.
+    Platform    string `json:"platform"`    // "discord", "telegram", "whatsapp", "slack", etc.
+
+    // Rich content
+    Attachments []Attachment          `json:"attachments,omitempty"`
+    Mentions    []Mention             `json:"mentions,omitempty"`
+    Reactions   []Reaction            `json:"reactions,omitempty"`
+
+    // Thread/reply information
+    ReplyToID   *string `json:"replyToId,omitempty"`
+
+    // Quote information for Discord replies
+    QuotedMessage *QuotedMessage `json:"quotedMessage,omitempty"`
+
+    // Where a forwarded or cross-posted message came from
+    ForwardedFrom *ForwardedFrom `json:"forwardedFrom,omitempty"`
+
+    // Link preview built from an embed
+    LinkPreview *LinkPreview          `json:"linkPreview,omitempty"`
+
+    // Platform-specific data (stored as JSON for flexibility)
+    PlatformData map[string]interface{} `json:"platformData,omitempty"`
+
+    // Message state
+    IsPinned    bool `json:"isPinned"`
+    IsSent      bool `json:"isSent"` // New field to track if message was sent by the user
+    IsDeleted   bool       `json:"isDeleted"`
+    DeletedAt   *time.Time `json:"deletedAt,omitempty"`
+}
+
+// The chat a forwarded message came from, as far as the source tells
+type ForwardedFrom struct {
+    ChatName  string `json:"chatName,omitempty"`  // Empty when the source doesn't name it
+    IsGroup   bool   `json:"isGroup,omitempty"`   // A group chat, like a Discord server channel
+    MessageID string `json:"messageId,omitempty"` // Source ID of the original when it's in the same chat
+}
+
+// The message a reply quotes, as SimpleX stores it
+type QuotedMessage struct {
+    SourceID string    `json:"sourceId"` // ID of the quoted message in the source, the insert finds its shared_msg_id
+    SentAt   time.Time `json:"sentAt"`
+    Content  string    `json:"content"`
+    IsSent   bool      `json:"isSent"`
+}
+
+// Message author
+type Author struct {
+    ID          string  `json:"id"`
+    Username    string  `json:"username"`
+    DisplayName string  `json:"displayName"`
+    AvatarURL   *string `json:"avatarUrl,omitempty"`
+    IsBot       bool    `json:"isBot"`
+    IsWebhook   bool    `json:"isWebhook,omitempty"` // Posted through a webhook, IsBot is set too
+
+    // Platform-specific author data
+    PlatformData map[string]interface{} `json:"platformData,omitempty"`
+}
+
+// Attached file; URL is a path relative to the export directory or a remote URL
+type Attachment struct {
+    ID       string `json:"id"`
+    Filename string `json:"filename"`
+    URL      string `json:"url"`
+    MimeType string `json:"mimeType"`
+    Size     int64  `json:"size"`
+}
+
+// Preview card for a link in the message
+type LinkPreview struct {
+    URL         string `json:"url"`
+    Title       string `json:"title"`
+    Description string `json:"description"`
+    ImageURL    string `json:"imageUrl"` // Preview image, relative to the export directory
+}
+
+// Mention of a user inside the message text
+type Mention struct {
+    UserID   string `json:"userId"`
+    Username string `json:"username"`
+    Start    int    `json:"start"` // Position in text where mention starts (in characters)
+    Length   int    `json:"length"` // Length of the mention text (in characters)
+}
+
+// Emoji reaction and who reacted with it
+type Reaction struct {
+    Emoji   string   `json:"emoji"`
+    Count   int      `json:"count"`
+    UserIDs []string `json:"userIds"`
+}
@@ -0,0 +1,359 @@
+package universal
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+
+    "github.com/ritiek/discord-to-simplex/pkg/tempfiles"
+)
+
+// Rough sizes, in bytes, of the parts of a message besides its strings: the struct with its
+// platform data, and each attachment, mention and reaction
+const (
+    messageOverhead    = 1024
+    attachmentOverhead = 128
+    mentionOverhead    = 64
+    reactionOverhead   = 64
+)
+
+// Messages of an import, kept in memory up to a budget and spilled to a temporary file beyond it.
+// Update and Each go over them chunk by chunk, so once they're spilled only a chunk that fits the
+// budget is in memory at a time; Reader streams them into the inserts
+type MessageQueue struct {
+    budget int64  // Bytes of messages kept in memory, 0 for no limit
+    dir    string // Where the spill directory is created, "" for the system temp directory
+
+    memory []Message // All messages, while they aren't spilled
+    size   int64     // Estimated bytes of memory
+    count  int
+
+    spillDir string // Temp directory of the spill files, once spilled
+    path     string // Spill file with all messages, once spilled
+    files    int    // Spill files written so far, numbering the next
+
+    // Spill file messages are being appended to
+    file    *os.File
+    writer  *bufio.Writer
+    encoder *json.Encoder
+}
+
+// Queue keeping up to budget bytes of messages in memory (0 for no limit), spilling the rest to a
+// temp directory in dir
+func NewMessageQueue(budget int64, dir string) *MessageQueue {
+    return &MessageQueue{budget: budget, dir: dir}
+}
+
+func (q *MessageQueue) Len() int {
+    return q.count
+}
+
+// Whether the messages went to disk for not fitting the budget
+func (q *MessageQueue) Spilled() bool {
+    return q.path != ""
+}
+
+// Temp directory the messages were spilled to, "" while they're in memory
+func (q *MessageQueue) SpillDir() string {
+    return q.spillDir
+}
+
+// Add a message at the end
+func (q *MessageQueue) Append(msg Message) error {
+    q.count++
+    if q.encoder != nil {
+        return q.encoder.Encode(msg)
+    }
+    if q.path != "" {
+        // Spilled and read back since: new messages go after the ones in the file
+        if err := q.reopen(); err != nil {
+            return err
+        }
+        return q.encoder.Encode(msg)
+    }
+    q.memory = append(q.memory, msg)
+    if q.budget > 0 {
+        q.size += messageSize(msg)
+        if q.size > q.budget {
+            return q.spill()
+        }
+    }
+    return nil
+}
+
+// Replace the messages with what fn makes of them, handing it all of them at once while they're
+// in memory and a chunk at a time that fits the budget once they're spilled. Steps that go by
+// single messages give the same result either way
+func (q *MessageQueue) Update(fn func([]Message) ([]Message, error)) error {
+    if err := q.flush(); err != nil {
+        return err
+    }
+    if q.path == "" {
+        updated, err := fn(q.memory)
+        if err != nil {
+            return err
+        }
+        return q.keep(updated)
+    }
+
+    reader, err := q.Reader()
+    if err != nil {
+        return err
+    }
+    defer reader.Close()
+    previous := q.path
+    if err := q.create(); err != nil {
+        return err
+    }
+    q.count = 0
+    for {
+        chunk, err := reader.next(0, q.budget)
+        if err != nil {
+            return err
+        }
+        if len(chunk) == 0 {
+            break
+        }
+        updated, err := fn(chunk)
+        if err != nil {
+            return err
+        }
+        for _, msg := range updated {
+            if err := q.encoder.Encode(msg); err != nil {
+                return fmt.Errorf("failed to spill messages: %w", err)
+            }
+        }
+        q.count += len(updated)
+    }
+    if err := q.flush(); err != nil {
+        return err
+    }
+    return tempfiles.Remove(previous)
+}
+
+// Hand the messages to fn without changing them, all at once or a chunk at a time like Update
+func (q *MessageQueue) Each(fn func([]Message) error) error {
+    if err := q.flush(); err != nil {
+        return err
+    }
+    if q.path == "" {
+        return fn(q.memory)
+    }
+
+    reader, err := q.Reader()
+    if err != nil {
+        return err
+    }
+    defer reader.Close()
+    for {
+        chunk, err := reader.next(0, q.budget)
+        if err != nil {
+            return err
+        }
+        if len(chunk) == 0 {
+            return nil
+        }
+        if err := fn(chunk); err != nil {
+            return err
+        }
+    }
+}
+
+// All messages in memory, for callers that need them at once whatever the budget
+func (q *MessageQueue) All() ([]Message, error) {
+    if q.path == "" {
+        return q.memory, nil
+    }
+    messages := make([]Message, 0, q.count)
+    err := q.Each(func(chunk []Message) error {
+        messages = append(messages, chunk...)
+        return nil
+    })
+    return messages, err
+}
+
+// Read the messages back in order, a batch at a time
+func (q *MessageQueue) Reader() (*MessageReader, error) {
+    if err := q.flush(); err != nil {
+        return nil, err
+    }
+    if q.path == "" {
+        return &MessageReader{memory: q.memory}, nil
+    }
+    file, err := os.Open(q.path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read spilled messages: %w", err)
+    }
+    return &MessageReader{file: file, decoder: json.NewDecoder(bufio.NewReader(file))}, nil
+}
+
+// Remove the spill files
+func (q *MessageQueue) Close() error {
+    if q.file != nil {
+        q.file.Close()
+        q.file, q.writer, q.encoder = nil, nil, nil
+    }
+    q.memory = nil
+    if q.spillDir == "" {
+        return nil
+    }
+    err := tempfiles.Remove(q.spillDir)
+    q.spillDir, q.path = "", ""
+    return err
+}
+
+// Keep messages in memory, or spill them when they don't fit
+func (q *MessageQueue) keep(messages []Message) error {
+    q.memory, q.count, q.size = messages, len(messages), 0
+    if q.budget == 0 {
+        return nil
+    }
+    for _, msg := range messages {
+        q.size += messageSize(msg)
+    }
+    if q.size > q.budget {
+        return q.spill()
+    }
+    return nil
+}
+
+// Move the messages in memory to a spill file, which the messages after them are appended to
+func (q *MessageQueue) spill() error {
+    if err := q.create(); err != nil {
+        return err
+    }
+    for _, msg := range q.memory {
+        if err := q.encoder.Encode(msg); err != nil {
+            return fmt.Errorf("failed to spill messages: %w", err)
+        }
+    }
+    q.memory, q.size = nil, 0
+    return nil
+}
+
+// Start a new spill file, which becomes the queue's
+func (q *MessageQueue) create() error {
+    if q.spillDir == "" {
+        spillDir, err := os.MkdirTemp(q.dir, "simplex_messages_")
+        if err != nil {
+            return fmt.Errorf("failed to create directory for spilled messages: %w", err)
+        }
+        tempfiles.Register(spillDir)
+        q.spillDir = spillDir
+    }
+    q.files++
+    path := filepath.Join(q.spillDir, fmt.Sprintf("messages_%d.jsonl", q.files))
+    file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+    if err != nil {
+        return fmt.Errorf("failed to create file for spilled messages: %w", err)
+    }
+    q.path, q.file = path, file
+    q.writer = bufio.NewWriter(file)
+    q.encoder = json.NewEncoder(q.writer)
+    return nil
+}
+
+// Append to the spill file again
+func (q *MessageQueue) reopen() error {
+    file, err := os.OpenFile(q.path, os.O_WRONLY|os.O_APPEND, 0600)
+    if err != nil {
+        return fmt.Errorf("failed to open spilled messages: %w", err)
+    }
+    q.file = file
+    q.writer = bufio.NewWriter(file)
+    q.encoder = json.NewEncoder(q.writer)
+    return nil
+}
+
+// Finish the spill file being written, so it can be read
+func (q *MessageQueue) flush() error {
+    if q.file == nil {
+        return nil
+    }
+    err := q.writer.Flush()
+    if closeErr := q.file.Close(); err == nil {
+        err = closeErr
+    }
+    q.file, q.writer, q.encoder = nil, nil, nil
+    if err != nil {
+        return fmt.Errorf("failed to spill messages: %w", err)
+    }
+    return nil
+}
+
+// Reads the messages of a MessageQueue back in order
+type MessageReader struct {
+    memory  []Message // Messages not read yet, for a queue in memory
+    file    *os.File
+    decoder *json.Decoder
+}
+
+// Up to n of the next messages, fewer at the end and none once all were read
+func (r *MessageReader) Next(n int) ([]Message, error) {
+    return r.next(n, 0)
+}
+
+// The next messages, up to n of them (0 for any number) adding up to budget bytes (0 for no
+// limit), and at least one while there are any left
+func (r *MessageReader) next(n int, budget int64) ([]Message, error) {
+    if r.decoder == nil {
+        if n == 0 || n > len(r.memory) {
+            n = len(r.memory)
+        }
+        chunk := r.memory[:n]
+        r.memory = r.memory[n:]
+        return chunk, nil
+    }
+
+    var chunk []Message
+    var size int64
+    for (n == 0 || len(chunk) < n) && (budget == 0 || size < budget) {
+        var msg Message
+        if err := r.decoder.Decode(&msg); err == io.EOF {
+            break
+        } else if err != nil {
+            return nil, fmt.Errorf("failed to read spilled messages: %w", err)
+        }
+        chunk = append(chunk, msg)
+        size += messageSize(msg)
+    }
+    return chunk, nil
+}
+
+func (r *MessageReader) Close() error {
+    if r.file == nil {
+        return nil
+    }
+    return r.file.Close()
+}
+
+// Rough bytes a message takes up in memory: its strings, with a fixed overhead for the rest
+func messageSize(msg Message) int64 {
+    size := messageOverhead + len(msg.ID) + len(msg.Content) + len(msg.MessageType) +
+        len(msg.Author.ID) + len(msg.Author.Username) + len(msg.Author.DisplayName)
+    for _, attachment := range msg.Attachments {
+        size += attachmentOverhead + len(attachment.ID) + len(attachment.Filename) + len(attachment.URL) + len(attachment.MimeType)
+    }
+    for _, mention := range msg.Mentions {
+        size += mentionOverhead + len(mention.UserID) + len(mention.Username)
+    }
+    for _, reaction := range msg.Reactions {
+        size += reactionOverhead + len(reaction.Emoji)
+        for _, userID := range reaction.UserIDs {
+            size += len(userID)
+        }
+    }
+    if quoted := msg.QuotedMessage; quoted != nil {
+        size += len(quoted.SourceID) + len(quoted.Content)
+    }
+    if preview := msg.LinkPreview; preview != nil {
+        size += len(preview.URL) + len(preview.Title) + len(preview.Description) + len(preview.ImageURL)
+    }
+    if forwarded := msg.ForwardedFrom; forwarded != nil {
+        size += len(forwarded.ChatName) + len(forwarded.MessageID)
+    }
+    return int64(size)
+}
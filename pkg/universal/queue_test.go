@@ -0,0 +1,201 @@
+package universal
+
+import (
+    "fmt"
+    "os"
+    "strings"
+    "testing"
+    "time"
+)
+
+func testMessages(n int) []Message {
+    start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+    messages := make([]Message, n)
+    for i := range messages {
+        messages[i] = Message{
+            ID:          fmt.Sprintf("%d", 1000+i),
+            Content:     strings.Repeat("x", i%7) + fmt.Sprintf("message %d", i),
+            Timestamp:   start.Add(time.Duration(i) * time.Minute),
+            Author:      Author{ID: "1", Username: "alice"},
+            MessageType: "Default",
+            Platform:    "discord",
+            IsSent:      i%2 == 0,
+            Reactions:   []Reaction{{Emoji: "👍", Count: 1}},
+        }
+    }
+    return messages
+}
+
+func checkMessages(t *testing.T, got, want []Message) {
+    t.Helper()
+    if len(got) != len(want) {
+        t.Fatalf("got %d messages, want %d", len(got), len(want))
+    }
+    for i := range want {
+        if got[i].ID != want[i].ID || got[i].Content != want[i].Content || got[i].IsSent != want[i].IsSent ||
+            !got[i].Timestamp.Equal(want[i].Timestamp) || len(got[i].Reactions) != len(want[i].Reactions) {
+            t.Fatalf("message %d is %+v, want %+v", i, got[i], want[i])
+        }
+    }
+}
+
+func TestMessageQueueRoundTrip(t *testing.T) {
+    tests := []struct {
+        name    string
+        budget  int64
+        count   int
+        spilled bool
+    }{
+        {"no limit", 0, 50, false},
+        {"within budget", 1 << 20, 50, false},
+        {"spilled", 4 * messageOverhead, 50, true},
+        {"spilled by the first", 1, 3, true},
+        {"empty", 1, 0, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            q := NewMessageQueue(tt.budget, t.TempDir())
+            defer q.Close()
+
+            want := testMessages(tt.count)
+            for _, msg := range want {
+                if err := q.Append(msg); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            if q.Spilled() != tt.spilled {
+                t.Fatalf("Spilled() = %v, want %v", q.Spilled(), tt.spilled)
+            }
+            if q.Len() != tt.count {
+                t.Fatalf("Len() = %d, want %d", q.Len(), tt.count)
+            }
+
+            all, err := q.All()
+            if err != nil {
+                t.Fatal(err)
+            }
+            checkMessages(t, all, want)
+
+            // Each hands over every message once, in chunks that fit the budget once spilled
+            var each []Message
+            err = q.Each(func(chunk []Message) error {
+                if tt.spilled && len(chunk) > 1 && int64(len(chunk)-1)*messageOverhead >= tt.budget {
+                    t.Errorf("chunk of %d messages is over the budget", len(chunk))
+                }
+                each = append(each, chunk...)
+                return nil
+            })
+            if err != nil {
+                t.Fatal(err)
+            }
+            checkMessages(t, each, want)
+
+            // Reader hands them out n at a time
+            reader, err := q.Reader()
+            if err != nil {
+                t.Fatal(err)
+            }
+            var read []Message
+            for {
+                batch, err := reader.Next(7)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                if len(batch) == 0 {
+                    break
+                }
+                if len(batch) > 7 {
+                    t.Fatalf("Next(7) returned %d messages", len(batch))
+                }
+                read = append(read, batch...)
+            }
+            reader.Close()
+            checkMessages(t, read, want)
+        })
+    }
+}
+
+func TestMessageQueueUpdate(t *testing.T) {
+    tests := []struct {
+        name   string
+        budget int64
+    }{
+        {"in memory", 0},
+        {"spilled", 3 * messageOverhead},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            q := NewMessageQueue(tt.budget, t.TempDir())
+            defer q.Close()
+
+            messages := testMessages(40)
+            for _, msg := range messages {
+                if err := q.Append(msg); err != nil {
+                    t.Fatal(err)
+                }
+            }
+
+            // Drop the messages whose ID ends in 0, 3, 6 or 9 and change the rest
+            err := q.Update(func(chunk []Message) ([]Message, error) {
+                var kept []Message
+                for _, msg := range chunk {
+                    if msg.ID[len(msg.ID)-1]%3 == 0 {
+                        continue
+                    }
+                    msg.Content += " (updated)"
+                    kept = append(kept, msg)
+                }
+                return kept, nil
+            })
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            var want []Message
+            for _, msg := range messages {
+                if msg.ID[len(msg.ID)-1]%3 == 0 {
+                    continue
+                }
+                msg.Content += " (updated)"
+                want = append(want, msg)
+            }
+
+            // Messages appended after reading back go after the others
+            extra := testMessages(45)[40:]
+            for _, msg := range extra {
+                if err := q.Append(msg); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            want = append(want, extra...)
+
+            if q.Len() != len(want) {
+                t.Fatalf("Len() = %d, want %d", q.Len(), len(want))
+            }
+            all, err := q.All()
+            if err != nil {
+                t.Fatal(err)
+            }
+            checkMessages(t, all, want)
+        })
+    }
+}
+
+func TestMessageQueueCloseRemovesSpill(t *testing.T) {
+    q := NewMessageQueue(1, t.TempDir())
+    for _, msg := range testMessages(5) {
+        if err := q.Append(msg); err != nil {
+            t.Fatal(err)
+        }
+    }
+    spillDir := q.SpillDir()
+    if spillDir == "" {
+        t.Fatal("messages weren't spilled")
+    }
+    if err := q.Close(); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := os.Stat(spillDir); !os.IsNotExist(err) {
+        t.Fatalf("spill directory is still there: %v", err)
+    }
+}
@@ -0,0 +1,79 @@
+package universal
+
+import (
+    "fmt"
+    "regexp"
+    "strings"
+)
+
+// Rule that rewrites message text before import, e.g. to scrub tokens or addresses
+type Redaction struct {
+    Pattern     *regexp.Regexp
+    Replacement string // May refer to groups of Pattern as $1 or ${name}
+}
+
+// Parse a "pattern=replacement" rule. It's split at the last =, so a pattern may contain = and a
+// replacement can't
+func ParseRedaction(rule string) (Redaction, error) {
+    i := strings.LastIndex(rule, "=")
+    if i <= 0 {
+        return Redaction{}, fmt.Errorf("'%s' is not in the form pattern=replacement", rule)
+    }
+    pattern, err := regexp.Compile(rule[:i])
+    if err != nil {
+        return Redaction{}, fmt.Errorf("invalid pattern in '%s': %w", rule, err)
+    }
+    return Redaction{Pattern: pattern, Replacement: rule[i+1:]}, nil
+}
+
+// Apply the rules to the text of each message, the quotes of other messages in it and its link
+// preview. Returns how many messages were changed
+func Redact(messages []Message, rules []Redaction) int {
+    redact := func(text string) string {
+        for _, rule := range rules {
+            text = rule.Pattern.ReplaceAllString(text, rule.Replacement)
+        }
+        return text
+    }
+
+    changed := 0
+    for i := range messages {
+        msg := &messages[i]
+        before := *msg
+        msg.Content = redact(msg.Content)
+        if msg.QuotedMessage != nil {
+            quoted := *msg.QuotedMessage
+            quoted.Content = redact(quoted.Content)
+            msg.QuotedMessage = &quoted
+        }
+        if msg.LinkPreview != nil {
+            preview := *msg.LinkPreview
+            preview.URL, preview.Title, preview.Description = redact(preview.URL), redact(preview.Title), redact(preview.Description)
+            msg.LinkPreview = &preview
+        }
+        if msg.Content != before.Content || (msg.QuotedMessage != nil && msg.QuotedMessage.Content != before.QuotedMessage.Content) ||
+            (msg.LinkPreview != nil && *msg.LinkPreview != *before.LinkPreview) {
+            changed++
+        }
+    }
+    return changed
+}
+
+// Leave out messages whose text matches any of the patterns. Returns the rest and how many were
+// left out
+func DropMatching(messages []Message, patterns []*regexp.Regexp) ([]Message, int) {
+    kept := messages[:0]
+    for _, msg := range messages {
+        drop := false
+        for _, pattern := range patterns {
+            if pattern.MatchString(msg.Content) {
+                drop = true
+                break
+            }
+        }
+        if !drop {
+            kept = append(kept, msg)
+        }
+    }
+    return kept, len(messages) - len(kept)
+}
@@ -0,0 +1,94 @@
+package universal
+
+import (
+    "strings"
+    "unicode"
+    "unicode/utf8"
+)
+
+// What Sanitize changed
+type SanitizeStats struct {
+    Messages int // Messages whose text, author, quote or link preview changed
+    Files    int // Attachment file names that changed
+}
+
+// Clean up text SimpleX clients can't show: invalid UTF-8 (lone surrogates in a JSON export end
+// up as such) becomes U+FFFD, and NULs and other control characters but tabs and line breaks are
+// removed. Done to message and quoted text, author names, link previews and attachment file
+// names, which fall back to "file" when nothing is left of them
+func Sanitize(messages []Message) SanitizeStats {
+    var stats SanitizeStats
+    for i := range messages {
+        msg := &messages[i]
+        changed := false
+        clean := func(text *string, keepBreaks bool) {
+            if cleaned := sanitizeText(*text, keepBreaks); cleaned != *text {
+                *text = cleaned
+                changed = true
+            }
+        }
+
+        clean(&msg.Content, true)
+        clean(&msg.Author.Username, false)
+        clean(&msg.Author.DisplayName, false)
+        if msg.QuotedMessage != nil {
+            quoted := *msg.QuotedMessage
+            clean(&quoted.Content, true)
+            msg.QuotedMessage = &quoted
+        }
+        if msg.LinkPreview != nil {
+            preview := *msg.LinkPreview
+            clean(&preview.Title, false)
+            clean(&preview.Description, true)
+            msg.LinkPreview = &preview
+        }
+        if changed {
+            stats.Messages++
+        }
+
+        if len(msg.Attachments) > 0 {
+            attachments := make([]Attachment, len(msg.Attachments))
+            copy(attachments, msg.Attachments)
+            for j := range attachments {
+                name := strings.TrimSpace(sanitizeText(attachments[j].Filename, false))
+                if name == "" {
+                    name = "file"
+                }
+                if name != attachments[j].Filename {
+                    attachments[j].Filename = name
+                    stats.Files++
+                }
+            }
+            msg.Attachments = attachments
+        }
+    }
+    return stats
+}
+
+// Text with invalid UTF-8 replaced and control characters removed, keeping tabs and (with
+// keepBreaks) line breaks
+func sanitizeText(text string, keepBreaks bool) string {
+    clean := utf8.ValidString(text)
+    for _, r := range text {
+        if unicode.IsControl(r) && !keptControl(r, keepBreaks) {
+            clean = false
+            break
+        }
+    }
+    if clean {
+        return text
+    }
+
+    text = strings.ToValidUTF8(text, string(utf8.RuneError))
+    return strings.Map(func(r rune) rune {
+        if unicode.IsControl(r) && !keptControl(r, keepBreaks) {
+            return -1
+        }
+        return r
+    }, text)
+}
+
+// Control characters that are fine in text
+func keptControl(r rune, keepBreaks bool) bool {
+    return r == '\t' || (keepBreaks && (r == '\n' || r == '\r'))
+}
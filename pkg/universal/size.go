@@ -0,0 +1,48 @@
+package universal
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// Parse a human-readable size like 500K, 25MB or 1.5G (binary units) into bytes
+func ParseByteSize(value string) (int64, error) {
+    value = strings.ToUpper(strings.TrimSpace(value))
+    value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+
+    multiplier := int64(1)
+    if value != "" {
+        switch value[len(value)-1] {
+        case 'K':
+            multiplier = 1 << 10
+        case 'M':
+            multiplier = 1 << 20
+        case 'G':
+            multiplier = 1 << 30
+        }
+        if multiplier > 1 {
+            value = value[:len(value)-1]
+        }
+    }
+
+    number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+    if err != nil || number < 0 {
+        return 0, fmt.Errorf("invalid size '%s'", value)
+    }
+    return int64(number * float64(multiplier)), nil
+}
+
+// Format a byte count for humans
+func FormatByteSize(size int64) string {
+    switch {
+    case size >= 1<<30:
+        return fmt.Sprintf("%.1f GB", float64(size)/(1<<30))
+    case size >= 1<<20:
+        return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+    case size >= 1<<10:
+        return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+    default:
+        return fmt.Sprintf("%d B", size)
+    }
+}
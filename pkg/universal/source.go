@@ -0,0 +1,105 @@
+package universal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// What a source knows about the chat it reads
+type SourceInfo struct {
+    Platform string // Registered platform name, e.g. "discord"
+    ChatName string // Channel or chat name
+    // Messages in the export, before attachments are split into messages of their own
+    MessageCount int
+    // Directory that relative attachment URLs and preview images are resolved against
+    BaseDir string
+    // Messages of the export that couldn't be read in full
+    ParseErrors []ParseError
+}
+
+// A message of an export a source couldn't read in full, and why
+type ParseError struct {
+    Index   int             `json:"index"` // Position in the export, from 0
+    ID      string          `json:"id,omitempty"`
+    Reason  string          `json:"reason"`
+    Skipped bool            `json:"skipped"` // Left out, otherwise converted without what couldn't be read
+    Raw     json.RawMessage `json:"message,omitempty"`
+}
+
+// A chat export from some platform, converted to universal messages. Sources only convert;
+// media handling and inserting into SimpleX are the same for every platform
+type Source interface {
+    Info() SourceInfo
+    // Stream the converted messages oldest first, including deleted ones (marked IsDeleted).
+    // The channel is closed after the last message, or early when ctx is done
+    Parse(ctx context.Context) (<-chan Message, error)
+}
+
+// Settings a source is created from
+type SourceConfig struct {
+    Path       string         // Export file
+    MyUsername string         // Our account on the platform; their messages become sent ones
+    Location   *time.Location // Time zone for timestamps rendered into text and export times without an offset
+    // Platform-specific settings, see each source's documentation
+    Options map[string]string
+}
+
+// Creates a source for one platform
+type SourceFactory func(ctx context.Context, cfg SourceConfig) (Source, error)
+
+var (
+    sourcesMu sync.RWMutex
+    sources   = make(map[string]SourceFactory)
+)
+
+// Make a platform available to NewSource, usually from the converter package's init
+func RegisterSource(platform string, factory SourceFactory) {
+    sourcesMu.Lock()
+    defer sourcesMu.Unlock()
+    if _, exists := sources[platform]; exists {
+        panic("universal: source " + platform + " registered twice")
+    }
+    sources[platform] = factory
+}
+
+// Create a source for a registered platform
+func NewSource(ctx context.Context, platform string, cfg SourceConfig) (Source, error) {
+    sourcesMu.RLock()
+    factory, ok := sources[platform]
+    sourcesMu.RUnlock()
+    if !ok {
+        return nil, fmt.Errorf("unknown source '%s': must be %s", platform, strings.Join(SourcePlatforms(), ", "))
+    }
+    return factory(ctx, cfg)
+}
+
+// Names of the registered platforms, sorted
+func SourcePlatforms() []string {
+    sourcesMu.RLock()
+    defer sourcesMu.RUnlock()
+    platforms := make([]string, 0, len(sources))
+    for platform := range sources {
+        platforms = append(platforms, platform)
+    }
+    sort.Strings(platforms)
+    return platforms
+}
+
+// Parse a source and collect all of its messages
+func ReadAll(ctx context.Context, source Source) ([]Message, error) {
+    stream, err := source.Parse(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    var messages []Message
+    for msg := range stream {
+        messages = append(messages, msg)
+    }
+    return messages, ctx.Err()
+}
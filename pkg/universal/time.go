@@ -0,0 +1,29 @@
+package universal
+
+import (
+    "fmt"
+    "time"
+)
+
+// Layouts of export timestamps without a UTC offset, tried after RFC3339
+var localTimeLayouts = []string{
+    "2006-01-02T15:04:05.999999999",
+    "2006-01-02 15:04:05.999999999",
+}
+
+// Parse an export timestamp: RFC3339 with its offset, or a local time without one, which is
+// taken to be in loc (UTC when nil). Fractional seconds are kept in both
+func ParseTimestamp(value string, loc *time.Location) (time.Time, error) {
+    if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+        return t, nil
+    }
+    if loc == nil {
+        loc = time.UTC
+    }
+    for _, layout := range localTimeLayouts {
+        if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+            return t, nil
+        }
+    }
+    return time.Time{}, fmt.Errorf("unrecognized timestamp '%s'", value)
+}
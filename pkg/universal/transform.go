@@ -0,0 +1,125 @@
+package universal
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "os/exec"
+    "runtime"
+    "strings"
+)
+
+// Runtime used for .wasm transforms; the module gets the same stdin/stdout protocol through WASI
+var WASMRuntime = "wasmtime"
+
+// An external program that rewrites messages before they're imported. It gets one message per
+// line as JSON on stdin and answers each one with a line on stdout: the (possibly modified)
+// message, or null to drop it. The program keeps running for the whole import, so it must
+// flush after every answer
+type Transform struct {
+    Command string
+    cmd     *exec.Cmd
+    stdin   io.WriteCloser
+    stdout  *bufio.Reader
+}
+
+// Start a transform: a .wasm module run with WASMRuntime, or a shell command
+func StartTransform(ctx context.Context, command string) (*Transform, error) {
+    var cmd *exec.Cmd
+    switch {
+    case strings.HasSuffix(command, ".wasm"):
+        cmd = exec.CommandContext(ctx, WASMRuntime, "run", command)
+    case runtime.GOOS == "windows":
+        cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+    default:
+        cmd = exec.CommandContext(ctx, "sh", "-c", command)
+    }
+    cmd.Stderr = os.Stderr
+
+    stdin, err := cmd.StdinPipe()
+    if err != nil {
+        return nil, err
+    }
+    stdout, err := cmd.StdoutPipe()
+    if err != nil {
+        return nil, err
+    }
+    if err := cmd.Start(); err != nil {
+        return nil, fmt.Errorf("failed to start transform %q: %w", command, err)
+    }
+
+    return &Transform{Command: command, cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout)}, nil
+}
+
+// Send one message through the transform; nil means it was dropped
+func (t *Transform) Apply(msg Message) (*Message, error) {
+    line, err := json.Marshal(msg)
+    if err != nil {
+        return nil, err
+    }
+    if _, err := t.stdin.Write(append(line, '\n')); err != nil {
+        return nil, fmt.Errorf("transform %q stopped reading: %w", t.Command, err)
+    }
+
+    reply, err := t.stdout.ReadBytes('\n')
+    if err != nil && len(reply) == 0 {
+        return nil, fmt.Errorf("transform %q didn't answer message %s: %w", t.Command, msg.ID, err)
+    }
+    reply = bytes.TrimSpace(reply)
+    if len(reply) == 0 || bytes.Equal(reply, []byte("null")) {
+        return nil, nil
+    }
+
+    var transformed Message
+    if err := json.Unmarshal(reply, &transformed); err != nil {
+        return nil, fmt.Errorf("transform %q returned invalid JSON for message %s: %w", t.Command, msg.ID, err)
+    }
+    return &transformed, nil
+}
+
+// Close the transform's stdin and wait for it to exit
+func (t *Transform) Close() error {
+    t.stdin.Close()
+    return t.cmd.Wait()
+}
+
+// Run the messages through each transform command in turn, one process per command for all of
+// them. Returns how many were dropped
+func ApplyTransforms(ctx context.Context, messages *MessageQueue, commands []string) (int, error) {
+    dropped := 0
+    for _, command := range commands {
+        transform, err := StartTransform(ctx, command)
+        if err != nil {
+            return dropped, err
+        }
+
+        err = messages.Update(func(chunk []Message) ([]Message, error) {
+            kept := make([]Message, 0, len(chunk))
+            for _, msg := range chunk {
+                transformed, err := transform.Apply(msg)
+                if err != nil {
+                    return nil, err
+                }
+                if transformed == nil {
+                    dropped++
+                    continue
+                }
+                kept = append(kept, *transformed)
+            }
+            return kept, nil
+        })
+        if err != nil {
+            transform.Close()
+            return dropped, err
+        }
+
+        if err := transform.Close(); err != nil {
+            return dropped, fmt.Errorf("transform %q failed: %w", command, err)
+        }
+    }
+    return dropped, nil
+}